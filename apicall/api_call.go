@@ -37,6 +37,10 @@ type APICall struct {
 	Endpoint ct.APIEndpoint
 	Response *http.Response
 	Body     []byte
+	// BodyHash is the SHA-256 hash of the response body.  It is only set if
+	// the body has been moved out of Body into separate, content-addressed
+	// storage, in which case Body will be empty.
+	BodyHash []byte
 	Err      error
 }
 
@@ -46,6 +50,9 @@ func (ac APICall) String() string {
 	if len(ac.Body) > maxBodyStringLen {
 		responseBody = fmt.Sprintf("%s [truncated next %d bytes]", ac.Body[:maxBodyStringLen], len(ac.Body)-maxBodyStringLen)
 	}
+	if len(ac.Body) == 0 && len(ac.BodyHash) > 0 {
+		responseBody = fmt.Sprintf("[stored separately, SHA256: %x]", ac.BodyHash)
+	}
 
 	lines := []string{
 		"APICall {",
@@ -18,7 +18,6 @@ package certgen
 import (
 	"crypto"
 	crand "crypto/rand"
-	"crypto/rsa"
 	"fmt"
 	"math"
 	"math/big"
@@ -31,10 +30,7 @@ import (
 	"github.com/google/monologue/interval"
 )
 
-const (
-	keySizeBits  = 2048
-	certValidity = time.Hour * 24
-)
+const certValidity = time.Hour * 24
 
 var timeNowUTC = func() time.Time {
 	return time.Now().UTC()
@@ -52,10 +48,17 @@ type CertificateConfig struct {
 	SubjectOrganizationalUnit string
 	SubjectLocality           string
 	SubjectCountry            string
-	SignatureAlgorithm        x509.SignatureAlgorithm
 
 	// Optional fields
 
+	// SignatureAlgorithm is the algorithm with which the CA signs
+	// certificates.  If unset, it is derived from the CA's key, as described
+	// for SignatureAlgorithmFor.
+	SignatureAlgorithm x509.SignatureAlgorithm
+	// KeyType is the type of key generated for each leaf certificate.  If
+	// unset, DefaultKeyType is used.
+	KeyType KeyType
+
 	// DNSPrefix is a prefix that will be used in conjunction with the
 	// SubjectCommonName to create a more specific DNS SAN.
 	DNSPrefix string
@@ -77,6 +80,17 @@ type CA struct {
 	SigningCert *x509.Certificate
 	SigningKey  crypto.Signer
 	CertConfig  CertificateConfig
+	// Chain holds the certificates that chain SigningCert to its root, in
+	// order, ending with the root.  It is empty if SigningCert is the root.
+	Chain []*x509.Certificate
+	// PrecertSigningCert and PrecertSigningKey, if set, are a Precertificate
+	// Signing Certificate issued by SigningCert, and its key, which issue
+	// precertificates in place of SigningCert (RFC 6962 s3.1).
+	PrecertSigningCert *x509.Certificate
+	PrecertSigningKey  crypto.Signer
+	// KeyPool, if set, provides the keys of leaf certificates, so that they
+	// do not have to be generated while issuing each certificate.
+	KeyPool *KeyPool
 }
 
 // IssueCertificate creates a new leaf certificate, issued by the key specified
@@ -87,48 +101,60 @@ func (ca *CA) IssueCertificate() (*x509.Certificate, error) {
 }
 
 // IssueCertificateChain creates a certificate chain, containing a new leaf
-// certificate (as created by IssueCertificate) and the certificate for the key
-// that signed it (stored in the SigningCert field of the CA).
+// certificate (as created by IssueCertificate), the certificate for the key
+// that signed it (stored in the SigningCert field of the CA), and the rest of
+// the CA's Chain up to its root.
 func (ca *CA) IssueCertificateChain() ([]*x509.Certificate, error) {
 	leaf, err := ca.IssueCertificate()
 	if err != nil {
 		return nil, fmt.Errorf("error issuing leaf certificate: %s", err)
 	}
 
-	return []*x509.Certificate{leaf, ca.SigningCert}, nil
+	return append([]*x509.Certificate{leaf, ca.SigningCert}, ca.Chain...), nil
 }
 
 // IssuePrecertificate creates a new leaf precertificate, issued by the key
-// specified in the SigningCert and SigningKey fields of the CA, and configured
-// using the CertConfig in the CA.
+// specified in the SigningCert and SigningKey fields of the CA, or by the
+// PrecertSigningKey if set, and configured using the CertConfig in the CA.
 func (ca *CA) IssuePrecertificate() (*x509.Certificate, error) {
 	return ca.issueCertificate(true /* precertificate */)
 }
 
 // IssuePrecertificateChain creates a certificate chain, containing a new leaf
-// precertificate (as created by IssuePrecertificate) and the certificate for
-// the key that signed it (stored in the SigningCert field of the CA).
-//
-// TODO(katjoyce): Add precert-signing-cert functionality.
+// precertificate (as created by IssuePrecertificate), the Precertificate
+// Signing Certificate if the CA has one, the SigningCert of the CA, and the
+// rest of the CA's Chain up to its root.
 func (ca *CA) IssuePrecertificateChain() ([]*x509.Certificate, error) {
 	leaf, err := ca.IssuePrecertificate()
 	if err != nil {
 		return nil, fmt.Errorf("error issuing leaf precertificate: %s", err)
 	}
 
-	return []*x509.Certificate{leaf, ca.SigningCert}, nil
+	chain := []*x509.Certificate{leaf}
+	if ca.PrecertSigningCert != nil {
+		chain = append(chain, ca.PrecertSigningCert)
+	}
+	return append(append(chain, ca.SigningCert), ca.Chain...), nil
 }
 
 func (ca *CA) issueCertificate(precert bool) (*x509.Certificate, error) {
-	key, err := rsa.GenerateKey(crand.Reader, keySizeBits)
+	key, err := ca.leafKey()
 	if err != nil {
 		return nil, fmt.Errorf("error generating key pair: %s", err)
 	}
 
+	issuer, issuerKey := ca.SigningCert, ca.SigningKey
+	if precert && ca.PrecertSigningCert != nil {
+		issuer, issuerKey = ca.PrecertSigningCert, ca.PrecertSigningKey
+	}
+
 	template, err := leafTemplate(ca.CertConfig)
 	if err != nil {
 		return nil, fmt.Errorf("error creating leaf template: %s", err)
 	}
+	if template.SignatureAlgorithm == x509.UnknownSignatureAlgorithm {
+		template.SignatureAlgorithm = SignatureAlgorithmFor(issuerKey.Public())
+	}
 
 	if precert {
 		// Add the CT poison extension.
@@ -140,7 +166,7 @@ func (ca *CA) issueCertificate(precert bool) (*x509.Certificate, error) {
 		template.ExtraExtensions = append(template.ExtraExtensions, poison)
 	}
 
-	leafDER, err := x509.CreateCertificate(crand.Reader, template, ca.SigningCert, key.Public(), ca.SigningKey)
+	leafDER, err := x509.CreateCertificate(crand.Reader, template, issuer, key.Public(), issuerKey)
 	if err != nil {
 		return nil, fmt.Errorf("error creating leaf certificate: %s", err)
 	}
@@ -153,6 +179,15 @@ func (ca *CA) issueCertificate(precert bool) (*x509.Certificate, error) {
 	return leaf, nil
 }
 
+// leafKey returns a new key for a leaf certificate, from the CA's KeyPool if it
+// has one.
+func (ca *CA) leafKey() (crypto.Signer, error) {
+	if ca.KeyPool != nil {
+		return ca.KeyPool.Key(ca.CertConfig.KeyType)
+	}
+	return GenerateKey(ca.CertConfig.KeyType)
+}
+
 func leafTemplate(c CertificateConfig) (*x509.Certificate, error) {
 	sn, err := randSerialNumber()
 	if err != nil {
@@ -161,7 +196,7 @@ func leafTemplate(c CertificateConfig) (*x509.Certificate, error) {
 
 	notAfter := timeNowUTC().Add(certValidity)
 	if c.NotAfterInterval != nil {
-		notAfter = c.NotAfterInterval.RandomSecond()
+		notAfter = randomNotAfter(c.NotAfterInterval)
 	}
 
 	return &x509.Certificate{
@@ -185,6 +220,17 @@ func leafTemplate(c CertificateConfig) (*x509.Certificate, error) {
 	}, nil
 }
 
+// randomNotAfter returns a random NotAfter time in i.  Where possible, it is
+// chosen so that the certificate is valid now, or will become valid in the
+// future, rather than having already expired.
+func randomNotAfter(i *interval.Interval) time.Time {
+	earliest := timeNowUTC().Add(certValidity)
+	if earliest.After(i.Start) && earliest.Before(i.End) {
+		return (&interval.Interval{Start: earliest, End: i.End}).RandomSecond()
+	}
+	return i.RandomSecond()
+}
+
 func randSerialNumber() (*big.Int, error) {
 	i := big.NewInt(0)
 	return crand.Int(crand.Reader, i.SetUint64(math.MaxUint64))
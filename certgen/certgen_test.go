@@ -295,6 +295,42 @@ func TestIssuePrecertificateChain(t *testing.T) {
 	}
 }
 
+func TestRandomNotAfter(t *testing.T) {
+	now := time.Date(2019, time.March, 25, 12, 0, 0, 0, time.UTC)
+	timeNowUTC = func() time.Time { return now }
+
+	tests := []struct {
+		desc      string
+		in        *interval.Interval
+		wantStart time.Time
+	}{
+		{
+			desc:      "future interval",
+			in:        &interval.Interval{Start: now.Add(48 * time.Hour), End: now.Add(72 * time.Hour)},
+			wantStart: now.Add(48 * time.Hour),
+		},
+		{
+			desc:      "current interval",
+			in:        &interval.Interval{Start: now.Add(-24 * time.Hour), End: now.Add(72 * time.Hour)},
+			wantStart: now.Add(certValidity),
+		},
+		{
+			desc:      "past interval",
+			in:        &interval.Interval{Start: now.Add(-72 * time.Hour), End: now.Add(-48 * time.Hour)},
+			wantStart: now.Add(-72 * time.Hour),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				if got := randomNotAfter(test.in); got.Before(test.wantStart) || !got.Before(test.in.End) {
+					t.Fatalf("randomNotAfter() = %s, want in [%s, %s)", got, test.wantStart, test.in.End)
+				}
+			}
+		})
+	}
+}
+
 func TestExtendedDNSSAN(t *testing.T) {
 	tests := []struct {
 		desc    string
@@ -328,3 +364,50 @@ func TestExtendedDNSSAN(t *testing.T) {
 		}
 	}
 }
+
+func TestNewRootCA(t *testing.T) {
+	ca, err := NewRootCA("Untrusted Root", certConfig)
+	if err != nil {
+		t.Fatalf("NewRootCA() = _, %s", err)
+	}
+	if got, want := ca.SigningCert.Subject.CommonName, "Untrusted Root"; got != want {
+		t.Errorf("NewRootCA(): root CommonName = %q, want %q", got, want)
+	}
+	if !ca.SigningCert.IsCA {
+		t.Error("NewRootCA(): root is not a CA certificate")
+	}
+	if err := ca.SigningCert.CheckSignatureFrom(ca.SigningCert); err != nil {
+		t.Errorf("NewRootCA(): root is not self-signed: %s", err)
+	}
+
+	chain, err := ca.IssueCertificateChain()
+	if err != nil {
+		t.Fatalf("ca.IssueCertificateChain() = _, %s", err)
+	}
+	if err := chain[0].CheckSignatureFrom(chain[1]); err != nil {
+		t.Errorf("ca.IssueCertificateChain(): leaf certificate signature doesn't verify against the new root: %s", err)
+	}
+}
+
+func TestCorruptSignature(t *testing.T) {
+	root, rootKey, err := rootAndKeySetup(rootFile, rootKeyFile)
+	if err != nil {
+		t.Fatalf("root and key setup error: %s", err)
+	}
+	ca := &CA{SigningCert: root, SigningKey: rootKey, CertConfig: certConfig}
+	leaf, err := ca.IssueCertificate()
+	if err != nil {
+		t.Fatalf("ca.IssueCertificate() = _, %s", err)
+	}
+
+	corrupt, err := CorruptSignature(leaf)
+	if err != nil {
+		t.Fatalf("CorruptSignature() = _, %s", err)
+	}
+	if !bytes.Equal(corrupt.RawTBSCertificate, leaf.RawTBSCertificate) {
+		t.Error("CorruptSignature() changed the TBSCertificate")
+	}
+	if err := corrupt.CheckSignatureFrom(root); err == nil {
+		t.Error("CorruptSignature(): signature still verifies")
+	}
+}
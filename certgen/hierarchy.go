@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"bytes"
+	"crypto"
+	crand "crypto/rand"
+	"fmt"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+)
+
+// rootValidity is how long the CA certificates created by newCACert are valid
+// for, unless their issuer expires sooner.
+const rootValidity = 10 * 365 * certValidity
+
+// HierarchyOptions configures the CA hierarchy built by NewHierarchy.
+type HierarchyOptions struct {
+	// Intermediates is the number of intermediate CAs between the root and
+	// the CA that issues leaf certificates.
+	Intermediates int
+	// PrecertSigner is whether precertificates are issued by a
+	// Precertificate Signing Certificate, rather than by the CA that issues
+	// leaf certificates.
+	PrecertSigner bool
+	// KeyType is the type of key generated for each intermediate CA and
+	// Precertificate Signing Certificate.  If unset, DefaultKeyType is used.
+	KeyType KeyType
+}
+
+// NewHierarchy builds a hierarchy of CAs beneath root, as configured by opts,
+// and returns the CA at the bottom of it, which issues chains through each of
+// the intermediates to root.  If opts asks for neither intermediates nor a
+// Precertificate Signing Certificate, root itself is returned.
+func NewHierarchy(root *CA, opts HierarchyOptions) (*CA, error) {
+	ca := root
+	for i := 0; i < opts.Intermediates; i++ {
+		var err error
+		if ca, err = ca.NewIntermediateCA(fmt.Sprintf("Monologue Intermediate %d", i+1), opts.KeyType); err != nil {
+			return nil, err
+		}
+	}
+	if opts.PrecertSigner {
+		return ca.WithPrecertSigner("Monologue Precertificate Signer", opts.KeyType)
+	}
+	return ca, nil
+}
+
+// NewIntermediateCA creates a CA whose SigningCert is a newly generated
+// intermediate certificate issued by ca, with a key of type k.  The new CA
+// issues leaf certificates configured like those of ca, in chains through ca
+// to its root, so calling NewIntermediateCA repeatedly builds chains of any
+// depth.
+func (ca *CA) NewIntermediateCA(commonName string, k KeyType) (*CA, error) {
+	cert, key, err := newCACert(commonName, ca.CertConfig, k, ca.SigningCert, ca.SigningKey, false /* precertSigner */)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{
+		SigningCert: cert,
+		SigningKey:  key,
+		CertConfig:  ca.CertConfig,
+		Chain:       append([]*x509.Certificate{ca.SigningCert}, ca.Chain...),
+		KeyPool:     ca.KeyPool,
+	}, nil
+}
+
+// WithPrecertSigner returns a copy of ca with a newly generated Precertificate
+// Signing Certificate, issued by ca's SigningCert with a key of type k, which
+// issues its precertificates.
+func (ca *CA) WithPrecertSigner(commonName string, k KeyType) (*CA, error) {
+	cert, key, err := newCACert(commonName, ca.CertConfig, k, ca.SigningCert, ca.SigningKey, true /* precertSigner */)
+	if err != nil {
+		return nil, err
+	}
+	signed := *ca
+	signed.PrecertSigningCert, signed.PrecertSigningKey = cert, key
+	return &signed, nil
+}
+
+// Root returns the root certificate at the top of the chains issued by ca.
+func (ca *CA) Root() *x509.Certificate {
+	if len(ca.Chain) > 0 {
+		return ca.Chain[len(ca.Chain)-1]
+	}
+	return ca.SigningCert
+}
+
+// WithoutRoot returns chain without its last certificate if that certificate
+// is a self-signed root, as RFC 6962 s4.1 allows chains to be submitted with
+// or without their root.
+func WithoutRoot(chain []*x509.Certificate) []*x509.Certificate {
+	if len(chain) < 2 {
+		return chain
+	}
+	last := chain[len(chain)-1]
+	if !bytes.Equal(last.RawSubject, last.RawIssuer) || last.CheckSignatureFrom(last) != nil {
+		return chain
+	}
+	return chain[:len(chain)-1]
+}
+
+// newCACert generates a key of type k and a CA certificate for it, issued by
+// parent and parentKey, or self-signed if parent is nil.  If precertSigner is
+// true, the certificate is a Precertificate Signing Certificate, which carries
+// the Certificate Transparency extended key usage (RFC 6962 s3.1).
+func newCACert(commonName string, cc CertificateConfig, k KeyType, parent *x509.Certificate, parentKey crypto.Signer, precertSigner bool) (*x509.Certificate, crypto.Signer, error) {
+	key, err := GenerateKey(k)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating key pair: %s", err)
+	}
+	sn, err := randSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := timeNowUTC()
+	template := &x509.Certificate{
+		SerialNumber: sn,
+		Subject: pkix.Name{
+			Country:            []string{cc.SubjectCountry},
+			Organization:       []string{cc.SubjectOrganization},
+			OrganizationalUnit: []string{cc.SubjectOrganizationalUnit},
+			CommonName:         commonName,
+		},
+		NotBefore:             now.Add(-certValidity),
+		NotAfter:              now.Add(rootValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if precertSigner {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCertificateTransparency}
+		template.MaxPathLenZero = true
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	} else if template.NotAfter.After(parent.NotAfter) {
+		// A CA certificate should not outlive its issuer.
+		template.NotAfter = parent.NotAfter
+	}
+	template.SignatureAlgorithm = SignatureAlgorithmFor(parentKey.Public())
+
+	der, err := x509.CreateCertificate(crand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating CA certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing CA certificate DER: %s", err)
+	}
+	return cert, key, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// checkChain checks that each certificate in chain is issued by the next.
+func checkChain(t *testing.T, chain []*x509.Certificate) {
+	t.Helper()
+	for i := 0; i+1 < len(chain); i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			t.Errorf("chain[%d] (%s) is not issued by chain[%d] (%s): %s", i, chain[i].Subject, i+1, chain[i+1].Subject, err)
+		}
+	}
+}
+
+func hasCTEKU(cert *x509.Certificate) bool {
+	for _, eku := range cert.ExtKeyUsage {
+		if eku == x509.ExtKeyUsageCertificateTransparency {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNewHierarchy(t *testing.T) {
+	root, rootKey, err := rootAndKeySetup(rootFile, rootKeyFile)
+	if err != nil {
+		t.Fatalf("root and key setup error: %s", err)
+	}
+	cc := certConfig
+	cc.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+	rootCA := &CA{SigningCert: root, SigningKey: rootKey, CertConfig: cc}
+
+	tests := []struct {
+		desc             string
+		opts             HierarchyOptions
+		wantChainLen     int
+		wantPrechainLen  int
+		wantPrecertBySig bool
+	}{
+		{
+			desc:            "root only",
+			wantChainLen:    2,
+			wantPrechainLen: 2,
+		},
+		{
+			desc:            "one intermediate",
+			opts:            HierarchyOptions{Intermediates: 1},
+			wantChainLen:    3,
+			wantPrechainLen: 3,
+		},
+		{
+			desc:             "two intermediates and precertificate signer",
+			opts:             HierarchyOptions{Intermediates: 2, PrecertSigner: true, KeyType: ECDSAP256},
+			wantChainLen:     4,
+			wantPrechainLen:  5,
+			wantPrecertBySig: true,
+		},
+		{
+			desc:             "precertificate signer only",
+			opts:             HierarchyOptions{PrecertSigner: true},
+			wantChainLen:     2,
+			wantPrechainLen:  3,
+			wantPrecertBySig: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			ca, err := NewHierarchy(rootCA, test.opts)
+			if err != nil {
+				t.Fatalf("NewHierarchy() = _, %s", err)
+			}
+			if !ca.Root().Equal(root) {
+				t.Errorf("Root() = %s, want %s", ca.Root().Subject, root.Subject)
+			}
+
+			chain, err := ca.IssueCertificateChain()
+			if err != nil {
+				t.Fatalf("IssueCertificateChain() = _, %s", err)
+			}
+			if len(chain) != test.wantChainLen {
+				t.Fatalf("IssueCertificateChain() returned %d certificates, want %d", len(chain), test.wantChainLen)
+			}
+			checkChain(t, chain)
+			for _, cert := range chain[1:] {
+				if !cert.IsCA {
+					t.Errorf("chain certificate %s is not a CA", cert.Subject)
+				}
+			}
+			if !chain[len(chain)-1].Equal(root) {
+				t.Errorf("chain ends in %s, want %s", chain[len(chain)-1].Subject, root.Subject)
+			}
+
+			prechain, err := ca.IssuePrecertificateChain()
+			if err != nil {
+				t.Fatalf("IssuePrecertificateChain() = _, %s", err)
+			}
+			if len(prechain) != test.wantPrechainLen {
+				t.Fatalf("IssuePrecertificateChain() returned %d certificates, want %d", len(prechain), test.wantPrechainLen)
+			}
+			checkChain(t, prechain)
+			if !prechain[0].IsPrecertificate() {
+				t.Error("prechain[0] is not a precertificate")
+			}
+			if got := hasCTEKU(prechain[1]); got != test.wantPrecertBySig {
+				t.Errorf("prechain[1] has the CT extended key usage = %t, want %t", got, test.wantPrecertBySig)
+			}
+			if test.wantPrecertBySig && !prechain[1].MaxPathLenZero {
+				t.Error("Precertificate Signing Certificate does not have a path length of zero")
+			}
+		})
+	}
+}
+
+func TestWithoutRoot(t *testing.T) {
+	rootCA, err := NewRootCA("Test Root", certConfig)
+	if err != nil {
+		t.Fatalf("NewRootCA() = _, %s", err)
+	}
+	ca, err := rootCA.NewIntermediateCA("Test Intermediate", "")
+	if err != nil {
+		t.Fatalf("NewIntermediateCA() = _, %s", err)
+	}
+	chain, err := ca.IssueCertificateChain()
+	if err != nil {
+		t.Fatalf("IssueCertificateChain() = _, %s", err)
+	}
+
+	got := WithoutRoot(chain)
+	if len(got) != 2 || !got[1].Equal(ca.SigningCert) {
+		t.Errorf("WithoutRoot() returned %d certificates, want the leaf and intermediate", len(got))
+	}
+	// An intermediate is not a root, so is never removed.
+	if got := WithoutRoot(got); len(got) != 2 {
+		t.Errorf("WithoutRoot() of a chain with no root returned %d certificates, want 2", len(got))
+	}
+	if got := WithoutRoot(chain[:1]); len(got) != 1 {
+		t.Errorf("WithoutRoot() of a lone leaf returned %d certificates, want 1", len(got))
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"fmt"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// NewRootCA creates a CA with a newly generated, self-signed root certificate
+// and key, which issues certificates configured by cc.  As no CT Log will have
+// seen the root before, chains issued by the CA are useful for checking that
+// Logs reject chains to roots they do not accept.
+func NewRootCA(commonName string, cc CertificateConfig) (*CA, error) {
+	root, key, err := newCACert(commonName, cc, DefaultKeyType, nil, nil, false /* precertSigner */)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{SigningCert: root, SigningKey: key, CertConfig: cc}, nil
+}
+
+// CorruptSignature returns a copy of cert whose signature has been altered, so
+// that it no longer verifies.
+func CorruptSignature(cert *x509.Certificate) (*x509.Certificate, error) {
+	// The signature is the last field of a certificate, so flipping bits in
+	// its last byte alters the signature without changing the structure of
+	// the DER.
+	der := append([]byte(nil), cert.Raw...)
+	der[len(der)-1] ^= 0xff
+	corrupt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing corrupted certificate DER: %s", err)
+	}
+	return corrupt, nil
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"crypto"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/trillian/monitoring"
+)
+
+const (
+	// DefaultKeyPoolSize is the number of keys of each type kept ready if no
+	// Size is configured.
+	DefaultKeyPoolSize = 10
+	// DefaultKeyPoolWorkers is the number of goroutines that generate keys of
+	// each type if no Workers is configured.
+	DefaultKeyPoolWorkers = 2
+
+	// retryDelay is how long a worker waits after failing to generate a key.
+	retryDelay = time.Second
+)
+
+var (
+	keyPoolOnce      sync.Once
+	keyPoolSize      monitoring.Gauge
+	keyPoolHits      monitoring.Counter
+	keyPoolStarved   monitoring.Counter
+	keyPoolFailures  monitoring.Counter
+	keyPoolKeygenDur monitoring.Histogram
+)
+
+func setupKeyPoolMetrics(mf monitoring.MetricFactory) {
+	keyPoolSize = mf.NewGauge("certgen_key_pool_size", "Number of keys ready in the key pool", "key_type")
+	keyPoolHits = mf.NewCounter("certgen_key_pool_hits", "Number of keys taken from the key pool", "key_type")
+	keyPoolStarved = mf.NewCounter("certgen_key_pool_starved", "Number of keys generated on demand because the key pool was empty", "key_type")
+	keyPoolFailures = mf.NewCounter("certgen_key_pool_failures", "Number of failures to generate a key for the key pool", "key_type")
+	keyPoolKeygenDur = mf.NewHistogram("certgen_key_pool_keygen_seconds", "Time taken to generate each key for the key pool", "key_type")
+}
+
+// KeyPoolOptions configures a KeyPool.
+type KeyPoolOptions struct {
+	// Size is the maximum number of keys of each type kept ready.  If 0,
+	// DefaultKeyPoolSize is used.
+	Size int
+	// Workers is the number of goroutines that generate keys of each type.
+	// If 0, DefaultKeyPoolWorkers is used.
+	Workers int
+	// KeyTypes are the types of key that the pool starts generating straight
+	// away.  Keys of other types are generated once first asked for.
+	KeyTypes []KeyType
+	// MetricFactory is used to create the metrics reported by every KeyPool.
+	// Only the MetricFactory passed to the first call to NewKeyPool is used.
+	// If nil, monitoring.InertMetricFactory is used.
+	MetricFactory monitoring.MetricFactory
+}
+
+// KeyPool generates keys in the background, so that issuing a certificate does
+// not have to wait for its key to be generated.  It is safe for concurrent
+// use, so one KeyPool can be shared by the CAs of many Logs.  Close must be
+// called to stop generating keys.
+type KeyPool struct {
+	size    int
+	workers int
+
+	// mu guards closed and keys, which holds the keys ready for each type.
+	mu     sync.Mutex
+	closed bool
+	keys   map[KeyType]chan crypto.Signer
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewKeyPool returns a KeyPool, and starts generating keys of the types given
+// in opts.
+func NewKeyPool(opts KeyPoolOptions) *KeyPool {
+	if opts.Size <= 0 {
+		opts.Size = DefaultKeyPoolSize
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultKeyPoolWorkers
+	}
+	mf := opts.MetricFactory
+	if mf == nil {
+		mf = monitoring.InertMetricFactory{}
+	}
+	keyPoolOnce.Do(func() { setupKeyPoolMetrics(mf) })
+
+	p := &KeyPool{
+		size:    opts.Size,
+		workers: opts.Workers,
+		keys:    make(map[KeyType]chan crypto.Signer),
+		done:    make(chan struct{}),
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range opts.KeyTypes {
+		p.keysLocked(k)
+	}
+	return p
+}
+
+// Key returns a new key of type k.  If the pool has no key of that type ready,
+// one is generated straight away, and the pool is counted as starved.  If k is
+// empty, a key of DefaultKeyType is returned.
+func (p *KeyPool) Key(k KeyType) (crypto.Signer, error) {
+	if k == "" {
+		k = DefaultKeyType
+	}
+	if _, err := ParseKeyType(string(k)); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	var keys chan crypto.Signer
+	if !p.closed {
+		keys = p.keysLocked(k)
+	}
+	p.mu.Unlock()
+
+	select {
+	case key := <-keys:
+		keyPoolHits.Inc(string(k))
+		keyPoolSize.Set(float64(len(keys)), string(k))
+		return key, nil
+	default:
+	}
+	keyPoolStarved.Inc(string(k))
+	return GenerateKey(k)
+}
+
+// Close stops the pool generating keys, and waits for its workers to stop.
+// Keys can still be taken from the pool after it is closed, but are then
+// always generated on demand.
+func (p *KeyPool) Close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// keysLocked returns the channel of keys ready of type k, starting the workers
+// that fill it if there are none yet.  p.mu must be held.
+func (p *KeyPool) keysLocked(k KeyType) chan crypto.Signer {
+	if keys, ok := p.keys[k]; ok {
+		return keys
+	}
+	keys := make(chan crypto.Signer, p.size)
+	p.keys[k] = keys
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.fill(k, keys)
+		}()
+	}
+	return keys
+}
+
+// fill generates keys of type k and adds them to keys until the pool is
+// closed, blocking whenever keys is full.
+func (p *KeyPool) fill(k KeyType, keys chan crypto.Signer) {
+	for {
+		start := time.Now()
+		key, err := GenerateKey(k)
+		if err != nil {
+			glog.Errorf("error generating %s key for key pool: %s", k, err)
+			keyPoolFailures.Inc(string(k))
+			select {
+			case <-p.done:
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		keyPoolKeygenDur.Observe(time.Since(start).Seconds(), string(k))
+
+		select {
+		case <-p.done:
+			return
+		case keys <- key:
+			keyPoolSize.Set(float64(len(keys)), string(k))
+		}
+	}
+}
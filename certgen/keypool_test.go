@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/trillian/monitoring"
+)
+
+func metricValue(t *testing.T, m interface{}, labels ...string) float64 {
+	t.Helper()
+	f, ok := m.(*monitoring.InertFloat)
+	if !ok {
+		t.Fatalf("metric is %T, want *monitoring.InertFloat", m)
+	}
+	return f.Value(labels...)
+}
+
+// waitForKeys waits until the pool has n keys of type k ready.
+func waitForKeys(t *testing.T, p *KeyPool, k KeyType, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		p.mu.Lock()
+		ready := len(p.keys[k])
+		p.mu.Unlock()
+		if ready >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("key pool has %d %s keys ready, want %d", ready, k, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeyPool(t *testing.T) {
+	p := NewKeyPool(KeyPoolOptions{Size: 2, KeyTypes: []KeyType{ECDSAP256}})
+	defer p.Close()
+	waitForKeys(t, p, ECDSAP256, 2)
+
+	hits := metricValue(t, keyPoolHits, string(ECDSAP256))
+	starved := metricValue(t, keyPoolStarved, string(ECDSAP256))
+	key, err := p.Key(ECDSAP256)
+	if err != nil {
+		t.Fatalf("Key(%s) = _, %s", ECDSAP256, err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("Key(%s) = %T, want *ecdsa.PrivateKey", ECDSAP256, key)
+	}
+	if got, want := metricValue(t, keyPoolHits, string(ECDSAP256)), hits+1; got != want {
+		t.Errorf("key pool hits = %v, want %v", got, want)
+	}
+	if got := metricValue(t, keyPoolStarved, string(ECDSAP256)); got != starved {
+		t.Errorf("key pool starved = %v, want %v", got, starved)
+	}
+
+	// The pool is refilled.
+	waitForKeys(t, p, ECDSAP256, 2)
+}
+
+func TestKeyPoolStarved(t *testing.T) {
+	p := NewKeyPool(KeyPoolOptions{Size: 1})
+	p.Close()
+
+	starved := metricValue(t, keyPoolStarved, string(ECDSAP384))
+	key, err := p.Key(ECDSAP384)
+	if err != nil {
+		t.Fatalf("Key(%s) = _, %s", ECDSAP384, err)
+	}
+	if pub, ok := key.Public().(*ecdsa.PublicKey); !ok || pub.Curve.Params().BitSize != 384 {
+		t.Errorf("Key(%s) = %T, want a P-384 key", ECDSAP384, key)
+	}
+	if got, want := metricValue(t, keyPoolStarved, string(ECDSAP384)), starved+1; got != want {
+		t.Errorf("key pool starved = %v, want %v", got, want)
+	}
+
+	if _, err := p.Key("rsa512"); err == nil {
+		t.Errorf("Key(%q) = _, nil, want error", "rsa512")
+	}
+}
+
+func TestKeyPoolConcurrent(t *testing.T) {
+	p := NewKeyPool(KeyPoolOptions{Size: 4, Workers: 4})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if _, err := p.Key(ECDSAP256); err != nil {
+					t.Errorf("Key(%s) = _, %s", ECDSAP256, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestIssueCertificateFromKeyPool(t *testing.T) {
+	root, rootKey, err := rootAndKeySetup(rootFile, rootKeyFile)
+	if err != nil {
+		t.Fatalf("root and key setup error: %s", err)
+	}
+	p := NewKeyPool(KeyPoolOptions{Size: 1, KeyTypes: []KeyType{ECDSAP256}})
+	defer p.Close()
+	waitForKeys(t, p, ECDSAP256, 1)
+
+	cc := certConfig
+	cc.KeyType = ECDSAP256
+	ca := &CA{SigningCert: root, SigningKey: rootKey, CertConfig: cc, KeyPool: p}
+	hits := metricValue(t, keyPoolHits, string(ECDSAP256))
+	if _, err := ca.IssueCertificate(); err != nil {
+		t.Fatalf("IssueCertificate() = _, %s", err)
+	}
+	if got, want := metricValue(t, keyPoolHits, string(ECDSAP256)), hits+1; got != want {
+		t.Errorf("key pool hits = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// KeyType is the algorithm, and size or curve, of the keys generated for leaf
+// certificates.
+type KeyType string
+
+// Key types that can be generated for leaf certificates.
+const (
+	RSA2048   KeyType = "rsa2048"
+	RSA3072   KeyType = "rsa3072"
+	RSA4096   KeyType = "rsa4096"
+	ECDSAP256 KeyType = "ecdsa-p256"
+	ECDSAP384 KeyType = "ecdsa-p384"
+	// Ed25519 keys are not used by publicly-trusted CAs, but some Logs may
+	// accept them.
+	Ed25519 KeyType = "ed25519"
+
+	// RSA1024 and ECDSAP224 keys are forbidden for publicly-trusted
+	// certificates by the CA/Browser Forum Baseline Requirements, section
+	// 6.1.5.
+	RSA1024   KeyType = "rsa1024"
+	ECDSAP224 KeyType = "ecdsa-p224"
+)
+
+// DefaultKeyType is the type of key generated for leaf certificates if none is
+// configured.
+const DefaultKeyType = RSA2048
+
+// KeyTypes lists every KeyType, with those used by publicly-trusted CAs first.
+var KeyTypes = []KeyType{RSA2048, RSA3072, RSA4096, ECDSAP256, ECDSAP384, Ed25519, RSA1024, ECDSAP224}
+
+// Forbidden returns whether keys of type k are forbidden for publicly-trusted
+// certificates, so should be rejected by Logs.
+func (k KeyType) Forbidden() bool {
+	return k == RSA1024 || k == ECDSAP224
+}
+
+// ParseKeyType returns the KeyType named s, e.g. "ecdsa-p256".
+func ParseKeyType(s string) (KeyType, error) {
+	for _, k := range KeyTypes {
+		if string(k) == s {
+			return k, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key type %q", s)
+}
+
+// GenerateKey generates a new key of type k.  If k is empty, a key of
+// DefaultKeyType is generated.
+func GenerateKey(k KeyType) (crypto.Signer, error) {
+	switch k {
+	case "":
+		return GenerateKey(DefaultKeyType)
+	case RSA1024:
+		return rsa.GenerateKey(crand.Reader, 1024)
+	case RSA2048:
+		return rsa.GenerateKey(crand.Reader, 2048)
+	case RSA3072:
+		return rsa.GenerateKey(crand.Reader, 3072)
+	case RSA4096:
+		return rsa.GenerateKey(crand.Reader, 4096)
+	case ECDSAP224:
+		return ecdsa.GenerateKey(elliptic.P224(), crand.Reader)
+	case ECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	case ECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), crand.Reader)
+	case Ed25519:
+		_, key, err := ed25519.GenerateKey(crand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unknown key type %q", k)
+	}
+}
+
+// SignatureAlgorithmFor returns the signature algorithm with which a CA whose
+// public key is pub signs certificates, as publicly-trusted CAs commonly do.
+func SignatureAlgorithmFor(pub crypto.PublicKey) x509.SignatureAlgorithm {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P384():
+			return x509.ECDSAWithSHA384
+		case elliptic.P521():
+			return x509.ECDSAWithSHA512
+		default:
+			return x509.ECDSAWithSHA256
+		}
+	case ed25519.PublicKey:
+		return x509.PureEd25519
+	default:
+		return x509.UnknownSignatureAlgorithm
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+func TestParseKeyType(t *testing.T) {
+	for _, k := range KeyTypes {
+		got, err := ParseKeyType(string(k))
+		if err != nil || got != k {
+			t.Errorf("ParseKeyType(%q) = %q, %v, want %q, nil", k, got, err, k)
+		}
+	}
+	if got, err := ParseKeyType("rsa512"); err == nil {
+		t.Errorf("ParseKeyType(%q) = %q, nil, want error", "rsa512", got)
+	}
+}
+
+func TestIssueCertificateKeyTypes(t *testing.T) {
+	root, rootKey, err := rootAndKeySetup(rootFile, rootKeyFile)
+	if err != nil {
+		t.Fatalf("root and key setup error: %s", err)
+	}
+
+	tests := []struct {
+		keyType    KeyType
+		wantPubAlg x509.PublicKeyAlgorithm
+		wantBits   int
+	}{
+		{keyType: "", wantPubAlg: x509.RSA, wantBits: 2048},
+		{keyType: RSA1024, wantPubAlg: x509.RSA, wantBits: 1024},
+		{keyType: RSA3072, wantPubAlg: x509.RSA, wantBits: 3072},
+		{keyType: ECDSAP224, wantPubAlg: x509.ECDSA, wantBits: 224},
+		{keyType: ECDSAP256, wantPubAlg: x509.ECDSA, wantBits: 256},
+		{keyType: ECDSAP384, wantPubAlg: x509.ECDSA, wantBits: 384},
+		{keyType: Ed25519, wantPubAlg: x509.Ed25519},
+	}
+	for _, test := range tests {
+		t.Run(string(test.keyType), func(t *testing.T) {
+			cc := certConfig
+			cc.SignatureAlgorithm = x509.UnknownSignatureAlgorithm
+			cc.KeyType = test.keyType
+			ca := &CA{SigningCert: root, SigningKey: rootKey, CertConfig: cc}
+			cert, err := ca.IssueCertificate()
+			if err != nil {
+				t.Fatalf("IssueCertificate() = _, %s", err)
+			}
+
+			if cert.PublicKeyAlgorithm != test.wantPubAlg {
+				t.Errorf("certificate PublicKeyAlgorithm = %s, want %s", cert.PublicKeyAlgorithm, test.wantPubAlg)
+			}
+			var bits int
+			switch pub := cert.PublicKey.(type) {
+			case *rsa.PublicKey:
+				bits = pub.N.BitLen()
+			case *ecdsa.PublicKey:
+				bits = pub.Curve.Params().BitSize
+			}
+			if bits != test.wantBits {
+				t.Errorf("certificate key size = %d bits, want %d", bits, test.wantBits)
+			}
+
+			// The signature algorithm is derived from the CA's RSA key.
+			if got, want := cert.SignatureAlgorithm, x509.SHA256WithRSA; got != want {
+				t.Errorf("certificate SignatureAlgorithm = %s, want %s", got, want)
+			}
+			if err := cert.CheckSignatureFrom(root); err != nil {
+				t.Errorf("certificate signature does not verify: %s", err)
+			}
+		})
+	}
+}
+
+func TestSignatureAlgorithmFor(t *testing.T) {
+	mustECDSA := func(c elliptic.Curve) *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(c, crand.Reader)
+		if err != nil {
+			t.Fatalf("ecdsa.GenerateKey() = _, %s", err)
+		}
+		return key
+	}
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = _, %s", err)
+	}
+	edPub, _, err := ed25519.GenerateKey(crand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() = _, %s", err)
+	}
+
+	tests := []struct {
+		desc string
+		pub  interface{}
+		want x509.SignatureAlgorithm
+	}{
+		{desc: "RSA", pub: rsaKey.Public(), want: x509.SHA256WithRSA},
+		{desc: "P-256", pub: mustECDSA(elliptic.P256()).Public(), want: x509.ECDSAWithSHA256},
+		{desc: "P-384", pub: mustECDSA(elliptic.P384()).Public(), want: x509.ECDSAWithSHA384},
+		{desc: "P-521", pub: mustECDSA(elliptic.P521()).Public(), want: x509.ECDSAWithSHA512},
+		{desc: "Ed25519", pub: edPub, want: x509.PureEd25519},
+		{desc: "unknown", pub: "not a key", want: x509.UnknownSignatureAlgorithm},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := SignatureAlgorithmFor(test.pub); got != test.want {
+				t.Errorf("SignatureAlgorithmFor() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certsubmitter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"path"
+	"sync"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/blob"
+)
+
+const (
+	// RejectedAdvertisedSummary is the summary of the incident reported when
+	// a Log rejects a chain to a root that it advertises in get-roots.  The
+	// incident is resolved once a submission's outcome matches the roots the
+	// Log advertises.
+	RejectedAdvertisedSummary = "Chain to advertised root rejected"
+	// AcceptedUnadvertisedSummary is the summary of the incident reported
+	// when a Log accepts a chain to a root that it does not advertise in
+	// get-roots.  The incident is resolved once a submission's outcome matches
+	// the roots the Log advertises.
+	AcceptedUnadvertisedSummary = "Chain to unadvertised root accepted"
+)
+
+// Outcome classifies the response of a Log to a submission against whether
+// the Log advertised the root of the submitted chain.
+type Outcome string
+
+// Outcomes of a submission.
+const (
+	// Indeterminate outcomes are those that cannot be classified, because
+	// the Log's roots are not yet known, or because the submission failed
+	// for a reason other than the Log rejecting the chain.
+	Indeterminate Outcome = "indeterminate"
+	// AcceptedAdvertised and RejectedUnadvertised outcomes are those that
+	// match the roots advertised by the Log.
+	AcceptedAdvertised   Outcome = "accepted-advertised"
+	RejectedUnadvertised Outcome = "rejected-unadvertised"
+	// RejectedAdvertised and AcceptedUnadvertised outcomes are those that do
+	// not.
+	RejectedAdvertised   Outcome = "rejected-advertised"
+	AcceptedUnadvertised Outcome = "accepted-unadvertised"
+)
+
+// Classify returns the Outcome of a submission, given whether the root of the
+// submitted chain was advertised by the Log and the error, if any, returned by
+// the submission.  Only a 400 or 403 HTTP status is taken as the Log rejecting
+// the chain.
+func Classify(advertised bool, addErr error) Outcome {
+	accepted := addErr == nil
+	if !accepted && !isRejection(addErr) {
+		return Indeterminate
+	}
+	switch {
+	case advertised && accepted:
+		return AcceptedAdvertised
+	case advertised:
+		return RejectedAdvertised
+	case accepted:
+		return AcceptedUnadvertised
+	default:
+		return RejectedUnadvertised
+	}
+}
+
+// isRejection reports whether err, returned by a submission, shows that the Log
+// rejected the submitted chain, i.e. that it responded with a 400 Bad Request or
+// 403 Forbidden HTTP status.  Other 4xx statuses, such as 408 Request Timeout
+// and 429 Too Many Requests, say nothing about the chain.
+func isRejection(err error) bool {
+	statusErr, ok := err.(*client.HTTPStatusError)
+	return ok && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusForbidden)
+}
+
+// rootWatcher keeps track of the latest root set observed for a Log.
+type rootWatcher struct {
+	st storage.RootsReader
+
+	mu     sync.Mutex
+	latest storage.RootSetID
+}
+
+// watchRoots returns a rootWatcher that follows the root sets stored in st for
+// l, until ctx is cancelled.
+func watchRoots(ctx context.Context, st storage.RootsReader, l *ctlog.Log) (*rootWatcher, error) {
+	c, err := st.WatchRoots(ctx, l)
+	if err != nil {
+		return nil, err
+	}
+	w := &rootWatcher{st: st}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case id := <-c:
+				w.mu.Lock()
+				w.latest = id
+				w.mu.Unlock()
+			}
+		}
+	}()
+	return w, nil
+}
+
+// advertised reports whether cert, the root of the chains that are submitted,
+// is or is issued by one of the roots in the latest root set observed for the
+// Log.  If no root set has been observed, known is false.
+func (w *rootWatcher) advertised(ctx context.Context, cert *x509.Certificate) (advertised, known bool, err error) {
+	w.mu.Lock()
+	latest := w.latest
+	w.mu.Unlock()
+	if latest == "" {
+		return false, false, nil
+	}
+
+	roots, err := w.st.ReadRoots(ctx, latest)
+	if err != nil {
+		return false, false, err
+	}
+	for _, root := range roots {
+		if bytes.Equal(root.Raw, cert.Raw) {
+			return true, true, nil
+		}
+		if bytes.Equal(root.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(root) == nil {
+			return true, true, nil
+		}
+	}
+	return false, true, nil
+}
+
+// reportOutcome reports an incident if outcome shows that a Log did not
+// honour the roots that it advertises, and declares any such incidents
+// resolved if it shows that it did.  root is the root of the submitted chain,
+// which may not have been included in the chain itself, and apiCall is the
+// submission, whose response body is given as evidence.
+func reportOutcome(ctx context.Context, rep incident.Reporter, l *ctlog.Log, root *x509.Certificate, outcome Outcome, apiCall *apicall.APICall, addErr error, isPreChain bool) {
+	var summary, details string
+	switch outcome {
+	case RejectedAdvertised:
+		summary = RejectedAdvertisedSummary
+		details = fmt.Sprintf("%s rejected a chain to %s, which it advertises in get-roots: %s", l.Name, root.Subject, addErr)
+	case AcceptedUnadvertised:
+		summary = AcceptedUnadvertisedSummary
+		details = fmt.Sprintf("%s accepted a chain to %s, which it does not advertise in get-roots", l.Name, root.Subject)
+	case AcceptedAdvertised, RejectedUnadvertised:
+		incident.LogResolved(ctx, rep, l.URL, RejectedAdvertisedSummary)
+		incident.LogResolved(ctx, rep, l.URL, AcceptedUnadvertisedSummary)
+		return
+	default:
+		return
+	}
+	incident.Report(ctx, rep, &incident.Incident{
+		BaseURL:     l.URL,
+		Summary:     summary,
+		FullURL:     addChainURL(l, isPreChain),
+		Details:     details,
+		IsViolation: true,
+		Category:    incident.RootAcceptance,
+		Severity:    incident.Warning,
+		LogID:       l.LogID,
+		Evidence:    []incident.EvidenceRef{blob.Evidence(apiCall.Body)},
+		RFCSections: []string{"RFC 6962 s3.1", "RFC 6962 s4.7"},
+	})
+}
+
+// addChainURL returns the URL of the add-chain or add-pre-chain endpoint of
+// the Log.
+func addChainURL(l *ctlog.Log, isPreChain bool) string {
+	u, err := neturl.Parse(l.URL)
+	if err != nil {
+		glog.Errorf("%s: %s: failed to parse CT Log URL: %v", l.URL, logStr, err)
+		return l.URL
+	}
+	p := ct.AddChainPath
+	if isPreChain {
+		p = ct.AddPreChainPath
+	}
+	u.Path = path.Join(u.Path, p)
+	return u.String()
+}
@@ -34,24 +34,67 @@ import (
 	"github.com/google/monologue/client"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/errors"
+	"github.com/google/monologue/incident"
 	"github.com/google/monologue/storage"
 )
 
 const logStr = "Certificate Submitter"
 
+// Storage interface required by Certificate Submitter.
+type Storage interface {
+	storage.APICallWriter
+	storage.SCTWriter
+}
+
 // Run runs a Certificate Submitter, which periodically issues a certificate or
 // pre-certificate, submits it to a CT Log, and checks and stores the SCT that
-// the Log returns.
-func Run(ctx context.Context, lc *client.LogClient, ca *certgen.CA, sv *ct.SignatureVerifier, st storage.APICallWriter, l *ctlog.Log, period time.Duration) {
+// the Log returns.  Submissions cycle through certificate and pre-certificate
+// chains that include the root of the CA and that leave it out, as a Log must
+// accept all of them (RFC 6962 s4.1).
+//
+// If roots is not nil, before each submission it checks whether the root of
+// the CA is in the latest root set stored in roots for the Log, and reports to
+// rep any submission that the Log rejects despite advertising the root, or
+// accepts despite not advertising it.
+func Run(ctx context.Context, lc *client.LogClient, ca *certgen.CA, sv *ct.SignatureVerifier, st Storage, roots storage.RootsReader, rep incident.Reporter, l *ctlog.Log, period time.Duration) {
 	glog.Infof("%s: %s: started with period %v", l.URL, logStr, period)
+	var w *rootWatcher
+	if roots != nil {
+		var err error
+		if w, err = watchRoots(ctx, roots, l); err != nil {
+			glog.Errorf("%s: %s: storage.RootsReader.WatchRoots() = %q; not checking submissions against advertised roots", l.URL, logStr, err)
+		}
+	}
+
+	var submissions int
 	schedule.Every(ctx, period, func(ctx context.Context) {
-		chain, sct, receivedAt, err := issueAndSubmit(ctx, lc, ca, st, l, false /* isPreSubmit */)
-		if err != nil {
+		omitRoot := submissions%2 == 1
+		isPreChain := submissions%4 >= 2
+		submissions++
+
+		var advertised, known bool
+		if w != nil {
+			var err error
+			if advertised, known, err = w.advertised(ctx, ca.Root()); err != nil {
+				glog.Errorf("%s: %s: error reading advertised roots: %s", l.URL, logStr, err)
+			}
+		}
+
+		chain, sct, apiCall, err := issueAndSubmit(ctx, lc, ca, st, l, isPreChain, omitRoot)
+		if chain == nil {
+			return
+		}
+		if known {
+			outcome := Classify(advertised, err)
+			glog.Infof("%s: %s: submission outcome: %s", l.URL, logStr, outcome)
+			reportOutcome(ctx, rep, l, ca.Root(), outcome, apiCall, err, isPreChain)
+		}
+		if err != nil || sct == nil {
 			return
 		}
 
 		// Verify the SCT.
-		errs := checkSCT(sct, chain, sv, l, *receivedAt)
+		errs := checkSCT(sct, chain, sv, l, apiCall.End)
 
 		// Log any errors found.
 		if len(errs) != 0 {
@@ -63,13 +106,23 @@ func Run(ctx context.Context, lc *client.LogClient, ca *certgen.CA, sv *ct.Signa
 			glog.Infof("%s: %s: %s", l.URL, logStr, b.String())
 		}
 
-		// TODO(katjoyce): Store the SCT & associated errors.
+		// Store the SCT & associated errors.
+		glog.Infof("%s: %s: writing SCT...", l.URL, logStr)
+		if err := st.WriteSCT(ctx, l, sct, chain, apiCall.End, errs); err != nil {
+			glog.Errorf("%s: %s: error writing SCT %v and associated errors: %s", l.URL, logStr, sct, err)
+		}
 	})
 
 	glog.Infof("%s: %s: stopped", l.URL, logStr)
 }
 
-func issueAndSubmit(ctx context.Context, lc *client.LogClient, ca *certgen.CA, st storage.APICallWriter, l *ctlog.Log, isPreChain bool) ([]*x509.Certificate, *ct.SignedCertificateTimestamp, *time.Time, error) {
+// issueAndSubmit issues a certificate or pre-certificate chain and submits it
+// to the Log, without its root if omitRoot is true.  The chain returned always
+// includes the root, so that an SCT for a pre-certificate issued directly by
+// the root can still be verified against the issuer.  If the chain cannot be
+// issued, no chain is returned; otherwise the error returned is that of the
+// submission.
+func issueAndSubmit(ctx context.Context, lc *client.LogClient, ca *certgen.CA, st storage.APICallWriter, l *ctlog.Log, isPreChain, omitRoot bool) ([]*x509.Certificate, *ct.SignedCertificateTimestamp, *apicall.APICall, error) {
 	prefix := ""
 	if isPreChain {
 		prefix = "pre-"
@@ -88,26 +141,33 @@ func issueAndSubmit(ctx context.Context, lc *client.LogClient, ca *certgen.CA, s
 		return nil, nil, nil, err
 	}
 
-	glog.Infof("%s: %s: adding %schain...", l.URL, logStr, prefix)
+	submitted := chain
+	if omitRoot {
+		submitted = certgen.WithoutRoot(chain)
+	}
+
+	glog.Infof("%s: %s: adding %schain of %d certificates...", l.URL, logStr, prefix, len(submitted))
+	endpoint := ct.AddChainStr
 	var sct *ct.SignedCertificateTimestamp
 	var httpData *client.HTTPData
 	var addErr error
 	if isPreChain {
-		sct, httpData, addErr = lc.AddPreChain(chain)
+		endpoint = ct.AddPreChainStr
+		sct, httpData, addErr = lc.AddPreChain(submitted)
 	} else {
-		sct, httpData, addErr = lc.AddChain(chain)
+		sct, httpData, addErr = lc.AddChain(submitted)
 	}
 	if len(httpData.Body) > 0 {
 		glog.Infof("%s: %s: response: %s", l.URL, logStr, httpData.Body)
 	}
 
 	// Store add-(pre-)chain API call.
-	apiCall := apicall.New(ct.AddChainStr, httpData, addErr)
+	apiCall := apicall.New(endpoint, httpData, addErr)
 	glog.Infof("%s: %s: writing API Call...", l.URL, logStr)
 	if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
 		glog.Errorf("%s: %s: error writing API Call %s: %s", l.URL, logStr, apiCall, err)
 	}
-	return chain, sct, &httpData.Timing.End, nil
+	return chain, sct, apiCall, addErr
 }
 
 func checkSCT(sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, sv *ct.SignatureVerifier, l *ctlog.Log, receivedAt time.Time) []error {
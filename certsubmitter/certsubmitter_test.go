@@ -15,16 +15,31 @@
 package certsubmitter
 
 import (
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/certgen"
+	"github.com/google/monologue/client"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/errors"
+	"github.com/google/monologue/incident"
 	"github.com/google/monologue/interval"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/memory"
 	"github.com/google/monologue/testdata"
 	"github.com/google/monologue/testonly"
+
+	itestonly "github.com/google/monologue/incident/testonly"
 )
 
 var (
@@ -158,3 +173,327 @@ func TestCheckSCT(t *testing.T) {
 		})
 	}
 }
+
+func TestClassify(t *testing.T) {
+	rejected := &client.HTTPStatusError{StatusCode: http.StatusBadRequest}
+	for _, test := range []struct {
+		advertised bool
+		addErr     error
+		want       Outcome
+	}{
+		{advertised: true, want: AcceptedAdvertised},
+		{advertised: true, addErr: rejected, want: RejectedAdvertised},
+		{advertised: false, want: AcceptedUnadvertised},
+		{advertised: false, addErr: rejected, want: RejectedUnadvertised},
+		{advertised: true, addErr: &client.HTTPStatusError{StatusCode: http.StatusForbidden}, want: RejectedAdvertised},
+		{advertised: false, addErr: &client.HTTPStatusError{StatusCode: http.StatusForbidden}, want: RejectedUnadvertised},
+		{advertised: true, addErr: &client.HTTPStatusError{StatusCode: http.StatusRequestTimeout}, want: Indeterminate},
+		{advertised: true, addErr: &client.HTTPStatusError{StatusCode: http.StatusTooManyRequests}, want: Indeterminate},
+		{advertised: false, addErr: &client.HTTPStatusError{StatusCode: http.StatusNotFound}, want: Indeterminate},
+		{advertised: true, addErr: &client.HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, want: Indeterminate},
+		{advertised: false, addErr: &client.PostError{Err: context.DeadlineExceeded}, want: Indeterminate},
+	} {
+		if got := Classify(test.advertised, test.addErr); got != test.want {
+			t.Errorf("Classify(%t, %v) = %s, want %s", test.advertised, test.addErr, got, test.want)
+		}
+	}
+}
+
+func TestAdvertised(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctl, err := ctlog.New(url, name, pubKey, mmd, tempInt)
+	if err != nil {
+		t.Fatalf("ctlog.New() = _, %s", err)
+	}
+	chain := testonly.MustCreateChain([]string{testdata.LeafCertPEM, testdata.IntermediateCertPEM, testdata.RootCertPEM})
+	leaf, intermediate, root := chain[0], chain[1], chain[2]
+
+	st := memory.New()
+	w, err := watchRoots(ctx, st, ctl)
+	if err != nil {
+		t.Fatalf("watchRoots() = _, %s", err)
+	}
+	if _, known, err := w.advertised(ctx, intermediate); known || err != nil {
+		t.Errorf("advertised() before any roots were stored = _, %t, %v; want unknown", known, err)
+	}
+
+	if err := st.WriteRoots(ctx, ctl, []*x509.Certificate{root}, time.Now()); err != nil {
+		t.Fatalf("WriteRoots() = %s", err)
+	}
+	for _, test := range []struct {
+		desc string
+		cert *x509.Certificate
+		want bool
+	}{
+		{desc: "root", cert: root, want: true},
+		{desc: "issued by root", cert: intermediate, want: true},
+		{desc: "not issued by root", cert: leaf, want: false},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			// The watcher learns of the stored roots asynchronously.
+			var got, known bool
+			for deadline := time.Now().Add(5 * time.Second); !known && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if got, known, err = w.advertised(ctx, test.cert); err != nil {
+					t.Fatalf("advertised() = _, _, %s", err)
+				}
+			}
+			if !known || got != test.want {
+				t.Errorf("advertised(%s) = %t, %t; want %t, true", test.cert.Subject, got, known, test.want)
+			}
+		})
+	}
+}
+
+func TestReportOutcome(t *testing.T) {
+	ctx := context.Background()
+	ctl, err := ctlog.New(url, name, pubKey, mmd, tempInt)
+	if err != nil {
+		t.Fatalf("ctlog.New() = _, %s", err)
+	}
+	chain := testonly.MustCreateChain([]string{testdata.LeafCertPEM, testdata.IntermediateCertPEM, testdata.RootCertPEM})
+
+	tests := []struct {
+		outcome      Outcome
+		wantSummary  string
+		wantResolved bool
+	}{
+		{outcome: RejectedAdvertised, wantSummary: RejectedAdvertisedSummary},
+		{outcome: AcceptedUnadvertised, wantSummary: AcceptedUnadvertisedSummary},
+		{outcome: AcceptedAdvertised, wantResolved: true},
+		{outcome: RejectedUnadvertised, wantResolved: true},
+		{outcome: Indeterminate},
+	}
+	for _, test := range tests {
+		t.Run(string(test.outcome), func(t *testing.T) {
+			rep := &itestonly.FakeReporter{
+				Violations:  make(chan itestonly.Report, 1),
+				Resolutions: make(chan itestonly.Resolution, 2),
+			}
+			apiCall := &apicall.APICall{Endpoint: ct.AddChainStr, Body: []byte("unknown root")}
+			reportOutcome(ctx, rep, ctl, chain[len(chain)-1], test.outcome, apiCall, &client.HTTPStatusError{StatusCode: http.StatusBadRequest}, false)
+
+			if test.wantSummary != "" {
+				if len(rep.Violations) != 1 {
+					t.Fatalf("reported %d violations, want 1", len(rep.Violations))
+				}
+				got := <-rep.Violations
+				if got.Summary != test.wantSummary || got.Category != incident.RootAcceptance || got.FullURL != url+"ct/v1/add-chain" {
+					t.Errorf("reported %+v, want %q in category %q for %s", got, test.wantSummary, incident.RootAcceptance, url+"ct/v1/add-chain")
+				}
+				if want := []incident.EvidenceRef{blob.Evidence(apiCall.Body)}; !reflect.DeepEqual(got.Evidence, want) {
+					t.Errorf("reported evidence %v, want %v", got.Evidence, want)
+				}
+			} else if len(rep.Violations) != 0 {
+				t.Errorf("reported %d violations, want none", len(rep.Violations))
+			}
+			wantResolutions := 0
+			if test.wantResolved {
+				wantResolutions = 2
+			}
+			if len(rep.Resolutions) != wantResolutions {
+				t.Errorf("reported %d resolutions, want %d", len(rep.Resolutions), wantResolutions)
+			}
+		})
+	}
+}
+
+func TestInvalidSubmissions(t *testing.T) {
+	ca, err := certgen.NewRootCA("Test Root", certgen.CertificateConfig{SubjectCommonName: "example.com", SignatureAlgorithm: x509.SHA256WithRSA})
+	if err != nil {
+		t.Fatalf("certgen.NewRootCA() = _, %s", err)
+	}
+	ctl, err := ctlog.New(url, name, pubKey, mmd, tempInt)
+	if err != nil {
+		t.Fatalf("ctlog.New() = _, %s", err)
+	}
+
+	subs := InvalidSubmissions(ca, ctl)
+	var names []string
+	for _, sub := range subs {
+		names = append(names, sub.Name)
+		if wantPolicy := strings.HasPrefix(sub.Name, "forbidden-key-"); sub.Policy != wantPolicy {
+			t.Errorf("%s: Policy = %t, want %t", sub.Name, sub.Policy, wantPolicy)
+		}
+		chain, err := sub.Chain()
+		if err != nil {
+			t.Errorf("%s: Chain() = _, %s", sub.Name, err)
+			continue
+		}
+		switch sub.Name {
+		case "untrusted-root":
+			if len(chain) != 2 || chain[1].Equal(ca.SigningCert) {
+				t.Errorf("%s: chain ends in the CA's own root", sub.Name)
+			}
+		case "broken-signature":
+			if err := chain[0].CheckSignatureFrom(chain[1]); err == nil {
+				t.Errorf("%s: leaf signature verifies", sub.Name)
+			}
+		case "empty-chain":
+			if len(chain) != 0 {
+				t.Errorf("%s: chain has %d certificates, want 0", sub.Name, len(chain))
+			}
+		case "forbidden-key-rsa1024":
+			if pub, ok := chain[0].PublicKey.(*rsa.PublicKey); !ok || pub.N.BitLen() != 1024 {
+				t.Errorf("%s: leaf has a %s key, want a 1024-bit RSA key", sub.Name, chain[0].PublicKeyAlgorithm)
+			}
+		case "not-after-before-interval":
+			if !chain[0].NotAfter.Before(tempInt.Start) {
+				t.Errorf("%s: NotAfter %s is not before %s", sub.Name, chain[0].NotAfter, tempInt.Start)
+			}
+		case "not-after-after-interval":
+			if chain[0].NotAfter.Before(tempInt.End) {
+				t.Errorf("%s: NotAfter %s is before %s", sub.Name, chain[0].NotAfter, tempInt.End)
+			}
+		}
+	}
+	want := []string{"untrusted-root", "broken-signature", "empty-chain", "precert-to-add-chain", "cert-to-add-pre-chain", "forbidden-key-rsa1024", "forbidden-key-ecdsa-p224", "not-after-before-interval", "not-after-after-interval"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("InvalidSubmissions() = %v, want %v", names, want)
+	}
+
+	ctl.TemporalInterval = nil
+	if got := len(InvalidSubmissions(ca, ctl)); got != len(want)-2 {
+		t.Errorf("InvalidSubmissions() for a Log without a temporal interval returned %d submissions, want %d", got, len(want)-2)
+	}
+}
+
+func TestSubmitInvalid(t *testing.T) {
+	ctx := context.Background()
+	// The fake Log accepts anything submitted to add-pre-chain, and rejects
+	// everything submitted to add-chain.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == ct.AddPreChainPath {
+			w.Write([]byte(testdata.SCT))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	ctl, err := ctlog.New(ts.URL+"/", name, pubKey, mmd, tempInt)
+	if err != nil {
+		t.Fatalf("ctlog.New() = _, %s", err)
+	}
+	lc := client.New(ctl.URL, ts.Client())
+	ca, err := certgen.NewRootCA("Test Root", certgen.CertificateConfig{SubjectCommonName: "example.com", SignatureAlgorithm: x509.SHA256WithRSA})
+	if err != nil {
+		t.Fatalf("certgen.NewRootCA() = _, %s", err)
+	}
+	chain := func() ([]*x509.Certificate, error) { return ca.IssueCertificateChain() }
+
+	for _, test := range []struct {
+		sub          *InvalidSubmission
+		wantAccepted bool
+	}{
+		{sub: &InvalidSubmission{Name: "rejected", Chain: chain}},
+		{sub: &InvalidSubmission{Name: "accepted", PreChain: true, Chain: chain}, wantAccepted: true},
+		{sub: &InvalidSubmission{Name: "accepted-policy", PreChain: true, Policy: true, Chain: chain}, wantAccepted: true},
+	} {
+		t.Run(test.sub.Name, func(t *testing.T) {
+			st := memory.New()
+			rep := &itestonly.FakeReporter{
+				Updates:     make(chan itestonly.Report, 1),
+				Violations:  make(chan itestonly.Report, 1),
+				Resolutions: make(chan itestonly.Resolution, 1),
+			}
+			submitInvalid(ctx, lc, st, rep, ctl, test.sub)
+
+			if got := len(st.APICalls(ctl.Name)); got != 1 {
+				t.Errorf("stored %d API calls, want 1", got)
+			}
+			wantSummary := InvalidSubmissionAcceptedSummary + ": " + test.sub.Name
+			if !test.wantAccepted {
+				if len(rep.Updates) != 0 || len(rep.Violations) != 0 || len(rep.Resolutions) != 1 {
+					t.Fatalf("reported %d updates, %d violations and %d resolutions, want 1 resolution", len(rep.Updates), len(rep.Violations), len(rep.Resolutions))
+				}
+				if got := (<-rep.Resolutions).Summary; got != wantSummary {
+					t.Errorf("resolved %q, want %q", got, wantSummary)
+				}
+				return
+			}
+			// Submissions rejected only as a matter of policy are
+			// reported as Warnings, rather than as violations.
+			reports, wantSeverity := rep.Violations, incident.Critical
+			if test.sub.Policy {
+				reports, wantSeverity = rep.Updates, incident.Warning
+			}
+			if len(reports) != 1 || len(rep.Updates)+len(rep.Violations) != 1 || len(rep.Resolutions) != 0 {
+				t.Fatalf("reported %d updates, %d violations and %d resolutions, want 1 %s report", len(rep.Updates), len(rep.Violations), len(rep.Resolutions), wantSeverity)
+			}
+			got := <-reports
+			if got.Summary != wantSummary || got.Category != incident.InvalidSubmission || got.Severity != wantSeverity {
+				t.Errorf("reported %q in category %q with severity %q, want %q in category %q with severity %q", got.Summary, got.Category, got.Severity, wantSummary, incident.InvalidSubmission, wantSeverity)
+			}
+			scts := st.SCTs(ctl.Name)
+			if len(scts) != 1 || len(scts[0].Errs) != 1 {
+				t.Fatalf("stored SCTs %+v, want 1 SCT with 1 error", scts)
+			}
+			if _, ok := scts[0].Errs[0].(*InvalidSubmissionAcceptedError); !ok {
+				t.Errorf("stored SCT error %T, want *InvalidSubmissionAcceptedError", scts[0].Errs[0])
+			}
+		})
+	}
+}
+
+func TestIssueAndSubmitWithAndWithoutRoot(t *testing.T) {
+	ctx := context.Background()
+	type submission struct {
+		path  string
+		certs int
+	}
+	submitted := make(chan submission, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ct.AddChainRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		submitted <- submission{path: r.URL.Path, certs: len(req.Chain)}
+		w.Write([]byte(testdata.SCT))
+	}))
+	defer ts.Close()
+	ctl, err := ctlog.New(ts.URL+"/", name, pubKey, mmd, nil)
+	if err != nil {
+		t.Fatalf("ctlog.New() = _, %s", err)
+	}
+	lc := client.New(ctl.URL, ts.Client())
+
+	root, err := certgen.NewRootCA("Test Root", certgen.CertificateConfig{SubjectCommonName: "example.com"})
+	if err != nil {
+		t.Fatalf("certgen.NewRootCA() = _, %s", err)
+	}
+	ca, err := certgen.NewHierarchy(root, certgen.HierarchyOptions{Intermediates: 1})
+	if err != nil {
+		t.Fatalf("certgen.NewHierarchy() = _, %s", err)
+	}
+
+	for _, test := range []struct {
+		isPreChain   bool
+		omitRoot     bool
+		wantEndpoint ct.APIEndpoint
+		want         int
+	}{
+		{omitRoot: false, wantEndpoint: ct.AddChainStr, want: 3},
+		{omitRoot: true, wantEndpoint: ct.AddChainStr, want: 2},
+		{isPreChain: true, omitRoot: false, wantEndpoint: ct.AddPreChainStr, want: 3},
+		{isPreChain: true, omitRoot: true, wantEndpoint: ct.AddPreChainStr, want: 2},
+	} {
+		chain, _, apiCall, err := issueAndSubmit(ctx, lc, ca, memory.New(), ctl, test.isPreChain, test.omitRoot)
+		if err != nil {
+			t.Fatalf("issueAndSubmit(isPreChain: %t, omitRoot: %t) = _, _, _, %s", test.isPreChain, test.omitRoot, err)
+		}
+		got := <-submitted
+		if got.certs != test.want {
+			t.Errorf("issueAndSubmit(isPreChain: %t, omitRoot: %t) submitted %d certificates, want %d", test.isPreChain, test.omitRoot, got.certs, test.want)
+		}
+		if len(chain) != 3 {
+			t.Errorf("issueAndSubmit(isPreChain: %t, omitRoot: %t) returned %d certificates, want 3", test.isPreChain, test.omitRoot, len(chain))
+		}
+		if gotPre := chain[0].IsPrecertificate(); gotPre != test.isPreChain {
+			t.Errorf("issueAndSubmit(isPreChain: %t, omitRoot: %t) issued a precertificate: %t, want %t", test.isPreChain, test.omitRoot, gotPre, test.isPreChain)
+		}
+		if !strings.HasSuffix(got.path, "/"+string(test.wantEndpoint)) || apiCall.Endpoint != test.wantEndpoint {
+			t.Errorf("issueAndSubmit(isPreChain: %t, omitRoot: %t) submitted to %q and stored endpoint %q, want %q", test.isPreChain, test.omitRoot, got.path, apiCall.Endpoint, test.wantEndpoint)
+		}
+	}
+}
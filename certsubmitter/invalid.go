@@ -0,0 +1,249 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package certsubmitter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/schedule"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/certgen"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/interval"
+	"github.com/google/monologue/storage/blob"
+)
+
+// InvalidSubmissionAcceptedSummary is the summary of the incident reported
+// when a Log returns an SCT for an InvalidSubmission, followed by the name of
+// the submission.  The incident is resolved once the Log rejects the
+// submission.
+const InvalidSubmissionAcceptedSummary = "SCT issued for invalid submission"
+
+// InvalidSubmission is a deliberately invalid submission, which a Log must, or
+// as a matter of policy should, reject.
+type InvalidSubmission struct {
+	// Name identifies the submission, e.g. "untrusted-root".
+	Name string
+	// Description explains why the Log should reject the submission.
+	Description string
+	// Policy is whether the submission is only expected to be rejected as a
+	// matter of policy, rather than being one that the Log must reject.  If
+	// the Log accepts it, a Warning is reported rather than a violation.
+	Policy bool
+	// PreChain is whether the chain is submitted to add-pre-chain, rather
+	// than add-chain.
+	PreChain bool
+	// Chain issues the chain to submit.
+	Chain func() ([]*x509.Certificate, error)
+	// RFCSections are the sections of RFC 6962, if any, that require the Log
+	// to reject the submission.
+	RFCSections []string
+}
+
+// InvalidSubmissions returns the catalogue of invalid submissions that can be
+// made to l using chains issued by ca.  Submissions of certificates whose
+// NotAfter is outside the temporal interval of l are only included if l is a
+// temporal shard.
+func InvalidSubmissions(ca *certgen.CA, l *ctlog.Log) []*InvalidSubmission {
+	subs := []*InvalidSubmission{
+		{
+			Name:        "untrusted-root",
+			Description: "a chain to a newly generated root that the Log cannot accept",
+			Chain: func() ([]*x509.Certificate, error) {
+				untrusted, err := certgen.NewRootCA("Monologue Untrusted Root", ca.CertConfig)
+				if err != nil {
+					return nil, err
+				}
+				return untrusted.IssueCertificateChain()
+			},
+			RFCSections: []string{"RFC 6962 s3.1"},
+		},
+		{
+			Name:        "broken-signature",
+			Description: "a chain whose leaf certificate signature does not verify",
+			Chain: func() ([]*x509.Certificate, error) {
+				chain, err := ca.IssueCertificateChain()
+				if err != nil {
+					return nil, err
+				}
+				if chain[0], err = certgen.CorruptSignature(chain[0]); err != nil {
+					return nil, err
+				}
+				return chain, nil
+			},
+			RFCSections: []string{"RFC 6962 s3.1"},
+		},
+		{
+			Name:        "empty-chain",
+			Description: "an empty chain",
+			Chain: func() ([]*x509.Certificate, error) {
+				return []*x509.Certificate{}, nil
+			},
+			RFCSections: []string{"RFC 6962 s4.1"},
+		},
+		{
+			Name:        "precert-to-add-chain",
+			Description: "a precertificate chain submitted to add-chain",
+			Chain:       ca.IssuePrecertificateChain,
+			RFCSections: []string{"RFC 6962 s4.1"},
+		},
+		{
+			Name:        "cert-to-add-pre-chain",
+			Description: "a final certificate chain submitted to add-pre-chain",
+			PreChain:    true,
+			Chain:       ca.IssueCertificateChain,
+			RFCSections: []string{"RFC 6962 s4.2"},
+		},
+	}
+
+	for _, k := range certgen.KeyTypes {
+		if !k.Forbidden() {
+			continue
+		}
+		k := k
+		subs = append(subs, &InvalidSubmission{
+			Name:        "forbidden-key-" + string(k),
+			Description: fmt.Sprintf("a certificate with a %s key, which is forbidden for publicly-trusted certificates", k),
+			Policy:      true,
+			Chain: func() ([]*x509.Certificate, error) {
+				weak := *ca
+				weak.CertConfig.KeyType = k
+				// Keys of forbidden types are rarely needed, so are
+				// generated directly rather than kept ready in the
+				// pool.
+				weak.KeyPool = nil
+				return weak.IssueCertificateChain()
+			},
+		})
+	}
+
+	if ti := l.TemporalInterval; ti != nil {
+		outside := func(i *interval.Interval) func() ([]*x509.Certificate, error) {
+			return func() ([]*x509.Certificate, error) {
+				shifted := *ca
+				shifted.CertConfig.NotAfterInterval = i
+				return shifted.IssueCertificateChain()
+			}
+		}
+		const day = 24 * time.Hour
+		subs = append(subs,
+			&InvalidSubmission{
+				Name:        "not-after-before-interval",
+				Description: fmt.Sprintf("a certificate whose NotAfter is before the start of the Log's temporal interval, %s", ti.Start.UTC().Format(time.RFC3339)),
+				Chain:       outside(&interval.Interval{Start: ti.Start.Add(-30 * day), End: ti.Start}),
+			},
+			&InvalidSubmission{
+				Name:        "not-after-after-interval",
+				Description: fmt.Sprintf("a certificate whose NotAfter is at or after the end of the Log's temporal interval, %s", ti.End.UTC().Format(time.RFC3339)),
+				Chain:       outside(&interval.Interval{Start: ti.End, End: ti.End.Add(30 * day)}),
+			},
+		)
+	}
+	return subs
+}
+
+// InvalidSubmissionAcceptedError indicates that a Log returned an SCT for an
+// InvalidSubmission.
+type InvalidSubmissionAcceptedError struct {
+	Name string
+}
+
+func (e *InvalidSubmissionAcceptedError) Error() string {
+	return fmt.Sprintf("SCT issued for invalid submission %q", e.Name)
+}
+
+// RunInvalid runs an Invalid Submitter, which periodically submits each of the
+// InvalidSubmissions for l to the Log, and reports to rep each one for which
+// the Log returns an SCT.  As Logs should not have to deal with many invalid
+// submissions, period should be long, e.g. a day.
+func RunInvalid(ctx context.Context, lc *client.LogClient, ca *certgen.CA, st Storage, rep incident.Reporter, l *ctlog.Log, period time.Duration) {
+	glog.Infof("%s: %s: invalid submissions started with period %v", l.URL, logStr, period)
+	subs := InvalidSubmissions(ca, l)
+	schedule.Every(ctx, period, func(ctx context.Context) {
+		for _, sub := range subs {
+			submitInvalid(ctx, lc, st, rep, l, sub)
+		}
+	})
+	glog.Infof("%s: %s: invalid submissions stopped", l.URL, logStr)
+}
+
+// submitInvalid submits sub to the Log, stores the API call, and reports an
+// incident if the Log returns an SCT for it.
+func submitInvalid(ctx context.Context, lc *client.LogClient, st Storage, rep incident.Reporter, l *ctlog.Log, sub *InvalidSubmission) {
+	chain, err := sub.Chain()
+	if err != nil {
+		glog.Errorf("%s: %s: error issuing invalid submission %q: %s", l.URL, logStr, sub.Name, err)
+		return
+	}
+
+	glog.Infof("%s: %s: submitting invalid submission %q...", l.URL, logStr, sub.Name)
+	endpoint := ct.AddChainStr
+	var sct *ct.SignedCertificateTimestamp
+	var httpData *client.HTTPData
+	var addErr error
+	if sub.PreChain {
+		endpoint = ct.AddPreChainStr
+		sct, httpData, addErr = lc.AddPreChain(chain)
+	} else {
+		sct, httpData, addErr = lc.AddChain(chain)
+	}
+	if httpData == nil {
+		glog.Errorf("%s: %s: error submitting invalid submission %q: %s", l.URL, logStr, sub.Name, addErr)
+		return
+	}
+
+	apiCall := apicall.New(endpoint, httpData, addErr)
+	if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
+		glog.Errorf("%s: %s: error writing API Call %s: %s", l.URL, logStr, apiCall, err)
+	}
+
+	summary := fmt.Sprintf("%s: %s", InvalidSubmissionAcceptedSummary, sub.Name)
+	if addErr == nil && sct != nil {
+		glog.Warningf("%s: %s: SCT issued for invalid submission %q", l.URL, logStr, sub.Name)
+		errs := []error{&InvalidSubmissionAcceptedError{Name: sub.Name}}
+		if err := st.WriteSCT(ctx, l, sct, chain, httpData.Timing.End, errs); err != nil {
+			glog.Errorf("%s: %s: error writing SCT %v and associated errors: %s", l.URL, logStr, sct, err)
+		}
+		inc := &incident.Incident{
+			BaseURL:     l.URL,
+			Summary:     summary,
+			FullURL:     addChainURL(l, sub.PreChain),
+			Details:     fmt.Sprintf("%s returned SCT %v for %s, which it must reject.", l.Name, sct, sub.Description),
+			IsViolation: true,
+			Category:    incident.InvalidSubmission,
+			Severity:    incident.Critical,
+			LogID:       l.LogID,
+			Evidence:    []incident.EvidenceRef{blob.Evidence(apiCall.Body)},
+			RFCSections: sub.RFCSections,
+		}
+		if sub.Policy {
+			inc.Details = fmt.Sprintf("%s returned SCT %v for %s, which Logs are expected to reject as a matter of policy.", l.Name, sct, sub.Description)
+			inc.IsViolation = false
+			inc.Severity = incident.Warning
+		}
+		incident.Report(ctx, rep, inc)
+		return
+	}
+	if isRejection(addErr) {
+		incident.LogResolved(ctx, rep, l.URL, summary)
+	}
+}
@@ -319,7 +319,8 @@ func (lc *LogClient) postAndParse(path string, body []byte, rsp interface{}) (*H
 }
 
 func (lc *LogClient) addChain(path string, chain []*x509.Certificate) (*ct.SignedCertificateTimestamp, *HTTPData, error) {
-	var req ct.AddChainRequest
+	// An empty chain is sent as an empty array, rather than null.
+	req := ct.AddChainRequest{Chain: [][]byte{}}
 	for _, cert := range chain {
 		req.Chain = append(req.Chain, cert.Raw)
 	}
@@ -26,10 +26,13 @@ import (
 	"time"
 
 	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/monologue/certgen"
 	"github.com/google/monologue/certsubmitter"
 	"github.com/google/monologue/client"
 	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/rootsanalyzer"
 	"github.com/google/monologue/rootsgetter"
 	"github.com/google/monologue/sthgetter"
 	"github.com/google/monologue/storage"
@@ -49,9 +52,21 @@ type Config struct {
 	// How regularly the monitor should submit a (pre-)certificate to the Log.
 	// To disable (pre-)certificate submission, set to 0.
 	AddChainPeriod time.Duration
+	// How regularly the monitor should submit each of the deliberately invalid
+	// chains that the Log must reject.  This should be long, e.g. a day.
+	// To disable invalid submissions, set to 0.
+	InvalidSubmissionPeriod time.Duration
 	// The CA that issues certificates for submission to the Log.  Must be set
-	// if AddChainPeriod != 0.
+	// if AddChainPeriod != 0 or InvalidSubmissionPeriod != 0.
 	CA *certgen.CA
+	// Reporter records incidents found while collecting data.  If nil,
+	// incidents are only logged.
+	Reporter incident.Reporter
+	// Roots reads the root sets collected from the Log, so that each
+	// submission can be checked against the roots that the Log advertises.
+	// If nil, and both roots and submissions are being collected, the roots
+	// collected are kept in memory for this purpose.
+	Roots storage.RootsReader
 }
 
 // Storage is an interface containing all of the storage methods required by
@@ -60,6 +75,7 @@ type Config struct {
 type Storage interface {
 	storage.APICallWriter
 	storage.RootsWriter
+	storage.SCTWriter
 	storage.STHWriter
 }
 
@@ -74,31 +90,49 @@ func Run(ctx context.Context, cfg *Config, cl *http.Client, st Storage) error {
 	}
 
 	lc := client.New(cfg.Log.URL, cl)
+	rep := cfg.Reporter
+	if rep == nil {
+		rep = &incident.LoggingReporter{}
+	}
 
 	sv, err := ct.NewSignatureVerifier(cfg.Log.PublicKey)
 	if err != nil {
 		return fmt.Errorf("couldn't create signature verifier: %s", err)
 	}
 
+	roots := cfg.Roots
+	if roots == nil && cfg.GetRootsPeriod > 0 && cfg.AddChainPeriod > 0 {
+		latest := newLatestRoots()
+		st = &rootsTee{Storage: st, latest: latest}
+		roots = latest
+	}
+
 	var wg sync.WaitGroup
 	if cfg.GetSTHPeriod > 0 {
 		wg.Add(1)
 		go func() {
-			sthgetter.Run(ctx, lc, sv, st, cfg.Log, cfg.GetSTHPeriod)
+			sthgetter.Run(ctx, lc, sv, st, rep, cfg.Log, cfg.GetSTHPeriod)
 			wg.Done()
 		}()
 	}
 	if cfg.GetRootsPeriod > 0 {
 		wg.Add(1)
 		go func() {
-			rootsgetter.Run(ctx, lc, st, cfg.Log, cfg.GetRootsPeriod)
+			rootsgetter.Run(ctx, lc, st, rep, cfg.Log, cfg.GetRootsPeriod)
 			wg.Done()
 		}()
 	}
 	if cfg.AddChainPeriod > 0 {
 		wg.Add(1)
 		go func() {
-			certsubmitter.Run(ctx, lc, cfg.CA, sv, st, cfg.Log, cfg.AddChainPeriod)
+			certsubmitter.Run(ctx, lc, cfg.CA, sv, st, roots, rep, cfg.Log, cfg.AddChainPeriod)
+			wg.Done()
+		}()
+	}
+	if cfg.InvalidSubmissionPeriod > 0 {
+		wg.Add(1)
+		go func() {
+			certsubmitter.RunInvalid(ctx, lc, cfg.CA, st, rep, cfg.Log, cfg.InvalidSubmissionPeriod)
 			wg.Done()
 		}()
 	}
@@ -106,3 +140,116 @@ func Run(ctx context.Context, cfg *Config, cl *http.Client, st Storage) error {
 
 	return nil
 }
+
+// rootsTee is a Storage that also records the latest roots written for each
+// Log in memory.
+type rootsTee struct {
+	Storage
+	latest *latestRoots
+}
+
+func (s *rootsTee) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	if err := s.latest.WriteRoots(ctx, l, roots, receivedAt); err != nil {
+		return err
+	}
+	return s.Storage.WriteRoots(ctx, l, roots, receivedAt)
+}
+
+// latestRoots is a storage.RootsReader that keeps only the latest root set
+// written for each Log, which is all that is needed to check submissions
+// against the roots that a Log advertises, so that its memory use does not
+// grow as roots are written.  Watchers are only guaranteed to be sent the
+// latest RootSetID: any that they have not read yet are replaced by it.
+type latestRoots struct {
+	mu       sync.Mutex
+	latest   map[string]storage.RootSetID
+	roots    map[storage.RootSetID][]*x509.Certificate
+	watchers map[string][]chan storage.RootSetID
+}
+
+func newLatestRoots() *latestRoots {
+	return &latestRoots{
+		latest:   make(map[string]storage.RootSetID),
+		roots:    make(map[storage.RootSetID][]*x509.Certificate),
+		watchers: make(map[string][]chan storage.RootSetID),
+	}
+}
+
+// WriteRoots makes roots the latest root set of l, forgetting any root set
+// that is no longer the latest of any Log, and notifies the watchers of l.
+func (r *latestRoots) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	id, err := rootsanalyzer.GenerateSetID(roots)
+	if err != nil {
+		return fmt.Errorf("unable to generate RootSetID: %s", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latest[l.Name] = id
+	r.roots[id] = append([]*x509.Certificate(nil), roots...)
+	inUse := make(map[storage.RootSetID]bool)
+	for _, id := range r.latest {
+		inUse[id] = true
+	}
+	for id := range r.roots {
+		if !inUse[id] {
+			delete(r.roots, id)
+		}
+	}
+	for _, c := range r.watchers[l.Name] {
+		send(c, id)
+	}
+	return nil
+}
+
+// send sends id on c, which has a buffer of one, replacing any RootSetID that
+// has not been read yet.
+func send(c chan storage.RootSetID, id storage.RootSetID) {
+	select {
+	case <-c:
+	default:
+	}
+	select {
+	case c <- id:
+	default:
+	}
+}
+
+// WatchRoots sends the RootSetID of the latest roots of l over the returned
+// channel, immediately if there are any, and whenever new roots are written,
+// until ctx is cancelled.
+func (r *latestRoots) WatchRoots(ctx context.Context, l *ctlog.Log) (<-chan storage.RootSetID, error) {
+	c := make(chan storage.RootSetID, 1)
+	r.mu.Lock()
+	if id, ok := r.latest[l.Name]; ok {
+		send(c, id)
+	}
+	r.watchers[l.Name] = append(r.watchers[l.Name], c)
+	r.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		ws := r.watchers[l.Name]
+		for i := range ws {
+			if ws[i] == c {
+				r.watchers[l.Name] = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+	}()
+	return c, nil
+}
+
+// ReadRoots returns the roots of the root set with the given ID, if it is still
+// the latest root set of a Log.
+func (r *latestRoots) ReadRoots(ctx context.Context, rootSet storage.RootSetID) ([]*x509.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	roots, ok := r.roots[rootSet]
+	if !ok {
+		return nil, fmt.Errorf("root set %X is no longer the latest of any Log", string(rootSet))
+	}
+	return roots, nil
+}
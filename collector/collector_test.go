@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/testdata"
+	"github.com/google/monologue/testonly"
+)
+
+func TestLatestRoots(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+	chain := testonly.MustCreateChain([]string{testdata.IntermediateCertPEM, testdata.RootCertPEM})
+	sets := [][]*x509.Certificate{{chain[1]}, {chain[0], chain[1]}, {chain[0]}}
+	ids := make([]storage.RootSetID, len(sets))
+	for i, roots := range sets {
+		id, err := rootsanalyzer.GenerateSetID(roots)
+		if err != nil {
+			t.Fatalf("GenerateSetID() = _, %s", err)
+		}
+		ids[i] = id
+	}
+
+	r := newLatestRoots()
+	if err := r.WriteRoots(ctx, l, sets[0], time.Now()); err != nil {
+		t.Fatalf("WriteRoots() = %s", err)
+	}
+	c, err := r.WatchRoots(ctx, l)
+	if err != nil {
+		t.Fatalf("WatchRoots() = _, %s", err)
+	}
+	if got := <-c; got != ids[0] {
+		t.Errorf("WatchRoots() sent %X first, want %X", got, ids[0])
+	}
+
+	// Root sets that are written without being read by the watcher do not
+	// block WriteRoots, and only the latest is sent.
+	for _, roots := range sets[1:] {
+		if err := r.WriteRoots(ctx, l, roots, time.Now()); err != nil {
+			t.Fatalf("WriteRoots() = %s", err)
+		}
+	}
+	if got := <-c; got != ids[2] {
+		t.Errorf("WatchRoots() sent %X, want latest %X", got, ids[2])
+	}
+	select {
+	case id := <-c:
+		t.Errorf("WatchRoots() sent %X, want nothing more", id)
+	default:
+	}
+
+	if roots, err := r.ReadRoots(ctx, ids[2]); err != nil || len(roots) != 1 {
+		t.Errorf("ReadRoots(latest) = %d roots, %v; want 1 root", len(roots), err)
+	}
+	for _, id := range ids[:2] {
+		if _, err := r.ReadRoots(ctx, id); err == nil {
+			t.Errorf("ReadRoots(%X) = _, nil; want error for a root set that is no longer the latest", id)
+		}
+	}
+	if len(r.roots) != 1 {
+		t.Errorf("kept %d root sets, want 1", len(r.roots))
+	}
+}
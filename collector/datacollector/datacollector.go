@@ -18,27 +18,50 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/smtp"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/golang/glog"
-	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/certificate-transparency-go/x509util"
+	"github.com/google/monologue/apicall"
 	"github.com/google/monologue/certgen"
 	"github.com/google/monologue/collector"
 	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/email"
+	"github.com/google/monologue/incident/local"
+	incidentmysql "github.com/google/monologue/incident/mysql"
+	"github.com/google/monologue/incident/route"
+	"github.com/google/monologue/incident/silence"
+	"github.com/google/monologue/incident/webhook"
+	"github.com/google/monologue/interval"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/buffered"
+	"github.com/google/monologue/storage/file"
+	"github.com/google/monologue/storage/mysql"
 	"github.com/google/monologue/storage/print"
 	"github.com/google/trillian/crypto/keys/pem"
+	"github.com/google/trillian/monitoring/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	getRootsPeriod = flag.Duration("get_roots_period", 0, "How regularly the monitor should get root certificates from the Log")
 	getSTHPeriod   = flag.Duration("get_sth_period", 0, "How regularly the monitor should get an STH from the Log")
 	addChainPeriod = flag.Duration("add_chain_period", 0, "How regularly the monitor should submit a (pre-)certificate to the Log")
+
+	invalidSubmissionPeriod = flag.Duration("invalid_submission_period", 0, "How regularly the monitor should submit each of the deliberately invalid chains that the Log must reject. Should be long, e.g. 24h")
 	// TODO(katjoyce): Change to read from log_list.json or all_logs_list.json to get Log details.
 	// TODO(katjoyce): Add ability to run against multiple Logs.
 	logURL    = flag.String("log_url", "", "The URL of the Log to monitor, e.g. https://ct.googleapis.com/pilot/")
@@ -46,8 +69,42 @@ var (
 	b64PubKey = flag.String("public_key", "", "The base64-encoded public key of the Log to monitor")
 	mmd       = flag.Duration("mmd", 24*time.Hour, "The Maximum Merge Delay for the Log")
 
-	signingCertFile = flag.String("signing_cert", "", "Path to the certificate containing the public key that corresponds to the signing key. Only needed if add_chain_period is not 0")
-	signingKeyFile  = flag.String("signing_key", "", "Path to the private key for signing certificates to submit to the Log. Only needed if add_chain_period is not 0")
+	temporalStart = flag.String("temporal_interval_start", "", "If the Log is a temporal shard, the start of the interval in which the NotAfter of the certificates it accepts must fall, in RFC 3339 format")
+	temporalEnd   = flag.String("temporal_interval_end", "", "If the Log is a temporal shard, the end of the interval in which the NotAfter of the certificates it accepts must fall, in RFC 3339 format")
+
+	storageDir      = flag.String("storage_dir", "", "Directory in which to write everything collected, as JSON Lines files. If unset, everything collected is only logged")
+	storageMaxBytes = flag.Int64("storage_max_bytes", 100<<20, "Size beyond which files in storage_dir are rotated; 0 to disable")
+	storageMaxAge   = flag.Duration("storage_max_age", 24*time.Hour, "Age beyond which files in storage_dir are rotated; 0 to disable")
+	storageCompress = flag.Bool("storage_compress", true, "Whether to gzip files in storage_dir once they have been rotated")
+	storageQueue    = flag.Int("storage_queue_size", buffered.DefaultQueueSize, "Number of writes that can be queued for storage, so that slow storage does not delay requests to the Log; 0 to write synchronously")
+	storageMaxBlock = flag.Duration("storage_max_block", time.Second, "How long to wait for space in a full storage queue before dropping a write")
+	storageBatch    = flag.Int("storage_batch_size", buffered.DefaultBatchSize, "Maximum number of queued writes to flush to storage together")
+	metricsEndpoint = flag.String("metrics_endpoint", "", "Endpoint for serving metrics; if left empty, metrics will not be exposed")
+	blobDir         = flag.String("blob_dir", "", "Directory in which to store response bodies, once each, keyed by their SHA-256 hash. If unset, response bodies are stored with the rest of each API call")
+
+	incidentsMySQLURI   = flag.String("incidents_mysql_uri", "", "URI of a MySQL database in which to record incidents (parseTime=true is always set). If unset, incidents are only logged")
+	incidentsFile       = flag.String("incidents_file", "", "Path to a JSON file in which to record incidents, for deployments without a MySQL database. It can be queried with the incidenttool")
+	incidentGroupWindow = flag.Duration("incident_group_window", time.Hour, "How soon after its last occurrence a repeated incident is grouped with it, rather than recorded as a new incident; 0 to disable")
+	webhookURL          = flag.String("incident_webhook_url", "", "URL of a webhook to POST incidents to. If unset, incidents are not sent to a webhook")
+	webhookSecretFile   = flag.String("incident_webhook_secret_file", "", "Path to a file containing the secret with which to sign requests to incident_webhook_url")
+	webhookQueueDir     = flag.String("incident_webhook_queue_dir", "", "Directory in which to keep incidents that could not be sent to incident_webhook_url, to be retried later")
+	smtpAddr            = flag.String("incident_smtp_addr", "", "host:port of an SMTP relay through which to email incidents. If unset, incidents are not emailed")
+	smtpUser            = flag.String("incident_smtp_user", "", "Username with which to authenticate to incident_smtp_addr, if it requires authentication")
+	smtpPasswordFile    = flag.String("incident_smtp_password_file", "", "Path to a file containing the password for incident_smtp_user")
+	emailFrom           = flag.String("incident_email_from", "", "Address from which incidents are emailed")
+	emailTo             = flag.String("incident_email_to", "", "Comma-separated addresses to which incidents are emailed")
+	emailDigestPeriod   = flag.Duration("incident_email_digest_period", email.DefaultDigestPeriod, "How often to email a digest of incidents that were not emailed immediately")
+	silencesFile        = flag.String("incident_silences_file", "", "Path to a JSON file of silences, during which matching incidents are recorded as suppressed rather than reported. It is rewritten when silences are changed through the API served on incident_silences_endpoint")
+	silencesEndpoint    = flag.String("incident_silences_endpoint", "", "Endpoint for serving the API for changing incident_silences_file; if left empty, it will not be exposed. The API is unauthenticated, so this must only be reachable by trusted operators, e.g. localhost:6963")
+	silencesAuditFile   = flag.String("incident_silences_audit_file", "", "Path to a file to which every change to the silences is appended")
+	incidentRoutes      = flag.String("incident_routes", "", "Path to a JSON file of rules choosing which of log, mysql, file, webhook and email each incident is sent to. If unset, every incident is sent to mysql, file, webhook and email, whichever are configured, or else only logged")
+
+	signingCertFile = flag.String("signing_cert", "", "Path to the certificate containing the public key that corresponds to the signing key. Only needed if add_chain_period or invalid_submission_period is not 0")
+	signingKeyFile  = flag.String("signing_key", "", "Path to the private key for signing certificates to submit to the Log. Only needed if add_chain_period or invalid_submission_period is not 0")
+	intermediates   = flag.Int("ca_intermediates", 0, "Number of intermediate CAs to generate beneath signing_cert, through which submitted certificates are issued, so that submitted chains are leaf, intermediates and root")
+	precertSigner   = flag.Bool("ca_precert_signer", false, "Whether to generate a Precertificate Signing Certificate to issue submitted precertificates")
+	keyPoolSize     = flag.Int("key_pool_size", certgen.DefaultKeyPoolSize, "Number of leaf keys to generate in advance, so that generating them does not delay submissions to the Log; 0 to generate each key as it is needed")
+	leafKeyType     = flag.String("leaf_key_type", string(certgen.DefaultKeyType), "Type of key to generate for each certificate submitted to the Log: one of rsa2048, rsa3072, rsa4096, ecdsa-p256, ecdsa-p384 or ed25519. Certificates are signed with an algorithm that suits the signing key")
 )
 
 func main() {
@@ -62,18 +119,49 @@ func main() {
 		glog.Exit("No public key provided.")
 	}
 
-	ctx := context.Background()
-	l, err := ctlog.New(*logURL, *logName, *b64PubKey, *mmd, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		glog.Infof("Received %s, shutting down", sig)
+		cancel()
+	}()
+
+	if *metricsEndpoint != "" {
+		http.Handle("/metrics", promhttp.Handler())
+		go func() {
+			glog.Exit(http.ListenAndServe(*metricsEndpoint, nil))
+		}()
+	}
+
+	ti, err := temporalInterval(*temporalStart, *temporalEnd)
+	if err != nil {
+		glog.Exitf("Invalid temporal interval: %s", err)
+	}
+	l, err := ctlog.New(*logURL, *logName, *b64PubKey, *mmd, ti)
 	if err != nil {
 		glog.Exitf("Unable to obtain Log metadata: %s", err)
 	}
 
 	var ca *certgen.CA
-	if *addChainPeriod > 0 {
-		var err error
-		if ca, err = setupCA(l, *signingCertFile, *signingKeyFile); err != nil {
+	if *addChainPeriod > 0 || *invalidSubmissionPeriod > 0 {
+		keyType, err := certgen.ParseKeyType(*leafKeyType)
+		if err != nil {
+			glog.Exitf("Invalid leaf_key_type: %s", err)
+		}
+		if ca, err = setupCA(l, *signingCertFile, *signingKeyFile, keyType, *intermediates, *precertSigner); err != nil {
 			glog.Exitf("Unable to create CA: %s", err)
 		}
+		if *keyPoolSize > 0 {
+			ca.KeyPool = certgen.NewKeyPool(certgen.KeyPoolOptions{
+				Size:          *keyPoolSize,
+				KeyTypes:      []certgen.KeyType{keyType},
+				MetricFactory: prometheus.MetricFactory{},
+			})
+			defer ca.KeyPool.Close()
+		}
 	}
 
 	cfg := &collector.Config{
@@ -82,14 +170,226 @@ func main() {
 		GetRootsPeriod: *getRootsPeriod,
 		AddChainPeriod: *addChainPeriod,
 		CA:             ca,
+
+		InvalidSubmissionPeriod: *invalidSubmissionPeriod,
 	}
 
-	if err := collector.Run(ctx, cfg, &http.Client{}, &print.Storage{}); err != nil {
+	rep, closeRep, err := setupReporter(ctx, l)
+	if err != nil {
+		glog.Exitf("Unable to create incident reporter: %s", err)
+	}
+	defer closeRep()
+	cfg.Reporter = rep
+
+	var st collector.Storage = &print.Storage{}
+	if *storageDir != "" {
+		fs, err := file.New(file.Options{
+			Dir:      *storageDir,
+			Prefix:   l.Name,
+			MaxBytes: *storageMaxBytes,
+			MaxAge:   *storageMaxAge,
+			Compress: *storageCompress,
+		})
+		if err != nil {
+			glog.Exitf("Unable to create file storage: %s", err)
+		}
+		defer fs.Close()
+		st = fs
+	}
+	if *blobDir != "" {
+		bs, err := blob.NewFileStore(*blobDir)
+		if err != nil {
+			glog.Exitf("Unable to create blob storage: %s", err)
+		}
+		st = &blobStorage{Storage: st, w: blob.NewAPICallWriter(bs, st)}
+	}
+	if *storageQueue > 0 {
+		bst := buffered.New(st, buffered.Options{
+			QueueSize:     *storageQueue,
+			MaxBlock:      *storageMaxBlock,
+			BatchSize:     *storageBatch,
+			MetricFactory: prometheus.MetricFactory{},
+		})
+		defer bst.Close()
+		st = bst
+	}
+
+	if err := collector.Run(ctx, cfg, &http.Client{}, st); err != nil {
 		glog.Exit(err)
 	}
 }
 
-func setupCA(ctl *ctlog.Log, signingCertFile, signingKeyFile string) (*certgen.CA, error) {
+// blobStorage is a collector.Storage that stores the response body of each API
+// call in a blob.Store.
+type blobStorage struct {
+	collector.Storage
+	w *blob.APICallWriter
+}
+
+func (s *blobStorage) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	return s.w.WriteAPICall(ctx, l, apiCall)
+}
+
+// Batch passes batches of writes through to the underlying storage, if it is a
+// buffered.Batcher.
+func (s *blobStorage) Batch(ctx context.Context, fn func() error) error {
+	if b, ok := s.Storage.(buffered.Batcher); ok {
+		return b.Batch(ctx, fn)
+	}
+	return fn()
+}
+
+// setupReporter returns a reporter that routes incidents to each of the
+// incident reporters configured by flags, unless they are silenced, and a
+// function to call to close them.
+func setupReporter(ctx context.Context, l *ctlog.Log) (incident.Reporter, func(), error) {
+	const source = "datacollector"
+	targets := map[string]incident.Reporter{"log": &incident.LoggingReporter{}}
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	if *incidentsMySQLURI != "" {
+		db, err := mysql.Open(*incidentsMySQLURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open incidents database: %s", err)
+		}
+		closers = append(closers, func() { db.Close() })
+		if targets["mysql"], err = incidentmysql.NewGroupingMySQLReporter(ctx, db, source, *incidentGroupWindow); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+	}
+	if *incidentsFile != "" {
+		fr, err := local.New(local.Options{Path: *incidentsFile, Source: source, GroupWindow: *incidentGroupWindow})
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		targets["file"] = fr
+	}
+	if *webhookURL != "" {
+		opts := webhook.Options{URL: *webhookURL, Source: source, QueueDir: *webhookQueueDir}
+		if *webhookSecretFile != "" {
+			secret, err := ioutil.ReadFile(*webhookSecretFile)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("error reading webhook secret: %s", err)
+			}
+			opts.Secret = bytes.TrimSpace(secret)
+		}
+		wr, err := webhook.New(opts)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, func() { wr.Close() })
+		targets["webhook"] = wr
+	}
+	if *smtpAddr != "" {
+		opts := email.Options{
+			Addr:         *smtpAddr,
+			From:         *emailFrom,
+			To:           strings.Split(*emailTo, ","),
+			Source:       source,
+			DigestPeriod: *emailDigestPeriod,
+		}
+		if *emailTo == "" {
+			opts.To = nil
+		}
+		if *smtpUser != "" {
+			password, err := ioutil.ReadFile(*smtpPasswordFile)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("error reading SMTP password: %s", err)
+			}
+			host, _, err := net.SplitHostPort(*smtpAddr)
+			if err != nil {
+				closeAll()
+				return nil, nil, err
+			}
+			opts.Auth = smtp.PlainAuth("", *smtpUser, strings.TrimSpace(string(password)), host)
+		}
+		er, err := email.New(opts)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, func() { er.Close() })
+		targets["email"] = er
+	}
+
+	var rules []route.Rule
+	if *incidentRoutes != "" {
+		var err error
+		if rules, err = route.LoadRules(*incidentRoutes); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+	} else {
+		all := route.Rule{}
+		for _, name := range []string{"mysql", "file", "webhook", "email"} {
+			if targets[name] != nil {
+				all.Targets = append(all.Targets, name)
+			}
+		}
+		if len(all.Targets) == 0 {
+			all.Targets = []string{"log"}
+		}
+		rules = []route.Rule{all}
+	}
+	r, err := route.New([]*ctlog.Log{l}, targets, rules)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	if *silencesFile == "" {
+		return r, closeAll, nil
+	}
+	set, err := silence.NewSet(silence.Options{Path: *silencesFile, AuditPath: *silencesAuditFile})
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	if *silencesEndpoint != "" {
+		mux := http.NewServeMux()
+		mux.Handle(silence.APIPath, silence.Handler(set))
+		mux.Handle(silence.APIPath+"/", silence.Handler(set))
+		go func() {
+			glog.Exit(http.ListenAndServe(*silencesEndpoint, mux))
+		}()
+	}
+	return silence.NewReporter(r, set, []*ctlog.Log{l}), closeAll, nil
+}
+
+// temporalInterval parses the temporal interval of a Log from its start and
+// end.  If neither is given, the Log is not a temporal shard, and nil is
+// returned.
+func temporalInterval(start, end string) (*interval.Interval, error) {
+	if start == "" && end == "" {
+		return nil, nil
+	}
+	if start == "" || end == "" {
+		return nil, fmt.Errorf("both the start and end must be provided")
+	}
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start: %s", err)
+	}
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end: %s", err)
+	}
+	if !s.Before(e) {
+		return nil, fmt.Errorf("start %s is not before end %s", start, end)
+	}
+	return &interval.Interval{Start: s, End: e}, nil
+}
+
+func setupCA(ctl *ctlog.Log, signingCertFile, signingKeyFile string, keyType certgen.KeyType, intermediates int, precertSigner bool) (*certgen.CA, error) {
 	// TODO(katjoyce): Add support for other key encodings and
 	// generally improve key management here.
 	signingCertPEM, err := ioutil.ReadFile(signingCertFile)
@@ -116,13 +416,19 @@ func setupCA(ctl *ctlog.Log, signingCertFile, signingKeyFile string) (*certgen.C
 		SubjectOrganizationalUnit: "Certificate Transparency",
 		SubjectLocality:           "London",
 		SubjectCountry:            "GB",
-		SignatureAlgorithm:        x509.SHA256WithRSA,
+		KeyType:                   keyType,
 		DNSPrefix:                 ctl.Name,
+		// Certificates for a temporal shard must expire within its interval.
+		NotAfterInterval: ctl.TemporalInterval,
 	}
 
-	return &certgen.CA{
+	root := &certgen.CA{
 		SigningCert: signingCert,
 		SigningKey:  signingKey,
 		CertConfig:  certConfig,
-	}, nil
+	}
+	return certgen.NewHierarchy(root, certgen.HierarchyOptions{
+		Intermediates: intermediates,
+		PrecertSigner: precertSigner,
+	})
 }
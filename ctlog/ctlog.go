@@ -20,11 +20,13 @@ package ctlog
 
 import (
 	"crypto"
+	"encoding/base64"
 	"fmt"
 	"time"
 
 	ct "github.com/google/certificate-transparency-go"
 	"github.com/google/certificate-transparency-go/logid"
+	"github.com/google/certificate-transparency-go/loglist2"
 	"github.com/google/monologue/interval"
 )
 
@@ -79,3 +81,33 @@ func New(url, name, b64PubKey string, mmd time.Duration, i *interval.Interval) (
 		TemporalInterval: ti,
 	}, nil
 }
+
+// FromLogList creates a Log structure for a Log in a log list, named after its
+// description.
+func FromLogList(ll *loglist2.Log) (*Log, error) {
+	var i *interval.Interval
+	if ll.TemporalInterval != nil {
+		i = &interval.Interval{Start: ll.TemporalInterval.StartInclusive, End: ll.TemporalInterval.EndExclusive}
+	}
+	return New(ll.URL, ll.Description, base64.StdEncoding.EncodeToString(ll.Key), time.Duration(ll.MMD)*time.Second, i)
+}
+
+// ShardFor returns the first of logs that accepts certificates with the given
+// NotAfter time: a temporal shard whose TemporalInterval contains notAfter, or
+// else a Log that is not a temporal shard.  If none of logs accepts such
+// certificates, nil is returned.
+func ShardFor(logs []*Log, notAfter time.Time) *Log {
+	var unsharded *Log
+	for _, l := range logs {
+		if l.TemporalInterval == nil {
+			if unsharded == nil {
+				unsharded = l
+			}
+			continue
+		}
+		if l.TemporalInterval.Contains(notAfter) {
+			return l
+		}
+	}
+	return unsharded
+}
@@ -15,9 +15,11 @@
 package ctlog
 
 import (
+	"encoding/base64"
 	"testing"
 	"time"
 
+	"github.com/google/certificate-transparency-go/loglist2"
 	"github.com/google/monologue/interval"
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -74,3 +76,93 @@ func TestNewTemporalInterval(t *testing.T) {
 		})
 	}
 }
+
+func TestShardFor(t *testing.T) {
+	shard := func(name string, year int) *Log {
+		return &Log{Name: name, TemporalInterval: &interval.Interval{
+			Start: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC),
+		}}
+	}
+	shards := []*Log{shard("argon2020", 2020), shard("argon2021", 2021)}
+	unsharded := &Log{Name: "pilot"}
+
+	tests := []struct {
+		desc     string
+		logs     []*Log
+		notAfter time.Time
+		want     string
+	}{
+		{
+			desc:     "first shard",
+			logs:     shards,
+			notAfter: time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			want:     "argon2020",
+		},
+		{
+			desc:     "start of second shard",
+			logs:     shards,
+			notAfter: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+			want:     "argon2021",
+		},
+		{
+			desc:     "no shard",
+			logs:     shards,
+			notAfter: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			desc:     "unsharded",
+			logs:     append([]*Log{unsharded}, shards...),
+			notAfter: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+			want:     "pilot",
+		},
+		{
+			desc:     "shard preferred to unsharded",
+			logs:     append([]*Log{unsharded}, shards...),
+			notAfter: time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC),
+			want:     "argon2020",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var got string
+			if l := ShardFor(test.logs, test.notAfter); l != nil {
+				got = l.Name
+			}
+			if got != test.want {
+				t.Errorf("ShardFor(%s) = %q, want %q", test.notAfter, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFromLogList(t *testing.T) {
+	key, err := base64.StdEncoding.DecodeString("MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==")
+	if err != nil {
+		t.Fatalf("failed to decode key: %s", err)
+	}
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ll := &loglist2.Log{
+		Description:      "Google 'Argon2020' log",
+		Key:              key,
+		URL:              "https://ct.googleapis.com/logs/argon2020/",
+		MMD:              86400,
+		TemporalInterval: &loglist2.TemporalInterval{StartInclusive: start, EndExclusive: end},
+	}
+	l, err := FromLogList(ll)
+	if err != nil {
+		t.Fatalf("FromLogList() = _, %s", err)
+	}
+	if l.Name != ll.Description || l.URL != ll.URL || l.MMD != 24*time.Hour {
+		t.Errorf("FromLogList() = %+v, want Log named %q at %s with MMD 24h", l, ll.Description, ll.URL)
+	}
+	if l.TemporalInterval == nil || !l.TemporalInterval.Start.Equal(start) || !l.TemporalInterval.End.Equal(end) {
+		t.Errorf("FromLogList() has TemporalInterval %+v, want [%s, %s)", l.TemporalInterval, start, end)
+	}
+
+	ll.TemporalInterval = nil
+	if l, err := FromLogList(ll); err != nil || l.TemporalInterval != nil {
+		t.Errorf("FromLogList() without interval = %+v, %v, want no TemporalInterval", l, err)
+	}
+}
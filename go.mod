@@ -9,4 +9,5 @@ require (
 	github.com/google/go-cmp v0.4.0
 	github.com/google/trillian v1.3.3
 	github.com/kylelemons/godebug v1.1.0
+	github.com/prometheus/client_golang v1.2.1
 )
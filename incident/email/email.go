@@ -0,0 +1,370 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package email provides an implementation of incident reporting that sends
+// email through an SMTP relay.
+//
+// Violations are mailed immediately.  Everything else, along with repeats of a
+// violation already mailed, is collected into a digest that is mailed
+// periodically, grouped by Log and summary so that a flapping Log produces one
+// line per problem rather than one mail per occurrence.
+package email
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DefaultDigestPeriod is how often a digest is sent if no DigestPeriod is
+// configured.
+const DefaultDigestPeriod = time.Hour
+
+var (
+	violationTemplate = template.Must(template.New("violation").Parse(`{{ .Source }} found a violation by {{ .BaseURL }} at {{ .Time.Format "2006-01-02 15:04:05 MST" }}.
+
+{{ .Summary }}
+{{ if .FullURL }}
+URL: {{ .FullURL }}
+{{ end }}
+{{ .Details }}
+
+Further occurrences of this violation will be included in the next digest.
+`))
+
+	digestTemplate = template.Must(template.New("digest").Parse(`Incidents found by {{ .Source }} between {{ .Start.Format "2006-01-02 15:04:05 MST" }} and {{ .End.Format "2006-01-02 15:04:05 MST" }}.
+{{ range .Logs }}
+{{ .BaseURL }}
+{{ range .Entries }}
+  {{ if .IsViolation }}[VIOLATION] {{ end }}{{ .Summary }}
+{{ if .Count }}    {{ .Count }} occurrence(s), first at {{ .First.Format "15:04:05" }}, last at {{ .Last.Format "15:04:05" }}
+{{ end }}{{ if not .Resolved.IsZero }}    Resolved at {{ .Resolved.Format "15:04:05" }}
+{{ end }}{{ if .FullURL }}    URL: {{ .FullURL }}
+{{ end }}{{ if .Details }}    Latest details:
+      {{ .Details }}
+{{ end }}{{ end }}{{ end }}`))
+)
+
+// Options configures a Reporter.
+type Options struct {
+	// Addr is the host:port of the SMTP relay.
+	Addr string
+	// Auth, if not nil, is used to authenticate to the SMTP relay.
+	Auth smtp.Auth
+	// From is the address mail is sent from.
+	From string
+	// To is the list of addresses mail is sent to.
+	To []string
+	// Source identifies the monitor in every mail.
+	Source string
+	// DigestPeriod is how often a digest is sent, if there is anything to put
+	// in it.  If 0, DefaultDigestPeriod is used.
+	DigestPeriod time.Duration
+}
+
+// key identifies a group of incidents in a digest.
+type key struct {
+	baseURL, summary string
+}
+
+// digestEntry is a group of incidents in a digest.
+type digestEntry struct {
+	BaseURL     string
+	Summary     string
+	IsViolation bool
+	Count       int
+	First, Last time.Time
+	Resolved    time.Time
+	FullURL     string
+	Details     string
+}
+
+type digestLog struct {
+	BaseURL string
+	Entries []*digestEntry
+}
+
+type violationArgs struct {
+	Source                             string
+	Time                               time.Time
+	BaseURL, Summary, FullURL, Details string
+}
+
+type digestArgs struct {
+	Source     string
+	Start, End time.Time
+	Logs       []*digestLog
+}
+
+// message is a mail waiting to be sent.
+type message struct {
+	subject, body string
+}
+
+// Reporter implements incident.Reporter and incident.Resolver by sending
+// email.  Mail is sent in the background, so reporting an incident does not
+// wait for the SMTP relay.
+type Reporter struct {
+	opts Options
+
+	mu sync.Mutex // guards everything below
+	// mailed records the violations mailed since the last digest.
+	mailed      map[key]bool
+	digest      map[key]*digestEntry
+	digestStart time.Time
+	closed      bool
+
+	outbox chan *message
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New returns a Reporter configured by opts, and starts the goroutine that
+// sends mail.
+func New(opts Options) (*Reporter, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("no SMTP relay address provided")
+	}
+	if opts.From == "" {
+		return nil, errors.New("no From address provided")
+	}
+	if len(opts.To) == 0 {
+		return nil, errors.New("no To addresses provided")
+	}
+	if opts.DigestPeriod <= 0 {
+		opts.DigestPeriod = DefaultDigestPeriod
+	}
+	r := &Reporter{
+		opts:        opts,
+		mailed:      make(map[key]bool),
+		digest:      make(map[key]*digestEntry),
+		digestStart: time.Now(),
+		outbox:      make(chan *message, 100),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+// LogUpdate adds an incident to the next digest.
+func (r *Reporter) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addLocked(time.Now(), baseURL, summary, false, fullURL, details)
+}
+
+// LogUpdatef adds an incident to the next digest, formatting parameters along the way.
+func (r *Reporter) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogUpdate(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogViolation mails a violation immediately, unless it has already been
+// mailed since the last digest, in which case it is added to the next digest.
+func (r *Reporter) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key{baseURL: baseURL, summary: summary}
+	if r.mailed[k] || r.closed {
+		r.addLocked(now, baseURL, summary, true, fullURL, details)
+		return
+	}
+	r.mailed[k] = true
+
+	var body strings.Builder
+	if err := violationTemplate.Execute(&body, violationArgs{
+		Source:  r.opts.Source,
+		Time:    now,
+		BaseURL: baseURL,
+		Summary: summary,
+		FullURL: fullURL,
+		Details: details,
+	}); err != nil {
+		glog.Errorf("email: failed to generate mail for %q for %s: %s", summary, baseURL, err)
+		return
+	}
+	r.queueLocked(&message{subject: fmt.Sprintf("Violation: %s: %s", baseURL, summary), body: body.String()})
+}
+
+// LogViolationf mails a violation, formatting parameters along the way.
+func (r *Reporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogResolved notes in the next digest that incidents have been resolved.
+// Nothing is noted if there have been no such incidents since the last
+// digest.
+func (r *Reporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := key{baseURL: baseURL, summary: summary}
+	if e, ok := r.digest[k]; ok && e.Resolved.IsZero() {
+		e.Resolved = time.Now()
+	} else if r.mailed[k] {
+		r.digest[k] = &digestEntry{BaseURL: baseURL, Summary: summary, IsViolation: true, Resolved: time.Now()}
+	}
+	delete(r.mailed, k)
+}
+
+// Flush sends the digest now, if there is anything in it.
+func (r *Reporter) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushLocked()
+}
+
+// Close sends the digest, if there is anything in it, and waits for all mail to
+// be sent.
+func (r *Reporter) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.flushLocked()
+		r.closed = true
+		close(r.stop)
+	}
+	r.mu.Unlock()
+	<-r.done
+	return nil
+}
+
+func (r *Reporter) addLocked(now time.Time, baseURL, summary string, isViolation bool, fullURL, details string) {
+	k := key{baseURL: baseURL, summary: summary}
+	e, ok := r.digest[k]
+	if !ok {
+		e = &digestEntry{BaseURL: baseURL, Summary: summary, First: now}
+		r.digest[k] = e
+	}
+	if e.Count == 0 {
+		e.First = now
+	}
+	e.Count++
+	e.Last = now
+	e.IsViolation = e.IsViolation || isViolation
+	// A new occurrence after a resolution means the problem is back.
+	e.Resolved = time.Time{}
+	e.FullURL = fullURL
+	e.Details = details
+}
+
+func (r *Reporter) flushLocked() {
+	now := time.Now()
+	start := r.digestStart
+	r.digestStart = now
+	r.mailed = make(map[key]bool)
+	if len(r.digest) == 0 || r.closed {
+		return
+	}
+	entries := r.digest
+	r.digest = make(map[key]*digestEntry)
+
+	logs := make(map[string]*digestLog)
+	for _, e := range entries {
+		dl, ok := logs[e.BaseURL]
+		if !ok {
+			dl = &digestLog{BaseURL: e.BaseURL}
+			logs[e.BaseURL] = dl
+		}
+		dl.Entries = append(dl.Entries, e)
+	}
+	args := digestArgs{Source: r.opts.Source, Start: start, End: now}
+	for _, dl := range logs {
+		sort.Slice(dl.Entries, func(i, j int) bool { return dl.Entries[i].Summary < dl.Entries[j].Summary })
+		args.Logs = append(args.Logs, dl)
+	}
+	sort.Slice(args.Logs, func(i, j int) bool { return args.Logs[i].BaseURL < args.Logs[j].BaseURL })
+
+	var body strings.Builder
+	if err := digestTemplate.Execute(&body, args); err != nil {
+		glog.Errorf("email: failed to generate digest: %s", err)
+		return
+	}
+	r.queueLocked(&message{
+		subject: fmt.Sprintf("Digest: %d incident(s) across %d Log(s)", len(entries), len(args.Logs)),
+		body:    body.String(),
+	})
+}
+
+func (r *Reporter) queueLocked(m *message) {
+	select {
+	case r.outbox <- m:
+	default:
+		glog.Errorf("email: too much mail waiting to be sent, dropping %q", m.subject)
+	}
+}
+
+// run sends mail from the outbox, and a digest every DigestPeriod, until Close
+// is called.
+func (r *Reporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.DigestPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-r.outbox:
+			r.sendMail(m)
+		case <-ticker.C:
+			r.Flush()
+		case <-r.stop:
+			for {
+				select {
+				case m := <-r.outbox:
+					r.sendMail(m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Reporter) sendMail(m *message) {
+	if err := smtp.SendMail(r.opts.Addr, r.opts.Auth, r.opts.From, r.opts.To, r.format(m)); err != nil {
+		glog.Errorf("email: failed to send %q: %s", m.subject, err)
+	}
+}
+
+// headerReplacer replaces the line breaks that would let text taken from an
+// incident, such as its summary, end a header and start another.
+var headerReplacer = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// format returns m as an RFC 5322 message.
+func (r *Reporter) format(m *message) []byte {
+	subject := m.subject
+	if r.opts.Source != "" {
+		subject = fmt.Sprintf("[%s] %s", r.opts.Source, subject)
+	}
+	// Any other characters that cannot appear in a header are encoded.
+	subject = mime.QEncoding.Encode("UTF-8", headerReplacer.Replace(subject))
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", r.opts.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(r.opts.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(m.body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/incident"
+)
+
+// fakeSMTP is a minimal SMTP server that records the mail sent to it.
+type fakeSMTP struct {
+	l  net.Listener
+	wg sync.WaitGroup
+
+	mu   sync.Mutex
+	mail []*mail.Message
+	rcpt [][]string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() = _, %s", err)
+	}
+	s := &fakeSMTP{l: l}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.serve(t, conn)
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *fakeSMTP) close() {
+	s.l.Close()
+	s.wg.Wait()
+}
+
+func (s *fakeSMTP) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	tc := textproto.NewConn(conn)
+	tc.PrintfLine("220 localhost fake SMTP")
+	var rcpt []string
+	for {
+		line, err := tc.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			tc.PrintfLine("250 localhost")
+		case "MAIL":
+			rcpt = nil
+			tc.PrintfLine("250 OK")
+		case "RCPT":
+			rcpt = append(rcpt, line)
+			tc.PrintfLine("250 OK")
+		case "DATA":
+			tc.PrintfLine("354 Go ahead")
+			msg, err := mail.ReadMessage(bufio.NewReader(tc.DotReader()))
+			if err != nil {
+				t.Errorf("failed to read mail: %s", err)
+				return
+			}
+			s.mu.Lock()
+			s.mail = append(s.mail, msg)
+			s.rcpt = append(s.rcpt, rcpt)
+			s.mu.Unlock()
+			tc.PrintfLine("250 OK")
+		case "QUIT":
+			tc.PrintfLine("221 Bye")
+			return
+		default:
+			tc.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+type received struct {
+	subject, body string
+}
+
+func (s *fakeSMTP) received(t *testing.T) []received {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var got []received
+	for _, m := range s.mail {
+		var body strings.Builder
+		if _, err := bufio.NewReader(m.Body).WriteTo(&body); err != nil {
+			t.Fatalf("failed to read mail body: %s", err)
+		}
+		got = append(got, received{subject: m.Header.Get("Subject"), body: body.String()})
+	}
+	s.mail = nil
+	return got
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{Addr: "localhost:25", To: []string{"oncall@example.com"}},
+		{Addr: "localhost:25", From: "monologue@example.com"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) = _, nil, want error", opts)
+		}
+	}
+}
+
+func TestReporter(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeSMTP(t)
+	defer s.close()
+
+	r, err := New(Options{
+		Addr:         s.addr(),
+		From:         "monologue@example.com",
+		To:           []string{"oncall@example.com", "ct@example.com"},
+		Source:       "unittest",
+		DigestPeriod: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	var _ incident.Reporter = r
+	var _ incident.Resolver = r
+
+	const pilot, aviator = "https://ct.googleapis.com/pilot/", "https://ct.googleapis.com/aviator/"
+	// The first occurrence of a violation is mailed immediately, later ones
+	// go in the digest.
+	r.LogViolationf(ctx, pilot, "STH older than MMD", pilot+"ct/v1/get-sth", "STH %d", 1)
+	r.LogViolationf(ctx, pilot, "STH older than MMD", pilot+"ct/v1/get-sth", "STH %d", 2)
+	r.LogViolationf(ctx, pilot, "STH older than MMD", pilot+"ct/v1/get-sth", "STH %d", 3)
+	incident.LogResolved(ctx, r, pilot, "STH older than MMD")
+	// A flapping Log produces one line in the digest.
+	for i := 0; i < 10; i++ {
+		r.LogUpdatef(ctx, aviator, "Root certificates changed", aviator+"ct/v1/get-roots", "change %d", i)
+	}
+	r.LogUpdate(ctx, pilot, "Root certificates changed", pilot+"ct/v1/get-roots", "details")
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	got := s.received(t)
+	if len(got) != 2 {
+		t.Fatalf("received %d mails, want 2: %v", len(got), got)
+	}
+	if want := "[unittest] Violation: " + pilot + ": STH older than MMD"; got[0].subject != want {
+		t.Errorf("violation mail subject = %q, want %q", got[0].subject, want)
+	}
+	for _, want := range []string{"STH 1", pilot + "ct/v1/get-sth"} {
+		if !strings.Contains(got[0].body, want) {
+			t.Errorf("violation mail body does not contain %q:\n%s", want, got[0].body)
+		}
+	}
+
+	if want := "[unittest] Digest: 3 incident(s) across 2 Log(s)"; got[1].subject != want {
+		t.Errorf("digest subject = %q, want %q", got[1].subject, want)
+	}
+	for _, want := range []string{
+		"10 occurrence(s)",
+		"change 9",
+		"[VIOLATION] STH older than MMD\n    2 occurrence(s)",
+		"Resolved at",
+	} {
+		if !strings.Contains(got[1].body, want) {
+			t.Errorf("digest body does not contain %q:\n%s", want, got[1].body)
+		}
+	}
+	if strings.Index(got[1].body, aviator) > strings.Index(got[1].body, pilot) {
+		t.Errorf("digest body does not list Logs in order:\n%s", got[1].body)
+	}
+	if n := strings.Count(got[1].body, "Root certificates changed"); n != 2 {
+		t.Errorf("digest body lists root changes %d times, want 2 (once per Log):\n%s", n, got[1].body)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rcpt := range s.rcpt {
+		if len(rcpt) != 2 {
+			t.Errorf("mail sent to %d recipients, want 2", len(rcpt))
+		}
+	}
+}
+
+func TestViolationMailedAgainAfterDigest(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeSMTP(t)
+	defer s.close()
+
+	r, err := New(Options{Addr: s.addr(), From: "monologue@example.com", To: []string{"oncall@example.com"}})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	r.LogViolation(ctx, "base", "summary", "", "first")
+	r.Flush()
+	r.LogViolation(ctx, "base", "summary", "", "second")
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	got := s.received(t)
+	if len(got) != 2 {
+		t.Fatalf("received %d mails, want 2: %v", len(got), got)
+	}
+	for i, want := range []string{"first", "second"} {
+		if !strings.HasPrefix(got[i].subject, "Violation") || !strings.Contains(got[i].body, want) {
+			t.Errorf("mail %d = %+v, want violation containing %q", i, got[i], want)
+		}
+	}
+}
+
+func TestSubjectSanitized(t *testing.T) {
+	r := &Reporter{opts: Options{From: "monologue@example.com", To: []string{"oncall@example.com"}, Source: "unittest"}}
+	for _, test := range []struct {
+		subject, want string
+	}{
+		{subject: "Violation: base: summary", want: "[unittest] Violation: base: summary"},
+		{subject: "Violation: base\r\nBcc: attacker@example.com: summary", want: "[unittest] Violation: base Bcc: attacker@example.com: summary"},
+		{subject: "Violation: base: summary\nwith\rbreaks", want: "[unittest] Violation: base: summary with breaks"},
+		{subject: "Violation: base: r\u00e9sum\u00e9", want: "[unittest] Violation: base: r\u00e9sum\u00e9"},
+	} {
+		m, err := mail.ReadMessage(bytes.NewReader(r.format(&message{subject: test.subject, body: "body"})))
+		if err != nil {
+			t.Errorf("format(%q) is not a valid message: %s", test.subject, err)
+			continue
+		}
+		if len(m.Header["Bcc"]) != 0 {
+			t.Errorf("format(%q) has a Bcc header", test.subject)
+		}
+		got, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+		if err != nil {
+			t.Errorf("format(%q) has invalid Subject %q: %s", test.subject, m.Header.Get("Subject"), err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("format(%q) has Subject %q, want %q", test.subject, got, test.want)
+		}
+	}
+}
@@ -38,6 +38,62 @@ type Reporter interface {
 	LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{})
 }
 
+// State is the stage of its lifecycle that an incident is at.
+type State string
+
+// States that an incident can be in.
+const (
+	// Open incidents are those whose condition has not yet cleared, and that
+	// nobody has acknowledged.
+	Open State = "open"
+	// Acknowledged incidents are those that somebody is looking into.
+	Acknowledged State = "acknowledged"
+	// Resolved incidents are those whose condition has cleared.
+	Resolved State = "resolved"
+	// Suppressed incidents are those that matched a silence, and so were
+	// recorded without being reported.
+	Suppressed State = "suppressed"
+)
+
+// Resolver describes a mechanism for marking incidents as resolved once the
+// condition that caused them has cleared.
+type Resolver interface {
+	// LogResolved marks every unresolved incident with the given baseURL and
+	// summary as resolved.  The baseURL and summary should be the same as
+	// were used to report the incidents.
+	LogResolved(ctx context.Context, baseURL, summary string)
+}
+
+// LogResolved declares that the condition that caused incidents with the given
+// baseURL and summary has cleared.  If rep is also a Resolver, the incidents
+// are marked as resolved; otherwise nothing happens.
+func LogResolved(ctx context.Context, rep Reporter, baseURL, summary string) {
+	if r, ok := rep.(Resolver); ok {
+		r.LogResolved(ctx, baseURL, summary)
+	}
+}
+
+// SuppressionRecorder describes a mechanism for recording incidents that were
+// suppressed by a silence, rather than reported.
+type SuppressionRecorder interface {
+	// RecordSuppressed records inc as Suppressed by the silence with the
+	// given ID.
+	RecordSuppressed(ctx context.Context, inc *Incident, silenceID string)
+}
+
+// Manager describes a mechanism for people to change the state of recorded
+// incidents.
+type Manager interface {
+	// Acknowledge marks the incident with the given ID as Acknowledged.
+	Acknowledge(ctx context.Context, id uint64) error
+	// Resolve marks the incident with the given ID as Resolved.
+	Resolve(ctx context.Context, id uint64) error
+	// Link makes the incident with the given ID, along with any
+	// sub-incidents of it, sub-incidents of the incident with owningID, or
+	// of its owning incident if it has one.
+	Link(ctx context.Context, id, owningID uint64) error
+}
+
 // LoggingReporter implements the Reporter interface by simply emitting
 // log messages.
 type LoggingReporter struct {
@@ -62,3 +118,8 @@ func (l *LoggingReporter) LogUpdatef(ctx context.Context, baseURL, summary, full
 func (l *LoggingReporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
 	glog.Errorf("%s: %s (%s)\n  %s", baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
 }
+
+// LogResolved emits a log message saying that the incidents have been resolved.
+func (l *LoggingReporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	glog.Infof("%s: %s: resolved", baseURL, summary)
+}
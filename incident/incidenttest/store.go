@@ -0,0 +1,287 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package incidenttest contains conformance tests for implementations of
+// incident recording and management.
+package incidenttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/certificate-transparency-go/logid"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
+)
+
+// Reporter is the interface that a backend must implement to record the
+// incidents found by its Store.
+type Reporter interface {
+	incident.StructuredReporter
+	incident.SuppressionRecorder
+}
+
+// StoreFactory returns a Reporter, and a Store of the incidents it records,
+// for use by a single test.  The Store must start empty, and the Reporter must
+// record incidents from the source "unittest", grouping repeated incidents
+// reported within at least a minute of each other.
+type StoreFactory func(ctx context.Context, t *testing.T) (Reporter, incident.Store)
+
+// RunStore runs the conformance test suite for incident.Store implementations
+// against the Stores produced by f.
+func RunStore(t *testing.T, f StoreFactory) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, t *testing.T, r Reporter, s incident.Store)
+	}{
+		{name: "List", fn: testList},
+		{name: "GetRoundTrip", fn: testGetRoundTrip},
+		{name: "GetMissing", fn: testGetMissing},
+		{name: "StateChanges", fn: testStateChanges},
+		{name: "Link", fn: testLink},
+		{name: "Suppressed", fn: testSuppressed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r, s := f(ctx, t)
+			test.fn(ctx, t, r, s)
+		})
+	}
+}
+
+const (
+	base1 = "https://ct.example.com/one/"
+	base2 = "https://ct.example.com/two/"
+)
+
+// populate reports a violation twice, so that the second is grouped with the
+// first, and then an update for another Log.  The details of the incidents are
+// "first", "second" and "third" respectively.
+func populate(ctx context.Context, r Reporter) {
+	r.LogViolation(ctx, base1, "stale STH", "full", "first")
+	r.LogViolation(ctx, base1, "stale STH", "full", "second")
+	r.LogUpdatef(ctx, base2, "roots changed", "full", "%s", "third")
+}
+
+func mustList(ctx context.Context, t *testing.T, s incident.Store, f incident.Filter) []*incident.Record {
+	t.Helper()
+	recs, err := s.List(ctx, f)
+	if err != nil {
+		t.Fatalf("List(%+v) = _, %s", f, err)
+	}
+	return recs
+}
+
+func details(recs []*incident.Record) []string {
+	var got []string
+	for _, r := range recs {
+		got = append(got, r.Details)
+	}
+	return got
+}
+
+func testList(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	populate(ctx, r)
+	violation, update := true, false
+	for _, test := range []struct {
+		desc string
+		f    incident.Filter
+		want []string
+	}{
+		{desc: "all", want: []string{"third", "first"}},
+		{desc: "with sub-incidents", f: incident.Filter{SubIncidents: true}, want: []string{"third", "second", "first"}},
+		{desc: "by log", f: incident.Filter{BaseURL: base1}, want: []string{"first"}},
+		{desc: "by source", f: incident.Filter{Source: "unittest"}, want: []string{"third", "first"}},
+		{desc: "by other source", f: incident.Filter{Source: "other"}},
+		{desc: "violations", f: incident.Filter{IsViolation: &violation}, want: []string{"first"}},
+		{desc: "updates", f: incident.Filter{IsViolation: &update}, want: []string{"third"}},
+		{desc: "by state", f: incident.Filter{State: incident.Open, SubIncidents: true}, want: []string{"third", "second", "first"}},
+		{desc: "limit", f: incident.Filter{Limit: 1}, want: []string{"third"}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if diff := cmp.Diff(details(mustList(ctx, t, s, test.f)), test.want); diff != "" {
+				t.Errorf("List(%+v): diff (-got +want)\n%s", test.f, diff)
+			}
+		})
+	}
+
+	recs := mustList(ctx, t, s, incident.Filter{SubIncidents: true})
+	if len(recs) != 3 {
+		t.Fatalf("List() returned %d incidents, want 3", len(recs))
+	}
+	first, second := recs[2], recs[1]
+	since := first.Timestamp
+	if got := details(mustList(ctx, t, s, incident.Filter{Since: since, Until: since, SubIncidents: true})); len(got) != 0 {
+		t.Errorf("List() with empty time range = %v, want none", got)
+	}
+	if got := details(mustList(ctx, t, s, incident.Filter{Since: since, SubIncidents: true})); len(got) != 3 {
+		t.Errorf("List() since %s = %v, want all 3 incidents", since, got)
+	}
+	if second.OwningID != first.ID {
+		t.Errorf("second occurrence has owning ID %d, want %d", second.OwningID, first.ID)
+	}
+	if first.LastSeen.IsZero() {
+		t.Errorf("owning incident has no LastSeen time")
+	}
+}
+
+func testGetRoundTrip(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	logID := logid.FromPubKeyB64OrDie("MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==")
+	inc := incident.Incident{
+		BaseURL:     base1,
+		Summary:     "bad STH signature",
+		FullURL:     "full",
+		Details:     "details",
+		IsViolation: true,
+		Category:    incident.STHSignature,
+		Severity:    incident.Warning,
+		LogID:       logID,
+		Evidence:    []incident.EvidenceRef{{Kind: "sth", ID: "42"}},
+		RFCSections: []string{"RFC 6962 s3.5", "RFC 6962 s3.6"},
+	}
+	r.Report(ctx, &inc)
+	recs := mustList(ctx, t, s, incident.Filter{})
+	if len(recs) != 1 {
+		t.Fatalf("List() returned %d incidents, want 1", len(recs))
+	}
+
+	got, subs, err := s.Get(ctx, recs[0].ID)
+	if err != nil {
+		t.Fatalf("Get(%d) = _, _, %s", recs[0].ID, err)
+	}
+	if len(subs) != 0 {
+		t.Errorf("Get(%d) returned %d sub-incidents, want 0", recs[0].ID, len(subs))
+	}
+	if diff := cmp.Diff(got.Incident, inc); diff != "" {
+		t.Errorf("Get(%d): diff (-got +want)\n%s", recs[0].ID, diff)
+	}
+	if got.Source != "unittest" || got.State != incident.Open {
+		t.Errorf("Get(%d) = source %q in state %q, want unittest in state %q", recs[0].ID, got.Source, got.State, incident.Open)
+	}
+}
+
+func testGetMissing(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	if _, _, err := s.Get(ctx, 12345); err == nil {
+		t.Errorf("Get(12345) = _, _, nil, want error")
+	}
+	if err := s.Acknowledge(ctx, 12345); err == nil {
+		t.Errorf("Acknowledge(12345) = nil, want error")
+	}
+	if err := s.Resolve(ctx, 12345); err == nil {
+		t.Errorf("Resolve(12345) = nil, want error")
+	}
+}
+
+func testStateChanges(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	populate(ctx, r)
+	recs := mustList(ctx, t, s, incident.Filter{SubIncidents: true})
+	if len(recs) != 3 {
+		t.Fatalf("List() returned %d incidents, want 3", len(recs))
+	}
+	third, second, first := recs[0], recs[1], recs[2]
+
+	// Acknowledging a sub-incident acknowledges its owner.
+	if err := s.Acknowledge(ctx, second.ID); err != nil {
+		t.Fatalf("Acknowledge(%d) = %s", second.ID, err)
+	}
+	if err := s.Resolve(ctx, third.ID); err != nil {
+		t.Fatalf("Resolve(%d) = %s", third.ID, err)
+	}
+	// Acknowledging a resolved incident has no effect.
+	if err := s.Acknowledge(ctx, third.ID); err != nil {
+		t.Fatalf("Acknowledge(%d) = %s", third.ID, err)
+	}
+
+	got, subs, err := s.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Get(%d) = _, _, %s", first.ID, err)
+	}
+	if got.State != incident.Acknowledged || got.AcknowledgedAt.IsZero() {
+		t.Errorf("Get(%d) = state %q acknowledged at %s, want %q", first.ID, got.State, got.AcknowledgedAt, incident.Acknowledged)
+	}
+	if len(subs) != 1 || subs[0].ID != second.ID || subs[0].State != incident.Acknowledged {
+		t.Errorf("Get(%d) returned sub-incidents %+v, want %d in state %q", first.ID, subs, second.ID, incident.Acknowledged)
+	}
+	if got := details(mustList(ctx, t, s, incident.Filter{State: incident.Resolved})); !cmp.Equal(got, []string{"third"}) {
+		t.Errorf("List() of resolved incidents = %v, want [third]", got)
+	}
+
+	// Clearing the condition resolves the incident, and a later occurrence
+	// starts a new incident.
+	incident.LogResolved(ctx, r, base1, "stale STH")
+	r.LogViolation(ctx, base1, "stale STH", "full", "fourth")
+	if got := details(mustList(ctx, t, s, incident.Filter{BaseURL: base1, State: incident.Open})); !cmp.Equal(got, []string{"fourth"}) {
+		t.Errorf("List() of open incidents = %v, want [fourth]", got)
+	}
+	if got := details(mustList(ctx, t, s, incident.Filter{BaseURL: base1, State: incident.Resolved})); !cmp.Equal(got, []string{"first"}) {
+		t.Errorf("List() of resolved incidents = %v, want [first]", got)
+	}
+}
+
+func testLink(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	populate(ctx, r)
+	recs := mustList(ctx, t, s, incident.Filter{SubIncidents: true})
+	if len(recs) != 3 {
+		t.Fatalf("List() returned %d incidents, want 3", len(recs))
+	}
+	third, second, first := recs[0], recs[1], recs[2]
+
+	// Linking to a sub-incident links to its owner.
+	if err := s.Link(ctx, third.ID, second.ID); err != nil {
+		t.Fatalf("Link(%d, %d) = %s", third.ID, second.ID, err)
+	}
+	if err := s.Link(ctx, third.ID, first.ID); err == nil {
+		t.Errorf("Link(%d, %d) of already linked incident = nil, want error", third.ID, first.ID)
+	}
+	if err := s.Link(ctx, first.ID, 12345); err == nil {
+		t.Errorf("Link(%d, 12345) = nil, want error", first.ID)
+	}
+
+	if got := details(mustList(ctx, t, s, incident.Filter{})); !cmp.Equal(got, []string{"first"}) {
+		t.Errorf("List() after Link() = %v, want [first]", got)
+	}
+	got, subs, err := s.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatalf("Get(%d) = _, _, %s", first.ID, err)
+	}
+	if diff := cmp.Diff(details(subs), []string{"second", "third"}); diff != "" {
+		t.Errorf("Get(%d) sub-incidents: diff (-got +want)\n%s", first.ID, diff)
+	}
+	if got.LastSeen.Before(third.Timestamp) {
+		t.Errorf("Get(%d) = last seen at %s, want at least %s", first.ID, got.LastSeen, third.Timestamp)
+	}
+}
+
+func testSuppressed(ctx context.Context, t *testing.T, r Reporter, s incident.Store) {
+	r.LogViolation(ctx, base1, "stale STH", "full", "first")
+	r.RecordSuppressed(ctx, &incident.Incident{BaseURL: base1, Summary: "stale STH", IsViolation: true, Details: "second"}, "maintenance")
+	r.LogViolation(ctx, base1, "stale STH", "full", "third")
+
+	got := mustList(ctx, t, s, incident.Filter{State: incident.Suppressed})
+	if len(got) != 1 || got[0].Details != "second" || got[0].SilenceID != "maintenance" || got[0].OwningID != 0 {
+		t.Fatalf("List() of suppressed incidents = %+v, want second, suppressed by maintenance", got)
+	}
+	// Suppressed incidents are not grouped with others.
+	if got := details(mustList(ctx, t, s, incident.Filter{State: incident.Open})); !cmp.Equal(got, []string{"first"}) {
+		t.Errorf("List() of open incidents = %v, want [first]", got)
+	}
+	// Nor are they resolved when the condition clears.
+	incident.LogResolved(ctx, r, base1, "stale STH")
+	if got := details(mustList(ctx, t, s, incident.Filter{State: incident.Suppressed})); !cmp.Equal(got, []string{"second"}) {
+		t.Errorf("List() of suppressed incidents after resolution = %v, want [second]", got)
+	}
+}
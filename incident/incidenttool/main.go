@@ -0,0 +1,375 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The incidenttool lists and shows the incidents recorded by the datacollector
+// and other monitoring components, and changes their state.
+//
+// Usage:
+//
+//	incidenttool [flags] list
+//	incidenttool [flags] show ID
+//	incidenttool [flags] ack ID
+//	incidenttool [flags] resolve ID
+//	incidenttool [flags] link ID OWNING_ID
+//	incidenttool --mysql_uri=URI migrate
+//
+// link makes incident ID, and its sub-incidents, sub-incidents of OWNING_ID.
+// migrate adds any columns and indexes that a MySQL Incidents table created
+// by an earlier version of incident.sql lacks.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/local"
+	incidentmysql "github.com/google/monologue/incident/mysql"
+	"github.com/google/monologue/storage/mysql"
+)
+
+var (
+	mysqlURI      = flag.String("mysql_uri", "", "URI of a MySQL database holding incidents (parseTime=true is always set)")
+	incidentsFile = flag.String("incidents_file", "", "JSON file holding incidents, as written by a local incident store. Used if mysql_uri is not set")
+
+	logURL       = flag.String("log_url", "", "Only list incidents for the Log with this base URL")
+	source       = flag.String("source", "", "Only list incidents recorded by this source, e.g. datacollector")
+	since        = flag.String("since", "", "Only list incidents recorded at or after this time, in RFC 3339 format")
+	until        = flag.String("until", "", "Only list incidents recorded before this time, in RFC 3339 format")
+	violations   = flag.String("violations", "", "If true, only list violations. If false, only list other incidents")
+	state        = flag.String("state", "", "Only list incidents in this state: open, acknowledged, resolved or suppressed")
+	subIncidents = flag.Bool("sub_incidents", false, "List sub-incidents as well as owning incidents")
+	limit        = flag.Int("limit", 100, "Maximum number of incidents to list, most recent first. If 0, list all")
+
+	format = flag.String("format", "text", "Output format: text or json")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] list | show ID | ack ID | resolve ID | link ID OWNING_ID | migrate\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func openStore(ctx context.Context) (incident.Store, func(), error) {
+	switch {
+	case *mysqlURI != "":
+		db, err := mysql.Open(*mysqlURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open MySQL database: %s", err)
+		}
+		return incidentmysql.NewMySQLStore(ctx, db), func() { db.Close() }, nil
+	case *incidentsFile != "":
+		if _, err := os.Stat(*incidentsFile); err != nil {
+			return nil, nil, err
+		}
+		s, err := local.New(local.Options{Path: *incidentsFile})
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("neither mysql_uri nor incidents_file provided")
+	}
+}
+
+func parseTime(name, s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	return t, nil
+}
+
+func filter() (incident.Filter, error) {
+	f := incident.Filter{
+		BaseURL:      *logURL,
+		Source:       *source,
+		State:        incident.State(*state),
+		SubIncidents: *subIncidents,
+		Limit:        *limit,
+	}
+	var err error
+	if f.Since, err = parseTime("since", *since); err != nil {
+		return f, err
+	}
+	if f.Until, err = parseTime("until", *until); err != nil {
+		return f, err
+	}
+	if *violations != "" {
+		v, err := strconv.ParseBool(*violations)
+		if err != nil {
+			return f, fmt.Errorf("invalid violations: %s", err)
+		}
+		f.IsViolation = &v
+	}
+	switch f.State {
+	case "", incident.Open, incident.Acknowledged, incident.Resolved, incident.Suppressed:
+	default:
+		return f, fmt.Errorf("invalid state %q", *state)
+	}
+	return f, nil
+}
+
+func parseID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid incident ID %q", s)
+	}
+	return id, nil
+}
+
+// run performs the command given by args, writing its output to w.
+func run(ctx context.Context, s incident.Store, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command provided")
+	}
+	cmd, args := args[0], args[1:]
+	want := map[string]int{"list": 0, "show": 1, "ack": 1, "resolve": 1, "link": 2}
+	n, ok := want[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", cmd, n, len(args))
+	}
+	ids := make([]uint64, len(args))
+	for i, arg := range args {
+		id, err := parseID(arg)
+		if err != nil {
+			return err
+		}
+		ids[i] = id
+	}
+
+	switch cmd {
+	case "list":
+		f, err := filter()
+		if err != nil {
+			return err
+		}
+		recs, err := s.List(ctx, f)
+		if err != nil {
+			return err
+		}
+		return writeList(w, recs)
+	case "show":
+		r, subs, err := s.Get(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		return writeRecord(w, r, subs)
+	case "ack":
+		return s.Acknowledge(ctx, ids[0])
+	case "resolve":
+		return s.Resolve(ctx, ids[0])
+	case "link":
+		return s.Link(ctx, ids[0], ids[1])
+	}
+	return nil
+}
+
+// migrate brings the schema of the MySQL database given by mysql_uri up to
+// date.
+func migrate(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("migrate takes 0 argument(s), got %d", len(args))
+	}
+	if *mysqlURI == "" {
+		return fmt.Errorf("migrate requires mysql_uri")
+	}
+	db, err := mysql.Open(*mysqlURI)
+	if err != nil {
+		return fmt.Errorf("unable to open MySQL database: %s", err)
+	}
+	defer db.Close()
+	return incidentmysql.Migrate(ctx, db)
+}
+
+// jsonRecord is the JSON representation of an incident.Record.
+type jsonRecord struct {
+	ID             uint64                 `json:"id"`
+	OwningID       uint64                 `json:"owning_id,omitempty"`
+	Source         string                 `json:"source"`
+	Timestamp      time.Time              `json:"timestamp"`
+	LastSeen       *time.Time             `json:"last_seen,omitempty"`
+	LogURL         string                 `json:"log_url"`
+	Summary        string                 `json:"summary"`
+	FullURL        string                 `json:"full_url,omitempty"`
+	Details        string                 `json:"details,omitempty"`
+	IsViolation    bool                   `json:"is_violation"`
+	Category       incident.Category      `json:"category,omitempty"`
+	Severity       incident.Severity      `json:"severity"`
+	LogID          string                 `json:"log_id,omitempty"`
+	Evidence       []incident.EvidenceRef `json:"evidence,omitempty"`
+	RFCSections    []string               `json:"rfc_sections,omitempty"`
+	State          incident.State         `json:"state"`
+	AcknowledgedAt *time.Time             `json:"acknowledged_at,omitempty"`
+	ResolvedAt     *time.Time             `json:"resolved_at,omitempty"`
+	SilenceID      string                 `json:"silence_id,omitempty"`
+	SubIncidents   []*jsonRecord          `json:"sub_incidents,omitempty"`
+}
+
+func toJSON(r *incident.Record) *jsonRecord {
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	j := &jsonRecord{
+		ID:             r.ID,
+		OwningID:       r.OwningID,
+		Source:         r.Source,
+		Timestamp:      r.Timestamp,
+		LastSeen:       optional(r.LastSeen),
+		LogURL:         r.BaseURL,
+		Summary:        r.Summary,
+		FullURL:        r.FullURL,
+		Details:        r.Details,
+		IsViolation:    r.IsViolation,
+		Category:       r.Category,
+		Severity:       r.EffectiveSeverity(),
+		Evidence:       r.Evidence,
+		RFCSections:    r.RFCSections,
+		State:          r.State,
+		AcknowledgedAt: optional(r.AcknowledgedAt),
+		ResolvedAt:     optional(r.ResolvedAt),
+		SilenceID:      r.SilenceID,
+	}
+	if r.HasLogID() {
+		j.LogID = r.LogID.String()
+	}
+	return j
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeList(w io.Writer, recs []*incident.Record) error {
+	if *format == "json" {
+		js := []*jsonRecord{}
+		for _, r := range recs {
+			js = append(js, toJSON(r))
+		}
+		return writeJSON(w, js)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tOWNER\tTIME\tSTATE\tKIND\tSEVERITY\tLOG\tSUMMARY")
+	for _, r := range recs {
+		owner := "-"
+		if r.OwningID != 0 {
+			owner = strconv.FormatUint(r.OwningID, 10)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.ID, owner, r.Timestamp.UTC().Format(time.RFC3339), r.State, kind(r), r.EffectiveSeverity(), r.BaseURL, r.Summary)
+	}
+	return tw.Flush()
+}
+
+func writeRecord(w io.Writer, r *incident.Record, subs []*incident.Record) error {
+	if *format == "json" {
+		j := toJSON(r)
+		for _, sub := range subs {
+			j.SubIncidents = append(j.SubIncidents, toJSON(sub))
+		}
+		return writeJSON(w, j)
+	}
+	fmt.Fprintf(w, "Incident %d: %s\n", r.ID, r.Summary)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(w, "  %-16s%s\n", name+":", value)
+		}
+	}
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	if r.OwningID != 0 {
+		field("Owning incident", strconv.FormatUint(r.OwningID, 10))
+	}
+	field("Log", r.BaseURL)
+	field("Source", r.Source)
+	field("Kind", kind(r))
+	field("Category", string(r.Category))
+	field("Severity", string(r.EffectiveSeverity()))
+	field("State", string(r.State))
+	field("Silence", r.SilenceID)
+	field("Recorded", formatTime(r.Timestamp))
+	field("Last seen", formatTime(r.LastSeen))
+	field("Acknowledged", formatTime(r.AcknowledgedAt))
+	field("Resolved", formatTime(r.ResolvedAt))
+	field("URL", r.FullURL)
+	if r.HasLogID() {
+		field("Log ID", r.LogID.String())
+	}
+	field("RFC sections", strings.Join(r.RFCSections, "; "))
+	for _, e := range r.Evidence {
+		field("Evidence", fmt.Sprintf("%s %s", e.Kind, e.ID))
+	}
+	if r.Details != "" {
+		fmt.Fprintf(w, "\n%s\n", r.Details)
+	}
+	if len(subs) > 0 {
+		fmt.Fprintf(w, "\n%d sub-incident(s):\n", len(subs))
+		return writeList(w, subs)
+	}
+	return nil
+}
+
+func kind(r *incident.Record) string {
+	if r.IsViolation {
+		return "violation"
+	}
+	return "update"
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		glog.Exitf("Invalid format %q: must be text or json", *format)
+	}
+
+	ctx := context.Background()
+	if flag.Arg(0) == "migrate" {
+		if err := migrate(ctx, flag.Args()[1:]); err != nil {
+			glog.Exit(err)
+		}
+		return
+	}
+	s, closeStore, err := openStore(ctx)
+	if err != nil {
+		glog.Exit(err)
+	}
+	defer closeStore()
+	if err := run(ctx, s, flag.Args(), os.Stdout); err != nil {
+		closeStore()
+		glog.Exit(err)
+	}
+}
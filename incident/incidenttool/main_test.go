@@ -0,0 +1,195 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/local"
+)
+
+// setFlag sets the flag variable p to v, and returns a function that restores
+// its previous value.
+func setFlag(p *string, v string) func() {
+	old := *p
+	*p = v
+	return func() { *p = old }
+}
+
+func TestFilter(t *testing.T) {
+	yes, no := true, false
+	start := time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	tests := []struct {
+		desc       string
+		since      string
+		until      string
+		violations string
+		state      string
+		want       incident.Filter
+		wantErr    bool
+	}{
+		{desc: "none", want: incident.Filter{Limit: 100}},
+		{desc: "times", since: "2020-03-01T00:00:00Z", until: "2020-03-02T00:00:00Z", want: incident.Filter{Since: start, Until: end, Limit: 100}},
+		{desc: "invalid since", since: "yesterday", wantErr: true},
+		{desc: "invalid until", until: "2020-03-02", wantErr: true},
+		{desc: "violations", violations: "true", want: incident.Filter{IsViolation: &yes, Limit: 100}},
+		{desc: "updates", violations: "false", want: incident.Filter{IsViolation: &no, Limit: 100}},
+		{desc: "invalid violations", violations: "sometimes", wantErr: true},
+		{desc: "state", state: "acknowledged", want: incident.Filter{State: incident.Acknowledged, Limit: 100}},
+		{desc: "invalid state", state: "closed", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			defer setFlag(since, test.since)()
+			defer setFlag(until, test.until)()
+			defer setFlag(violations, test.violations)()
+			defer setFlag(state, test.state)()
+
+			got, err := filter()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("filter() = _, %v, want err %t", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("filter(): diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	const pilot, argon = "https://ct.googleapis.com/pilot/", "https://ct.googleapis.com/logs/argon2020/"
+
+	tests := []struct {
+		desc        string
+		args        []string
+		violations  string
+		format      string
+		wantErr     bool
+		wantOutput  []string
+		wantMissing []string
+		// check, if set, checks the state of the store after the command.
+		check func(t *testing.T, s *local.Store)
+	}{
+		{desc: "no command", wantErr: true},
+		{desc: "unknown command", args: []string{"delete", "1"}, wantErr: true},
+		{desc: "too few args", args: []string{"show"}, wantErr: true},
+		{desc: "too many args", args: []string{"ack", "1", "2"}, wantErr: true},
+		{desc: "zero ID", args: []string{"show", "0"}, wantErr: true},
+		{desc: "invalid ID", args: []string{"resolve", "one"}, wantErr: true},
+		{desc: "unknown ID", args: []string{"show", "3"}, wantErr: true},
+		{
+			desc:       "list",
+			args:       []string{"list"},
+			wantOutput: []string{"ID", "STH is stale", "Root certificates changed", pilot, argon},
+		},
+		{
+			desc:        "list violations",
+			args:        []string{"list"},
+			violations:  "true",
+			wantOutput:  []string{"STH is stale"},
+			wantMissing: []string{"Root certificates changed"},
+		},
+		{
+			desc:       "list json",
+			args:       []string{"list"},
+			format:     "json",
+			wantOutput: []string{`"summary": "STH is stale"`, `"is_violation": true`},
+		},
+		{
+			desc:       "show",
+			args:       []string{"show", "1"},
+			wantOutput: []string{"Incident 1: STH is stale", "Kind:", "violation", "RFC 6962 s3.5", "served a stale STH"},
+		},
+		{
+			desc: "ack",
+			args: []string{"ack", "1"},
+			check: func(t *testing.T, s *local.Store) {
+				if r, _, _ := s.Get(ctx, 1); r.State != incident.Acknowledged {
+					t.Errorf("state after ack = %q, want %q", r.State, incident.Acknowledged)
+				}
+			},
+		},
+		{
+			desc: "resolve",
+			args: []string{"resolve", "2"},
+			check: func(t *testing.T, s *local.Store) {
+				if r, _, _ := s.Get(ctx, 2); r.State != incident.Resolved {
+					t.Errorf("state after resolve = %q, want %q", r.State, incident.Resolved)
+				}
+			},
+		},
+		{
+			desc: "link",
+			args: []string{"link", "2", "1"},
+			check: func(t *testing.T, s *local.Store) {
+				if _, subs, _ := s.Get(ctx, 1); len(subs) != 1 || subs[0].ID != 2 {
+					t.Errorf("sub-incidents of 1 after link = %v, want [2]", subs)
+				}
+			},
+		},
+		{desc: "link to itself", args: []string{"link", "1", "1"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			defer setFlag(violations, test.violations)()
+			f := test.format
+			if f == "" {
+				f = "text"
+			}
+			defer setFlag(format, f)()
+
+			s, err := local.New(local.Options{Source: "test"})
+			if err != nil {
+				t.Fatalf("local.New() = _, %s", err)
+			}
+			s.Report(ctx, &incident.Incident{BaseURL: pilot, Summary: "STH is stale", Details: "pilot served a stale STH", IsViolation: true, RFCSections: []string{"RFC 6962 s3.5"}})
+			s.Report(ctx, &incident.Incident{BaseURL: argon, Summary: "Root certificates changed"})
+
+			var out strings.Builder
+			err = run(ctx, s, test.args, &out)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("run(%q) = %v, want err %t", test.args, err, test.wantErr)
+			}
+			for _, want := range test.wantOutput {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("run(%q) output does not contain %q:\n%s", test.args, want, out.String())
+				}
+			}
+			for _, missing := range test.wantMissing {
+				if strings.Contains(out.String(), missing) {
+					t.Errorf("run(%q) output contains %q:\n%s", test.args, missing, out.String())
+				}
+			}
+			if f == "json" && !json.Valid([]byte(out.String())) {
+				t.Errorf("run(%q) output is not valid JSON:\n%s", test.args, out.String())
+			}
+			if test.check != nil {
+				test.check(t, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package local provides an implementation of incident recording and
+// management that keeps incidents in memory and, optionally, in a local JSON
+// file, for deployments without a database.
+//
+// Incidents are grouped, acknowledged and resolved in the same way as by the
+// MySQL implementation in incident/mysql.  The whole file is rewritten every
+// time an incident is recorded or changed, so it is only suitable for modest
+// numbers of incidents.  Every change is made under a lock on the file, to the
+// incidents most recently saved in it, so the file can be shared by several
+// processes, such as the datacollector and the incidenttool.
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/incident"
+)
+
+// timeNow is the source of incident timestamps, which tests can override.
+var timeNow = time.Now
+
+// Options configures a Store.
+type Options struct {
+	// Path, if set, is the JSON file in which incidents are kept.  It is
+	// loaded when the Store is created, if it exists.
+	Path string
+	// Source is the source of every incident recorded by the Store.
+	Source string
+	// GroupWindow is how long after the last occurrence of an incident a new
+	// occurrence is grouped with it.  If 0, incidents are never grouped.
+	GroupWindow time.Duration
+}
+
+// lockExtension is appended to Options.Path to give the name of the file that
+// is locked while the incidents are read or changed.  The incidents file itself
+// cannot be locked, as it is replaced whenever it is saved.
+const lockExtension = ".lock"
+
+// Store records incidents, and implements incident.Store for them.  It is safe
+// for concurrent use, including by other processes sharing its file.
+type Store struct {
+	opts Options
+
+	mu      sync.Mutex
+	records []*incident.Record // ordered by ID
+}
+
+// New returns a Store configured by opts, containing the incidents in
+// opts.Path.
+func New(opts Options) (*Store, error) {
+	s := &Store{opts: opts}
+	if err := s.view(func() error { return nil }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// view calls fn with s.mu held, once the incidents saved in opts.Path, if set,
+// have been loaded.
+func (s *Store) view(fn func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.Path == "" {
+		return fn()
+	}
+	unlock, err := lockFile(s.opts.Path + lockExtension)
+	if err != nil {
+		return fmt.Errorf("error locking incidents: %s", err)
+	}
+	defer unlock()
+	if err := s.loadLocked(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// change calls fn with s.mu held, to change the incidents, and saves them if
+// fn reports that it changed them.  If opts.Path is set, the file is locked
+// and the incidents saved in it are loaded first, so that changes made by
+// other processes sharing the file are not lost.  If the incidents cannot be
+// loaded, fn still changes those in memory, but they are not saved.
+func (s *Store) change(fn func() (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.Path == "" {
+		_, err := fn()
+		return err
+	}
+	unlock, err := lockFile(s.opts.Path + lockExtension)
+	if err != nil {
+		fn()
+		return fmt.Errorf("error locking incidents: %s", err)
+	}
+	defer unlock()
+	if err := s.loadLocked(); err != nil {
+		fn()
+		return err
+	}
+	changed, err := fn()
+	if err != nil || !changed {
+		return err
+	}
+	return s.writeLocked()
+}
+
+// record is change for the methods of incident.Reporter, which cannot return
+// errors, so log them instead.
+func (s *Store) record(fn func() bool) {
+	if err := s.change(func() (bool, error) { return fn(), nil }); err != nil {
+		glog.Errorf("local: failed to save incidents to %s: %s", s.opts.Path, err)
+	}
+}
+
+// loadLocked replaces the incidents in memory with those saved in opts.Path,
+// if it exists.
+func (s *Store) loadLocked() error {
+	b, err := ioutil.ReadFile(s.opts.Path)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	var records []*incident.Record
+	if err := json.Unmarshal(b, &records); err != nil {
+		return fmt.Errorf("error parsing incidents from %s: %s", s.opts.Path, err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	s.records = records
+	return nil
+}
+
+// LogUpdate records an incident with the given details.
+func (s *Store) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
+	s.Report(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details})
+}
+
+// LogUpdatef records an incident with the given details and formatting.
+func (s *Store) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	s.LogUpdate(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogViolation records an incident with the given details.
+func (s *Store) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
+	s.Report(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details, IsViolation: true})
+}
+
+// LogViolationf records an incident with the given details and formatting.
+func (s *Store) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	s.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// Report records the given incident, as a sub-incident of an earlier
+// occurrence of the same incident if there is one within the grouping window.
+func (s *Store) Report(ctx context.Context, inc *incident.Incident) {
+	now := timeNow()
+	s.record(func() bool {
+		r := &incident.Record{Incident: *inc, Source: s.opts.Source, Timestamp: now, LastSeen: now, State: incident.Open}
+		if owner := s.groupLocked(inc, now); owner != nil {
+			r.OwningID = owner.ID
+			r.LastSeen = time.Time{}
+			owner.LastSeen = now
+		}
+		s.appendLocked(r)
+		return true
+	})
+}
+
+// RecordSuppressed records an incident as suppressed by the silence with the
+// given ID.  Suppressed incidents are never grouped, and are not resolved by
+// LogResolved.
+func (s *Store) RecordSuppressed(ctx context.Context, inc *incident.Incident, silenceID string) {
+	now := timeNow()
+	s.record(func() bool {
+		s.appendLocked(&incident.Record{Incident: *inc, Source: s.opts.Source, Timestamp: now, LastSeen: now, State: incident.Suppressed, SilenceID: silenceID})
+		return true
+	})
+}
+
+// LogResolved marks every unresolved incident from this Store's source with the
+// given details as resolved.
+func (s *Store) LogResolved(ctx context.Context, baseURL, summary string) {
+	now := timeNow()
+	s.record(func() bool {
+		changed := false
+		for _, r := range s.records {
+			if r.OwningID == 0 && r.Source == s.opts.Source && r.BaseURL == baseURL && r.Summary == summary && active(r) {
+				r.State = incident.Resolved
+				r.ResolvedAt = now
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+// active reports whether r is neither resolved nor suppressed.
+func active(r *incident.Record) bool {
+	return r.State != incident.Resolved && r.State != incident.Suppressed
+}
+
+// groupLocked returns the owning incident that an occurrence of inc at now
+// should be grouped with, or nil if there is none.
+func (s *Store) groupLocked(inc *incident.Incident, now time.Time) *incident.Record {
+	if s.opts.GroupWindow <= 0 {
+		return nil
+	}
+	for i := len(s.records) - 1; i >= 0; i-- {
+		r := s.records[i]
+		if r.OwningID != 0 || r.Source != s.opts.Source || r.BaseURL != inc.BaseURL || r.Summary != inc.Summary || !active(r) {
+			continue
+		}
+		if now.Sub(r.LastSeen) > s.opts.GroupWindow {
+			return nil
+		}
+		return r
+	}
+	return nil
+}
+
+func (s *Store) appendLocked(r *incident.Record) {
+	r.ID = 1
+	if n := len(s.records); n > 0 {
+		r.ID = s.records[n-1].ID + 1
+	}
+	s.records = append(s.records, r)
+}
+
+// writeLocked atomically rewrites the file at opts.Path.
+func (s *Store) writeLocked() error {
+	b, err := json.Marshal(s.records)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.opts.Path), filepath.Base(s.opts.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.opts.Path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// findLocked returns the incident with the given ID, or nil.
+func (s *Store) findLocked(id uint64) *incident.Record {
+	i := sort.Search(len(s.records), func(i int) bool { return s.records[i].ID >= id })
+	if i < len(s.records) && s.records[i].ID == id {
+		return s.records[i]
+	}
+	return nil
+}
+
+// ownerLocked returns the owning incident of the incident with the given ID,
+// or the incident itself if it has no owner.
+func (s *Store) ownerLocked(id uint64) (*incident.Record, error) {
+	r := s.findLocked(id)
+	if r == nil {
+		return nil, fmt.Errorf("no incident with ID %d", id)
+	}
+	if r.OwningID != 0 {
+		if o := s.findLocked(r.OwningID); o != nil {
+			return o, nil
+		}
+	}
+	return r, nil
+}
+
+// viewLocked returns a copy of r, with the state of its owning incident.
+func (s *Store) viewLocked(r *incident.Record) *incident.Record {
+	v := *r
+	if r.OwningID != 0 {
+		if o := s.findLocked(r.OwningID); o != nil {
+			v.State, v.AcknowledgedAt, v.ResolvedAt = o.State, o.AcknowledgedAt, o.ResolvedAt
+		}
+	}
+	return &v
+}
+
+// List returns the incidents selected by f, most recent first.
+func (s *Store) List(ctx context.Context, f incident.Filter) ([]*incident.Record, error) {
+	var recs []*incident.Record
+	if err := s.view(func() error {
+		for _, r := range s.records {
+			if v := s.viewLocked(r); f.Matches(v) {
+				recs = append(recs, v)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		if !recs[i].Timestamp.Equal(recs[j].Timestamp) {
+			return recs[i].Timestamp.After(recs[j].Timestamp)
+		}
+		return recs[i].ID > recs[j].ID
+	})
+	if f.Limit > 0 && len(recs) > f.Limit {
+		recs = recs[:f.Limit]
+	}
+	return recs, nil
+}
+
+// Get returns the incident with the given ID, and its sub-incidents in the
+// order they were recorded.
+func (s *Store) Get(ctx context.Context, id uint64) (*incident.Record, []*incident.Record, error) {
+	var v *incident.Record
+	var subs []*incident.Record
+	err := s.view(func() error {
+		r := s.findLocked(id)
+		if r == nil {
+			return fmt.Errorf("no incident with ID %d", id)
+		}
+		v = s.viewLocked(r)
+		for _, sub := range s.records {
+			if sub.OwningID == id {
+				subs = append(subs, s.viewLocked(sub))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, subs, nil
+}
+
+// Acknowledge marks an open incident as acknowledged.  Acknowledging an
+// incident that is already acknowledged or resolved has no effect.
+func (s *Store) Acknowledge(ctx context.Context, id uint64) error {
+	return s.change(func() (bool, error) {
+		o, err := s.ownerLocked(id)
+		if err != nil || o.State != incident.Open {
+			return false, err
+		}
+		o.State = incident.Acknowledged
+		o.AcknowledgedAt = timeNow()
+		return true, nil
+	})
+}
+
+// Resolve marks an incident as resolved.  Resolving an incident that is
+// already resolved has no effect.
+func (s *Store) Resolve(ctx context.Context, id uint64) error {
+	return s.change(func() (bool, error) {
+		o, err := s.ownerLocked(id)
+		if err != nil || o.State == incident.Resolved {
+			return false, err
+		}
+		o.State = incident.Resolved
+		o.ResolvedAt = timeNow()
+		return true, nil
+	})
+}
+
+// Link makes an incident, and its sub-incidents, sub-incidents of the owning
+// incident of owningID, and updates the LastSeen time of that owning incident
+// to include them.
+func (s *Store) Link(ctx context.Context, id, owningID uint64) error {
+	return s.change(func() (bool, error) {
+		owner, err := s.ownerLocked(owningID)
+		if err != nil {
+			return false, err
+		}
+		current, err := s.ownerLocked(id)
+		if err != nil {
+			return false, err
+		}
+		if current == owner {
+			return false, fmt.Errorf("incident %d is already part of incident %d", id, owner.ID)
+		}
+		for _, r := range s.records {
+			if r.ID == id || r.OwningID == id {
+				r.OwningID = owner.ID
+				r.LastSeen = time.Time{}
+				if r.Timestamp.After(owner.LastSeen) {
+					owner.LastSeen = r.Timestamp
+				}
+			}
+		}
+		return true, nil
+	})
+}
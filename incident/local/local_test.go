@@ -0,0 +1,146 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/incidenttest"
+)
+
+func TestStoreConformance(t *testing.T) {
+	incidenttest.RunStore(t, func(ctx context.Context, t *testing.T) (incidenttest.Reporter, incident.Store) {
+		s, err := New(Options{Source: "unittest", GroupWindow: time.Hour})
+		if err != nil {
+			t.Fatalf("New()=nil,%v; want _,nil", err)
+		}
+		return s, s
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{Path: filepath.Join(dir, "incidents.json"), Source: "unittest", GroupWindow: time.Hour}
+
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New()=nil,%v; want _,nil", err)
+	}
+	s.LogViolation(ctx, "https://ct.example.com/", "stale STH", "full", "first")
+	s.LogViolation(ctx, "https://ct.example.com/", "stale STH", "full", "second")
+	s.LogUpdate(ctx, "https://ct.example.com/", "roots changed", "full", "third")
+	if err := s.Acknowledge(ctx, 1); err != nil {
+		t.Fatalf("Acknowledge(1)=%v; want nil", err)
+	}
+
+	reloaded, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() after reload=nil,%v; want _,nil", err)
+	}
+	got, subs, err := reloaded.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("Get(1)=_,_,%v; want _,_,nil", err)
+	}
+	if got.Details != "first" || got.State != incident.Acknowledged || len(subs) != 1 {
+		t.Errorf("Get(1)=%+v,%d sub-incidents; want first, acknowledged, 1 sub-incident", got, len(subs))
+	}
+
+	// New incidents continue the sequence of IDs, and are grouped with those
+	// that were loaded.
+	reloaded.LogViolation(ctx, "https://ct.example.com/", "stale STH", "full", "fourth")
+	recs, err := reloaded.List(ctx, incident.Filter{SubIncidents: true, Limit: 1})
+	if err != nil {
+		t.Fatalf("List()=nil,%v; want _,nil", err)
+	}
+	if len(recs) != 1 || recs[0].ID != 4 || recs[0].OwningID != 1 {
+		t.Errorf("List()=%+v; want incident 4 owned by incident 1", recs)
+	}
+}
+
+func TestSharedFile(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "local")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "incidents.json")
+
+	// collector and tool share the file, as the datacollector and the
+	// incidenttool do.
+	collector, err := New(Options{Path: path, Source: "unittest"})
+	if err != nil {
+		t.Fatalf("New()=nil,%v; want _,nil", err)
+	}
+	collector.LogViolation(ctx, "https://ct.example.com/", "stale STH", "full", "first")
+	tool, err := New(Options{Path: path})
+	if err != nil {
+		t.Fatalf("New()=nil,%v; want _,nil", err)
+	}
+	if err := tool.Acknowledge(ctx, 1); err != nil {
+		t.Fatalf("Acknowledge(1)=%v; want nil", err)
+	}
+	// Neither overwrites the changes of the other.
+	collector.LogUpdate(ctx, "https://ct.example.com/", "roots changed", "full", "second")
+	if err := tool.Resolve(ctx, 2); err != nil {
+		t.Fatalf("Resolve(2)=%v; want nil", err)
+	}
+
+	for _, s := range []*Store{collector, tool} {
+		recs, err := s.List(ctx, incident.Filter{})
+		if err != nil {
+			t.Fatalf("List()=nil,%v; want _,nil", err)
+		}
+		if len(recs) != 2 || recs[0].ID != 2 || recs[0].State != incident.Resolved || recs[1].ID != 1 || recs[1].State != incident.Acknowledged {
+			t.Errorf("List()=%+v; want incident 2 resolved and incident 1 acknowledged", recs)
+		}
+	}
+
+	// Incidents recorded concurrently through both get distinct IDs.
+	var wg sync.WaitGroup
+	for _, s := range []*Store{collector, tool} {
+		wg.Add(1)
+		go func(s *Store) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				s.LogUpdate(ctx, "https://ct.example.com/", "roots changed", "full", "concurrent")
+			}
+		}(s)
+	}
+	wg.Wait()
+	recs, err := collector.List(ctx, incident.Filter{})
+	if err != nil {
+		t.Fatalf("List()=nil,%v; want _,nil", err)
+	}
+	seen := make(map[uint64]bool)
+	for _, r := range recs {
+		seen[r.ID] = true
+	}
+	if len(recs) != 42 || len(seen) != 42 {
+		t.Errorf("List() returned %d incidents with %d distinct IDs; want 42", len(recs), len(seen))
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package local
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// lockFile always fails, as there is no flock on this platform, so a Store
+// that keeps its incidents in a file cannot safely share it with other
+// processes.
+func lockFile(path string) (func(), error) {
+	return nil, fmt.Errorf("cannot lock %s: file locking is not supported on %s", path, runtime.GOOS)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/retention"
+)
+
+type evidenceHolds struct {
+	db     *sql.DB
+	margin time.Duration
+}
+
+// NewEvidenceHolds builds a retention.Holds that holds all records about a Log
+// from margin before each unresolved incident recorded for that Log in a MySQL
+// database was first seen until margin after now, for as long as the incident
+// remains unresolved.  Suppressed incidents are not held.
+func NewEvidenceHolds(ctx context.Context, db *sql.DB, margin time.Duration) retention.Holds {
+	return &evidenceHolds{db: db, margin: margin}
+}
+
+// Holds returns a Window for each unresolved incident, or sub-incident of an
+// unresolved incident, whose BaseURL is the URL of l.  The Window starts at the
+// Timestamp of the owning incident, which is when the problem was first seen,
+// so that the records behind the earliest evidence of an incident that is
+// still being seen are kept along with the latest.
+func (e *evidenceHolds) Holds(ctx context.Context, l *ctlog.Log) ([]retention.Window, error) {
+	rows, err := e.db.QueryContext(ctx, "SELECT COALESCE(o.Timestamp, i.Timestamp) FROM Incidents i LEFT JOIN Incidents o ON i.OwningId = o.Id WHERE i.BaseURL = ? AND COALESCE(o.State, i.State) NOT IN (?, ?);", l.URL, incident.Resolved, incident.Suppressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query incidents for %s: %v", l.URL, err)
+	}
+	defer rows.Close()
+
+	end := timeNow().Add(e.margin)
+	var windows []retention.Window
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return nil, fmt.Errorf("failed to scan incident for %s: %v", l.URL, err)
+		}
+		windows = append(windows, retention.Window{Start: ts.Add(-e.margin), End: end})
+	}
+	return windows, rows.Err()
+}
+
+type evidenceBlobRefs struct {
+	db *sql.DB
+}
+
+// NewEvidenceBlobRefs builds a blob.Referencer for the blobs named as evidence
+// by the incidents recorded in a MySQL database, so that they are not collected
+// while the incidents that refer to them are kept.
+func NewEvidenceBlobRefs(ctx context.Context, db *sql.DB) blob.Referencer {
+	return &evidenceBlobRefs{db: db}
+}
+
+// BlobRefs calls fn with the Hash of every blob in the Evidence of an incident,
+// which is an incident.EvidenceRef of Kind blob.EvidenceKind whose ID is the
+// hex-encoded Hash.
+func (e *evidenceBlobRefs) BlobRefs(ctx context.Context, fn func(h blob.Hash) error) error {
+	rows, err := e.db.QueryContext(ctx, "SELECT Evidence FROM Incidents WHERE Evidence IS NOT NULL;")
+	if err != nil {
+		return fmt.Errorf("failed to query incident evidence: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var evidence string
+		if err := rows.Scan(&evidence); err != nil {
+			return fmt.Errorf("failed to scan incident evidence: %v", err)
+		}
+		var refs []incident.EvidenceRef
+		if err := json.Unmarshal([]byte(evidence), &refs); err != nil {
+			return fmt.Errorf("failed to parse incident evidence %q: %v", evidence, err)
+		}
+		for _, ref := range refs {
+			if ref.Kind != blob.EvidenceKind {
+				continue
+			}
+			h, err := blob.ParseHash(ref.ID)
+			if err != nil {
+				return fmt.Errorf("invalid blob hash in incident evidence %q: %v", evidence, err)
+			}
+			if err := fn(h); err != nil {
+				return err
+			}
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/mysql/testdb"
+	"github.com/google/monologue/storage/retention"
+)
+
+func TestEvidenceHolds(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+
+	reporter, err := NewMySQLReporter(ctx, testDB, "unittest")
+	if err != nil {
+		t.Fatalf("failed to build MySQLReporter: %v", err)
+	}
+	l := &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+	other := &ctlog.Log{Name: "aviator", URL: "https://ct.googleapis.com/aviator/"}
+	reporter.LogViolation(ctx, l.URL, "summary", "full", "details")
+
+	holds := NewEvidenceHolds(ctx, testDB, time.Hour)
+	windows, err := holds.Holds(ctx, l)
+	if err != nil {
+		t.Fatalf("Holds(%s) = _, %v", l.Name, err)
+	}
+	if len(windows) != 1 {
+		t.Fatalf("Holds(%s) returned %d windows, want 1", l.Name, len(windows))
+	}
+	if now := time.Now(); !retention.Held(windows, now) {
+		t.Errorf("Holds(%s) = %v, which does not hold %s", l.Name, windows, now)
+	}
+
+	// Once resolved, the incident no longer holds anything.
+	incident.LogResolved(ctx, reporter, l.URL, "summary")
+	windows, err = holds.Holds(ctx, l)
+	if err != nil {
+		t.Fatalf("Holds(%s) = _, %v", l.Name, err)
+	}
+	if len(windows) != 0 {
+		t.Errorf("Holds(%s) after resolution = %v, want none", l.Name, windows)
+	}
+
+	windows, err = holds.Holds(ctx, other)
+	if err != nil {
+		t.Fatalf("Holds(%s) = _, %v", other.Name, err)
+	}
+	if len(windows) != 0 {
+		t.Errorf("Holds(%s) = %v, want none", other.Name, windows)
+	}
+}
+
+func TestEvidenceHoldsLongRunning(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+
+	reporter, err := NewGroupingMySQLReporter(ctx, testDB, "unittest", 2*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to build grouping MySQLReporter: %v", err)
+	}
+	l := &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+	// The same problem is seen every hour for three days.
+	for d := time.Duration(0); d <= 72*time.Hour; d += time.Hour {
+		now = start.Add(d)
+		reporter.LogViolation(ctx, l.URL, "log down", "full", "details")
+	}
+	now = now.Add(30 * time.Minute)
+
+	windows, err := NewEvidenceHolds(ctx, testDB, time.Hour).Holds(ctx, l)
+	if err != nil {
+		t.Fatalf("Holds(%s) = _, %v", l.Name, err)
+	}
+	// Everything from when the problem was first seen until now is held.
+	for d := time.Duration(0); d <= 72*time.Hour; d += 30 * time.Minute {
+		if ts := start.Add(d); !retention.Held(windows, ts) {
+			t.Errorf("Holds(%s) = %v, which does not hold %s", l.Name, windows, ts)
+		}
+	}
+	if ts := start.Add(-2 * time.Hour); retention.Held(windows, ts) {
+		t.Errorf("Holds(%s) = %v, which holds %s", l.Name, windows, ts)
+	}
+	if !retention.Held(windows, now) {
+		t.Errorf("Holds(%s) = %v, which does not hold now (%s)", l.Name, windows, now)
+	}
+}
+
+func TestEvidenceBlobRefs(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+
+	reporter, err := NewMySQLReporter(ctx, testDB, "unittest")
+	if err != nil {
+		t.Fatalf("failed to build MySQLReporter: %v", err)
+	}
+	h := blob.HashOf([]byte("response body"))
+	reporter.Report(ctx, &incident.Incident{
+		BaseURL:  "https://ct.googleapis.com/pilot/",
+		Summary:  "summary",
+		Evidence: []incident.EvidenceRef{{Kind: "sth", ID: "42"}, {Kind: "blob", ID: h.String()}},
+	})
+	reporter.LogUpdate(ctx, "https://ct.googleapis.com/pilot/", "no evidence", "full", "details")
+
+	var got []blob.Hash
+	if err := NewEvidenceBlobRefs(ctx, testDB).BlobRefs(ctx, func(h blob.Hash) error {
+		got = append(got, h)
+		return nil
+	}); err != nil {
+		t.Fatalf("BlobRefs() = %v", err)
+	}
+	if len(got) != 1 || got[0] != h {
+		t.Errorf("BlobRefs() found %v, want [%s]", got, h)
+	}
+}
@@ -13,53 +13,201 @@
 // limitations under the License.
 
 // Package mysql provides a MySQL based implementation of incident management.
+//
+// Incident times are scanned from DATETIME columns, so databases must be
+// opened with parseTime=true set in their data source name, as
+// storage/mysql.Open does.
 package mysql
 
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/monologue/incident"
 )
 
+// rfcSectionSeparator separates the RFC sections cited by an incident in the
+// RFCSections column.
+const rfcSectionSeparator = "; "
+
+// timeNow is the source of incident timestamps, which tests can override.
+var timeNow = time.Now
+
 type mysqlReporter struct {
 	db     *sql.DB
 	stmt   *sql.Stmt
 	source string
+	// window is how long after the last occurrence of an incident a new
+	// occurrence is grouped with it.  If 0, incidents are never grouped.
+	window time.Duration
+}
+
+// Reporter is an incident.StructuredReporter that records incidents in a MySQL
+// database, including those suppressed by a silence.
+type Reporter interface {
+	incident.StructuredReporter
+	incident.Resolver
+	incident.SuppressionRecorder
 }
 
 // NewMySQLReporter builds an incident.Reporter instance that records incidents
 // in a MySQL database, all of which will be marked as emanating from the given
 // source.
-func NewMySQLReporter(ctx context.Context, db *sql.DB, source string) (incident.Reporter, error) {
-	stmt, err := db.PrepareContext(ctx, "INSERT INTO Incidents(Timestamp, Source, BaseURL, Summary, IsViolation, FullURL, Details) VALUES (?, ?, ?, ?, ?, ?, ?);")
+func NewMySQLReporter(ctx context.Context, db *sql.DB, source string) (Reporter, error) {
+	return NewGroupingMySQLReporter(ctx, db, source, 0)
+}
+
+// NewGroupingMySQLReporter builds an incident.Reporter instance that records
+// incidents in a MySQL database, all of which will be marked as emanating from
+// the given source.
+//
+// Repeated occurrences of an incident, with the same source, base URL and
+// summary, are grouped together: an occurrence within window of the last one
+// is recorded as a sub-incident of the first, whose LastSeen time is updated,
+// unless the first has since been resolved.
+// An ongoing problem that is reported every time the Log is polled therefore
+// appears as a single incident.  If window is 0, incidents are never grouped.
+func NewGroupingMySQLReporter(ctx context.Context, db *sql.DB, source string, window time.Duration) (Reporter, error) {
+	stmt, err := db.PrepareContext(ctx, "INSERT INTO Incidents(Timestamp, Source, BaseURL, Summary, IsViolation, FullURL, Details, OwningId, LastSeen, Category, Severity, LogID, Evidence, RFCSections) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);")
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare context for %q: %v", source, err)
 	}
-	return &mysqlReporter{db: db, source: source, stmt: stmt}, nil
+	return &mysqlReporter{db: db, source: source, stmt: stmt, window: window}, nil
 }
 
 // LogUpdate records an incident with the given details.
 func (m *mysqlReporter) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
-	now := time.Now()
-	glog.Errorf("[%s] %s: %s (url=%s)\n  %s", now, baseURL, summary, fullURL, details)
-	if _, err := m.stmt.ExecContext(ctx, now, m.source, baseURL, summary, false /* isViolation */, fullURL, details); err != nil {
-		glog.Errorf("failed to insert incident for %q: %v", m.source, err)
-	}
+	m.Report(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details})
 }
 
 // LogViolation records an incident with the given details.
 func (m *mysqlReporter) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
-	now := time.Now()
-	glog.Infof("[%s] %s: %s (url=%s)\n  %s", now, baseURL, summary, fullURL, details)
-	if _, err := m.stmt.ExecContext(ctx, now, m.source, baseURL, summary, true /* isViolation */, fullURL, details); err != nil {
+	m.Report(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details, IsViolation: true})
+}
+
+// Report records the given incident.
+func (m *mysqlReporter) Report(ctx context.Context, inc *incident.Incident) {
+	now := timeNow()
+	if inc.IsViolation {
+		glog.Errorf("[%s] %s: %s (url=%s)\n  %s", now, inc.BaseURL, inc.Summary, inc.FullURL, inc.Details)
+	} else {
+		glog.Infof("[%s] %s: %s (url=%s)\n  %s", now, inc.BaseURL, inc.Summary, inc.FullURL, inc.Details)
+	}
+	if err := m.record(ctx, now, inc); err != nil {
 		glog.Errorf("failed to insert incident for %q: %v", m.source, err)
 	}
 }
 
+// LogResolved marks every unresolved incident from this reporter's source with
+// the given details as resolved.
+func (m *mysqlReporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	res, err := m.db.ExecContext(ctx, "UPDATE Incidents SET State = ?, ResolvedAt = ? WHERE OwningId IS NULL AND Source = ? AND BaseURL = ? AND Summary = ? AND State NOT IN (?, ?);", incident.Resolved, timeNow(), m.source, baseURL, summary, incident.Resolved, incident.Suppressed)
+	if err != nil {
+		glog.Errorf("failed to resolve incidents for %q: %v", m.source, err)
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		glog.Infof("%s: %s: resolved %d incident(s)", baseURL, summary, n)
+	}
+}
+
+// RecordSuppressed records an incident as suppressed by the silence with the
+// given ID.  Suppressed incidents are never grouped, and are not resolved by
+// LogResolved.
+func (m *mysqlReporter) RecordSuppressed(ctx context.Context, inc *incident.Incident, silenceID string) {
+	now := timeNow()
+	glog.Infof("[%s] %s: %s (url=%s): suppressed by silence %s", now, inc.BaseURL, inc.Summary, inc.FullURL, silenceID)
+	cols, err := structuredColumns(inc)
+	if err == nil {
+		args := append(m.args(now, inc, nil, now, cols), incident.Suppressed, silenceID)
+		_, err = m.db.ExecContext(ctx, "INSERT INTO Incidents(Timestamp, Source, BaseURL, Summary, IsViolation, FullURL, Details, OwningId, LastSeen, Category, Severity, LogID, Evidence, RFCSections, State, SilenceId) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);", args...)
+	}
+	if err != nil {
+		glog.Errorf("failed to insert suppressed incident for %q: %v", m.source, err)
+	}
+}
+
+// record inserts an incident, as a sub-incident of an earlier occurrence of
+// the same incident if there is one within the grouping window.
+func (m *mysqlReporter) record(ctx context.Context, now time.Time, inc *incident.Incident) error {
+	cols, err := structuredColumns(inc)
+	if err != nil {
+		return err
+	}
+	if m.window <= 0 {
+		_, err := m.stmt.ExecContext(ctx, m.args(now, inc, nil, now, cols)...)
+		return err
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil /* opts */)
+	if err != nil {
+		return err
+	}
+	if err := m.recordGrouped(ctx, tx, now, inc, cols); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *mysqlReporter) recordGrouped(ctx context.Context, tx *sql.Tx, now time.Time, inc *incident.Incident, cols []interface{}) error {
+	var owningID uint64
+	var lastSeen time.Time
+	err := tx.QueryRowContext(ctx, "SELECT Id, COALESCE(LastSeen, Timestamp) FROM Incidents WHERE OwningId IS NULL AND Source = ? AND BaseURL = ? AND Summary = ? AND State NOT IN (?, ?) ORDER BY Id DESC LIMIT 1 FOR UPDATE;", m.source, inc.BaseURL, inc.Summary, incident.Resolved, incident.Suppressed).Scan(&owningID, &lastSeen)
+	switch {
+	case err == sql.ErrNoRows || (err == nil && now.Sub(lastSeen) > m.window):
+		// Start a new group.
+		_, err := tx.StmtContext(ctx, m.stmt).ExecContext(ctx, m.args(now, inc, nil, now, cols)...)
+		return err
+	case err != nil:
+		return err
+	}
+
+	if _, err := tx.StmtContext(ctx, m.stmt).ExecContext(ctx, m.args(now, inc, owningID, nil, cols)...); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE Incidents SET LastSeen = ? WHERE Id = ?;", now, owningID)
+	return err
+}
+
+// args returns the arguments for the insert statement.
+func (m *mysqlReporter) args(now time.Time, inc *incident.Incident, owningID, lastSeen interface{}, cols []interface{}) []interface{} {
+	args := []interface{}{now, m.source, inc.BaseURL, inc.Summary, inc.IsViolation, inc.FullURL, inc.Details, owningID, lastSeen}
+	return append(args, cols...)
+}
+
+// structuredColumns returns the values of the Category, Severity, LogID,
+// Evidence and RFCSections columns for inc.  Each is NULL if inc does not set
+// it, as is the case for incidents recorded with the string methods.
+func structuredColumns(inc *incident.Incident) ([]interface{}, error) {
+	cols := []interface{}{nil, nil, nil, nil, nil}
+	if inc.Category != incident.Uncategorized {
+		cols[0] = string(inc.Category)
+	}
+	if inc.Severity != "" {
+		cols[1] = string(inc.Severity)
+	}
+	if inc.HasLogID() {
+		cols[2] = inc.LogID.String()
+	}
+	if len(inc.Evidence) > 0 {
+		evidence, err := json.Marshal(inc.Evidence)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal evidence: %v", err)
+		}
+		cols[3] = string(evidence)
+	}
+	if len(inc.RFCSections) > 0 {
+		cols[4] = strings.Join(inc.RFCSections, rfcSectionSeparator)
+	}
+	return cols, nil
+}
+
 // LogUpdatef records an incident with the given details and formatting.
 func (m *mysqlReporter) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
 	details := fmt.Sprintf(detailsFmt, args...)
@@ -9,6 +9,27 @@ CREATE TABLE IF NOT EXISTS Incidents(
   Details TEXT,
   -- OwningId indicates that an incident is considered a sub-incident of the owning incident.
   OwningId BIGINT UNSIGNED NULL,
+  -- LastSeen is the time of the most recent sub-incident of an owning incident,
+  -- whose own Timestamp is the time it was first seen.
+  LastSeen DATETIME NULL,
+  -- State is one of 'open', 'acknowledged', 'resolved' or 'suppressed'.  The
+  -- state of a sub-incident is that of its owning incident.
+  State VARCHAR(16) NOT NULL DEFAULT 'open',
+  AcknowledgedAt DATETIME NULL,
+  ResolvedAt DATETIME NULL,
+  -- The structured fields of an incident.Incident.  They are NULL for
+  -- incidents recorded without them, so that rows written through the string
+  -- methods of incident.Reporter are unchanged.
+  Category VARCHAR(32) NULL,
+  Severity VARCHAR(16) NULL,
+  -- LogID is base64-encoded.
+  LogID VARCHAR(64) NULL,
+  -- Evidence is a JSON list of incident.EvidenceRef.
+  Evidence TEXT NULL,
+  -- RFCSections is a "; " separated list of citations.
+  RFCSections VARCHAR(512) NULL,
+  -- SilenceId is the ID of the silence that suppressed a 'suppressed' incident.
+  SilenceId VARCHAR(64) NULL,
   PRIMARY KEY(Id),
   FOREIGN KEY(OwningId) REFERENCES Incidents(Id)
 );
@@ -20,3 +41,6 @@ CREATE INDEX BaseURLIndex ON Incidents(BaseURL);
 # character sets.
 CREATE INDEX SummaryIndex ON Incidents(Summary(512));
 CREATE INDEX FullURLIndex ON Incidents(FullURL);
+CREATE INDEX StateIndex ON Incidents(State);
+CREATE INDEX CategoryIndex ON Incidents(Category);
+CREATE INDEX LogIDIndex ON Incidents(LogID);
@@ -20,9 +20,12 @@ import (
 	"flag"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/logid"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
 	"github.com/google/monologue/storage/mysql/testdb"
 
 	_ "github.com/go-sql-driver/mysql" // Load MySQL driver
@@ -88,6 +91,138 @@ func TestLogf(t *testing.T) {
 	checkContents(ctx, testDB, t, []entry{e, ev, e})
 }
 
+type structuredEntry struct {
+	Category, Severity, LogID, Evidence, RFCSections sql.NullString
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+
+	reporter, err := NewMySQLReporter(ctx, testDB, "unittest")
+	if err != nil {
+		t.Fatalf("failed to build MySQLReporter: %v", err)
+	}
+	logID := logid.FromPubKeyB64OrDie("MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==")
+	reporter.LogUpdate(ctx, "base", "summary", "full", "blah")
+	incident.Report(ctx, reporter, &incident.Incident{
+		BaseURL:     "base",
+		Summary:     "STH older than MMD",
+		FullURL:     "full",
+		Details:     "blah",
+		IsViolation: true,
+		Category:    incident.MMDBreach,
+		LogID:       logID,
+		Evidence:    []incident.EvidenceRef{{Kind: "sth", ID: "42"}},
+		RFCSections: []string{"RFC 6962 s3.5", "RFC 6962 s3.6"},
+	})
+	checkContents(ctx, testDB, t, []entry{
+		{BaseURL: "base", Summary: "summary", FullURL: "full", Details: "blah"},
+		{BaseURL: "base", Summary: "STH older than MMD", IsViolation: true, FullURL: "full", Details: "blah"},
+	})
+
+	rows, err := testDB.QueryContext(ctx, "SELECT Category, Severity, LogID, Evidence, RFCSections FROM Incidents ORDER BY Id;")
+	if err != nil {
+		t.Fatalf("failed to query rows: %v", err)
+	}
+	defer rows.Close()
+	var got []structuredEntry
+	for rows.Next() {
+		var e structuredEntry
+		if err := rows.Scan(&e.Category, &e.Severity, &e.LogID, &e.Evidence, &e.RFCSections); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		got = append(got, e)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("incident table iteration failed: %v", err)
+	}
+	valid := func(s string) sql.NullString { return sql.NullString{String: s, Valid: true} }
+	want := []structuredEntry{
+		{},
+		{
+			Category:    valid("mmd_breach"),
+			LogID:       valid(logID.String()),
+			Evidence:    valid(`[{"kind":"sth","id":"42"}]`),
+			RFCSections: valid("RFC 6962 s3.5; RFC 6962 s3.6"),
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("structured columns: diff (-got +want)\n%s", diff)
+	}
+}
+
+type groupEntry struct {
+	Summary   string
+	Owned     bool
+	FirstSeen time.Time
+	LastSeen  *time.Time
+	NumOwned  int
+}
+
+func checkGroups(ctx context.Context, testDB *sql.DB, t *testing.T, want []groupEntry) {
+	t.Helper()
+
+	rows, err := testDB.QueryContext(ctx, "SELECT i.Summary, i.OwningId IS NOT NULL, i.Timestamp, i.LastSeen, (SELECT COUNT(*) FROM Incidents c WHERE c.OwningId = i.Id) FROM Incidents i ORDER BY i.Id;")
+	if err != nil {
+		t.Fatalf("failed to query rows: %v", err)
+	}
+	defer rows.Close()
+
+	var got []groupEntry
+	for rows.Next() {
+		var e groupEntry
+		if err := rows.Scan(&e.Summary, &e.Owned, &e.FirstSeen, &e.LastSeen, &e.NumOwned); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		got = append(got, e)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("incident table iteration failed: %v", err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("incident groups: diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestGrouping(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+
+	reporter, err := NewGroupingMySQLReporter(ctx, testDB, "unittest", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("failed to build grouping MySQLReporter: %v", err)
+	}
+
+	// Three occurrences, each within the window of the last, are grouped even
+	// though the last is more than the window after the first.
+	for _, d := range []time.Duration{0, 8 * time.Minute, 16 * time.Minute} {
+		now = start.Add(d)
+		reporter.LogViolation(ctx, "base", "log down", "full", "details")
+	}
+	// A different summary is not grouped.
+	reporter.LogViolation(ctx, "base", "other", "full", "details")
+	// An occurrence after the window starts a new group.
+	now = start.Add(time.Hour)
+	reporter.LogViolationf(ctx, "base", "log down", "full", "%s", "details")
+
+	checkGroups(ctx, testDB, t, []groupEntry{
+		{Summary: "log down", FirstSeen: start, LastSeen: at(16 * time.Minute), NumOwned: 2},
+		{Summary: "log down", Owned: true, FirstSeen: start.Add(8 * time.Minute)},
+		{Summary: "log down", Owned: true, FirstSeen: start.Add(16 * time.Minute)},
+		{Summary: "other", FirstSeen: start.Add(16 * time.Minute), LastSeen: at(16 * time.Minute)},
+		{Summary: "log down", FirstSeen: start.Add(time.Hour), LastSeen: at(time.Hour)},
+	})
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	if err := testdb.MySQLAvailable(); err != nil {
@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/monologue/incident"
+)
+
+type mysqlManager struct {
+	db *sql.DB
+}
+
+// NewMySQLManager builds an incident.Manager instance that changes the state
+// of incidents recorded in a MySQL database.  Changing the state of a
+// sub-incident changes the state of its owning incident.
+func NewMySQLManager(ctx context.Context, db *sql.DB) incident.Manager {
+	return &mysqlManager{db: db}
+}
+
+// NewMySQLStore builds an incident.Store instance that finds, and changes the
+// state of, incidents recorded in a MySQL database.
+func NewMySQLStore(ctx context.Context, db *sql.DB) incident.Store {
+	return &mysqlManager{db: db}
+}
+
+// Acknowledge marks an open incident as acknowledged.  Acknowledging an
+// incident that is already acknowledged or resolved has no effect.
+func (m *mysqlManager) Acknowledge(ctx context.Context, id uint64) error {
+	owningID, err := m.owningID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE Incidents SET State = ?, AcknowledgedAt = ? WHERE Id = ? AND State = ?;", incident.Acknowledged, timeNow(), owningID, incident.Open); err != nil {
+		return fmt.Errorf("failed to acknowledge incident %d: %v", id, err)
+	}
+	return nil
+}
+
+// Resolve marks an incident as resolved.  Resolving an incident that is
+// already resolved has no effect.
+func (m *mysqlManager) Resolve(ctx context.Context, id uint64) error {
+	owningID, err := m.owningID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE Incidents SET State = ?, ResolvedAt = ? WHERE Id = ? AND State != ?;", incident.Resolved, timeNow(), owningID, incident.Resolved); err != nil {
+		return fmt.Errorf("failed to resolve incident %d: %v", id, err)
+	}
+	return nil
+}
+
+// Link makes an incident, and its sub-incidents, sub-incidents of the owning
+// incident of owningID, and updates the LastSeen time of that owning incident
+// to include them.
+func (m *mysqlManager) Link(ctx context.Context, id, owningID uint64) error {
+	ownerID, err := m.owningID(ctx, owningID)
+	if err != nil {
+		return err
+	}
+	currentOwnerID, err := m.owningID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if currentOwnerID == ownerID {
+		return fmt.Errorf("incident %d is already part of incident %d", id, ownerID)
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil /* opts */)
+	if err != nil {
+		return err
+	}
+	if err := link(ctx, tx, id, ownerID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to link incident %d to incident %d: %v", id, ownerID, err)
+	}
+	return tx.Commit()
+}
+
+func link(ctx context.Context, tx *sql.Tx, id, ownerID uint64) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE Incidents SET OwningId = ?, LastSeen = NULL WHERE Id = ? OR OwningId = ?;", ownerID, id, id); err != nil {
+		return err
+	}
+	var lastSeen time.Time
+	if err := tx.QueryRowContext(ctx, "SELECT MAX(Timestamp) FROM Incidents WHERE Id = ? OR OwningId = ?;", ownerID, ownerID).Scan(&lastSeen); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE Incidents SET LastSeen = ? WHERE Id = ? AND COALESCE(LastSeen, Timestamp) < ?;", lastSeen, ownerID, lastSeen)
+	return err
+}
+
+// owningID returns the ID of the owning incident of the incident with the given
+// ID, or id itself if the incident has no owner.
+func (m *mysqlManager) owningID(ctx context.Context, id uint64) (uint64, error) {
+	var owningID sql.NullInt64
+	err := m.db.QueryRowContext(ctx, "SELECT OwningId FROM Incidents WHERE Id = ?;", id).Scan(&owningID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no incident with ID %d", id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up incident %d: %v", id, err)
+	}
+	if owningID.Valid {
+		return uint64(owningID.Int64), nil
+	}
+	return id, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage/mysql/testdb"
+)
+
+type stateEntry struct {
+	ID             uint64
+	Summary        string
+	State          incident.State
+	AcknowledgedAt *time.Time
+	ResolvedAt     *time.Time
+}
+
+func checkStates(ctx context.Context, testDB *sql.DB, t *testing.T, want []stateEntry) {
+	t.Helper()
+
+	rows, err := testDB.QueryContext(ctx, "SELECT Id, Summary, State, AcknowledgedAt, ResolvedAt FROM Incidents WHERE OwningId IS NULL ORDER BY Id;")
+	if err != nil {
+		t.Fatalf("failed to query rows: %v", err)
+	}
+	defer rows.Close()
+
+	var got []stateEntry
+	for rows.Next() {
+		var e stateEntry
+		if err := rows.Scan(&e.ID, &e.Summary, &e.State, &e.AcknowledgedAt, &e.ResolvedAt); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		got = append(got, e)
+	}
+	if err := rows.Err(); err != nil {
+		t.Errorf("incident table iteration failed: %v", err)
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("incident states: diff (-got +want)\n%s", diff)
+	}
+}
+
+func incidentIDs(ctx context.Context, testDB *sql.DB, t *testing.T) []uint64 {
+	t.Helper()
+	rows, err := testDB.QueryContext(ctx, "SELECT Id FROM Incidents ORDER BY Id;")
+	if err != nil {
+		t.Fatalf("failed to query rows: %v", err)
+	}
+	defer rows.Close()
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("failed to scan row: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "Incidents")
+	defer func(f func() time.Time) { timeNow = f }(timeNow)
+	start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	timeNow = func() time.Time { return now }
+	at := func(d time.Duration) *time.Time {
+		t := start.Add(d)
+		return &t
+	}
+
+	reporter, err := NewGroupingMySQLReporter(ctx, testDB, "unittest", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to build grouping MySQLReporter: %v", err)
+	}
+	manager := NewMySQLManager(ctx, testDB)
+
+	reporter.LogViolation(ctx, "base", "stale STH", "full", "details")
+	now = start.Add(time.Minute)
+	reporter.LogViolation(ctx, "base", "stale STH", "full", "details")
+	reporter.LogUpdate(ctx, "base", "roots changed", "full", "details")
+	ids := incidentIDs(ctx, testDB, t)
+	if len(ids) != 3 {
+		t.Fatalf("recorded %d incidents, want 3", len(ids))
+	}
+	checkStates(ctx, testDB, t, []stateEntry{
+		{ID: ids[0], Summary: "stale STH", State: incident.Open},
+		{ID: ids[2], Summary: "roots changed", State: incident.Open},
+	})
+
+	// Acknowledging a sub-incident acknowledges its owner.
+	now = start.Add(2 * time.Minute)
+	if err := manager.Acknowledge(ctx, ids[1]); err != nil {
+		t.Fatalf("Acknowledge(%d) = %v", ids[1], err)
+	}
+	if err := manager.Resolve(ctx, ids[2]); err != nil {
+		t.Fatalf("Resolve(%d) = %v", ids[2], err)
+	}
+	// Acknowledging a resolved incident has no effect.
+	if err := manager.Acknowledge(ctx, ids[2]); err != nil {
+		t.Fatalf("Acknowledge(%d) = %v", ids[2], err)
+	}
+	if err := manager.Acknowledge(ctx, ids[2]+100); err == nil {
+		t.Errorf("Acknowledge(%d) = nil, want error for missing incident", ids[2]+100)
+	}
+	checkStates(ctx, testDB, t, []stateEntry{
+		{ID: ids[0], Summary: "stale STH", State: incident.Acknowledged, AcknowledgedAt: at(2 * time.Minute)},
+		{ID: ids[2], Summary: "roots changed", State: incident.Resolved, ResolvedAt: at(2 * time.Minute)},
+	})
+
+	// Clearing the condition resolves the incident, and a later occurrence
+	// starts a new incident rather than joining the resolved one.
+	now = start.Add(3 * time.Minute)
+	incident.LogResolved(ctx, reporter, "base", "stale STH")
+	now = start.Add(4 * time.Minute)
+	reporter.LogViolation(ctx, "base", "stale STH", "full", "details")
+	ids = incidentIDs(ctx, testDB, t)
+	if len(ids) != 4 {
+		t.Fatalf("recorded %d incidents, want 4", len(ids))
+	}
+	checkStates(ctx, testDB, t, []stateEntry{
+		{ID: ids[0], Summary: "stale STH", State: incident.Resolved, AcknowledgedAt: at(2 * time.Minute), ResolvedAt: at(3 * time.Minute)},
+		{ID: ids[2], Summary: "roots changed", State: incident.Resolved, ResolvedAt: at(2 * time.Minute)},
+		{ID: ids[3], Summary: "stale STH", State: incident.Open},
+	})
+}
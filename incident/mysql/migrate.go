@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/golang/glog"
+)
+
+// migration adds a column or an index that incident.sql has gained since the
+// Incidents table was first released.  Exactly one of column and index is set.
+type migration struct {
+	column string
+	index  string
+	// alter is the statement that adds the column or index.
+	alter string
+}
+
+// migrations are applied in order, so a migration may depend on the columns
+// added by those before it.
+var migrations = []migration{
+	{column: "LastSeen", alter: "ALTER TABLE Incidents ADD COLUMN LastSeen DATETIME NULL AFTER OwningId;"},
+	// Incidents recorded before State was added are open.
+	{column: "State", alter: "ALTER TABLE Incidents ADD COLUMN State VARCHAR(16) NOT NULL DEFAULT 'open' AFTER LastSeen;"},
+	{column: "AcknowledgedAt", alter: "ALTER TABLE Incidents ADD COLUMN AcknowledgedAt DATETIME NULL AFTER State;"},
+	{column: "ResolvedAt", alter: "ALTER TABLE Incidents ADD COLUMN ResolvedAt DATETIME NULL AFTER AcknowledgedAt;"},
+	{index: "StateIndex", alter: "CREATE INDEX StateIndex ON Incidents(State);"},
+	{column: "Category", alter: "ALTER TABLE Incidents ADD COLUMN Category VARCHAR(32) NULL AFTER ResolvedAt;"},
+	{column: "Severity", alter: "ALTER TABLE Incidents ADD COLUMN Severity VARCHAR(16) NULL AFTER Category;"},
+	{column: "LogID", alter: "ALTER TABLE Incidents ADD COLUMN LogID VARCHAR(64) NULL AFTER Severity;"},
+	{column: "Evidence", alter: "ALTER TABLE Incidents ADD COLUMN Evidence TEXT NULL AFTER LogID;"},
+	{column: "RFCSections", alter: "ALTER TABLE Incidents ADD COLUMN RFCSections VARCHAR(512) NULL AFTER Evidence;"},
+	{index: "CategoryIndex", alter: "CREATE INDEX CategoryIndex ON Incidents(Category);"},
+	{index: "LogIDIndex", alter: "CREATE INDEX LogIDIndex ON Incidents(LogID);"},
+	{column: "SilenceId", alter: "ALTER TABLE Incidents ADD COLUMN SilenceId VARCHAR(64) NULL AFTER RFCSections;"},
+}
+
+// Migrate brings an Incidents table created from any earlier version of
+// incident.sql up to date, by adding the columns and indexes that it lacks.
+// It does nothing to a table that is already up to date, so is safe to run
+// repeatedly.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	for _, m := range migrations {
+		var query, name string
+		if m.column != "" {
+			query, name = "SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'Incidents' AND COLUMN_NAME = ?;", m.column
+		} else {
+			query, name = "SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'Incidents' AND INDEX_NAME = ?;", m.index
+		}
+		var n int
+		if err := db.QueryRowContext(ctx, query, name).Scan(&n); err != nil {
+			return fmt.Errorf("failed to look for %s: %v", name, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, m.alter); err != nil {
+			return fmt.Errorf("failed to add %s: %v", name, err)
+		}
+		glog.Infof("Added %s to the Incidents table", name)
+	}
+	return nil
+}
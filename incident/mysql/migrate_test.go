@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage/mysql/testdb"
+)
+
+// schema describes the columns and indexes of the Incidents table of db.
+func schema(ctx context.Context, t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	var got []string
+	for _, query := range []string{
+		"SELECT CONCAT_WS(' ', COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COALESCE(COLUMN_DEFAULT, 'NULL')) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'Incidents' ORDER BY ORDINAL_POSITION;",
+		"SELECT CONCAT_WS(' ', INDEX_NAME, COLUMN_NAME, COALESCE(SUB_PART, 'NULL')) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'Incidents' ORDER BY INDEX_NAME, SEQ_IN_INDEX;",
+	} {
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			t.Fatalf("failed to query schema: %v", err)
+		}
+		for rows.Next() {
+			var s string
+			if err := rows.Scan(&s); err != nil {
+				t.Fatalf("failed to scan schema: %v", err)
+			}
+			got = append(got, s)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatalf("schema iteration failed: %v", err)
+		}
+		rows.Close()
+	}
+	return got
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db, err := testdb.New(ctx, "testdata/baseline.sql")
+	if err != nil {
+		t.Fatalf("failed to create baseline database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "INSERT INTO Incidents(Timestamp, Source, BaseURL, Summary, IsViolation, FullURL, Details) VALUES (NOW(), 'unittest', 'base', 'summary', TRUE, 'full', 'details');"); err != nil {
+		t.Fatalf("failed to insert baseline incident: %v", err)
+	}
+
+	// Migrating twice is the same as migrating once.
+	for i := 0; i < 2; i++ {
+		if err := Migrate(ctx, db); err != nil {
+			t.Fatalf("Migrate() #%d = %v", i, err)
+		}
+		if diff := cmp.Diff(schema(ctx, t, db), schema(ctx, t, testDB)); diff != "" {
+			t.Errorf("Migrate() #%d: schema diff (-migrated +incident.sql)\n%s", i, diff)
+		}
+	}
+
+	// Incidents recorded before the migration can still be read.
+	recs, err := NewMySQLStore(ctx, db).List(ctx, incident.Filter{})
+	if err != nil {
+		t.Fatalf("List() after Migrate() = _, %v", err)
+	}
+	if len(recs) != 1 || recs[0].Summary != "summary" {
+		t.Errorf("List() after Migrate() = %v, want the baseline incident", recs)
+	}
+
+	// An up to date table is left alone.
+	if err := Migrate(ctx, testDB); err != nil {
+		t.Errorf("Migrate() of up to date table = %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/certificate-transparency-go/logid"
+	"github.com/google/monologue/incident"
+)
+
+// selectRecords selects the columns scanned by scanRecord.  The state of a
+// sub-incident is that of its owning incident.
+const selectRecords = "SELECT i.Id, i.OwningId, i.Source, i.Timestamp, i.LastSeen, i.BaseURL, i.Summary, i.IsViolation, i.FullURL, i.Details, i.Category, i.Severity, i.LogID, i.Evidence, i.RFCSections, COALESCE(o.State, i.State), COALESCE(o.AcknowledgedAt, i.AcknowledgedAt), COALESCE(o.ResolvedAt, i.ResolvedAt), i.SilenceId FROM Incidents i LEFT JOIN Incidents o ON i.OwningId = o.Id"
+
+// List returns the incidents selected by f, most recent first.
+func (m *mysqlManager) List(ctx context.Context, f incident.Filter) ([]*incident.Record, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.BaseURL != "" {
+		add("i.BaseURL = ?", f.BaseURL)
+	}
+	if f.Source != "" {
+		add("i.Source = ?", f.Source)
+	}
+	if !f.Since.IsZero() {
+		add("i.Timestamp >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("i.Timestamp < ?", f.Until)
+	}
+	if f.IsViolation != nil {
+		add("i.IsViolation = ?", *f.IsViolation)
+	}
+	if f.State != "" {
+		add("COALESCE(o.State, i.State) = ?", f.State)
+	}
+	if !f.SubIncidents {
+		where = append(where, "i.OwningId IS NULL")
+	}
+
+	query := selectRecords
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY i.Timestamp DESC, i.Id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+	return m.query(ctx, query+";", args...)
+}
+
+// Get returns the incident with the given ID, and its sub-incidents in the
+// order they were recorded.
+func (m *mysqlManager) Get(ctx context.Context, id uint64) (*incident.Record, []*incident.Record, error) {
+	recs, err := m.query(ctx, selectRecords+" WHERE i.Id = ?;", id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(recs) == 0 {
+		return nil, nil, fmt.Errorf("no incident with ID %d", id)
+	}
+	subs, err := m.query(ctx, selectRecords+" WHERE i.OwningId = ? ORDER BY i.Id;", id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return recs[0], subs, nil
+}
+
+func (m *mysqlManager) query(ctx context.Context, query string, args ...interface{}) ([]*incident.Record, error) {
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query incidents: %v", err)
+	}
+	defer rows.Close()
+
+	var recs []*incident.Record
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan incident: %v", err)
+		}
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+func scanRecord(rows *sql.Rows) (*incident.Record, error) {
+	var (
+		r                                                             incident.Record
+		owningID                                                      sql.NullInt64
+		source, fullURL, details, category, severity, logID, evidence sql.NullString
+		rfcSections, state, silenceID                                 sql.NullString
+		lastSeen, acknowledgedAt, resolvedAt                          sql.NullTime
+	)
+	if err := rows.Scan(&r.ID, &owningID, &source, &r.Timestamp, &lastSeen, &r.BaseURL, &r.Summary, &r.IsViolation, &fullURL, &details, &category, &severity, &logID, &evidence, &rfcSections, &state, &acknowledgedAt, &resolvedAt, &silenceID); err != nil {
+		return nil, err
+	}
+	r.OwningID = uint64(owningID.Int64)
+	r.Source = source.String
+	r.LastSeen = lastSeen.Time
+	r.FullURL = fullURL.String
+	r.Details = details.String
+	r.Category = incident.Category(category.String)
+	r.Severity = incident.Severity(severity.String)
+	if logID.Valid {
+		id, err := logid.FromB64(logID.String)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Log ID for incident %d: %v", r.ID, err)
+		}
+		r.LogID = id
+	}
+	if evidence.Valid {
+		if err := json.Unmarshal([]byte(evidence.String), &r.Evidence); err != nil {
+			return nil, fmt.Errorf("invalid evidence for incident %d: %v", r.ID, err)
+		}
+	}
+	if rfcSections.Valid {
+		r.RFCSections = strings.Split(rfcSections.String, rfcSectionSeparator)
+	}
+	r.State = incident.State(state.String)
+	r.AcknowledgedAt = acknowledgedAt.Time
+	r.ResolvedAt = resolvedAt.Time
+	r.SilenceID = silenceID.String
+	return &r, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/incidenttest"
+	"github.com/google/monologue/storage/mysql/testdb"
+)
+
+func TestStoreConformance(t *testing.T) {
+	incidenttest.RunStore(t, func(ctx context.Context, t *testing.T) (incidenttest.Reporter, incident.Store) {
+		testdb.Clean(ctx, testDB, "Incidents")
+		reporter, err := NewGroupingMySQLReporter(ctx, testDB, "unittest", time.Hour)
+		if err != nil {
+			t.Fatalf("NewGroupingMySQLReporter()=nil,%v; want _,nil", err)
+		}
+		return reporter, NewMySQLStore(ctx, testDB)
+	})
+}
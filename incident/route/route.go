@@ -0,0 +1,250 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package route provides an implementation of incident reporting that sends
+// each incident to some number of other incident reporters, chosen by a list
+// of rules.
+//
+// Rules are checked in order, and every rule that matches an incident adds
+// its targets to the set of reporters the incident is sent to.  A matching
+// rule that silences stops any later rules from being checked, so a silencing
+// rule placed first drops matching incidents entirely.
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+)
+
+// Kind is the kind of incident a Rule matches.
+type Kind string
+
+// Kinds of incident.
+const (
+	// Any matches every incident.
+	Any Kind = ""
+	// Violation matches incidents reported with LogViolation.
+	Violation Kind = "violation"
+	// Update matches incidents reported with LogUpdate.
+	Update Kind = "update"
+)
+
+// Rule chooses where the incidents that it matches are sent.  An incident
+// matches a Rule if it matches every field that is set.
+type Rule struct {
+	// Logs, if not empty, are the names or URLs of the Logs whose incidents
+	// the Rule matches.
+	Logs []string `json:"logs,omitempty"`
+	// Kind, if set, is the kind of incident the Rule matches.
+	Kind Kind `json:"kind,omitempty"`
+	// Summary, if set, is a regular expression that the summary of an
+	// incident must match for the Rule to match.
+	Summary string `json:"summary,omitempty"`
+	// Targets are the names of the reporters that matching incidents are sent
+	// to.
+	Targets []string `json:"targets,omitempty"`
+	// Silence stops any later Rules from being checked for matching
+	// incidents.  Matching incidents are still sent to the Targets of this
+	// and earlier Rules.
+	Silence bool `json:"silence,omitempty"`
+}
+
+// ParseRules parses a JSON list of Rules.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("error parsing rules: %s", err)
+	}
+	return rules, nil
+}
+
+// LoadRules reads a JSON list of Rules from the file at path.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRules(f)
+}
+
+// rule is a Rule prepared for matching.
+type rule struct {
+	logs    map[string]bool
+	kind    Kind
+	summary *regexp.Regexp
+	targets []string
+	silence bool
+}
+
+func (r *rule) matches(name, baseURL string, kind Kind, summary string) bool {
+	if len(r.logs) > 0 && !r.logs[name] && !r.logs[normalizeURL(baseURL)] {
+		return false
+	}
+	if r.kind != Any && kind != Any && r.kind != kind {
+		return false
+	}
+	if r.summary != nil && !r.summary.MatchString(summary) {
+		return false
+	}
+	return true
+}
+
+func kindOf(inc *incident.Incident) Kind {
+	if inc.IsViolation {
+		return Violation
+	}
+	return Update
+}
+
+// normalizeURL makes URLs that differ only by a trailing slash compare equal.
+func normalizeURL(u string) string {
+	return strings.TrimSuffix(u, "/")
+}
+
+// Reporter implements incident.StructuredReporter, incident.Resolver and
+// incident.SuppressionRecorder by sending incidents on to the reporters chosen
+// by its rules.
+type Reporter struct {
+	// names maps the normalized URL of each known Log to its name.
+	names   map[string]string
+	targets map[string]incident.Reporter
+	rules   []*rule
+}
+
+// New returns a Reporter that routes incidents to the reporters in targets,
+// keyed by name, according to rules.  logs are the Logs whose names rules may
+// refer to; incidents for other Logs can only be matched by URL.
+func New(logs []*ctlog.Log, targets map[string]incident.Reporter, rules []Rule) (*Reporter, error) {
+	r := &Reporter{names: make(map[string]string), targets: targets}
+	for _, l := range logs {
+		r.names[normalizeURL(l.URL)] = l.Name
+	}
+	for i, rl := range rules {
+		pr := &rule{kind: rl.Kind, silence: rl.Silence}
+		switch rl.Kind {
+		case Any, Violation, Update:
+		default:
+			return nil, fmt.Errorf("rule %d: unknown kind %q", i, rl.Kind)
+		}
+		if len(rl.Logs) > 0 {
+			pr.logs = make(map[string]bool)
+			for _, l := range rl.Logs {
+				pr.logs[l] = true
+				pr.logs[normalizeURL(l)] = true
+			}
+		}
+		if rl.Summary != "" {
+			re, err := regexp.Compile(rl.Summary)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid summary pattern: %s", i, err)
+			}
+			pr.summary = re
+		}
+		for _, t := range rl.Targets {
+			if _, ok := targets[t]; !ok {
+				return nil, fmt.Errorf("rule %d: unknown target %q", i, t)
+			}
+			pr.targets = append(pr.targets, t)
+		}
+		if len(pr.targets) == 0 && !pr.silence {
+			return nil, fmt.Errorf("rule %d: no targets and does not silence", i)
+		}
+		r.rules = append(r.rules, pr)
+	}
+	return r, nil
+}
+
+// route returns the reporters that an incident should be sent to, each once,
+// in the order in which the rules first name them.
+func (r *Reporter) route(baseURL string, kind Kind, summary string) []incident.Reporter {
+	name := r.names[normalizeURL(baseURL)]
+	var reps []incident.Reporter
+	seen := make(map[string]bool)
+	for _, rl := range r.rules {
+		if !rl.matches(name, baseURL, kind, summary) {
+			continue
+		}
+		for _, t := range rl.targets {
+			if !seen[t] {
+				seen[t] = true
+				reps = append(reps, r.targets[t])
+			}
+		}
+		if rl.silence {
+			break
+		}
+	}
+	return reps
+}
+
+// LogUpdate sends an incident to the reporters chosen for it.
+func (r *Reporter) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
+	for _, rep := range r.route(baseURL, Update, summary) {
+		rep.LogUpdate(ctx, baseURL, summary, fullURL, details)
+	}
+}
+
+// LogUpdatef sends an incident to the reporters chosen for it, formatting parameters along the way.
+func (r *Reporter) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogUpdate(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogViolation sends a violation to the reporters chosen for it.
+func (r *Reporter) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
+	for _, rep := range r.route(baseURL, Violation, summary) {
+		rep.LogViolation(ctx, baseURL, summary, fullURL, details)
+	}
+}
+
+// LogViolationf sends a violation to the reporters chosen for it, formatting parameters along the way.
+func (r *Reporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// Report sends an incident to the reporters chosen for it, in full to those
+// that are StructuredReporters.
+func (r *Reporter) Report(ctx context.Context, inc *incident.Incident) {
+	for _, rep := range r.route(inc.BaseURL, kindOf(inc), inc.Summary) {
+		incident.Report(ctx, rep, inc)
+	}
+}
+
+// RecordSuppressed records a suppressed incident in those of the reporters
+// chosen for it that are SuppressionRecorders.
+func (r *Reporter) RecordSuppressed(ctx context.Context, inc *incident.Incident, silenceID string) {
+	for _, rep := range r.route(inc.BaseURL, kindOf(inc), inc.Summary) {
+		if sr, ok := rep.(incident.SuppressionRecorder); ok {
+			sr.RecordSuppressed(ctx, inc, silenceID)
+		}
+	}
+}
+
+// LogResolved resolves incidents in every reporter that incidents with the
+// given baseURL and summary could have been sent to, of either kind.
+func (r *Reporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	for _, rep := range r.route(baseURL, Any, summary) {
+		incident.LogResolved(ctx, rep, baseURL, summary)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package route
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/testonly"
+)
+
+var logs = []*ctlog.Log{
+	{Name: "google_pilot", URL: "https://ct.googleapis.com/pilot/"},
+	{Name: "google_aviator", URL: "https://ct.googleapis.com/aviator/"},
+	{Name: "example_log", URL: "https://ct.example.com/log/"},
+}
+
+func newFake() *testonly.FakeReporter {
+	return &testonly.FakeReporter{
+		Updates:     make(chan testonly.Report, 10),
+		Violations:  make(chan testonly.Report, 10),
+		Resolutions: make(chan testonly.Resolution, 10),
+	}
+}
+
+// counts returns the number of updates, violations and resolutions that f has
+// received, and empties its channels.
+func counts(f *testonly.FakeReporter) [3]int {
+	var c [3]int
+	for len(f.Updates) > 0 {
+		<-f.Updates
+		c[0]++
+	}
+	for len(f.Violations) > 0 {
+		<-f.Violations
+		c[1]++
+	}
+	for len(f.Resolutions) > 0 {
+		<-f.Resolutions
+		c[2]++
+	}
+	return c
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`[
+		{"logs": ["google_aviator"], "silence": true},
+		{"targets": ["db"]},
+		{"kind": "violation", "summary": "^STH", "targets": ["email", "db"]}
+	]`))
+	if err != nil {
+		t.Fatalf("ParseRules() = _, %s", err)
+	}
+	if len(rules) != 3 || !rules[0].Silence || rules[2].Kind != Violation || rules[2].Summary != "^STH" || len(rules[2].Targets) != 2 {
+		t.Errorf("ParseRules() = %+v", rules)
+	}
+
+	for _, in := range []string{`{}`, `[{"target": ["db"]}]`, `[`} {
+		if _, err := ParseRules(strings.NewReader(in)); err == nil {
+			t.Errorf("ParseRules(%q) = _, nil, want error", in)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	targets := map[string]incident.Reporter{"db": newFake()}
+	for _, test := range []struct {
+		desc string
+		rule Rule
+	}{
+		{desc: "unknown kind", rule: Rule{Kind: "warning", Targets: []string{"db"}}},
+		{desc: "invalid summary", rule: Rule{Summary: "(", Targets: []string{"db"}}},
+		{desc: "unknown target", rule: Rule{Targets: []string{"email"}}},
+		{desc: "no targets", rule: Rule{Logs: []string{"google_pilot"}}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if _, err := New(logs, targets, []Rule{test.rule}); err == nil {
+				t.Errorf("New(%+v) = _, nil, want error", test.rule)
+			}
+		})
+	}
+}
+
+func TestRouting(t *testing.T) {
+	ctx := context.Background()
+	db, email, hook := newFake(), newFake(), newFake()
+	r, err := New(logs, map[string]incident.Reporter{"db": db, "email": email, "hook": hook}, []Rule{
+		{Logs: []string{"google_aviator"}, Silence: true},
+		{Targets: []string{"db"}},
+		{Logs: []string{"google_pilot", "https://ct.example.com/log"}, Kind: Violation, Targets: []string{"email", "db"}},
+		{Logs: []string{"example_log"}, Summary: "^Root", Targets: []string{"hook"}},
+	})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	var _ incident.StructuredReporter = r
+	var _ incident.Resolver = r
+
+	tests := []struct {
+		desc      string
+		report    func()
+		db, email [3]int
+		hook      [3]int
+	}{
+		{
+			desc:   "silenced log",
+			report: func() { r.LogViolation(ctx, "https://ct.googleapis.com/aviator/", "STH older than MMD", "", "") },
+		},
+		{
+			desc:   "update goes to db only",
+			report: func() { r.LogUpdate(ctx, "https://ct.googleapis.com/pilot/", "STH older than MMD", "", "") },
+			db:     [3]int{1, 0, 0},
+		},
+		{
+			desc:   "violation is sent to db and email once each",
+			report: func() { r.LogViolationf(ctx, "https://ct.googleapis.com/pilot/", "STH older than MMD", "", "%d", 1) },
+			db:     [3]int{0, 1, 0},
+			email:  [3]int{0, 1, 0},
+		},
+		{
+			desc:   "log matched by URL without trailing slash",
+			report: func() { r.LogViolation(ctx, "https://ct.example.com/log/", "Bad SCT", "", "") },
+			db:     [3]int{0, 1, 0},
+			email:  [3]int{0, 1, 0},
+		},
+		{
+			desc:   "summary pattern",
+			report: func() { r.LogUpdatef(ctx, "https://ct.example.com/log/", "Root certificates changed", "", "%s", "x") },
+			db:     [3]int{1, 0, 0},
+			hook:   [3]int{1, 0, 0},
+		},
+		{
+			desc: "structured incident",
+			report: func() {
+				incident.Report(ctx, r, &incident.Incident{BaseURL: "https://ct.googleapis.com/pilot/", Summary: "Bad SCT", IsViolation: true, Category: incident.SCTSignature})
+			},
+			db:    [3]int{0, 1, 0},
+			email: [3]int{0, 1, 0},
+		},
+		{
+			desc:   "unknown log",
+			report: func() { r.LogViolation(ctx, "https://ct.other.com/", "Bad SCT", "", "") },
+			db:     [3]int{0, 1, 0},
+		},
+		{
+			desc:   "resolution goes wherever either kind could have",
+			report: func() { incident.LogResolved(ctx, r, "https://ct.example.com/log/", "Root certificates changed") },
+			db:     [3]int{0, 0, 1},
+			email:  [3]int{0, 0, 1},
+			hook:   [3]int{0, 0, 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			test.report()
+			for _, f := range []struct {
+				name string
+				f    *testonly.FakeReporter
+				want [3]int
+			}{
+				{"db", db, test.db},
+				{"email", email, test.email},
+				{"hook", hook, test.hook},
+			} {
+				if got := counts(f.f); got != f.want {
+					t.Errorf("%s received %v (updates, violations, resolutions), want %v", f.name, got, f.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package silence
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// APIPath is the path under which Handler serves the silences API.
+const APIPath = "/silences"
+
+// Handler returns an http.Handler that serves an API for a Set:
+//
+//	GET    /silences           lists every Silence, as JSON.
+//	POST   /silences           adds the Silence in the JSON request body, and
+//	                           responds with it as added.
+//	DELETE /silences/ID?by=WHO expires the Silence with the given ID on behalf
+//	                           of WHO, and responds with it as expired.
+//
+// Handler does no authentication.  The creator and expirer of each Silence are
+// taken from the request, so are recorded as unverified in the audit trail,
+// and access to the API must be restricted.
+func Handler(s *Set) http.Handler {
+	return &handler{set: s}
+}
+
+type handler struct {
+	set *Set
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, APIPath), "/")
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, h.set.List())
+	case id == "" && r.Method == http.MethodPost:
+		var sil Silence
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sil); err != nil {
+			http.Error(w, "invalid silence: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		added, err := h.set.add(sil, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, added)
+	case id != "" && r.Method == http.MethodDelete:
+		expired, err := h.set.expire(id, r.URL.Query().Get("by"), true)
+		switch {
+		case err == ErrNotFound:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusOK, expired)
+		}
+	default:
+		http.Error(w, "unsupported request", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		glog.Errorf("silence: failed to write response: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package silence
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+)
+
+// Reporter implements incident.StructuredReporter and incident.Resolver by
+// passing incidents on to another reporter, unless they match an active
+// Silence.  Matching incidents are recorded as suppressed if the other
+// reporter is an incident.SuppressionRecorder, and otherwise only logged.
+type Reporter struct {
+	next  incident.Reporter
+	set   *Set
+	names map[string]string
+}
+
+// NewReporter returns a Reporter that passes incidents that do not match any
+// Silence in set on to next.  logs are the Logs whose names Silences may refer
+// to; incidents for other Logs can only be matched by URL.
+func NewReporter(next incident.Reporter, set *Set, logs []*ctlog.Log) *Reporter {
+	r := &Reporter{next: next, set: set, names: make(map[string]string)}
+	for _, l := range logs {
+		r.names[normalizeURL(l.URL)] = l.Name
+	}
+	return r
+}
+
+// LogUpdate reports an incident, unless it is silenced.
+func (r *Reporter) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
+	if sil := r.silenced(baseURL, incident.Uncategorized); sil != nil {
+		r.suppress(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details}, sil)
+		return
+	}
+	r.next.LogUpdate(ctx, baseURL, summary, fullURL, details)
+}
+
+// LogUpdatef reports an incident, unless it is silenced, formatting parameters along the way.
+func (r *Reporter) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogUpdate(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogViolation reports a violation, unless it is silenced.
+func (r *Reporter) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
+	if sil := r.silenced(baseURL, incident.Uncategorized); sil != nil {
+		r.suppress(ctx, &incident.Incident{BaseURL: baseURL, Summary: summary, FullURL: fullURL, Details: details, IsViolation: true}, sil)
+		return
+	}
+	r.next.LogViolation(ctx, baseURL, summary, fullURL, details)
+}
+
+// LogViolationf reports a violation, unless it is silenced, formatting parameters along the way.
+func (r *Reporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// Report reports an incident, unless it is silenced.
+func (r *Reporter) Report(ctx context.Context, inc *incident.Incident) {
+	if sil := r.silenced(inc.BaseURL, inc.Category); sil != nil {
+		r.suppress(ctx, inc, sil)
+		return
+	}
+	incident.Report(ctx, r.next, inc)
+}
+
+// LogResolved passes the resolution on, whether or not incidents are
+// currently silenced, so that incidents reported before a Silence started are
+// still resolved.
+func (r *Reporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	incident.LogResolved(ctx, r.next, baseURL, summary)
+}
+
+func (r *Reporter) silenced(baseURL string, category incident.Category) *Silence {
+	return r.set.Match(r.names[normalizeURL(baseURL)], baseURL, category)
+}
+
+func (r *Reporter) suppress(ctx context.Context, inc *incident.Incident, sil *Silence) {
+	if sr, ok := r.next.(incident.SuppressionRecorder); ok {
+		sr.RecordSuppressed(ctx, inc, sil.ID)
+		return
+	}
+	glog.Infof("%s: %s (%s): suppressed by silence %s created by %s", inc.BaseURL, inc.Summary, inc.FullURL, sil.ID, sil.CreatedBy)
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package silence provides silences, which stop incidents from being reported
+// during a window of time, e.g. while a Log is undergoing maintenance that its
+// operator announced in advance.
+//
+// Silences are kept in a Set, which can be loaded from a file and changed
+// through an HTTP API.  Every change is recorded in an audit trail, saying who
+// made it.  A Reporter wraps any incident.Reporter, and records incidents that
+// match an active silence as suppressed instead of reporting them.
+package silence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/incident"
+)
+
+// timeNow is the source of the current time, which tests can override.
+var timeNow = time.Now
+
+// ErrNotFound is returned when there is no silence with a given ID.
+var ErrNotFound = errors.New("silence not found")
+
+// Silence stops incidents that match it from being reported between Start and
+// End.  An incident matches a Silence if it matches every field that is set.
+type Silence struct {
+	// ID identifies the Silence.  It is assigned when the Silence is added
+	// to a Set, unless it was already set.
+	ID string `json:"id"`
+	// Logs, if not empty, are the names or URLs of the Logs whose incidents
+	// the Silence matches.
+	Logs []string `json:"logs,omitempty"`
+	// Categories, if not empty, are the categories of incident the Silence
+	// matches.  Incidents without a category are only matched by Silences
+	// without Categories.
+	Categories []incident.Category `json:"categories,omitempty"`
+	// Start and End bound the window during which the Silence is active.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// CreatedBy is who created the Silence.
+	CreatedBy string `json:"created_by"`
+	// CreatedAt is when the Silence was created.  It is set when the Silence
+	// is added to a Set, unless it was already set.
+	CreatedAt time.Time `json:"created_at"`
+	// Comment says why the Silence was created, e.g. a link to the
+	// maintenance announcement.
+	Comment string `json:"comment,omitempty"`
+}
+
+// Validate returns an error if s is not a usable Silence.
+func (s *Silence) Validate() error {
+	if s.CreatedBy == "" {
+		return errors.New("no creator provided")
+	}
+	if len(s.Logs) == 0 && len(s.Categories) == 0 {
+		return errors.New("neither logs nor categories provided; a silence must not match every incident")
+	}
+	if s.End.Before(s.Start) {
+		return fmt.Errorf("end %s is before start %s", s.End, s.Start)
+	}
+	return nil
+}
+
+// Active reports whether s is active at the given time.
+func (s *Silence) Active(at time.Time) bool {
+	return !at.Before(s.Start) && at.Before(s.End)
+}
+
+// Matches reports whether an incident with the given category, for the Log
+// with the given name and base URL, matches s.  It does not consider whether s
+// is active.
+func (s *Silence) Matches(logName, baseURL string, category incident.Category) bool {
+	if len(s.Logs) > 0 {
+		found := false
+		for _, l := range s.Logs {
+			if l == logName || normalizeURL(l) == normalizeURL(baseURL) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(s.Categories) > 0 {
+		found := false
+		for _, c := range s.Categories {
+			if c == category {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeURL makes URLs that differ only by a trailing slash compare equal.
+func normalizeURL(u string) string {
+	return strings.TrimSuffix(u, "/")
+}
+
+// Action is a kind of change to a Set.
+type Action string
+
+// Actions recorded in the audit trail.
+const (
+	Create Action = "create"
+	Expire Action = "expire"
+)
+
+// AuditEntry records a change to a Set.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action Action    `json:"action"`
+	// By is who made the change.
+	By string `json:"by"`
+	// Unverified is set if By is only who the client of the API that made
+	// the change claimed to be, as the API does no authentication.
+	Unverified bool `json:"unverified,omitempty"`
+	// Silence is the Silence as it was after the change.
+	Silence Silence `json:"silence"`
+}
+
+// Options configures a Set.
+type Options struct {
+	// Path, if set, is a file containing a JSON list of Silences, which is
+	// loaded when the Set is created, if it exists, and rewritten whenever
+	// the Set changes.
+	Path string
+	// AuditPath, if set, is a file to which an AuditEntry is appended, as a
+	// line of JSON, for every change to the Set.
+	AuditPath string
+}
+
+// Set is a collection of Silences.  It is safe for concurrent use.
+type Set struct {
+	opts Options
+
+	mu       sync.Mutex
+	silences []*Silence
+}
+
+// NewSet returns a Set configured by opts, containing the Silences in
+// opts.Path.
+func NewSet(opts Options) (*Set, error) {
+	s := &Set{opts: opts}
+	if opts.Path == "" {
+		return s, nil
+	}
+	b, err := ioutil.ReadFile(opts.Path)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, err
+	}
+	var silences []*Silence
+	if err := json.Unmarshal(b, &silences); err != nil {
+		return nil, fmt.Errorf("error parsing silences from %s: %s", opts.Path, err)
+	}
+	ids := make(map[string]bool)
+	for i, sil := range silences {
+		if err := sil.Validate(); err != nil {
+			return nil, fmt.Errorf("silence %d in %s: %s", i, opts.Path, err)
+		}
+		if sil.ID == "" {
+			if sil.ID, err = newID(); err != nil {
+				return nil, err
+			}
+		}
+		if ids[sil.ID] {
+			return nil, fmt.Errorf("silence %d in %s: duplicate ID %q", i, opts.Path, sil.ID)
+		}
+		ids[sil.ID] = true
+	}
+	s.silences = silences
+	return s, nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating silence ID: %s", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Add adds sil to the Set, and returns it as added.
+func (s *Set) Add(sil Silence) (Silence, error) {
+	return s.add(sil, false)
+}
+
+// add adds sil to the Set, recording whether its creator is unverified.
+func (s *Set) add(sil Silence, unverified bool) (Silence, error) {
+	if err := sil.Validate(); err != nil {
+		return Silence{}, err
+	}
+	if sil.CreatedAt.IsZero() {
+		sil.CreatedAt = timeNow().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sil.ID == "" {
+		var err error
+		if sil.ID, err = newID(); err != nil {
+			return Silence{}, err
+		}
+	} else if s.findLocked(sil.ID) != nil {
+		return Silence{}, fmt.Errorf("a silence with ID %q already exists", sil.ID)
+	}
+	s.silences = append(s.silences, &sil)
+	if err := s.changedLocked(Create, sil.CreatedBy, unverified, &sil); err != nil {
+		s.silences = s.silences[:len(s.silences)-1]
+		return Silence{}, err
+	}
+	return sil, nil
+}
+
+// Expire ends the Silence with the given ID now, on behalf of by.  Expiring a
+// Silence that has already ended does nothing.
+func (s *Set) Expire(id, by string) (Silence, error) {
+	return s.expire(id, by, false)
+}
+
+// expire ends the Silence with the given ID, recording whether by is
+// unverified.
+func (s *Set) expire(id, by string, unverified bool) (Silence, error) {
+	if by == "" {
+		return Silence{}, errors.New("no expirer provided")
+	}
+	now := timeNow().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sil := s.findLocked(id)
+	if sil == nil {
+		return Silence{}, ErrNotFound
+	}
+	if !sil.End.After(now) {
+		return *sil, nil
+	}
+	old := *sil
+	sil.End = now
+	if sil.Start.After(now) {
+		// The Silence never started.
+		sil.Start = now
+	}
+	if err := s.changedLocked(Expire, by, unverified, sil); err != nil {
+		*sil = old
+		return Silence{}, err
+	}
+	return *sil, nil
+}
+
+// List returns every Silence in the Set, ordered by Start.
+func (s *Set) List() []Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	silences := make([]Silence, 0, len(s.silences))
+	for _, sil := range s.silences {
+		silences = append(silences, *sil)
+	}
+	sort.SliceStable(silences, func(i, j int) bool { return silences[i].Start.Before(silences[j].Start) })
+	return silences
+}
+
+// Match returns an active Silence that matches an incident with the given
+// category, for the Log with the given name and base URL, or nil if there is
+// none.
+func (s *Set) Match(logName, baseURL string, category incident.Category) *Silence {
+	now := timeNow()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sil := range s.silences {
+		if sil.Active(now) && sil.Matches(logName, baseURL, category) {
+			match := *sil
+			return &match
+		}
+	}
+	return nil
+}
+
+func (s *Set) findLocked(id string) *Silence {
+	for _, sil := range s.silences {
+		if sil.ID == id {
+			return sil
+		}
+	}
+	return nil
+}
+
+// changedLocked saves the Set and records a change to sil in the audit trail.
+// If the Set cannot be saved, the change must be undone.
+func (s *Set) changedLocked(a Action, by string, unverified bool, sil *Silence) error {
+	if err := s.saveLocked(); err != nil {
+		return err
+	}
+	e := AuditEntry{Time: timeNow().UTC(), Action: a, By: by, Unverified: unverified, Silence: *sil}
+	if err := s.auditLocked(e); err != nil {
+		// The change has been saved, so it has happened whether or not it
+		// can be audited.
+		glog.Errorf("silence: failed to audit %s of silence %s by %s: %s", a, sil.ID, by, err)
+	}
+	return nil
+}
+
+// saveLocked atomically rewrites the file at opts.Path, if set.
+func (s *Set) saveLocked() error {
+	if s.opts.Path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(s.silences, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling silences: %s", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.opts.Path), filepath.Base(s.opts.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("error saving silences: %s", err)
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving silences: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving silences: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), s.opts.Path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error saving silences: %s", err)
+	}
+	return nil
+}
+
+// auditLocked appends e to the file at opts.AuditPath, if set.
+func (s *Set) auditLocked(e AuditEntry) error {
+	if s.opts.AuditPath == "" {
+		return nil
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshalling audit entry: %s", err)
+	}
+	f, err := os.OpenFile(s.opts.AuditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening audit trail: %s", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing audit trail: %s", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,337 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package silence
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/testonly"
+)
+
+var (
+	start = time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	pilot = &ctlog.Log{Name: "google_pilot", URL: "https://ct.googleapis.com/pilot/"}
+)
+
+func setTime(t *testing.T, at time.Time) func() {
+	t.Helper()
+	old := timeNow
+	timeNow = func() time.Time { return at }
+	return func() { timeNow = old }
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monologue-silence-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %s", err)
+	}
+	return dir
+}
+
+func readAudit(t *testing.T, path string) []AuditEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit trail: %s", err)
+	}
+	defer f.Close()
+	var entries []AuditEntry
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("failed to parse audit entry %q: %s", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		desc string
+		sil  Silence
+	}{
+		{desc: "no creator", sil: Silence{Logs: []string{"google_pilot"}, Start: start, End: start.Add(time.Hour)}},
+		{desc: "matches everything", sil: Silence{CreatedBy: "alice", Start: start, End: start.Add(time.Hour)}},
+		{desc: "end before start", sil: Silence{CreatedBy: "alice", Logs: []string{"google_pilot"}, Start: start, End: start.Add(-time.Minute)}},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			if err := test.sil.Validate(); err == nil {
+				t.Errorf("Validate() = nil, want error")
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	defer setTime(t, start.Add(time.Minute))()
+	set, err := NewSet(Options{})
+	if err != nil {
+		t.Fatalf("NewSet() = _, %s", err)
+	}
+	for _, sil := range []Silence{
+		{CreatedBy: "alice", Logs: []string{"google_pilot"}, Categories: []incident.Category{incident.Availability, incident.MMDBreach}, Start: start, End: start.Add(time.Hour)},
+		{CreatedBy: "bob", Logs: []string{"https://ct.example.com/log"}, Start: start, End: start.Add(time.Hour)},
+		{CreatedBy: "carol", Categories: []incident.Category{incident.RootChange}, Start: start.Add(time.Hour), End: start.Add(2 * time.Hour)},
+	} {
+		if _, err := set.Add(sil); err != nil {
+			t.Fatalf("Add() = _, %s", err)
+		}
+	}
+
+	for _, test := range []struct {
+		desc          string
+		name, baseURL string
+		category      incident.Category
+		wantBy        string
+	}{
+		{desc: "log and category", name: "google_pilot", baseURL: pilot.URL, category: incident.MMDBreach, wantBy: "alice"},
+		{desc: "other category", name: "google_pilot", baseURL: pilot.URL, category: incident.Fork},
+		{desc: "uncategorized", name: "google_pilot", baseURL: pilot.URL},
+		{desc: "log by URL", baseURL: "https://ct.example.com/log/", wantBy: "bob"},
+		{desc: "not yet active", name: "google_pilot", baseURL: pilot.URL, category: incident.RootChange},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			sil := set.Match(test.name, test.baseURL, test.category)
+			switch {
+			case sil == nil && test.wantBy != "":
+				t.Errorf("Match() = nil, want silence created by %s", test.wantBy)
+			case sil != nil && sil.CreatedBy != test.wantBy:
+				t.Errorf("Match() = silence created by %s, want %q", sil.CreatedBy, test.wantBy)
+			}
+		})
+	}
+}
+
+func TestPersistenceAndAudit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := Options{Path: filepath.Join(dir, "silences.json"), AuditPath: filepath.Join(dir, "audit.jsonl")}
+	defer setTime(t, start)()
+
+	set, err := NewSet(opts)
+	if err != nil {
+		t.Fatalf("NewSet() = _, %s", err)
+	}
+	sil, err := set.Add(Silence{CreatedBy: "alice", Logs: []string{"google_pilot"}, Start: start.Add(time.Hour), End: start.Add(2 * time.Hour), Comment: "planned maintenance"})
+	if err != nil {
+		t.Fatalf("Add() = _, %s", err)
+	}
+	if sil.ID == "" || !sil.CreatedAt.Equal(start) {
+		t.Errorf("Add() = %+v, want ID and CreatedAt set", sil)
+	}
+	if _, err := set.Add(Silence{ID: sil.ID, CreatedBy: "bob", Logs: []string{"google_pilot"}, Start: start, End: start.Add(time.Hour)}); err == nil {
+		t.Errorf("Add() with duplicate ID = _, nil, want error")
+	}
+	if _, err := set.Expire("unknown", "bob"); err != ErrNotFound {
+		t.Errorf("Expire(unknown) = _, %v, want %v", err, ErrNotFound)
+	}
+	if _, err := set.Expire(sil.ID, ""); err == nil {
+		t.Errorf("Expire() with no expirer = _, nil, want error")
+	}
+	expired, err := set.Expire(sil.ID, "bob")
+	if err != nil {
+		t.Fatalf("Expire() = _, %s", err)
+	}
+	if !expired.End.Equal(start) || !expired.Start.Equal(start) {
+		t.Errorf("Expire() = %+v, want window ending now", expired)
+	}
+
+	// A new Set loads the silence as expired.
+	set, err = NewSet(opts)
+	if err != nil {
+		t.Fatalf("NewSet() = _, %s", err)
+	}
+	got := set.List()
+	if len(got) != 1 || got[0].ID != sil.ID || !got[0].End.Equal(start) || got[0].Comment != "planned maintenance" {
+		t.Errorf("List() = %+v, want expired silence %s", got, sil.ID)
+	}
+
+	audit := readAudit(t, opts.AuditPath)
+	if len(audit) != 2 {
+		t.Fatalf("audit trail has %d entries, want 2: %+v", len(audit), audit)
+	}
+	for i, want := range []struct {
+		action Action
+		by     string
+	}{{Create, "alice"}, {Expire, "bob"}} {
+		if audit[i].Action != want.action || audit[i].By != want.by || audit[i].Unverified || audit[i].Silence.ID != sil.ID {
+			t.Errorf("audit entry %d = %+v, want %s by %s of %s", i, audit[i], want.action, want.by, sil.ID)
+		}
+	}
+}
+
+func TestNewSetErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, contents := range []string{
+		`{}`,
+		`[{"logs": ["google_pilot"], "start": "2020-03-01T12:00:00Z", "end": "2020-03-01T13:00:00Z"}]`,
+		`[{"id": "a", "created_by": "alice", "logs": ["google_pilot"], "start": "2020-03-01T12:00:00Z", "end": "2020-03-01T13:00:00Z"},
+		  {"id": "a", "created_by": "alice", "logs": ["google_pilot"], "start": "2020-03-01T12:00:00Z", "end": "2020-03-01T13:00:00Z"}]`,
+	} {
+		path := filepath.Join(dir, "silences.json")
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("WriteFile() = %s", err)
+		}
+		if _, err := NewSet(Options{Path: path}); err == nil {
+			t.Errorf("NewSet() with %s = _, nil, want error", contents)
+		}
+	}
+}
+
+// recordingReporter is a FakeReporter that also records suppressed incidents.
+type recordingReporter struct {
+	*testonly.FakeReporter
+	suppressed []string
+}
+
+func (r *recordingReporter) RecordSuppressed(ctx context.Context, inc *incident.Incident, silenceID string) {
+	r.suppressed = append(r.suppressed, inc.Summary+":"+silenceID)
+}
+
+func TestReporter(t *testing.T) {
+	ctx := context.Background()
+	defer setTime(t, start.Add(time.Minute))()
+	set, err := NewSet(Options{})
+	if err != nil {
+		t.Fatalf("NewSet() = _, %s", err)
+	}
+	sil, err := set.Add(Silence{ID: "maintenance", CreatedBy: "alice", Logs: []string{"google_pilot"}, Start: start, End: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Add() = _, %s", err)
+	}
+
+	next := &recordingReporter{FakeReporter: &testonly.FakeReporter{
+		Updates:     make(chan testonly.Report, 10),
+		Violations:  make(chan testonly.Report, 10),
+		Resolutions: make(chan testonly.Resolution, 10),
+	}}
+	r := NewReporter(next, set, []*ctlog.Log{pilot})
+	var _ incident.StructuredReporter = r
+	var _ incident.Resolver = r
+
+	r.LogViolationf(ctx, pilot.URL, "STH older than MMD", "", "%d", 1)
+	r.LogUpdate(ctx, pilot.URL, "Root certificates changed", "", "")
+	r.Report(ctx, &incident.Incident{BaseURL: pilot.URL, Summary: "Bad STH signature", IsViolation: true, Category: incident.STHSignature})
+	r.LogViolation(ctx, "https://ct.example.com/", "STH older than MMD", "", "")
+	incident.LogResolved(ctx, r, pilot.URL, "STH older than MMD")
+
+	if got, want := strings.Join(next.suppressed, ","), "STH older than MMD:maintenance,Root certificates changed:maintenance,Bad STH signature:maintenance"; got != want {
+		t.Errorf("suppressed %q, want %q", got, want)
+	}
+	if len(next.Violations) != 1 || len(next.Updates) != 0 {
+		t.Errorf("reported %d violations and %d updates, want 1 violation", len(next.Violations), len(next.Updates))
+	}
+	if got := <-next.Violations; got.BaseURL != "https://ct.example.com/" {
+		t.Errorf("reported violation for %s, want https://ct.example.com/", got.BaseURL)
+	}
+	if len(next.Resolutions) != 1 {
+		t.Errorf("passed on %d resolutions, want 1", len(next.Resolutions))
+	}
+
+	// Once the silence is expired, incidents are reported again.
+	if _, err := set.Expire(sil.ID, "bob"); err != nil {
+		t.Fatalf("Expire() = _, %s", err)
+	}
+	r.LogViolation(ctx, pilot.URL, "STH older than MMD", "", "")
+	if len(next.Violations) != 1 {
+		t.Errorf("reported %d violations after expiry, want 1", len(next.Violations))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	defer setTime(t, start)()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	set, err := NewSet(Options{AuditPath: auditPath})
+	if err != nil {
+		t.Fatalf("NewSet() = _, %s", err)
+	}
+	ts := httptest.NewServer(Handler(set))
+	defer ts.Close()
+
+	do := func(method, path, body string, wantCode int) []byte {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("http.NewRequest() = _, %s", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s = _, %s", method, path, err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read response: %s", err)
+		}
+		if resp.StatusCode != wantCode {
+			t.Fatalf("%s %s = %d %s, want %d", method, path, resp.StatusCode, b, wantCode)
+		}
+		return b
+	}
+
+	do(http.MethodPost, APIPath, `{"created_by": "alice"}`, http.StatusBadRequest)
+	do(http.MethodPost, APIPath, `{"who": "alice"}`, http.StatusBadRequest)
+	b := do(http.MethodPost, APIPath, `{"created_by": "alice", "logs": ["google_pilot"], "start": "2020-03-01T12:00:00Z", "end": "2020-03-01T14:00:00Z"}`, http.StatusCreated)
+	var added Silence
+	if err := json.Unmarshal(b, &added); err != nil {
+		t.Fatalf("failed to parse added silence: %s", err)
+	}
+
+	var listed []Silence
+	if err := json.Unmarshal(do(http.MethodGet, APIPath, "", http.StatusOK), &listed); err != nil {
+		t.Fatalf("failed to parse silences: %s", err)
+	}
+	if len(listed) != 1 || listed[0].ID != added.ID {
+		t.Errorf("GET %s = %+v, want silence %s", APIPath, listed, added.ID)
+	}
+
+	do(http.MethodDelete, APIPath+"/unknown?by=bob", "", http.StatusNotFound)
+	do(http.MethodDelete, APIPath+"/"+added.ID, "", http.StatusBadRequest)
+	do(http.MethodDelete, APIPath+"/"+added.ID+"?by=bob", "", http.StatusOK)
+	if sil := set.Match("google_pilot", pilot.URL, incident.Uncategorized); sil != nil {
+		t.Errorf("Match() after expiry = %+v, want nil", sil)
+	}
+	do(http.MethodPut, APIPath, "", http.StatusMethodNotAllowed)
+
+	// Who made each change through the API is only who the client claimed
+	// to be.
+	audit := readAudit(t, auditPath)
+	if len(audit) != 2 {
+		t.Fatalf("audit trail has %d entries, want 2: %+v", len(audit), audit)
+	}
+	for i, want := range []string{"alice", "bob"} {
+		if e := audit[i]; e.By != want || !e.Unverified {
+			t.Errorf("audit entry %d = %+v, want unverified change by %s", i, e, want)
+		}
+	}
+}
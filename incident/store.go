@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package incident
+
+import (
+	"context"
+	"time"
+)
+
+// Record is an incident as recorded, along with where it is in its lifecycle.
+type Record struct {
+	Incident
+	ID uint64
+	// OwningID is the ID of the owning incident of a sub-incident, or 0.
+	OwningID uint64
+	Source   string
+	// Timestamp is when the incident was recorded.
+	Timestamp time.Time
+	// LastSeen is when the most recent sub-incident of an owning incident was
+	// recorded.  It is zero for sub-incidents.
+	LastSeen time.Time
+	// State is the State of the incident or, for a sub-incident, of its
+	// owning incident.
+	State          State
+	AcknowledgedAt time.Time
+	ResolvedAt     time.Time
+	// SilenceID is the ID of the silence that suppressed a Suppressed
+	// incident.
+	SilenceID string
+}
+
+// Filter selects incidents.  An incident is selected if it matches every field
+// that is set.
+type Filter struct {
+	// BaseURL selects incidents for the Log with this base URL.
+	BaseURL string
+	// Source selects incidents recorded by this source.
+	Source string
+	// Since and Until select incidents recorded at or after Since, and before
+	// Until.
+	Since, Until time.Time
+	// IsViolation, if not nil, selects violations if true, or other
+	// incidents if false.
+	IsViolation *bool
+	// State selects incidents in this State.
+	State State
+	// SubIncidents selects sub-incidents as well as owning incidents, and
+	// incidents that have no owner.
+	SubIncidents bool
+	// Limit, if not 0, is the maximum number of incidents to select, most
+	// recent first.
+	Limit int
+}
+
+// Matches reports whether f selects r.  It does not consider f.Limit.
+func (f *Filter) Matches(r *Record) bool {
+	switch {
+	case f.BaseURL != "" && r.BaseURL != f.BaseURL:
+		return false
+	case f.Source != "" && r.Source != f.Source:
+		return false
+	case !f.Since.IsZero() && r.Timestamp.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !r.Timestamp.Before(f.Until):
+		return false
+	case f.IsViolation != nil && r.IsViolation != *f.IsViolation:
+		return false
+	case f.State != "" && r.State != f.State:
+		return false
+	case !f.SubIncidents && r.OwningID != 0:
+		return false
+	}
+	return true
+}
+
+// Querier describes a mechanism for finding recorded incidents.
+type Querier interface {
+	// List returns the incidents selected by f, most recent first.
+	List(ctx context.Context, f Filter) ([]*Record, error)
+	// Get returns the incident with the given ID, and its sub-incidents in
+	// the order they were recorded.
+	Get(ctx context.Context, id uint64) (*Record, []*Record, error)
+}
+
+// Store describes a mechanism for both finding recorded incidents and changing
+// their state.
+type Store interface {
+	Querier
+	Manager
+}
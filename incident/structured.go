@@ -0,0 +1,181 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package incident
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/logid"
+)
+
+// Category is a stable classification of what an incident is about, that
+// does not depend on the wording of its summary.
+type Category string
+
+// Categories of incident.
+const (
+	// Uncategorized incidents are those reported without a Category, for
+	// example through the string methods of Reporter.
+	Uncategorized Category = ""
+	// STHSignature incidents are STHs whose signature does not verify.
+	STHSignature Category = "sth_signature"
+	// SCTSignature incidents are SCTs whose signature does not verify.
+	SCTSignature Category = "sct_signature"
+	// Fork incidents are inconsistent views of a Log's tree, e.g. two STHs
+	// with the same tree size but different root hashes.
+	Fork Category = "fork"
+	// MMDBreach incidents are failures to incorporate entries, or to serve a
+	// fresh STH, within the Maximum Merge Delay.
+	MMDBreach Category = "mmd_breach"
+	// RootChange incidents are changes to the root certificates accepted by a
+	// Log.
+	RootChange Category = "root_change"
+	// DuplicateRoots incidents are root certificates that a Log returns more
+	// than once, possibly with different encodings.
+	DuplicateRoots Category = "duplicate_roots"
+	// RootProgram incidents are differences between the root certificates
+	// accepted by a Log and those in reference root programs.
+	RootProgram Category = "root_program"
+	// ShardInconsistency incidents are differences between the Logs in a
+	// family of temporal shards that should behave identically.
+	ShardInconsistency Category = "shard_inconsistency"
+	// RootHealth incidents are root certificates accepted by a Log that are
+	// expired, expiring, not CA certificates, not self-signed, or weak.
+	RootHealth Category = "root_health"
+	// RootAcceptance incidents are submissions that a Log rejected despite
+	// advertising their root, or accepted despite not advertising it.
+	RootAcceptance Category = "root_acceptance"
+	// InvalidSubmission incidents are SCTs issued for deliberately invalid
+	// submissions that a Log must reject.
+	InvalidSubmission Category = "invalid_submission"
+	// ShardSuccession incidents are temporal shards whose intervals are ending
+	// with no other shard in their family to succeed them.
+	ShardSuccession Category = "shard_succession"
+	// Availability incidents are failures to get a response from a Log.
+	Availability Category = "availability"
+)
+
+// Severity is how urgently an incident needs attention.
+type Severity string
+
+// Severities of incident, from least to most severe.
+const (
+	// Info incidents are worth recording, but need no action.
+	Info Severity = "info"
+	// Warning incidents may need action if they persist.
+	Warning Severity = "warning"
+	// Critical incidents need action now.
+	Critical Severity = "critical"
+)
+
+// EvidenceRef refers to a stored record that shows an incident happened.
+type EvidenceRef struct {
+	// Kind is the kind of record, e.g. "sth", "api_call", "roots" or "blob".
+	Kind string `json:"kind"`
+	// ID identifies the record within the storage for its Kind, e.g. a
+	// database row ID or a blob hash.
+	ID string `json:"id"`
+}
+
+func (e EvidenceRef) String() string {
+	return e.Kind + ":" + e.ID
+}
+
+// Incident holds everything known about a single compliance incident.
+type Incident struct {
+	// BaseURL, Summary, FullURL, Details and IsViolation have the same
+	// meaning as the parameters of the Reporter methods.
+	BaseURL     string
+	Summary     string
+	FullURL     string
+	Details     string
+	IsViolation bool
+
+	Category Category
+	// Severity, if unset, is Critical for violations and Info otherwise.
+	Severity Severity
+	// LogID is the ID of the Log, if known.
+	LogID logid.LogID
+	// Evidence refers to the records that show the incident happened.
+	Evidence []EvidenceRef
+	// RFCSections are citations of the requirements that were not met, e.g.
+	// "RFC 6962 s3.5".
+	RFCSections []string
+}
+
+// EffectiveSeverity returns the Severity of the incident, or the default for
+// its kind if none was set.
+func (i *Incident) EffectiveSeverity() Severity {
+	switch {
+	case i.Severity != "":
+		return i.Severity
+	case i.IsViolation:
+		return Critical
+	default:
+		return Info
+	}
+}
+
+// HasLogID reports whether the LogID of the incident is known.
+func (i *Incident) HasLogID() bool {
+	return i.LogID != logid.LogID{}
+}
+
+// StructuredReporter describes a mechanism for recording compliance incidents
+// that keeps all of the information in an Incident.
+type StructuredReporter interface {
+	Reporter
+	// Report records the given incident.
+	Report(ctx context.Context, inc *Incident)
+}
+
+// Report records inc using rep.  If rep is a StructuredReporter, inc is
+// recorded in full; otherwise only the fields supported by Reporter are
+// recorded, with the category and any RFC sections added to the details.
+func Report(ctx context.Context, rep Reporter, inc *Incident) {
+	if sr, ok := rep.(StructuredReporter); ok {
+		sr.Report(ctx, inc)
+		return
+	}
+	details := inc.Details
+	var notes []string
+	if inc.Category != Uncategorized {
+		notes = append(notes, fmt.Sprintf("category: %s", inc.Category))
+	}
+	if len(inc.RFCSections) > 0 {
+		notes = append(notes, fmt.Sprintf("see: %s", strings.Join(inc.RFCSections, ", ")))
+	}
+	if len(notes) > 0 {
+		details = fmt.Sprintf("%s\n[%s]", details, strings.Join(notes, "; "))
+	}
+	if inc.IsViolation {
+		rep.LogViolation(ctx, inc.BaseURL, inc.Summary, inc.FullURL, details)
+	} else {
+		rep.LogUpdate(ctx, inc.BaseURL, inc.Summary, inc.FullURL, details)
+	}
+}
+
+// Report emits a log message for the incident.
+func (l *LoggingReporter) Report(ctx context.Context, inc *Incident) {
+	msg := fmt.Sprintf("%s: %s (%s) [%s/%s]\n  %s", inc.BaseURL, inc.Summary, inc.FullURL, inc.Category, inc.EffectiveSeverity(), inc.Details)
+	if inc.IsViolation {
+		glog.Error(msg)
+	} else {
+		glog.Info(msg)
+	}
+}
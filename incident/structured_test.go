@@ -0,0 +1,87 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package incident_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/testonly"
+)
+
+// stringReporter is an incident.Reporter that is not an
+// incident.StructuredReporter.
+type stringReporter struct {
+	incident.Reporter
+}
+
+func TestReport(t *testing.T) {
+	ctx := context.Background()
+	inc := &incident.Incident{
+		BaseURL:     "https://ct.example.com/",
+		Summary:     "STH older than MMD",
+		FullURL:     "https://ct.example.com/ct/v1/get-sth",
+		Details:     "details",
+		IsViolation: true,
+		Category:    incident.MMDBreach,
+		RFCSections: []string{"RFC 6962 s3.5"},
+	}
+
+	tests := []struct {
+		desc string
+		wrap func(*testonly.FakeReporter) incident.Reporter
+		want testonly.Report
+	}{
+		{
+			desc: "structured",
+			wrap: func(f *testonly.FakeReporter) incident.Reporter { return f },
+			want: testonly.Report{BaseURL: inc.BaseURL, Summary: inc.Summary, FullURL: inc.FullURL, Details: "details", Category: incident.MMDBreach},
+		},
+		{
+			desc: "strings only",
+			wrap: func(f *testonly.FakeReporter) incident.Reporter { return stringReporter{f} },
+			want: testonly.Report{BaseURL: inc.BaseURL, Summary: inc.Summary, FullURL: inc.FullURL, Details: "details\n[category: mmd_breach; see: RFC 6962 s3.5]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			f := &testonly.FakeReporter{Updates: make(chan testonly.Report, 1), Violations: make(chan testonly.Report, 1)}
+			incident.Report(ctx, test.wrap(f), inc)
+			if len(f.Violations) != 1 {
+				t.Fatalf("reported %d violations, want 1", len(f.Violations))
+			}
+			if diff := cmp.Diff(<-f.Violations, test.want); diff != "" {
+				t.Errorf("reported: diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEffectiveSeverity(t *testing.T) {
+	for _, test := range []struct {
+		inc  incident.Incident
+		want incident.Severity
+	}{
+		{inc: incident.Incident{}, want: incident.Info},
+		{inc: incident.Incident{IsViolation: true}, want: incident.Critical},
+		{inc: incident.Incident{IsViolation: true, Severity: incident.Warning}, want: incident.Warning},
+	} {
+		if got := test.inc.EffectiveSeverity(); got != test.want {
+			t.Errorf("%+v.EffectiveSeverity() = %q, want %q", test.inc, got, test.want)
+		}
+	}
+}
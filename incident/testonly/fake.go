@@ -18,6 +18,8 @@ package testonly
 import (
 	"context"
 	"fmt"
+
+	"github.com/google/monologue/incident"
 )
 
 // Report contains all of the information submitted when creating an incident report.
@@ -26,12 +28,26 @@ type Report struct {
 	Summary string
 	FullURL string
 	Details string
+	// Category, Severity and Evidence are only set for incidents reported
+	// with Report.
+	Category incident.Category
+	Severity incident.Severity
+	Evidence []incident.EvidenceRef
+}
+
+// Resolution contains all of the information submitted when resolving incidents.
+type Resolution struct {
+	BaseURL string
+	Summary string
 }
 
 // FakeReporter sends incident reports to its Reports channel.
 type FakeReporter struct {
 	Updates    chan Report
 	Violations chan Report
+	// Resolutions, if not nil, receives a Resolution for each call to
+	// LogResolved.
+	Resolutions chan Resolution
 }
 
 // LogUpdate sends an incident report to the FakeReporter's Updates channel.
@@ -63,3 +79,30 @@ func (f *FakeReporter) LogViolation(ctx context.Context, baseURL, summary, fullU
 func (f *FakeReporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
 	f.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
 }
+
+// Report sends an incident report to the FakeReporter's Violations or Updates
+// channel, depending on its kind.
+func (f *FakeReporter) Report(ctx context.Context, inc *incident.Incident) {
+	r := Report{
+		BaseURL:  inc.BaseURL,
+		Summary:  inc.Summary,
+		FullURL:  inc.FullURL,
+		Details:  inc.Details,
+		Category: inc.Category,
+		Severity: inc.Severity,
+		Evidence: inc.Evidence,
+	}
+	if inc.IsViolation {
+		f.Violations <- r
+	} else {
+		f.Updates <- r
+	}
+}
+
+// LogResolved sends a Resolution to the FakeReporter's Resolutions channel, if it has one.
+func (f *FakeReporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	if f.Resolutions == nil {
+		return
+	}
+	f.Resolutions <- Resolution{BaseURL: baseURL, Summary: summary}
+}
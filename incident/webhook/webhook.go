@@ -0,0 +1,441 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook provides an implementation of incident reporting that POSTs
+// each incident, as JSON, to a configured URL.
+//
+// Requests can be signed with a shared secret, so that the receiver can check
+// that they came from the monitor.  Deliveries that fail are retried with
+// exponential backoff, and those that still fail can be kept in a directory on
+// disk to be retried later, including by a later run of the monitor.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/incident"
+)
+
+// SignatureHeader is the HTTP header that carries the signature of a request,
+// in the form "sha256=<hex-encoded HMAC-SHA256 of the request body>".
+const SignatureHeader = "X-Monologue-Signature"
+
+// Defaults for Options left unset.
+const (
+	DefaultMaxAttempts    = 5
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = time.Minute
+	DefaultRetryInterval  = 5 * time.Minute
+	DefaultMaxQueued      = 1000
+	// DefaultTimeout is the timeout of the Client used if none is
+	// configured.
+	DefaultTimeout = 30 * time.Second
+	// pendingSize is the number of payloads waiting for their first delivery
+	// attempt before further payloads go straight to the disk queue.
+	pendingSize = 100
+)
+
+// queueExtension is the file name extension of payloads in the disk queue.
+const queueExtension = ".json"
+
+// Payload is the JSON body of each request.
+type Payload struct {
+	Source      string    `json:"source"`
+	Timestamp   time.Time `json:"timestamp"`
+	LogURL      string    `json:"log_url"`
+	Summary     string    `json:"summary"`
+	FullURL     string    `json:"full_url,omitempty"`
+	Details     string    `json:"details,omitempty"`
+	IsViolation bool      `json:"is_violation"`
+	// The structured fields of an incident.Incident, which are only set for
+	// incidents reported with Report.
+	Category    incident.Category      `json:"category,omitempty"`
+	Severity    incident.Severity      `json:"severity,omitempty"`
+	LogID       string                 `json:"log_id,omitempty"`
+	Evidence    []incident.EvidenceRef `json:"evidence,omitempty"`
+	RFCSections []string               `json:"rfc_sections,omitempty"`
+	// Resolved is set for notifications that the condition that caused
+	// earlier incidents with the same LogURL and Summary has cleared.
+	Resolved bool `json:"resolved,omitempty"`
+}
+
+// Options configures a Reporter.
+type Options struct {
+	// URL is where payloads are POSTed to.
+	URL string
+	// Source identifies the monitor in every payload.
+	Source string
+	// Secret, if set, is used to sign each request with HMAC-SHA256.
+	Secret []byte
+	// Client is used to make requests.  If nil, a Client with a Timeout of
+	// DefaultTimeout is used.
+	Client *http.Client
+
+	// MaxAttempts is how many times delivery of a payload is attempted
+	// before it is queued on disk.
+	MaxAttempts int
+	// InitialBackoff is how long to wait after the first failed delivery
+	// attempt, doubling after each subsequent failure up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// QueueDir, if set, is a directory in which to keep payloads that could
+	// not be delivered.  It must already exist.  If unset, such payloads are
+	// dropped.
+	QueueDir string
+	// MaxQueued is the maximum number of payloads kept in QueueDir.  Once it
+	// is reached, the oldest payload is dropped to make room for a new one.
+	MaxQueued int
+	// RetryInterval is how often delivery of payloads in QueueDir is
+	// retried.
+	RetryInterval time.Duration
+}
+
+// Reporter implements incident.StructuredReporter and incident.Resolver by
+// POSTing a Payload to a webhook for each incident.  Payloads are delivered in
+// the background, so reporting an incident does not wait for delivery.
+type Reporter struct {
+	opts Options
+
+	mu      sync.Mutex // guards closed and seq
+	closed  bool
+	seq     int
+	pending chan *Payload
+	stop    chan struct{}
+	done    chan struct{}
+	// ctx is the context of every request, which is cancelled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns a Reporter configured by opts, and starts delivering payloads.
+func New(opts Options) (*Reporter, error) {
+	if opts.URL == "" {
+		return nil, errors.New("no webhook URL provided")
+	}
+	if opts.QueueDir != "" {
+		if fi, err := os.Stat(opts.QueueDir); err != nil {
+			return nil, err
+		} else if !fi.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", opts.QueueDir)
+		}
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = DefaultInitialBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.MaxQueued <= 0 {
+		opts.MaxQueued = DefaultMaxQueued
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultRetryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Reporter{
+		opts:    opts,
+		pending: make(chan *Payload, pendingSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go r.run()
+	return r, nil
+}
+
+// LogUpdate sends a payload for a non-violation incident.
+func (r *Reporter) LogUpdate(ctx context.Context, baseURL, summary, fullURL, details string) {
+	r.send(&Payload{LogURL: baseURL, Summary: summary, FullURL: fullURL, Details: details})
+}
+
+// LogUpdatef sends a payload for a non-violation incident, formatting parameters along the way.
+func (r *Reporter) LogUpdatef(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogUpdate(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// LogViolation sends a payload for a violation incident.
+func (r *Reporter) LogViolation(ctx context.Context, baseURL, summary, fullURL, details string) {
+	r.send(&Payload{LogURL: baseURL, Summary: summary, FullURL: fullURL, Details: details, IsViolation: true})
+}
+
+// LogViolationf sends a payload for a violation incident, formatting parameters along the way.
+func (r *Reporter) LogViolationf(ctx context.Context, baseURL, summary, fullURL, detailsFmt string, args ...interface{}) {
+	r.LogViolation(ctx, baseURL, summary, fullURL, fmt.Sprintf(detailsFmt, args...))
+}
+
+// Report sends a payload for an incident, including its structured fields.
+func (r *Reporter) Report(ctx context.Context, inc *incident.Incident) {
+	p := &Payload{
+		LogURL:      inc.BaseURL,
+		Summary:     inc.Summary,
+		FullURL:     inc.FullURL,
+		Details:     inc.Details,
+		IsViolation: inc.IsViolation,
+		Category:    inc.Category,
+		Severity:    inc.EffectiveSeverity(),
+		Evidence:    inc.Evidence,
+		RFCSections: inc.RFCSections,
+	}
+	if inc.HasLogID() {
+		p.LogID = inc.LogID.String()
+	}
+	r.send(p)
+}
+
+// LogResolved sends a payload saying that incidents have been resolved.
+func (r *Reporter) LogResolved(ctx context.Context, baseURL, summary string) {
+	r.send(&Payload{LogURL: baseURL, Summary: summary, Resolved: true})
+}
+
+// Close stops accepting incidents, abandons any delivery in progress, and
+// queues on disk every payload not yet delivered, so that a later Reporter
+// can deliver it.  Without a QueueDir, such payloads are dropped.
+func (r *Reporter) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.stop)
+		r.cancel()
+	}
+	r.mu.Unlock()
+	<-r.done
+	return nil
+}
+
+func (r *Reporter) send(p *Payload) {
+	p.Source = r.opts.Source
+	p.Timestamp = time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		glog.Errorf("webhook: reporter closed, dropping incident %q for %s", p.Summary, p.LogURL)
+		return
+	}
+	select {
+	case r.pending <- p:
+	default:
+		// Too many incidents to deliver right now; keep this one for later.
+		r.enqueueLocked(p)
+	}
+}
+
+// run delivers payloads as they are reported, and retries those on disk every
+// RetryInterval, until Close is called.
+func (r *Reporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.RetryInterval)
+	defer ticker.Stop()
+	r.retryQueued()
+	for {
+		select {
+		case p := <-r.pending:
+			r.deliverOrEnqueue(p)
+		case <-ticker.C:
+			r.retryQueued()
+		case <-r.stop:
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			for {
+				select {
+				case p := <-r.pending:
+					r.enqueueLocked(p)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Reporter) deliverOrEnqueue(p *Payload) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		glog.Errorf("webhook: failed to marshal incident %q for %s: %s", p.Summary, p.LogURL, err)
+		return
+	}
+	if err := r.deliverWithRetry(body); err != nil {
+		glog.Errorf("webhook: failed to deliver incident %q for %s: %s", p.Summary, p.LogURL, err)
+		r.mu.Lock()
+		r.enqueueLocked(p)
+		r.mu.Unlock()
+	}
+}
+
+// deliverWithRetry attempts to deliver body up to MaxAttempts times, backing
+// off between attempts, until Close is called.
+func (r *Reporter) deliverWithRetry(body []byte) error {
+	backoff := r.opts.InitialBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = r.deliver(body); err == nil {
+			return nil
+		}
+		if attempt >= r.opts.MaxAttempts {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-r.stop:
+			t.Stop()
+			return err
+		}
+		if backoff *= 2; backoff > r.opts.MaxBackoff {
+			backoff = r.opts.MaxBackoff
+		}
+	}
+}
+
+// deliver makes a single attempt to POST body to the webhook.
+func (r *Reporter) deliver(body []byte) error {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodPost, r.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(r.opts.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(r.opts.Secret, body))
+	}
+	rsp, err := r.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(ioutil.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", rsp.Status)
+	}
+	return nil
+}
+
+// Sign returns the value of the SignatureHeader for a request with the given
+// body, signed with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns whether signature, the value of the SignatureHeader of a
+// request, is a valid signature of body with secret.
+func Verify(secret, body []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, body)))
+}
+
+// enqueueLocked writes p to the disk queue, dropping the oldest queued payload
+// if the queue is full.  r.mu must be held.
+func (r *Reporter) enqueueLocked(p *Payload) {
+	if r.opts.QueueDir == "" {
+		glog.Errorf("webhook: no queue directory, dropping incident %q for %s", p.Summary, p.LogURL)
+		return
+	}
+	files, err := r.queuedFiles()
+	if err != nil {
+		glog.Errorf("webhook: failed to list queue, dropping incident %q for %s: %s", p.Summary, p.LogURL, err)
+		return
+	}
+	for len(files) >= r.opts.MaxQueued {
+		glog.Errorf("webhook: queue full, dropping oldest queued incident %s", files[0])
+		if err := os.Remove(files[0]); err != nil {
+			glog.Errorf("webhook: failed to drop %s: %s", files[0], err)
+			return
+		}
+		files = files[1:]
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		glog.Errorf("webhook: failed to marshal incident %q for %s: %s", p.Summary, p.LogURL, err)
+		return
+	}
+	name := filepath.Join(r.opts.QueueDir, fmt.Sprintf("%s-%06d%s", p.Timestamp.Format("20060102T150405.000000000Z"), r.seq, queueExtension))
+	r.seq++
+	if err := ioutil.WriteFile(name, data, 0644); err != nil {
+		glog.Errorf("webhook: failed to queue incident %q for %s: %s", p.Summary, p.LogURL, err)
+	}
+}
+
+// queuedFiles returns the names of the payload files in the disk queue, oldest
+// first.
+func (r *Reporter) queuedFiles() ([]string, error) {
+	entries, err := ioutil.ReadDir(r.opts.QueueDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), queueExtension) {
+			files = append(files, filepath.Join(r.opts.QueueDir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// retryQueued makes one attempt to deliver each payload in the disk queue,
+// oldest first, stopping at the first failure so that payloads are delivered
+// in order.
+func (r *Reporter) retryQueued() {
+	if r.opts.QueueDir == "" {
+		return
+	}
+	r.mu.Lock()
+	files, err := r.queuedFiles()
+	r.mu.Unlock()
+	if err != nil {
+		glog.Errorf("webhook: failed to list queue: %s", err)
+		return
+	}
+	for _, f := range files {
+		body, err := ioutil.ReadFile(f)
+		if err != nil {
+			// Dropped to make room for a newer payload.
+			continue
+		}
+		if err := r.deliver(body); err != nil {
+			glog.Warningf("webhook: %d queued incident(s) still undeliverable: %s", len(files), err)
+			return
+		}
+		if err := os.Remove(f); err != nil {
+			glog.Errorf("webhook: delivered %s, but failed to remove it from the queue: %s", f, err)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/monologue/incident"
+)
+
+var secret = []byte("shared secret")
+
+// server is a webhook that records the payloads it receives, and fails the
+// first failures requests made to it.
+type server struct {
+	t *testing.T
+
+	mu       sync.Mutex
+	failures int
+	attempts int
+	payloads []Payload
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.t.Errorf("failed to read request body: %s", err)
+	}
+	if !Verify(secret, body, r.Header.Get(SignatureHeader)) {
+		s.t.Errorf("request has invalid signature %q", r.Header.Get(SignatureHeader))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		s.t.Errorf("failed to unmarshal payload: %s", err)
+	}
+	s.payloads = append(s.payloads, p)
+}
+
+func (s *server) setFailures(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = n
+}
+
+func (s *server) received() []Payload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Payload(nil), s.payloads...)
+}
+
+var ignoreTimestamp = cmpopts.IgnoreFields(Payload{}, "Timestamp")
+
+func TestNewErrors(t *testing.T) {
+	for _, opts := range []Options{
+		{},
+		{URL: "http://localhost", QueueDir: "/does/not/exist"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) = _, nil, want error", opts)
+		}
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"summary":"x"}`)
+	sig := Sign(secret, body)
+	if !Verify(secret, body, sig) {
+		t.Errorf("Verify(%q) = false, want true", sig)
+	}
+	if Verify([]byte("other secret"), body, sig) {
+		t.Errorf("Verify() with wrong secret = true, want false")
+	}
+	if Verify(secret, []byte(`{"summary":"y"}`), sig) {
+		t.Errorf("Verify() with wrong body = true, want false")
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	ctx := context.Background()
+	s := &server{t: t, failures: 2}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	r, err := New(Options{URL: ts.URL, Source: "unittest", Secret: secret, InitialBackoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	var _ incident.StructuredReporter = r
+	var _ incident.Resolver = r
+
+	r.LogViolationf(ctx, "https://ct.example.com/", "STH older than MMD", "https://ct.example.com/ct/v1/get-sth", "tree size %d", 10)
+	r.LogUpdate(ctx, "https://ct.example.com/", "Root certificates changed", "https://ct.example.com/ct/v1/get-roots", "details")
+	incident.LogResolved(ctx, r, "https://ct.example.com/", "STH older than MMD")
+	incident.Report(ctx, r, &incident.Incident{
+		BaseURL:     "https://ct.example.com/",
+		Summary:     "Bad STH signature",
+		IsViolation: true,
+		Category:    incident.STHSignature,
+		Evidence:    []incident.EvidenceRef{{Kind: "sth", ID: "42"}},
+		RFCSections: []string{"RFC 6962 s3.5"},
+	})
+	deadline := time.Now().Add(10 * time.Second)
+	for len(s.received()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	want := []Payload{
+		{Source: "unittest", LogURL: "https://ct.example.com/", Summary: "STH older than MMD", FullURL: "https://ct.example.com/ct/v1/get-sth", Details: "tree size 10", IsViolation: true},
+		{Source: "unittest", LogURL: "https://ct.example.com/", Summary: "Root certificates changed", FullURL: "https://ct.example.com/ct/v1/get-roots", Details: "details"},
+		{Source: "unittest", LogURL: "https://ct.example.com/", Summary: "STH older than MMD", Resolved: true},
+		{
+			Source:      "unittest",
+			LogURL:      "https://ct.example.com/",
+			Summary:     "Bad STH signature",
+			IsViolation: true,
+			Category:    incident.STHSignature,
+			Severity:    incident.Critical,
+			Evidence:    []incident.EvidenceRef{{Kind: "sth", ID: "42"}},
+			RFCSections: []string{"RFC 6962 s3.5"},
+		},
+	}
+	if diff := cmp.Diff(s.received(), want, ignoreTimestamp); diff != "" {
+		t.Errorf("payloads: diff (-got +want)\n%s", diff)
+	}
+	if s.attempts != 6 {
+		t.Errorf("webhook received %d requests, want 6", s.attempts)
+	}
+}
+
+func TestDiskQueue(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "monologue-webhook-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &server{t: t, failures: 1000}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	opts := Options{
+		URL:            ts.URL,
+		Source:         "unittest",
+		Secret:         secret,
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+		QueueDir:       dir,
+		MaxQueued:      2,
+		RetryInterval:  time.Hour,
+	}
+
+	// Every delivery fails, so every payload is queued, and the oldest is
+	// dropped once the queue is full.
+	r, err := New(opts)
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	for _, summary := range []string{"one", "two", "three"} {
+		r.LogViolation(ctx, "https://ct.example.com/", summary, "", "")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	if got := s.received(); len(got) != 0 {
+		t.Fatalf("webhook received %d payloads, want 0", len(got))
+	}
+	files, err := r.queuedFiles()
+	if err != nil {
+		t.Fatalf("queuedFiles() = _, %s", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("%d payloads queued, want 2", len(files))
+	}
+
+	// A new Reporter delivers the queued payloads once the webhook works.
+	s.setFailures(0)
+	opts.RetryInterval = 10 * time.Millisecond
+	r, err = New(opts)
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for len(s.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	want := []Payload{
+		{Source: "unittest", LogURL: "https://ct.example.com/", Summary: "two", IsViolation: true},
+		{Source: "unittest", LogURL: "https://ct.example.com/", Summary: "three", IsViolation: true},
+	}
+	if diff := cmp.Diff(s.received(), want, ignoreTimestamp); diff != "" {
+		t.Errorf("payloads: diff (-got +want)\n%s", diff)
+	}
+	if files, err := r.queuedFiles(); err != nil || len(files) != 0 {
+		t.Errorf("queuedFiles() = %v, %v, want none", files, err)
+	}
+}
+
+func TestCloseQueuesPending(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "monologue-webhook-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &server{t: t, failures: 1000}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// The first delivery attempt fails, leaving the Reporter backing off for
+	// an hour, with the second payload still waiting for its first attempt.
+	r, err := New(Options{URL: ts.URL, Source: "unittest", Secret: secret, InitialBackoff: time.Hour, QueueDir: dir, RetryInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	r.LogViolation(ctx, "https://ct.example.com/", "one", "", "")
+	r.LogViolation(ctx, "https://ct.example.com/", "two", "", "")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		s.mu.Lock()
+		attempts := s.attempts
+		s.mu.Unlock()
+		if attempts > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Close() took %s, want it not to wait out the backoff", d)
+	}
+	files, err := r.queuedFiles()
+	if err != nil {
+		t.Fatalf("queuedFiles() = _, %s", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d payloads queued, want 2", len(files))
+	}
+}
@@ -80,3 +80,13 @@ func (i *Interval) RandomSecond() time.Time {
 	rand.Seed(time.Now().UnixNano())
 	return time.Unix(start+rand.Int63n(delta), 0)
 }
+
+// Contains returns whether t falls within the Interval [Start, End).
+//
+// If the Interval is nil, false will be returned.
+func (i *Interval) Contains(t time.Time) bool {
+	if i == nil {
+		return false
+	}
+	return !t.Before(i.Start) && t.Before(i.End)
+}
@@ -117,3 +117,29 @@ func TestRandomSecond(t *testing.T) {
 		})
 	}
 }
+
+func TestContains(t *testing.T) {
+	i := &Interval{
+		Start: time.Date(2019, time.March, 25, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2019, time.March, 26, 0, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		desc string
+		in   *Interval
+		t    time.Time
+		want bool
+	}{
+		{desc: "nil", in: nil, t: i.Start},
+		{desc: "before", in: i, t: i.Start.Add(-time.Second)},
+		{desc: "start", in: i, t: i.Start, want: true},
+		{desc: "middle", in: i, t: i.Start.Add(12 * time.Hour), want: true},
+		{desc: "end", in: i, t: i.End},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if got := test.in.Contains(test.t); got != test.want {
+				t.Errorf("%v.Contains(%s) = %t, want %t", test.in, test.t, got, test.want)
+			}
+		})
+	}
+}
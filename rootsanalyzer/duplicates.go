@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"text/template"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+)
+
+// DuplicateRootsSummary is the summary of the incident reported when a Log's
+// get-roots response contains duplicate root certificates.  The incident is
+// resolved once a get-roots response does not.
+const DuplicateRootsSummary = "Duplicate root certificates"
+
+var duplicatesTemplate = template.Must(template.New("duplicates_incident").Funcs(template.FuncMap{
+	"sha256": sha256.Sum256,
+}).Parse(`The root certificates returned by {{ .Log.Name }} ({{ .Log.URL }}) contain duplicates.
+{{ if gt (len .Repeated) 0 }}
+Certificates returned more than once ({{ len .Repeated }}):
+{{ range .Repeated }}{{ (index .Certs 0).Subject }} (SHA256: {{ sha256 (index .Certs 0).Raw | printf "%X" }}) returned {{ len .Certs }} times
+{{ end }}{{ end }}{{ if gt (len .Reencoded) 0 }}
+Subjects and keys returned in more than one certificate ({{ len .Reencoded }}):
+{{ range .Reencoded }}{{ (index .Certs 0).Subject }}:
+{{ range .Certs }}  SHA256: {{ sha256 .Raw | printf "%X" }}
+{{ end }}{{ end }}{{ end }}`))
+
+type duplicatesTemplateArgs struct {
+	Log       *ctlog.Log
+	Repeated  []Duplicate
+	Reencoded []Duplicate
+}
+
+// Duplicate is a group of certificates in a root set that duplicate one
+// another.
+type Duplicate struct {
+	// Certs are the duplicates, in the order they appear in the root set.
+	Certs []*x509.Certificate
+	// Reencoded is false if Certs are all the same certificate, and true if
+	// they are different certificates, each with the same subject and public
+	// key.  A reencoded Duplicate contains each certificate only once.
+	Reencoded bool
+}
+
+// FindDuplicates returns the certificates that appear in certs more than once,
+// and the sets of distinct certificates in certs that have the same subject and
+// public key, in the order they first appear.
+func FindDuplicates(certs []*x509.Certificate) []Duplicate {
+	type subjectKey struct{ subject, key string }
+	var (
+		byDER            = make(map[string][]*x509.Certificate)
+		bySubjectKey     = make(map[subjectKey][]*x509.Certificate)
+		ders             []string
+		subjectKeys      []subjectKey
+		reencodedSubject = make(map[subjectKey]bool)
+	)
+	for _, cert := range certs {
+		der := string(cert.Raw)
+		if byDER[der] == nil {
+			ders = append(ders, der)
+			sk := subjectKey{string(cert.RawSubject), string(cert.RawSubjectPublicKeyInfo)}
+			if bySubjectKey[sk] == nil {
+				subjectKeys = append(subjectKeys, sk)
+			} else {
+				reencodedSubject[sk] = true
+			}
+			bySubjectKey[sk] = append(bySubjectKey[sk], cert)
+		}
+		byDER[der] = append(byDER[der], cert)
+	}
+
+	var dups []Duplicate
+	for _, der := range ders {
+		if len(byDER[der]) > 1 {
+			dups = append(dups, Duplicate{Certs: byDER[der]})
+		}
+	}
+	for _, sk := range subjectKeys {
+		if reencodedSubject[sk] {
+			dups = append(dups, Duplicate{Certs: bySubjectKey[sk], Reencoded: true})
+		}
+	}
+	return dups
+}
+
+// ReportDuplicates reports an incident if the root certificates returned by l
+// contain duplicates, as found by FindDuplicates, and otherwise declares any
+// such incident resolved.
+func ReportDuplicates(ctx context.Context, rep incident.Reporter, l *ctlog.Log, roots []*x509.Certificate) error {
+	dups := FindDuplicates(roots)
+	if len(dups) == 0 {
+		incident.LogResolved(ctx, rep, l.URL, DuplicateRootsSummary)
+		return nil
+	}
+
+	args := duplicatesTemplateArgs{Log: l}
+	for _, d := range dups {
+		if d.Reencoded {
+			args.Reencoded = append(args.Reencoded, d)
+		} else {
+			args.Repeated = append(args.Repeated, d)
+		}
+	}
+	var strBuilder strings.Builder
+	if err := duplicatesTemplate.Execute(&strBuilder, args); err != nil {
+		return err
+	}
+	incident.Report(ctx, rep, &incident.Incident{
+		BaseURL:  l.URL,
+		Summary:  DuplicateRootsSummary,
+		FullURL:  getRootsURL(l),
+		Details:  strBuilder.String(),
+		Category: incident.DuplicateRoots,
+		Severity: incident.Warning,
+		LogID:    l.LogID,
+	})
+	return nil
+}
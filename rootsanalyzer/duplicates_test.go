@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+
+	itestonly "github.com/google/monologue/incident/testonly"
+)
+
+// mustReencodedRoots returns two self-signed certificates with the same
+// subject and key, but different serial numbers.
+func mustReencodedRoots(t *testing.T) (*x509.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = _, %v", err)
+	}
+	var certs []*x509.Certificate
+	for serial := int64(1); serial <= 2; serial++ {
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: "Reencoded Root"},
+			NotBefore:             time.Unix(0, 0),
+			NotAfter:              time.Unix(0, 0).Add(24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+		if err != nil {
+			t.Fatalf("x509.CreateCertificate() = _, %v", err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("x509.ParseCertificate() = _, %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs[0], certs[1]
+}
+
+func TestFindDuplicates(t *testing.T) {
+	reencoded1, reencoded2 := mustReencodedRoots(t)
+
+	tests := []struct {
+		desc  string
+		certs []*x509.Certificate
+		want  []Duplicate
+	}{
+		{
+			desc:  "none",
+			certs: []*x509.Certificate{root1, root2, reencoded1},
+		},
+		{
+			desc:  "repeated",
+			certs: []*x509.Certificate{root1, root2, root1, root2, root1},
+			want: []Duplicate{
+				{Certs: []*x509.Certificate{root1, root1, root1}},
+				{Certs: []*x509.Certificate{root2, root2}},
+			},
+		},
+		{
+			desc:  "reencoded",
+			certs: []*x509.Certificate{reencoded2, root1, reencoded1},
+			want: []Duplicate{
+				{Certs: []*x509.Certificate{reencoded2, reencoded1}, Reencoded: true},
+			},
+		},
+		{
+			desc:  "repeated and reencoded",
+			certs: []*x509.Certificate{reencoded1, reencoded2, reencoded1},
+			want: []Duplicate{
+				{Certs: []*x509.Certificate{reencoded1, reencoded1}},
+				{Certs: []*x509.Certificate{reencoded1, reencoded2}, Reencoded: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, FindDuplicates(test.certs)); diff != "" {
+				t.Errorf("FindDuplicates() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReportDuplicates(t *testing.T) {
+	ctx := context.Background()
+	reencoded1, reencoded2 := mustReencodedRoots(t)
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	rep := &itestonly.FakeReporter{
+		Updates:     make(chan itestonly.Report, 1),
+		Resolutions: make(chan itestonly.Resolution, 1),
+	}
+
+	if err := ReportDuplicates(ctx, rep, l, []*x509.Certificate{root1, root2, root1, reencoded1, reencoded2}); err != nil {
+		t.Fatalf("ReportDuplicates() = %v", err)
+	}
+	select {
+	case got := <-rep.Updates:
+		want := itestonly.Report{
+			BaseURL:  l.URL,
+			Summary:  DuplicateRootsSummary,
+			FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+			Category: incident.DuplicateRoots,
+			Severity: incident.Warning,
+		}
+		details := got.Details
+		got.Details = ""
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("ReportDuplicates() reported diff (-want +got):\n%s", diff)
+		}
+		for _, s := range []string{
+			"Certificates returned more than once (1):\nCN=GLOBALTRUST",
+			"returned 2 times",
+			"Subjects and keys returned in more than one certificate (1):\nCN=Reencoded Root:\n  SHA256: ",
+		} {
+			if !strings.Contains(details, s) {
+				t.Errorf("ReportDuplicates() reported details %q, want to contain %q", details, s)
+			}
+		}
+	default:
+		t.Fatal("ReportDuplicates() reported no incident")
+	}
+
+	if err := ReportDuplicates(ctx, rep, l, []*x509.Certificate{root1, root2}); err != nil {
+		t.Fatalf("ReportDuplicates() = %v", err)
+	}
+	select {
+	case got := <-rep.Resolutions:
+		if want := (itestonly.Resolution{BaseURL: l.URL, Summary: DuplicateRootsSummary}); got != want {
+			t.Errorf("ReportDuplicates() resolved %+v, want %+v", got, want)
+		}
+	default:
+		t.Fatal("ReportDuplicates() resolved no incident")
+	}
+}
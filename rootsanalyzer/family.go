@@ -0,0 +1,364 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/loglist2"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+)
+
+// ShardDriftSummary is the summary of the incident reported when a Log accepts
+// different root certificates to the other Logs in its Family.  The incident
+// is resolved once it accepts the same root certificates again.
+const ShardDriftSummary = "Root certificates differ from sibling shards"
+
+// Family is a group of Logs, such as the temporal shards of a single operator,
+// that are expected to accept identical root certificates.
+type Family struct {
+	Operator string `json:"operator"`
+	Name     string `json:"name"`
+	// Logs are the Logs in the family, which are identified by their names or
+	// URLs in configuration.
+	Logs []*ctlog.Log `json:"-"`
+}
+
+// EndedShardAge is how long after the end of its temporal interval a shard is
+// taken to have been frozen or retired.  Such shards are left out of the
+// Families built by FamiliesFromLogList, and are not checked for a successor
+// by EndingShards.
+const EndedShardAge = 90 * 24 * time.Hour
+
+// endedLongAgo reports whether l is a temporal shard whose interval ended more
+// than EndedShardAge before now.
+func endedLongAgo(l *ctlog.Log, now time.Time) bool {
+	return l.TemporalInterval != nil && now.Sub(l.TemporalInterval.End) > EndedShardAge
+}
+
+// shardYear matches the part of a Log's description that distinguishes it from
+// the other temporal shards in its family, e.g. "2020" in "Google 'Argon2020'
+// log", or " 2021 H1" in "Let's Encrypt Oak 2021 H1".
+var shardYear = regexp.MustCompile(`\s?(19|20)\d\d(\s?[Hh][12])?`)
+
+// FamiliesFromLogList groups the temporal shards in ll into Families, by
+// operator and by their descriptions with the year of each shard removed.
+// Shards that are retired or rejected in ll, or whose intervals ended more than
+// EndedShardAge before now, are left out.  Only families of more than one Log
+// are returned, ordered by operator and name, with their Logs ordered by the
+// start of their temporal intervals.
+func FamiliesFromLogList(ll *loglist2.LogList, now time.Time) ([]*Family, error) {
+	type key struct{ operator, name string }
+	byKey := make(map[key]*Family)
+	for _, op := range ll.Operators {
+		for _, ll := range op.Logs {
+			if ll.TemporalInterval == nil {
+				continue
+			}
+			if ll.State != nil && (ll.State.Retired != nil || ll.State.Rejected != nil) {
+				continue
+			}
+			l, err := ctlog.FromLogList(ll)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", ll.URL, err)
+			}
+			if endedLongAgo(l, now) {
+				continue
+			}
+			k := key{op.Name, strings.TrimSpace(shardYear.ReplaceAllString(ll.Description, ""))}
+			if byKey[k] == nil {
+				byKey[k] = &Family{Operator: k.operator, Name: k.name}
+			}
+			byKey[k].Logs = append(byKey[k].Logs, l)
+		}
+	}
+
+	var families []*Family
+	for _, f := range byKey {
+		if len(f.Logs) < 2 {
+			continue
+		}
+		sort.SliceStable(f.Logs, func(i, j int) bool {
+			return f.Logs[i].TemporalInterval.Start.Before(f.Logs[j].TemporalInterval.Start)
+		})
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		if families[i].Operator != families[j].Operator {
+			return families[i].Operator < families[j].Operator
+		}
+		return families[i].Name < families[j].Name
+	})
+	return families, nil
+}
+
+// familyConfig is the configuration of a Family, as read by ParseFamilies.
+type familyConfig struct {
+	Family
+	Logs []string `json:"logs"`
+}
+
+// ParseFamilies reads a JSON array of Families from r, each of which has an
+// "operator", a "name", and "logs" listing the names or URLs of the Logs in
+// the family.  Each Log must be one of logs.
+func ParseFamilies(r io.Reader, logs []*ctlog.Log) ([]*Family, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var configs []familyConfig
+	if err := dec.Decode(&configs); err != nil {
+		return nil, fmt.Errorf("error parsing families: %s", err)
+	}
+
+	families := make([]*Family, 0, len(configs))
+	for i, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("family %d: no name", i)
+		}
+		if len(c.Logs) < 2 {
+			return nil, fmt.Errorf("family %q: fewer than 2 logs", c.Name)
+		}
+		f := c.Family
+		for _, id := range c.Logs {
+			l := findLog(logs, id)
+			if l == nil {
+				return nil, fmt.Errorf("family %q: unknown log %q", c.Name, id)
+			}
+			f.Logs = append(f.Logs, l)
+		}
+		families = append(families, &f)
+	}
+	return families, nil
+}
+
+// LoadFamilies reads Families from the JSON file at path, as described for
+// ParseFamilies.
+func LoadFamilies(path string, logs []*ctlog.Log) ([]*Family, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseFamilies(f, logs)
+}
+
+// findLog returns the Log in logs with the given name or URL, or nil.
+func findLog(logs []*ctlog.Log, id string) *ctlog.Log {
+	for _, l := range logs {
+		if l.Name == id || strings.TrimSuffix(l.URL, "/") == strings.TrimSuffix(id, "/") {
+			return l
+		}
+	}
+	return nil
+}
+
+var shardDriftTemplate = template.Must(template.New("shard_drift_incident").Funcs(template.FuncMap{
+	"sha256": sha256.Sum256,
+}).Parse(`The root certificates accepted by {{ .Log.Name }} ({{ .Log.URL }}) differ from those accepted by {{ len .Siblings }} other {{ if .Family.Operator }}{{ .Family.Operator }} {{ end }}{{ .Family.Name }} shards ({{ range $i, $l := .Siblings }}{{ if $i }}, {{ end }}{{ $l.Name }}{{ end }}).
+{{ if gt (len .OnlyShard) 0 }}
+Certificates only accepted by {{ .Log.Name }} ({{ len .OnlyShard }}):
+{{ range .OnlyShard }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }})
+{{ end }}{{ end }}{{ if gt (len .OnlySiblings) 0 }}
+Certificates only accepted by the other shards ({{ len .OnlySiblings }}):
+{{ range .OnlySiblings }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }})
+{{ end }}{{ end }}`))
+
+type shardDriftTemplateArgs struct {
+	Log          *ctlog.Log
+	Family       *Family
+	Siblings     []*ctlog.Log
+	OnlyShard    []*x509.Certificate
+	OnlySiblings []*x509.Certificate
+}
+
+// familyUpdate is a root set observed for the Log at an index in a Family.
+type familyUpdate struct {
+	index     int
+	rootSetID storage.RootSetID
+}
+
+// drift is the difference between the root set of a Log and that of the rest
+// of its Family, as last reported.
+type drift struct {
+	shard, siblings storage.RootSetID
+}
+
+// familyAnalyzer holds the state of a Family Roots Analyzer.
+type familyAnalyzer struct {
+	st  storage.RootsReader
+	rep incident.Reporter
+	f   *Family
+
+	// latest holds the latest root set observed for each Log in the Family.
+	latest []storage.RootSetID
+	// reported holds the drift last reported for each Log in the Family.
+	reported []drift
+}
+
+// RunFamily starts a Family Roots Analyzer, which watches the root
+// certificates of each Log in f, and reports incidents for the Logs whose
+// latest root set differs from that of most of the Family.  If no root set is
+// shared by more Logs than any other, the root set of the earliest of those
+// Logs in f is taken to be the Family's.
+func RunFamily(ctx context.Context, st storage.RootsReader, rep incident.Reporter, f *Family) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	updates := make(chan familyUpdate)
+	for i, l := range f.Logs {
+		rootSetChan, err := st.WatchRoots(ctx, l)
+		if err != nil {
+			glog.Errorf("%s: %s: storage.RootsReader.WatchRoots() = %q", l.URL, logStr, err)
+			return
+		}
+		go func(i int, rootSetChan <-chan storage.RootSetID) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-rootSetChan:
+					select {
+					case updates <- familyUpdate{index: i, rootSetID: id}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(i, rootSetChan)
+	}
+
+	a := &familyAnalyzer{
+		st:       st,
+		rep:      rep,
+		f:        f,
+		latest:   make([]storage.RootSetID, len(f.Logs)),
+		reported: make([]drift, len(f.Logs)),
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case u := <-updates:
+			if a.latest[u.index] == u.rootSetID {
+				continue
+			}
+			a.latest[u.index] = u.rootSetID
+			if err := a.compare(ctx); err != nil {
+				glog.Errorf("%s %s: %s: %s", f.Operator, f.Name, logStr, err)
+			}
+		}
+	}
+}
+
+// familyRootSet returns the root set shared by the most Logs in the Family
+// whose root sets are known.
+func (a *familyAnalyzer) familyRootSet() storage.RootSetID {
+	counts := make(map[storage.RootSetID]int)
+	var best storage.RootSetID
+	for _, id := range a.latest {
+		if id == "" {
+			continue
+		}
+		counts[id]++
+		if counts[id] > counts[best] {
+			best = id
+		}
+	}
+	// On a tie, prefer the root set of the earliest Log.
+	for _, id := range a.latest {
+		if id != "" && counts[id] == counts[best] {
+			return id
+		}
+	}
+	return best
+}
+
+// compare reports each Log in the Family whose root set has drifted from the
+// Family's since it was last reported, and declares resolved the incidents of
+// those that no longer differ.
+func (a *familyAnalyzer) compare(ctx context.Context) error {
+	family := a.familyRootSet()
+	for i, l := range a.f.Logs {
+		id := a.latest[i]
+		if id == "" {
+			continue
+		}
+		d := drift{shard: id, siblings: family}
+		if id == family {
+			d = drift{}
+		}
+		if d == a.reported[i] {
+			continue
+		}
+		if d == (drift{}) {
+			incident.LogResolved(ctx, a.rep, l.URL, ShardDriftSummary)
+		} else if err := a.reportDrift(ctx, i, d); err != nil {
+			return err
+		}
+		a.reported[i] = d
+	}
+	return nil
+}
+
+// reportDrift reports that the Log at index i in the Family has drifted as
+// described by d.
+func (a *familyAnalyzer) reportDrift(ctx context.Context, i int, d drift) error {
+	l := a.f.Logs[i]
+	shardRoots, err := a.st.ReadRoots(ctx, d.shard)
+	if err != nil {
+		return err
+	}
+	familyRoots, err := a.st.ReadRoots(ctx, d.siblings)
+	if err != nil {
+		return err
+	}
+	onlyShard, onlySiblings := storage.DiffRoots(familyRoots, shardRoots)
+	sortCerts(onlyShard)
+	sortCerts(onlySiblings)
+
+	args := shardDriftTemplateArgs{Log: l, Family: a.f, OnlyShard: onlyShard, OnlySiblings: onlySiblings}
+	for j, sibling := range a.f.Logs {
+		if a.latest[j] == d.siblings {
+			args.Siblings = append(args.Siblings, sibling)
+		}
+	}
+	var strBuilder strings.Builder
+	if err := shardDriftTemplate.Execute(&strBuilder, args); err != nil {
+		return err
+	}
+	incident.Report(ctx, a.rep, &incident.Incident{
+		BaseURL:  l.URL,
+		Summary:  ShardDriftSummary,
+		FullURL:  getRootsURL(l),
+		Details:  strBuilder.String(),
+		Category: incident.ShardInconsistency,
+		Severity: incident.Warning,
+		LogID:    l.LogID,
+	})
+	return nil
+}
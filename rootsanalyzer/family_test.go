@@ -0,0 +1,278 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/loglist2"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+
+	itestonly "github.com/google/monologue/incident/testonly"
+)
+
+func mustPublicKeyDER(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() = _, %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() = _, %v", err)
+	}
+	return der
+}
+
+func TestFamiliesFromLogList(t *testing.T) {
+	year := func(y int) *loglist2.TemporalInterval {
+		return &loglist2.TemporalInterval{
+			StartInclusive: time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndExclusive:   time.Date(y+1, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	shard := func(desc, url string, ti *loglist2.TemporalInterval) *loglist2.Log {
+		return &loglist2.Log{Description: desc, Key: mustPublicKeyDER(t), URL: url, MMD: 86400, TemporalInterval: ti}
+	}
+	retired := func(l *loglist2.Log) *loglist2.Log {
+		l.State = &loglist2.LogStates{Retired: &loglist2.LogState{Timestamp: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}}
+		return l
+	}
+	rejected := func(l *loglist2.Log) *loglist2.Log {
+		l.State = &loglist2.LogStates{Rejected: &loglist2.LogState{Timestamp: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)}}
+		return l
+	}
+	ll := &loglist2.LogList{
+		Operators: []*loglist2.Operator{
+			{
+				Name: "Google",
+				Logs: []*loglist2.Log{
+					shard("Google 'Argon2021' log", "https://ct.googleapis.com/logs/argon2021/", year(2021)),
+					shard("Google 'Argon2020' log", "https://ct.googleapis.com/logs/argon2020/", year(2020)),
+					shard("Google 'Argon2019' log", "https://ct.googleapis.com/logs/argon2019/", year(2019)),
+					shard("Google 'Xenon2020' log", "https://ct.googleapis.com/logs/xenon2020/", year(2020)),
+					retired(shard("Google 'Xenon2021' log", "https://ct.googleapis.com/logs/xenon2021/", year(2021))),
+					shard("Google 'Pilot' log", "https://ct.googleapis.com/pilot/", nil),
+				},
+			},
+			{
+				Name: "Let's Encrypt",
+				Logs: []*loglist2.Log{
+					shard("Let's Encrypt 'Oak2020' log", "https://oak.ct.letsencrypt.org/2020/", year(2020)),
+					shard("Let's Encrypt 'Oak2021' log", "https://oak.ct.letsencrypt.org/2021/", year(2021)),
+					rejected(shard("Let's Encrypt 'Oak2022' log", "https://oak.ct.letsencrypt.org/2022/", year(2022))),
+				},
+			},
+		},
+	}
+
+	// Argon2019 ended long before now, so is left out along with Xenon2021 and
+	// Oak2022, leaving Xenon2020 with no family.
+	now := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	families, err := FamiliesFromLogList(ll, now)
+	if err != nil {
+		t.Fatalf("FamiliesFromLogList() = _, %v", err)
+	}
+	type summary struct {
+		Operator, Name string
+		URLs           []string
+	}
+	var got []summary
+	for _, f := range families {
+		s := summary{Operator: f.Operator, Name: f.Name}
+		for _, l := range f.Logs {
+			s.URLs = append(s.URLs, l.URL)
+		}
+		got = append(got, s)
+	}
+	want := []summary{
+		{
+			Operator: "Google",
+			Name:     "Google 'Argon' log",
+			URLs:     []string{"https://ct.googleapis.com/logs/argon2020/", "https://ct.googleapis.com/logs/argon2021/"},
+		},
+		{
+			Operator: "Let's Encrypt",
+			Name:     "Let's Encrypt 'Oak' log",
+			URLs:     []string{"https://oak.ct.letsencrypt.org/2020/", "https://oak.ct.letsencrypt.org/2021/"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("FamiliesFromLogList() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseFamilies(t *testing.T) {
+	logs := []*ctlog.Log{
+		{Name: "argon2020", URL: "https://ct.googleapis.com/logs/argon2020/"},
+		{Name: "argon2021", URL: "https://ct.googleapis.com/logs/argon2021/"},
+	}
+
+	tests := []struct {
+		desc     string
+		json     string
+		wantLogs [][]*ctlog.Log
+		wantErr  bool
+	}{
+		{
+			desc:     "by name and URL",
+			json:     `[{"operator": "Google", "name": "Argon", "logs": ["argon2020", "https://ct.googleapis.com/logs/argon2021"]}]`,
+			wantLogs: [][]*ctlog.Log{logs},
+		},
+		{
+			desc:    "unknown log",
+			json:    `[{"name": "Argon", "logs": ["argon2020", "argon2022"]}]`,
+			wantErr: true,
+		},
+		{
+			desc:    "one log",
+			json:    `[{"name": "Argon", "logs": ["argon2020"]}]`,
+			wantErr: true,
+		},
+		{
+			desc:    "no name",
+			json:    `[{"logs": ["argon2020", "argon2021"]}]`,
+			wantErr: true,
+		},
+		{
+			desc:    "unknown field",
+			json:    `[{"name": "Argon", "shards": ["argon2020", "argon2021"]}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			families, err := ParseFamilies(strings.NewReader(test.json), logs)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ParseFamilies() = _, %v; want error %t", err, test.wantErr)
+			}
+			var gotLogs [][]*ctlog.Log
+			for _, f := range families {
+				gotLogs = append(gotLogs, f.Logs)
+			}
+			if diff := cmp.Diff(test.wantLogs, gotLogs); diff != "" {
+				t.Errorf("ParseFamilies() Logs diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// familyRootsReader is a storage.RootsReader with a channel of root sets for
+// each Log.
+type familyRootsReader struct {
+	chans        map[string]chan storage.RootSetID
+	rootSetCerts map[storage.RootSetID][]*x509.Certificate
+}
+
+func (f *familyRootsReader) WatchRoots(ctx context.Context, l *ctlog.Log) (<-chan storage.RootSetID, error) {
+	return f.chans[l.URL], nil
+}
+
+func (f *familyRootsReader) ReadRoots(ctx context.Context, rootSet storage.RootSetID) ([]*x509.Certificate, error) {
+	return f.rootSetCerts[rootSet], nil
+}
+
+func TestRunFamily(t *testing.T) {
+	f := &Family{Operator: "Google", Name: "Argon"}
+	st := &familyRootsReader{
+		chans: make(map[string]chan storage.RootSetID),
+		rootSetCerts: map[storage.RootSetID][]*x509.Certificate{
+			"1":  {root1},
+			"12": {root1, root2},
+		},
+	}
+	for _, name := range []string{"argon2020", "argon2021", "argon2022"} {
+		l := &ctlog.Log{Name: name, URL: fmt.Sprintf("https://ct.googleapis.com/logs/%s/", name)}
+		f.Logs = append(f.Logs, l)
+		st.chans[l.URL] = make(chan storage.RootSetID, 1)
+	}
+	rep := &itestonly.FakeReporter{
+		Updates:     make(chan itestonly.Report, 1),
+		Resolutions: make(chan itestonly.Resolution, 1),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go RunFamily(ctx, st, rep, f)
+
+	observe := func(i int, id storage.RootSetID) {
+		st.chans[f.Logs[i].URL] <- id
+	}
+	expectNothing := func() {
+		t.Helper()
+		select {
+		case r := <-rep.Updates:
+			t.Errorf("Unexpected incident report %+v", r)
+		case r := <-rep.Resolutions:
+			t.Errorf("Unexpected resolution %+v", r)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	observe(0, "1")
+	observe(1, "1")
+	expectNothing()
+
+	// argon2022 has drifted from the other two shards.
+	observe(2, "12")
+	select {
+	case <-ctx.Done():
+		t.Fatal("No incident report received before context expired")
+	case r := <-rep.Updates:
+		want := itestonly.Report{
+			BaseURL: "https://ct.googleapis.com/logs/argon2022/",
+			Summary: ShardDriftSummary,
+			FullURL: "https://ct.googleapis.com/logs/argon2022/ct/v1/get-roots",
+			Details: `The root certificates accepted by argon2022 (https://ct.googleapis.com/logs/argon2022/) differ from those accepted by 2 other Google Argon shards (argon2020, argon2021).
+
+Certificates only accepted by argon2022 (1):
+CN=A-Trust-Qual-02,OU=A-Trust-Qual-02,O=A-Trust Ges. f. Sicherheitssysteme im elektr. Datenverkehr GmbH,C=AT (SHA256: 75C9D4361CB96E993ABD9620CF043BE9407A4633F202F0F4C0E17851CC6089CD)
+`,
+			Category: incident.ShardInconsistency,
+			Severity: incident.Warning,
+		}
+		if diff := cmp.Diff(want, r); diff != "" {
+			t.Errorf("Incident report diff (-want +got):\n%s", diff)
+		}
+	}
+
+	// Observing the same root sets again is not reported again.
+	observe(2, "12")
+	observe(0, "1")
+	expectNothing()
+
+	// Once argon2022 agrees with the others again, the incident is resolved.
+	observe(2, "1")
+	select {
+	case <-ctx.Done():
+		t.Fatal("No resolution received before context expired")
+	case r := <-rep.Resolutions:
+		if want := (itestonly.Resolution{BaseURL: "https://ct.googleapis.com/logs/argon2022/", Summary: ShardDriftSummary}); r != want {
+			t.Errorf("Resolution %+v, want %+v", r, want)
+		}
+	}
+	expectNothing()
+}
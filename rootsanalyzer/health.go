@@ -0,0 +1,268 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+)
+
+// RootHealthSummary is the summary of the incident reported when a Log accepts
+// root certificates with problems found by CheckRoots.  The incident is
+// resolved once it does not.
+const RootHealthSummary = "Root certificate problems"
+
+const (
+	// DefaultExpiryWarning is how long before they expire roots are
+	// flagged, if HealthOptions.ExpiryWarning is not set.
+	DefaultExpiryWarning = 90 * 24 * time.Hour
+	// DefaultMinRSABits is the smallest RSA key that is not flagged as
+	// weak, if HealthOptions.MinRSABits is not set.
+	DefaultMinRSABits = 2048
+	// DefaultMinECDSABits is the smallest ECDSA key that is not flagged as
+	// weak, if HealthOptions.MinECDSABits is not set.
+	DefaultMinECDSABits = 256
+)
+
+// HealthOptions configures the checks made by CheckRoots.
+type HealthOptions struct {
+	// ExpiryWarning is how long before they expire roots are flagged.
+	ExpiryWarning time.Duration
+	// MinRSABits and MinECDSABits are the smallest keys of each type that
+	// are not flagged as weak.
+	MinRSABits   int
+	MinECDSABits int
+}
+
+func (o HealthOptions) withDefaults() HealthOptions {
+	if o.ExpiryWarning <= 0 {
+		o.ExpiryWarning = DefaultExpiryWarning
+	}
+	if o.MinRSABits <= 0 {
+		o.MinRSABits = DefaultMinRSABits
+	}
+	if o.MinECDSABits <= 0 {
+		o.MinECDSABits = DefaultMinECDSABits
+	}
+	return o
+}
+
+// weakSignatureAlgorithms are signature algorithms whose hash functions are
+// no longer considered secure.
+var weakSignatureAlgorithms = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// problemKind classifies the problems found by CheckRoot, whose descriptions
+// may change from day to day, e.g. as a root approaches its expiry.
+type problemKind string
+
+const (
+	problemExpired       problemKind = "expired"
+	problemExpiring      problemKind = "expiring"
+	problemNotCA         problemKind = "not CA"
+	problemWeakSignature problemKind = "weak signature"
+	problemNotSelfSigned problemKind = "not self-signed"
+	problemBadSelfSig    problemKind = "bad self-signature"
+	problemWeakKey       problemKind = "weak key"
+)
+
+// Finding is the problems found with a root certificate.
+type Finding struct {
+	// CertID is the ID of the certificate, from GenerateCertID.
+	CertID   [32]byte
+	Cert     *x509.Certificate
+	Problems []string
+	// Expired is set if one of the problems is that the certificate has
+	// expired.
+	Expired bool
+	// kinds are the kinds of each of Problems.
+	kinds []problemKind
+}
+
+// CheckRoot returns the problems with cert as a root certificate at now: it
+// must not have expired or expire within opts.ExpiryWarning, must have the
+// BasicConstraints CA flag, must be self-signed, and must not have a weak key
+// or signature algorithm.
+func CheckRoot(cert *x509.Certificate, now time.Time, opts HealthOptions) (problems []string, expired bool) {
+	problems, kinds := checkRoot(cert, now, opts)
+	for _, k := range kinds {
+		if k == problemExpired {
+			expired = true
+		}
+	}
+	return problems, expired
+}
+
+// checkRoot returns the problems found by CheckRoot, and the kind of each.
+func checkRoot(cert *x509.Certificate, now time.Time, opts HealthOptions) (problems []string, kinds []problemKind) {
+	opts = opts.withDefaults()
+	add := func(k problemKind, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+		kinds = append(kinds, k)
+	}
+	const day = 24 * time.Hour
+	switch {
+	case !now.Before(cert.NotAfter):
+		add(problemExpired, "expired on %s", cert.NotAfter.UTC().Format("2006-01-02"))
+	case cert.NotAfter.Sub(now) < opts.ExpiryWarning:
+		add(problemExpiring, "expires on %s, in %d days", cert.NotAfter.UTC().Format("2006-01-02"), cert.NotAfter.Sub(now)/day)
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		add(problemNotCA, "does not have the BasicConstraints CA flag")
+	}
+
+	weakAlgorithm := weakSignatureAlgorithms[cert.SignatureAlgorithm]
+	if weakAlgorithm {
+		add(problemWeakSignature, "uses weak signature algorithm %s", cert.SignatureAlgorithm)
+	}
+	if string(cert.RawIssuer) != string(cert.RawSubject) {
+		add(problemNotSelfSigned, "is not self-signed: issued by %s", cert.Issuer)
+	} else if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		if _, insecure := err.(x509.InsecureAlgorithmError); !insecure || !weakAlgorithm {
+			add(problemBadSelfSig, "self-signature does not verify: %s", err)
+		}
+	}
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < opts.MinRSABits {
+			add(problemWeakKey, "has a weak %d-bit RSA key", bits)
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < opts.MinECDSABits {
+			add(problemWeakKey, "has a weak %d-bit ECDSA key", bits)
+		}
+	case ed25519.PublicKey:
+		// Ed25519 keys have a single size, which is not weak.
+	default:
+		add(problemWeakKey, "has a weak or unsupported %s key", cert.PublicKeyAlgorithm)
+	}
+	return problems, kinds
+}
+
+// CheckRoots returns the problems found by CheckRoot with each of the distinct
+// certificates in roots, sorted in the same way as the certificates in
+// incident reports.
+func CheckRoots(roots []*x509.Certificate, now time.Time, opts HealthOptions) ([]Finding, error) {
+	sorted := append([]*x509.Certificate(nil), roots...)
+	sortCerts(sorted)
+	var findings []Finding
+	seen := make(map[[32]byte]bool)
+	for _, cert := range sorted {
+		id, err := GenerateCertID(cert)
+		if err != nil {
+			return nil, err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if problems, kinds := checkRoot(cert, now, opts); len(problems) > 0 {
+			f := Finding{CertID: id, Cert: cert, Problems: problems, kinds: kinds}
+			for _, k := range kinds {
+				if k == problemExpired {
+					f.Expired = true
+				}
+			}
+			findings = append(findings, f)
+		}
+	}
+	return findings, nil
+}
+
+// healthKey identifies the certificates in findings and the kinds of problem
+// found with each, but not their descriptions, so that findings that differ
+// only in, say, the number of days until a root expires have the same key.
+func healthKey(findings []Finding) string {
+	var b strings.Builder
+	for _, f := range findings {
+		fmt.Fprintf(&b, "%X:", f.CertID)
+		for _, k := range f.kinds {
+			fmt.Fprintf(&b, " %s;", k)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+var healthTemplate = template.Must(template.New("health_incident").Parse(`{{ len .Findings }} of the root certificates accepted by {{ .Log.Name }} ({{ .Log.URL }}) in root set {{ printf "%X" .RootSetID }} have problems.
+{{ range .Findings }}
+{{ .Cert.Subject }} (SHA256: {{ printf "%X" .CertID }}):
+{{ range .Problems }}  - {{ . }}
+{{ end }}{{ end }}`))
+
+type healthTemplateArgs struct {
+	Log       *ctlog.Log
+	RootSetID storage.RootSetID
+	Findings  []Finding
+}
+
+// ReportHealth reports an incident, linked to rootSetID, if any of roots have
+// problems found by CheckRoots at now, and otherwise declares any such
+// incident resolved.  The incident is Critical if any of the roots have
+// expired.
+func ReportHealth(ctx context.Context, rep incident.Reporter, l *ctlog.Log, rootSetID storage.RootSetID, roots []*x509.Certificate, now time.Time, opts HealthOptions) error {
+	findings, err := CheckRoots(roots, now, opts)
+	if err != nil {
+		return err
+	}
+	return reportFindings(ctx, rep, l, rootSetID, findings)
+}
+
+func reportFindings(ctx context.Context, rep incident.Reporter, l *ctlog.Log, rootSetID storage.RootSetID, findings []Finding) error {
+	if len(findings) == 0 {
+		incident.LogResolved(ctx, rep, l.URL, RootHealthSummary)
+		return nil
+	}
+
+	var strBuilder strings.Builder
+	if err := healthTemplate.Execute(&strBuilder, healthTemplateArgs{Log: l, RootSetID: rootSetID, Findings: findings}); err != nil {
+		return err
+	}
+	severity := incident.Warning
+	for _, f := range findings {
+		if f.Expired {
+			severity = incident.Critical
+		}
+	}
+	incident.Report(ctx, rep, &incident.Incident{
+		BaseURL:  l.URL,
+		Summary:  RootHealthSummary,
+		FullURL:  getRootsURL(l),
+		Details:  strBuilder.String(),
+		Category: incident.RootHealth,
+		Severity: severity,
+		LogID:    l.LogID,
+		Evidence: rootsEvidence(rootSetID),
+	})
+	return nil
+}
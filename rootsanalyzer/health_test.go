@@ -0,0 +1,326 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509/pkix"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+
+	itestonly "github.com/google/monologue/incident/testonly"
+	stestonly "github.com/google/monologue/storage/testonly"
+)
+
+var healthNow = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+// certOpts describes a certificate for mustCreateCert to create.
+type certOpts struct {
+	notAfter time.Time
+	notCA    bool
+	// issuer, if set, issues the certificate instead of it being
+	// self-signed.
+	issuer *x509.Certificate
+	key    crypto.Signer
+	sigAlg x509.SignatureAlgorithm
+}
+
+var (
+	testIssuerKey = mustGenerateECDSAKey(elliptic.P256())
+	testIssuer    = &x509.Certificate{
+		SerialNumber:          big.NewInt(99),
+		Subject:               pkix.Name{CommonName: "Test Issuer"},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+)
+
+func mustGenerateECDSAKey(c elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(c, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func mustCreateCert(t *testing.T, o certOpts) *x509.Certificate {
+	t.Helper()
+	if o.notAfter.IsZero() {
+		o.notAfter = healthNow.Add(10 * 365 * 24 * time.Hour)
+	}
+	if o.key == nil {
+		o.key = mustGenerateECDSAKey(elliptic.P256())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             healthNow.Add(-365 * 24 * time.Hour),
+		NotAfter:              o.notAfter,
+		BasicConstraintsValid: !o.notCA,
+		IsCA:                  !o.notCA,
+		SignatureAlgorithm:    o.sigAlg,
+	}
+	parent, signer := tmpl, o.key
+	if o.issuer != nil {
+		parent, signer = o.issuer, testIssuerKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, o.key.Public(), signer)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() = _, %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate() = _, %v", err)
+	}
+	return cert
+}
+
+func TestCheckRoot(t *testing.T) {
+	weakRSAKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = _, %v", err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = _, %v", err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey() = _, %v", err)
+	}
+
+	tests := []struct {
+		desc         string
+		cert         *x509.Certificate
+		opts         HealthOptions
+		wantProblems []string
+		wantExpired  bool
+	}{
+		{
+			desc: "healthy",
+			cert: mustCreateCert(t, certOpts{}),
+		},
+		{
+			desc:         "expired",
+			cert:         mustCreateCert(t, certOpts{notAfter: healthNow.Add(-time.Hour)}),
+			wantProblems: []string{"expired on 2020-05-31"},
+			wantExpired:  true,
+		},
+		{
+			desc:         "expiring",
+			cert:         mustCreateCert(t, certOpts{notAfter: healthNow.Add(30*24*time.Hour + time.Hour)}),
+			wantProblems: []string{"expires on 2020-07-01, in 30 days"},
+		},
+		{
+			desc: "expiring outside warning",
+			cert: mustCreateCert(t, certOpts{notAfter: healthNow.Add(30*24*time.Hour + time.Hour)}),
+			opts: HealthOptions{ExpiryWarning: 7 * 24 * time.Hour},
+		},
+		{
+			desc:         "not CA",
+			cert:         mustCreateCert(t, certOpts{notCA: true}),
+			wantProblems: []string{"does not have the BasicConstraints CA flag"},
+		},
+		{
+			desc:         "not self-signed",
+			cert:         mustCreateCert(t, certOpts{issuer: testIssuer}),
+			wantProblems: []string{"is not self-signed: issued by CN=Test Issuer"},
+		},
+		{
+			desc:         "weak RSA key",
+			cert:         mustCreateCert(t, certOpts{key: weakRSAKey}),
+			wantProblems: []string{"has a weak 1024-bit RSA key"},
+		},
+		{
+			desc:         "weak ECDSA key",
+			cert:         mustCreateCert(t, certOpts{key: mustGenerateECDSAKey(elliptic.P224())}),
+			wantProblems: []string{"has a weak 224-bit ECDSA key"},
+		},
+		{
+			desc: "Ed25519 key",
+			cert: mustCreateCert(t, certOpts{key: ed25519Key}),
+		},
+		{
+			desc:         "weak signature algorithm",
+			cert:         mustCreateCert(t, certOpts{key: rsaKey, sigAlg: x509.SHA1WithRSA}),
+			wantProblems: []string{"uses weak signature algorithm SHA1-RSA"},
+		},
+		{
+			desc: "expired, SHA-1",
+			cert: root2,
+			wantProblems: []string{
+				"expired on 2014-12-02",
+				"uses weak signature algorithm SHA1-RSA",
+			},
+			wantExpired: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			problems, expired := CheckRoot(test.cert, healthNow, test.opts)
+			if diff := cmp.Diff(test.wantProblems, problems); diff != "" {
+				t.Errorf("CheckRoot() problems diff (-want +got):\n%s", diff)
+			}
+			if expired != test.wantExpired {
+				t.Errorf("CheckRoot() expired = %t, want %t", expired, test.wantExpired)
+			}
+		})
+	}
+}
+
+func TestHealthKey(t *testing.T) {
+	expiring := mustCreateCert(t, certOpts{notAfter: healthNow.Add(30 * 24 * time.Hour)})
+	notCA := mustCreateCert(t, certOpts{notCA: true})
+	key := func(now time.Time, roots ...*x509.Certificate) string {
+		t.Helper()
+		findings, err := CheckRoots(roots, now, HealthOptions{})
+		if err != nil {
+			t.Fatalf("CheckRoots() = _, %v", err)
+		}
+		return healthKey(findings)
+	}
+
+	today := key(healthNow, expiring, notCA)
+	if got := key(healthNow.Add(24*time.Hour), expiring, notCA); got != today {
+		t.Errorf("healthKey() changed a day later, from %q to %q", today, got)
+	}
+	if got := key(healthNow.Add(31*24*time.Hour), expiring, notCA); got == today {
+		t.Errorf("healthKey() = %q once a root has expired, want it to change", got)
+	}
+	if got := key(healthNow, expiring); got == today {
+		t.Errorf("healthKey() = %q without a root with problems, want it to change", got)
+	}
+}
+
+// incidentRecorder records the incidents reported to it.
+type incidentRecorder struct {
+	incident.LoggingReporter
+	incidents chan *incident.Incident
+	resolved  chan string
+}
+
+func (r *incidentRecorder) Report(ctx context.Context, inc *incident.Incident) {
+	r.incidents <- inc
+}
+
+func (r *incidentRecorder) LogResolved(ctx context.Context, baseURL, summary string) {
+	r.resolved <- summary
+}
+
+func TestReportHealth(t *testing.T) {
+	ctx := context.Background()
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	healthy := mustCreateCert(t, certOpts{})
+	notCA := mustCreateCert(t, certOpts{notCA: true})
+	notCAID, err := GenerateCertID(notCA)
+	if err != nil {
+		t.Fatalf("GenerateCertID() = _, %v", err)
+	}
+	rep := &incidentRecorder{incidents: make(chan *incident.Incident, 1), resolved: make(chan string, 1)}
+
+	if err := ReportHealth(ctx, rep, l, "\x01\x02", []*x509.Certificate{healthy, root2, notCA, root2}, healthNow, HealthOptions{}); err != nil {
+		t.Fatalf("ReportHealth() = %v", err)
+	}
+	got := <-rep.incidents
+	if got.Summary != RootHealthSummary || got.Category != incident.RootHealth || got.Severity != incident.Critical {
+		t.Errorf("ReportHealth() reported %q, %q, %q; want %q, %q, %q", got.Summary, got.Category, got.Severity, RootHealthSummary, incident.RootHealth, incident.Critical)
+	}
+	if want := []incident.EvidenceRef{{Kind: "roots", ID: "0102"}}; !cmp.Equal(got.Evidence, want) {
+		t.Errorf("ReportHealth() evidence = %v, want %v", got.Evidence, want)
+	}
+	for _, s := range []string{
+		"2 of the root certificates accepted by testtube (https://ct.googleapis.com/testtube/) in root set 0102 have problems.\n",
+		"\nCN=A-Trust-Qual-02,OU=A-Trust-Qual-02,O=A-Trust Ges. f. Sicherheitssysteme im elektr. Datenverkehr GmbH,C=AT (SHA256: 75C9D4361CB96E993ABD9620CF043BE9407A4633F202F0F4C0E17851CC6089CD):\n  - expired on 2014-12-02\n",
+		fmt.Sprintf("\nCN=Test Root (SHA256: %X):\n", notCAID) + "  - does not have the BasicConstraints CA flag\n",
+	} {
+		if !strings.Contains(got.Details, s) {
+			t.Errorf("ReportHealth() details %q; want to contain %q", got.Details, s)
+		}
+	}
+
+	if err := ReportHealth(ctx, rep, l, "\x03", []*x509.Certificate{healthy}, healthNow, HealthOptions{}); err != nil {
+		t.Fatalf("ReportHealth() = %v", err)
+	}
+	if got := <-rep.resolved; got != RootHealthSummary {
+		t.Errorf("ReportHealth() resolved %q, want %q", got, RootHealthSummary)
+	}
+}
+
+func TestRunChecksHealth(t *testing.T) {
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	ids := []storage.RootSetID{"1", "2", "1", "2", "2", "2"}
+	fakeStorage := &stestonly.FakeRootsReader{
+		RootSetChan: make(chan storage.RootSetID, len(ids)),
+		RootSetCerts: map[storage.RootSetID][]*x509.Certificate{
+			"1": {root1},
+			"2": {root1, root2},
+		},
+	}
+	fakeReporter := &itestonly.FakeReporter{
+		Updates:     make(chan itestonly.Report, 10),
+		Violations:  make(chan itestonly.Report, 10),
+		Resolutions: make(chan itestonly.Resolution, 10),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Root set 2 is only reported once it has been observed twice in a row,
+	// so its health is not checked while it is pending or has been flapped
+	// away from.
+	go Run(ctx, fakeStorage, fakeReporter, l, Options{HoldObservations: 2, FlapThreshold: 10, Health: &HealthOptions{}})
+	for _, id := range ids {
+		fakeStorage.RootSetChan <- id
+	}
+
+	// root1 uses SHA-1, and root2 has also expired.  Observing root set 2
+	// again does not report the same problems again.
+	var got []incident.Severity
+	for {
+		select {
+		case r := <-fakeReporter.Updates:
+			if r.Summary == RootHealthSummary {
+				got = append(got, r.Severity)
+			}
+			continue
+		case <-time.After(500 * time.Millisecond):
+		}
+		break
+	}
+	if diff := cmp.Diff([]incident.Severity{incident.Warning, incident.Critical}, got); diff != "" {
+		t.Errorf("Root health report severities diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,393 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+)
+
+const (
+	// OutsideProgramsSummary is the summary of the incident reported when a
+	// Log accepts roots that are not included in any reference root program.
+	OutsideProgramsSummary = "Roots outside root programs"
+	// MissingRootsSummary is the summary of the incident reported when a Log
+	// does not accept roots that every reference root program includes.
+	MissingRootsSummary = "Roots required by root programs missing"
+)
+
+// Status is the status of a root certificate in the reference root programs.
+type Status int
+
+const (
+	// Unknown roots are not known to any root program.
+	Unknown Status = iota
+	// InProgram roots are included in at least one root program.
+	InProgram
+	// RemovedFromProgram roots are not included in any root program, but
+	// have been removed from at least one.
+	RemovedFromProgram
+)
+
+func (s Status) String() string {
+	switch s {
+	case InProgram:
+		return "in-program"
+	case RemovedFromProgram:
+		return "removed-from-program"
+	default:
+		return "unknown"
+	}
+}
+
+// Classification is the status of a root certificate across the reference
+// root programs.
+type Classification struct {
+	Status Status
+	// Programs are the names of the programs that include the root or, if
+	// Status is RemovedFromProgram, that have removed it.
+	Programs []string
+}
+
+func (c Classification) String() string {
+	if len(c.Programs) == 0 {
+		return c.Status.String()
+	}
+	return fmt.Sprintf("%s: %s", c.Status, strings.Join(c.Programs, ", "))
+}
+
+// Fingerprint is the SHA-256 hash of the DER encoding of a certificate.
+type Fingerprint [sha256.Size]byte
+
+func (f Fingerprint) String() string {
+	return fmt.Sprintf("%X", f[:])
+}
+
+// Program is the set of root certificates in a reference root program, such as
+// a browser or operating system trust store.
+type Program struct {
+	Name string
+	// Included maps the fingerprints of the roots included in the program to
+	// their names.
+	Included map[Fingerprint]string
+	// Removed maps the fingerprints of roots that were removed from the
+	// program to their names.
+	Removed map[Fingerprint]string
+}
+
+// ParsePEMProgram returns a Program called name that includes each of the
+// certificates in the PEM bundle read from r.
+func ParsePEMProgram(name string, r io.Reader) (*Program, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{Name: name, Included: make(map[Fingerprint]string), Removed: make(map[Fingerprint]string)}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if x509.IsFatal(err) {
+			return nil, fmt.Errorf("%s: invalid certificate: %s", name, err)
+		}
+		p.Included[sha256.Sum256(block.Bytes)] = cert.Subject.String()
+	}
+	if len(p.Included) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", name)
+	}
+	return p, nil
+}
+
+// ParseCCADBProgram returns a Program called name from the CSV read from r, in
+// the style of the reports exported by the Common CA Database (CCADB).
+//
+// The CSV must have a header row, and a "SHA-256 Fingerprint" column.  If
+// statusColumn is set, only rows whose value in that column is "Included" or
+// "Removed" are used, and they are included in or removed from the program
+// respectively; otherwise every row is included.  Roots are named after the
+// "Certificate Name" or "Common Name or Certificate Name" column, if there is
+// one.
+func ParseCCADBProgram(name string, r io.Reader, statusColumn string) (*Program, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read CSV header: %s", name, err)
+	}
+	column := func(names ...string) int {
+		for i, h := range header {
+			for _, n := range names {
+				if strings.EqualFold(strings.TrimSpace(h), n) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	fpCol := column("SHA-256 Fingerprint")
+	if fpCol < 0 {
+		return nil, fmt.Errorf("%s: no SHA-256 Fingerprint column", name)
+	}
+	nameCol := column("Certificate Name", "Common Name or Certificate Name")
+	statusCol := -1
+	if statusColumn != "" {
+		if statusCol = column(statusColumn); statusCol < 0 {
+			return nil, fmt.Errorf("%s: no %s column", name, statusColumn)
+		}
+	}
+
+	p := &Program{Name: name, Included: make(map[Fingerprint]string), Removed: make(map[Fingerprint]string)}
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		field := func(i int) string {
+			if i < 0 || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+		fp, err := parseFingerprint(field(fpCol))
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s", name, line, err)
+		}
+		rootName := field(nameCol)
+		if rootName == "" {
+			rootName = fp.String()
+		}
+		switch status := field(statusCol); {
+		case statusCol < 0 || strings.EqualFold(status, "Included"):
+			p.Included[fp] = rootName
+		case strings.EqualFold(status, "Removed"):
+			p.Removed[fp] = rootName
+		}
+	}
+	return p, nil
+}
+
+func parseFingerprint(s string) (Fingerprint, error) {
+	var fp Fingerprint
+	b, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil || len(b) != len(fp) {
+		return fp, fmt.Errorf("invalid SHA-256 fingerprint %q", s)
+	}
+	copy(fp[:], b)
+	return fp, nil
+}
+
+// LoadPEMProgram returns a Program called name that includes each of the
+// certificates in the PEM bundle at path.
+func LoadPEMProgram(name, path string) (*Program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParsePEMProgram(name, f)
+}
+
+// LoadCCADBProgram returns a Program called name from the CCADB-style CSV file
+// at path, as described for ParseCCADBProgram.
+func LoadCCADBProgram(name, path, statusColumn string) (*Program, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCCADBProgram(name, f, statusColumn)
+}
+
+// Programs are the reference root programs that a Log's roots are compared
+// against.
+type Programs []*Program
+
+// Classify returns the status of cert across the programs.
+func (ps Programs) Classify(cert *x509.Certificate) Classification {
+	fp := Fingerprint(sha256.Sum256(cert.Raw))
+	var included, removed []string
+	for _, p := range ps {
+		if _, ok := p.Included[fp]; ok {
+			included = append(included, p.Name)
+		} else if _, ok := p.Removed[fp]; ok {
+			removed = append(removed, p.Name)
+		}
+	}
+	switch {
+	case len(included) > 0:
+		return Classification{Status: InProgram, Programs: included}
+	case len(removed) > 0:
+		return Classification{Status: RemovedFromProgram, Programs: removed}
+	default:
+		return Classification{Status: Unknown}
+	}
+}
+
+// Required returns the roots that every program includes, mapped to their
+// names.
+func (ps Programs) Required() map[Fingerprint]string {
+	if len(ps) == 0 {
+		return nil
+	}
+	required := make(map[Fingerprint]string)
+	for fp, name := range ps[0].Included {
+		required[fp] = name
+	}
+	for _, p := range ps[1:] {
+		for fp := range required {
+			if _, ok := p.Included[fp]; !ok {
+				delete(required, fp)
+			}
+		}
+	}
+	return required
+}
+
+var (
+	outsideProgramsTemplate = template.Must(template.New("outside_programs_incident").Funcs(template.FuncMap{
+		"sha256": sha256.Sum256,
+	}).Parse(`{{ .Log.Name }} ({{ .Log.URL }}) accepts {{ len .Certs }} root certificates that are not included in any of the root programs {{ .Programs }}.
+{{ range $i, $cert := .Certs }}
+{{ $cert.Subject }} (SHA256: {{ sha256 $cert.Raw | printf "%X" }}) [{{ index $.Classifications $i }}]{{ end }}
+`))
+
+	missingRootsTemplate = template.Must(template.New("missing_roots_incident").Parse(`{{ .Log.Name }} ({{ .Log.URL }}) does not accept {{ len .Missing }} root certificates that every one of the root programs {{ .Programs }} includes.
+{{ range .Missing }}
+{{ .Name }} (SHA256: {{ .Fingerprint }}){{ end }}
+`))
+)
+
+type outsideProgramsTemplateArgs struct {
+	Log             *ctlog.Log
+	Programs        string
+	Certs           []*x509.Certificate
+	Classifications []Classification
+}
+
+type missingRoot struct {
+	Name        string
+	Fingerprint Fingerprint
+}
+
+type missingRootsTemplateArgs struct {
+	Log      *ctlog.Log
+	Programs string
+	Missing  []missingRoot
+}
+
+// names returns the names of the programs, for use in incident reports.
+func (ps Programs) names() string {
+	var names []string
+	for _, p := range ps {
+		names = append(names, p.Name)
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// ReportConformance reports incidents if l accepts roots that are not included
+// in any of ps, or does not accept roots that all of ps include, and otherwise
+// declares any such incidents resolved.  It does nothing if ps is empty.
+func ReportConformance(ctx context.Context, rep incident.Reporter, l *ctlog.Log, ps Programs, roots []*x509.Certificate) error {
+	if len(ps) == 0 {
+		return nil
+	}
+
+	outside := outsideProgramsTemplateArgs{Log: l, Programs: ps.names()}
+	accepted := make(map[Fingerprint]bool)
+	sorted := append([]*x509.Certificate(nil), roots...)
+	sortCerts(sorted)
+	for _, cert := range sorted {
+		fp := Fingerprint(sha256.Sum256(cert.Raw))
+		if accepted[fp] {
+			continue
+		}
+		accepted[fp] = true
+		if c := ps.Classify(cert); c.Status != InProgram {
+			outside.Certs = append(outside.Certs, cert)
+			outside.Classifications = append(outside.Classifications, c)
+		}
+	}
+	if len(outside.Certs) > 0 {
+		var strBuilder strings.Builder
+		if err := outsideProgramsTemplate.Execute(&strBuilder, outside); err != nil {
+			return err
+		}
+		incident.Report(ctx, rep, &incident.Incident{
+			BaseURL:  l.URL,
+			Summary:  OutsideProgramsSummary,
+			FullURL:  getRootsURL(l),
+			Details:  strBuilder.String(),
+			Category: incident.RootProgram,
+			Severity: incident.Warning,
+			LogID:    l.LogID,
+		})
+	} else {
+		incident.LogResolved(ctx, rep, l.URL, OutsideProgramsSummary)
+	}
+
+	missing := missingRootsTemplateArgs{Log: l, Programs: ps.names()}
+	for fp, name := range ps.Required() {
+		if !accepted[fp] {
+			missing.Missing = append(missing.Missing, missingRoot{Name: name, Fingerprint: fp})
+		}
+	}
+	if len(missing.Missing) > 0 {
+		sort.Slice(missing.Missing, func(i, j int) bool {
+			if a, b := missing.Missing[i], missing.Missing[j]; a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return missing.Missing[i].Fingerprint.String() < missing.Missing[j].Fingerprint.String()
+		})
+		var strBuilder strings.Builder
+		if err := missingRootsTemplate.Execute(&strBuilder, missing); err != nil {
+			return err
+		}
+		incident.Report(ctx, rep, &incident.Incident{
+			BaseURL:  l.URL,
+			Summary:  MissingRootsSummary,
+			FullURL:  getRootsURL(l),
+			Details:  strBuilder.String(),
+			Category: incident.RootProgram,
+			Severity: incident.Warning,
+			LogID:    l.LogID,
+		})
+	} else {
+		incident.LogResolved(ctx, rep, l.URL, MissingRootsSummary)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+
+	itestonly "github.com/google/monologue/incident/testonly"
+	stestonly "github.com/google/monologue/storage/testonly"
+)
+
+func pemBundle(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, cert := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return b.String()
+}
+
+func fingerprint(cert *x509.Certificate) Fingerprint {
+	return sha256.Sum256(cert.Raw)
+}
+
+func TestParsePEMProgram(t *testing.T) {
+	p, err := ParsePEMProgram("Test", strings.NewReader("Comments are ignored.\n"+pemBundle(root1, root2)))
+	if err != nil {
+		t.Fatalf("ParsePEMProgram() = _, %v", err)
+	}
+	want := &Program{
+		Name: "Test",
+		Included: map[Fingerprint]string{
+			fingerprint(root1): root1.Subject.String(),
+			fingerprint(root2): root2.Subject.String(),
+		},
+		Removed: map[Fingerprint]string{},
+	}
+	if diff := cmp.Diff(want, p); diff != "" {
+		t.Errorf("ParsePEMProgram() diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := ParsePEMProgram("Empty", strings.NewReader("")); err == nil {
+		t.Error("ParsePEMProgram(empty) = _, nil; want error")
+	}
+}
+
+func TestParseCCADBProgram(t *testing.T) {
+	// CCADB reports use uppercase hex, but colon-separated fingerprints are
+	// accepted too.
+	fp2 := fingerprint(root2)
+	csv := fmt.Sprintf(`"Owner","Certificate Name","SHA-256 Fingerprint","Mozilla Status"
+"Owner 1","Root One","%s","Included"
+"Owner 2","Root Two","%s","Removed"
+"Owner 3","Root Three","%s","Pending"
+`, fingerprint(root1), strings.Replace(fmt.Sprintf("% X", fp2[:]), " ", ":", -1), fingerprint(root2))
+
+	tests := []struct {
+		desc         string
+		csv          string
+		statusColumn string
+		want         *Program
+		wantErr      bool
+	}{
+		{
+			desc:         "status column",
+			csv:          csv,
+			statusColumn: "Mozilla Status",
+			want: &Program{
+				Name:     "Test",
+				Included: map[Fingerprint]string{fingerprint(root1): "Root One"},
+				Removed:  map[Fingerprint]string{fingerprint(root2): "Root Two"},
+			},
+		},
+		{
+			desc: "no status column",
+			csv:  csv,
+			want: &Program{
+				Name: "Test",
+				Included: map[Fingerprint]string{
+					fingerprint(root1): "Root One",
+					fingerprint(root2): "Root Three",
+				},
+				Removed: map[Fingerprint]string{},
+			},
+		},
+		{
+			desc:         "missing status column",
+			csv:          csv,
+			statusColumn: "Apple Status",
+			wantErr:      true,
+		},
+		{
+			desc:    "missing fingerprint column",
+			csv:     "Owner,Certificate Name\nOwner 1,Root One\n",
+			wantErr: true,
+		},
+		{
+			desc:    "invalid fingerprint",
+			csv:     "SHA-256 Fingerprint\nABCD\n",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			p, err := ParseCCADBProgram("Test", strings.NewReader(test.csv), test.statusColumn)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("ParseCCADBProgram() = _, %v; want error %t", err, test.wantErr)
+			}
+			if diff := cmp.Diff(test.want, p); diff != "" {
+				t.Errorf("ParseCCADBProgram() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	other, _ := mustReencodedRoots(t)
+	ps := Programs{
+		{Name: "A", Included: map[Fingerprint]string{fingerprint(root1): "1"}, Removed: map[Fingerprint]string{fingerprint(root2): "2"}},
+		{Name: "B", Included: map[Fingerprint]string{fingerprint(root1): "1"}, Removed: map[Fingerprint]string{fingerprint(root2): "2"}},
+		{Name: "C", Included: map[Fingerprint]string{}, Removed: map[Fingerprint]string{fingerprint(root1): "1"}},
+	}
+
+	for _, test := range []struct {
+		cert *x509.Certificate
+		want Classification
+	}{
+		{cert: root1, want: Classification{Status: InProgram, Programs: []string{"A", "B"}}},
+		{cert: root2, want: Classification{Status: RemovedFromProgram, Programs: []string{"A", "B"}}},
+		{cert: other, want: Classification{Status: Unknown}},
+	} {
+		if diff := cmp.Diff(test.want, ps.Classify(test.cert)); diff != "" {
+			t.Errorf("Classify(%s) diff (-want +got):\n%s", test.cert.Subject, diff)
+		}
+	}
+}
+
+func TestReportConformance(t *testing.T) {
+	ctx := context.Background()
+	other, _ := mustReencodedRoots(t)
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	ps := Programs{
+		{Name: "A", Included: map[Fingerprint]string{fingerprint(root1): "Root One"}, Removed: map[Fingerprint]string{fingerprint(root2): "Root Two"}},
+		{Name: "B", Included: map[Fingerprint]string{fingerprint(root1): "Root One"}},
+	}
+
+	rep := &itestonly.FakeReporter{
+		Updates:     make(chan itestonly.Report, 2),
+		Resolutions: make(chan itestonly.Resolution, 2),
+	}
+	if err := ReportConformance(ctx, rep, l, ps, []*x509.Certificate{root2, other}); err != nil {
+		t.Fatalf("ReportConformance() = %v", err)
+	}
+	close(rep.Updates)
+	var got []itestonly.Report
+	for r := range rep.Updates {
+		got = append(got, r)
+	}
+	getRoots := "https://ct.googleapis.com/testtube/ct/v1/get-roots"
+	want := []itestonly.Report{
+		{
+			BaseURL: l.URL,
+			Summary: OutsideProgramsSummary,
+			FullURL: getRoots,
+			Details: fmt.Sprintf(`testtube (https://ct.googleapis.com/testtube/) accepts 2 root certificates that are not included in any of the root programs (A, B).
+
+%s (SHA256: %s) [removed-from-program: A]
+CN=Reencoded Root (SHA256: %s) [unknown]
+`, root2.Subject, fingerprint(root2), fingerprint(other)),
+			Category: incident.RootProgram,
+			Severity: incident.Warning,
+		},
+		{
+			BaseURL: l.URL,
+			Summary: MissingRootsSummary,
+			FullURL: getRoots,
+			Details: fmt.Sprintf(`testtube (https://ct.googleapis.com/testtube/) does not accept 1 root certificates that every one of the root programs (A, B) includes.
+
+Root One (SHA256: %s)
+`, fingerprint(root1)),
+			Category: incident.RootProgram,
+			Severity: incident.Warning,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReportConformance() reports diff (-want +got):\n%s", diff)
+	}
+
+	if err := ReportConformance(ctx, rep, l, ps, []*x509.Certificate{root1}); err != nil {
+		t.Fatalf("ReportConformance() = %v", err)
+	}
+	close(rep.Resolutions)
+	var gotResolved []itestonly.Resolution
+	for r := range rep.Resolutions {
+		gotResolved = append(gotResolved, r)
+	}
+	wantResolved := []itestonly.Resolution{
+		{BaseURL: l.URL, Summary: OutsideProgramsSummary},
+		{BaseURL: l.URL, Summary: MissingRootsSummary},
+	}
+	if diff := cmp.Diff(wantResolved, gotResolved); diff != "" {
+		t.Errorf("ReportConformance() resolutions diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRootsChangedWithPrograms(t *testing.T) {
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	ps := Programs{
+		{Name: "A", Included: map[Fingerprint]string{fingerprint(root1): "Root One", fingerprint(root2): "Root Two"}},
+	}
+	fakeStorage := &stestonly.FakeRootsReader{
+		RootSetChan: make(chan storage.RootSetID, 2),
+		RootSetCerts: map[storage.RootSetID][]*x509.Certificate{
+			"1": {root1},
+			"2": {root1, root2},
+		},
+	}
+	fakeReporter := &itestonly.FakeReporter{
+		Updates:     make(chan itestonly.Report, 4),
+		Resolutions: make(chan itestonly.Resolution, 4),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	go Run(ctx, fakeStorage, fakeReporter, l, Options{Programs: ps})
+	fakeStorage.RootSetChan <- "1"
+	fakeStorage.RootSetChan <- "2"
+
+	// The first root set lacks Root Two, which every program includes.
+	for _, wantSummary := range []string{MissingRootsSummary, changeSummary} {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("No %q incident report received before context expired", wantSummary)
+		case report := <-fakeReporter.Updates:
+			if report.Summary != wantSummary {
+				t.Fatalf("Incident report %q; want %q", report.Summary, wantSummary)
+			}
+			if report.Summary != changeSummary {
+				continue
+			}
+			want := fmt.Sprintf("%s (SHA256: %s) [in-program: A]\n", root2.Subject, fingerprint(root2))
+			if !strings.Contains(report.Details, want) {
+				t.Errorf("Incident report details %q; want to contain %q", report.Details, want)
+			}
+		}
+	}
+}
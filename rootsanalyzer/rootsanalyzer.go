@@ -20,11 +20,13 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"fmt"
 	"net/url"
 	"path"
 	"sort"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/golang/glog"
 	ct "github.com/google/certificate-transparency-go"
@@ -42,10 +44,17 @@ var (
 	}).Parse(`The root certificates accepted by {{ .Log.Name }} ({{ .Log.URL }}) have changed.
 {{ if gt (len .AddedCerts) 0 }}
 Certificates added ({{ len .AddedCerts }}):
-{{ range .AddedCerts }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }})
+{{ range .AddedCerts }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }}){{ $.Context . }}
 {{ end }}{{ end }}{{ if gt (len .RemovedCerts) 0 }}
 Certificates removed ({{ len .RemovedCerts }}):
-{{ range .RemovedCerts }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }})
+{{ range .RemovedCerts }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }}){{ $.Context . }}
+{{ end }}{{ end }}`))
+	flapTemplate = template.Must(template.New("flap_incident").Funcs(template.FuncMap{
+		"sha256": sha256.Sum256,
+	}).Parse(`{{ .Log.Name }} ({{ .Log.URL }}) has switched back and forth between two sets of root certificates {{ .Flaps }} times. Its frontends may be returning inconsistent root sets.
+{{ range .Sets }}
+Root set {{ printf "%X" .ID }} ({{ len .Certs }} certificates):
+{{ range .Certs }}{{ .Subject }} (SHA256: {{ sha256 .Raw | printf "%X" }})
 {{ end }}{{ end }}`))
 )
 
@@ -53,72 +62,287 @@ type incidentTemplateArgs struct {
 	Log          *ctlog.Log
 	AddedCerts   []*x509.Certificate
 	RemovedCerts []*x509.Certificate
+	Programs     Programs
+}
+
+// Context returns the classification of cert in the reference root programs,
+// for inclusion in the report, or nothing if there are none.
+func (a incidentTemplateArgs) Context(cert *x509.Certificate) string {
+	if len(a.Programs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" [%s]", a.Programs.Classify(cert))
+}
+
+type flapTemplateArgs struct {
+	Log   *ctlog.Log
+	Flaps int
+	Sets  []flapTemplateSet
+}
+
+type flapTemplateSet struct {
+	ID    storage.RootSetID
+	Certs []*x509.Certificate
+}
+
+const (
+	changeSummary = "Root certificates changed"
+	flapSummary   = "Inconsistent root certificates between frontends"
+)
+
+// DefaultFlapThreshold is the number of times that the root set must flap
+// back to its previous value before an "inconsistent frontends" incident is
+// reported, if Options.FlapThreshold is not set.
+const DefaultFlapThreshold = 2
+
+// Options configures the hold-down that a Roots Analyzer applies to changes in
+// a Log's root set, so that transient changes caused by skew between the Log's
+// frontends are not reported as changes.
+//
+// A change to a new root set is reported once the new root set has been
+// observed HoldObservations times in a row, or has persisted for HoldDuration,
+// whichever comes first.  If neither is set, changes are reported as soon as
+// they are observed.
+//
+// HoldDuration is timed from when the Roots Analyzer receives the first
+// observation of the new root set, not from when the observation was made,
+// because storage.RootsReader.WatchRoots passes on only the IDs of the root
+// sets observed.  Observations that are received together, e.g. when stored
+// observations are caught up on at startup or after storage was unavailable,
+// are therefore counted towards HoldObservations but take no time.
+type Options struct {
+	HoldObservations int
+	HoldDuration     time.Duration
+	// FlapThreshold is the number of times that the root set must change
+	// and then flap back to the previously reported root set, before the
+	// change is reported, for a single "inconsistent frontends" incident
+	// listing both root sets to be reported.  If 0, DefaultFlapThreshold is
+	// used.
+	FlapThreshold int
+	// Programs are the reference root programs that the Log's root set is
+	// compared against, when it is first observed and whenever a change to it
+	// is reported.  Reports of changes also classify each root that was added
+	// or removed.
+	Programs Programs
+	// Health, if set, configures the checks made on the contents of the
+	// reported root set: the first one observed, then each one that a
+	// change is reported to, rechecked whenever it is observed again.  If
+	// nil, root sets are not checked.
+	Health *HealthOptions
+}
+
+// analyzer holds the state of a Roots Analyzer for a single Log.
+type analyzer struct {
+	st   storage.RootsReader
+	rep  incident.Reporter
+	l    *ctlog.Log
+	opts Options
+
+	// reported is the root set that changes are reported relative to.
+	reported storage.RootSetID
+	// pending is a root set that differs from reported, but has not yet
+	// persisted for long enough for the change to be reported.
+	pending      storage.RootSetID
+	pendingCount int
+	holdTimer    *time.Timer
+	// flaps counts how often the root set has flapped from reported to each
+	// other root set and back again, since the last reported change.
+	flaps map[storage.RootSetID]int
+	// flapReported is whether an incident has been reported for flapping
+	// since the last reported change.
+	flapReported bool
+	// health is the healthKey of the problems last reported by checkHealth,
+	// so that they are only reported again if the roots with problems, or
+	// the kinds of problem with them, change.
+	health string
 }
 
 // Run starts a Roots Analyzer, which watches a CT log's root certificates and creates incident reports for changes to them.
-func Run(ctx context.Context, st storage.RootsReader, rep incident.Reporter, l *ctlog.Log) {
+// Changes are subject to the hold-down configured by opts.
+func Run(ctx context.Context, st storage.RootsReader, rep incident.Reporter, l *ctlog.Log, opts Options) {
 	rootSetChan, err := st.WatchRoots(ctx, l)
 	if err != nil {
 		glog.Errorf("%s: %s: storage.RootsReader.WatchRoots() = %q", l.URL, logStr, err)
 		return
 	}
+	if opts.FlapThreshold <= 0 {
+		opts.FlapThreshold = DefaultFlapThreshold
+	}
 
-	var lastRootSetID storage.RootSetID
+	a := &analyzer{st: st, rep: rep, l: l, opts: opts, flaps: make(map[storage.RootSetID]int)}
+	defer a.clearPending()
 	for {
+		var holdExpired <-chan time.Time
+		if a.holdTimer != nil {
+			holdExpired = a.holdTimer.C
+		}
+		var err error
 		select {
 		case <-ctx.Done():
 			return
 		case rootSetID := <-rootSetChan:
-			if lastRootSetID != "" && lastRootSetID != rootSetID {
-				// TODO(RJPercival): If the root set is flapping back to what it recently was, suppress sending an incident report
-				// since it could just be the result of skew between log frontends. However, if it doesn't flap back to the new
-				// root set again within a certain amount of time, then the suppressed report should be sent.
-				oldRoots, err := st.ReadRoots(ctx, lastRootSetID)
-				if err != nil {
-					glog.Errorf("%s: %s: %s", l.URL, logStr, err)
-					return
-				}
-				newRoots, err := st.ReadRoots(ctx, rootSetID)
-				if err != nil {
-					glog.Errorf("%s: %s: %s", l.URL, logStr, err)
-					return
-				}
-				addedCerts, removedCerts := diffRootSets(oldRoots, newRoots)
-				if err := reportChange(ctx, rep, l, addedCerts, removedCerts); err != nil {
-					glog.Errorf("%s: %s: %s", l.URL, logStr, err)
-					return
-				}
-			}
-			lastRootSetID = rootSetID
+			err = a.observe(ctx, rootSetID)
+		case <-holdExpired:
+			a.holdTimer = nil
+			err = a.reportPending(ctx)
+		}
+		if err != nil {
+			glog.Errorf("%s: %s: %s", l.URL, logStr, err)
+			return
+		}
+	}
+}
+
+// observe updates the analyzer's state to reflect that rootSetID has been
+// observed, reporting incidents as necessary.  Any hold timer for a new root set
+// starts now, when the observation is received; see Options.
+func (a *analyzer) observe(ctx context.Context, rootSetID storage.RootSetID) error {
+	switch {
+	case a.reported == "":
+		a.reported = rootSetID
+		if err := a.checkHealth(ctx); err != nil {
+			return err
+		}
+		return a.checkConformance(ctx)
+	case rootSetID == a.reported:
+		if err := a.checkHealth(ctx); err != nil {
+			return err
+		}
+		if a.pending == "" {
+			return nil
 		}
+		// The root set has flapped back to what it was, which could just be
+		// the result of skew between log frontends.
+		other := a.pending
+		a.clearPending()
+		a.flaps[other]++
+		if a.flaps[other] < a.opts.FlapThreshold || a.flapReported {
+			return nil
+		}
+		a.flapReported = true
+		return a.reportFlaps(ctx, other, a.flaps[other])
+	case rootSetID == a.pending:
+		a.pendingCount++
+	default:
+		a.clearPending()
+		a.pending = rootSetID
+		a.pendingCount = 1
+		if a.opts.HoldDuration > 0 {
+			a.holdTimer = time.NewTimer(a.opts.HoldDuration)
+		}
+	}
+
+	n, d := a.opts.HoldObservations, a.opts.HoldDuration
+	if (n > 0 && a.pendingCount >= n) || (n <= 0 && d <= 0) {
+		return a.reportPending(ctx)
+	}
+	return nil
+}
+
+// clearPending forgets the pending root set.
+func (a *analyzer) clearPending() {
+	if a.holdTimer != nil {
+		a.holdTimer.Stop()
+		a.holdTimer = nil
+	}
+	a.pending = ""
+	a.pendingCount = 0
+}
+
+// reportPending reports the change from the reported root set to the pending
+// root set, which has persisted for long enough.
+func (a *analyzer) reportPending(ctx context.Context) error {
+	oldRoots, err := a.st.ReadRoots(ctx, a.reported)
+	if err != nil {
+		return err
+	}
+	newRoots, err := a.st.ReadRoots(ctx, a.pending)
+	if err != nil {
+		return err
+	}
+	addedCerts, removedCerts := storage.DiffRoots(oldRoots, newRoots)
+	if err := reportChange(ctx, a.rep, a.l, a.opts.Programs, rootsEvidence(a.reported, a.pending), addedCerts, removedCerts); err != nil {
+		return err
+	}
+	if a.flapReported {
+		incident.LogResolved(ctx, a.rep, a.l.URL, flapSummary)
+	}
+	a.reported = a.pending
+	a.clearPending()
+	a.flaps = make(map[storage.RootSetID]int)
+	a.flapReported = false
+	if err := a.checkHealth(ctx); err != nil {
+		return err
+	}
+	return a.checkConformance(ctx)
+}
+
+// checkHealth checks the contents of the reported root set, if configured to,
+// and reports any problems found that differ from those last reported.  Root
+// sets that are pending, or have been flapped to, are not checked, so that the
+// incident follows the same hold-down as changes do.
+func (a *analyzer) checkHealth(ctx context.Context) error {
+	if a.opts.Health == nil {
+		return nil
+	}
+	roots, err := a.st.ReadRoots(ctx, a.reported)
+	if err != nil {
+		return err
+	}
+	findings, err := CheckRoots(roots, time.Now(), *a.opts.Health)
+	if err != nil {
+		return err
+	}
+	key := healthKey(findings)
+	if key == a.health {
+		return nil
+	}
+	a.health = key
+	return reportFindings(ctx, a.rep, a.l, a.reported, findings)
+}
+
+// checkConformance compares the reported root set with the reference root
+// programs, if there are any.
+func (a *analyzer) checkConformance(ctx context.Context) error {
+	if len(a.opts.Programs) == 0 {
+		return nil
 	}
+	roots, err := a.st.ReadRoots(ctx, a.reported)
+	if err != nil {
+		return err
+	}
+	return ReportConformance(ctx, a.rep, a.l, a.opts.Programs, roots)
 }
 
-// diffRootSets returns the certificates that have been added or removed in new, relative to old.
-// Neither old nor new are allowed to contain duplicates.
-func diffRootSets(old, new []*x509.Certificate) (added, removed []*x509.Certificate) {
-	oldSet := make(map[string]*x509.Certificate, len(old))
-	for _, cert := range old {
-		oldSet[string(cert.Raw)] = cert
-	}
-	// This algorithm assumes that there are no duplicates in new.
-	// TODO(RJPercival): Support old and new containing duplicate certificates.
-	for _, cert := range new {
-		certDER := string(cert.Raw)
-		if oldSet[certDER] != nil {
-			// cert appears in both old and new - remove it from oldSet
-			// so that, after the loop, oldSet contains only certs
-			// that are in old but not new.
-			delete(oldSet, certDER)
-		} else {
-			// cert is only in new.
-			added = append(added, cert)
+// reportFlaps reports that the root set has flapped between the reported root
+// set and other the given number of times.
+func (a *analyzer) reportFlaps(ctx context.Context, other storage.RootSetID, flaps int) error {
+	args := flapTemplateArgs{Log: a.l, Flaps: flaps}
+	for _, id := range []storage.RootSetID{a.reported, other} {
+		certs, err := a.st.ReadRoots(ctx, id)
+		if err != nil {
+			return err
 		}
+		certs = append([]*x509.Certificate(nil), certs...)
+		sortCerts(certs)
+		args.Sets = append(args.Sets, flapTemplateSet{ID: id, Certs: certs})
 	}
-	for _, cert := range oldSet {
-		removed = append(removed, cert)
+
+	var strBuilder strings.Builder
+	if err := flapTemplate.Execute(&strBuilder, args); err != nil {
+		return err
 	}
-	return added, removed
+	incident.Report(ctx, a.rep, &incident.Incident{
+		BaseURL:  a.l.URL,
+		Summary:  flapSummary,
+		FullURL:  getRootsURL(a.l),
+		Details:  strBuilder.String(),
+		Category: incident.RootChange,
+		Severity: incident.Warning,
+		LogID:    a.l.LogID,
+		Evidence: rootsEvidence(a.reported, other),
+	})
+	return nil
 }
 
 // sortCerts sorts a slice of certificates first by their subject, then by their raw DER.
@@ -131,16 +355,29 @@ func sortCerts(certs []*x509.Certificate) {
 	})
 }
 
-func reportChange(ctx context.Context, rep incident.Reporter, l *ctlog.Log, addedCerts, removedCerts []*x509.Certificate) error {
-	fullURL := l.URL
-	getRootsURL, err := url.Parse(l.URL)
+// getRootsURL returns the URL of l's get-roots endpoint, or of l if that
+// cannot be determined.
+func getRootsURL(l *ctlog.Log) string {
+	u, err := url.Parse(l.URL)
 	if err != nil {
 		glog.Errorf("%s: %s: failed to parse CT Log URL: %v", l.URL, logStr, err)
-	} else {
-		getRootsURL.Path = path.Join(getRootsURL.Path, ct.GetRootsPath)
-		fullURL = getRootsURL.String()
+		return l.URL
 	}
+	u.Path = path.Join(u.Path, ct.GetRootsPath)
+	return u.String()
+}
 
+// rootsEvidence returns references to the stored root sets with the given IDs,
+// for use as the evidence of an incident.
+func rootsEvidence(ids ...storage.RootSetID) []incident.EvidenceRef {
+	refs := make([]incident.EvidenceRef, 0, len(ids))
+	for _, id := range ids {
+		refs = append(refs, incident.EvidenceRef{Kind: "roots", ID: fmt.Sprintf("%X", string(id))})
+	}
+	return refs
+}
+
+func reportChange(ctx context.Context, rep incident.Reporter, l *ctlog.Log, programs Programs, evidence []incident.EvidenceRef, addedCerts, removedCerts []*x509.Certificate) error {
 	// Sort certs so that the report is deterministic - makes testing easier.
 	sortCerts(addedCerts)
 	sortCerts(removedCerts)
@@ -150,9 +387,18 @@ func reportChange(ctx context.Context, rep incident.Reporter, l *ctlog.Log, adde
 		Log:          l,
 		AddedCerts:   addedCerts,
 		RemovedCerts: removedCerts,
+		Programs:     programs,
 	}); err != nil {
 		return err
 	}
-	rep.LogUpdate(ctx, l.URL, "Root certificates changed", fullURL, strBuilder.String())
+	incident.Report(ctx, rep, &incident.Incident{
+		BaseURL:  l.URL,
+		Summary:  changeSummary,
+		FullURL:  getRootsURL(l),
+		Details:  strBuilder.String(),
+		Category: incident.RootChange,
+		LogID:    l.LogID,
+		Evidence: evidence,
+	})
 	return nil
 }
@@ -0,0 +1,383 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The rootsanalyzer watches the root certificates accepted by the Logs in a log
+// list, as recorded by datacollectors, and reports incidents for changes to
+// them, for roots that are unhealthy or do not conform to the configured root
+// programs, for Logs whose roots differ from those of the rest of their
+// family, and for temporal shards that are ending with no successor.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/loglist2"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/certificate-transparency-go/x509util"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/incident/local"
+	incidentmysql "github.com/google/monologue/incident/mysql"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/file"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/monologue/storage/mysql"
+)
+
+var (
+	logList = flag.String("log_list", "", "Path to a log list, in the v2 JSON format, of the Logs whose roots to analyze. Each Log is named after its description")
+
+	storageDir = flag.String("storage_dir", "", "Directory of JSON Lines files written by the datacollectors of the Logs. Root sets in them are matched to Logs by URL. Used if mysql_uri is not set")
+	pollPeriod = flag.Duration("poll_period", time.Minute, "How often to read storage_dir for new root sets")
+	mysqlURI   = flag.String("mysql_uri", "", "URI of a MySQL database holding root sets, which are matched to Logs by name (parseTime=true is always set)")
+
+	incidentsMySQLURI = flag.String("incidents_mysql_uri", "", "URI of a MySQL database in which to record incidents (parseTime=true is always set). If unset, incidents are recorded in incidents_file, if set, or else only logged")
+	incidentsFile     = flag.String("incidents_file", "", "Path to a JSON file in which to record incidents, for deployments without a MySQL database. It can be queried with the incidenttool")
+	groupWindow       = flag.Duration("incident_group_window", time.Hour, "How soon after its last occurrence a repeated incident is grouped with it, rather than recorded as a new incident; 0 to disable")
+
+	holdObservations = flag.Int("hold_observations", 0, "Number of times in a row that a new root set must be observed before the change to it is reported")
+	holdDuration     = flag.Duration("hold_duration", 0, "How long a new root set must persist before the change to it is reported, timed from when its first observation is read from storage rather than when it was made. If neither this nor hold_observations is set, changes are reported as soon as they are observed")
+	flapThreshold    = flag.Int("flap_threshold", rootsanalyzer.DefaultFlapThreshold, "Number of times a root set must flap back to the reported root set before the Log is reported as inconsistent")
+
+	healthChecks  = flag.Bool("health_checks", true, "Whether to report roots that have expired or will soon, are not self-signed CA certificates, or have weak keys or signatures")
+	expiryWarning = flag.Duration("expiry_warning", rootsanalyzer.DefaultExpiryWarning, "How long before they expire roots are reported, if health_checks is set")
+	minRSABits    = flag.Int("min_rsa_bits", rootsanalyzer.DefaultMinRSABits, "Smallest RSA key in a root that is not reported as weak, if health_checks is set")
+	minECDSABits  = flag.Int("min_ecdsa_bits", rootsanalyzer.DefaultMinECDSABits, "Smallest ECDSA key in a root that is not reported as weak, if health_checks is set")
+
+	familiesFile = flag.String("families", "", "Path to a JSON file listing the families of Logs that are expected to accept the same roots, as the operator, name and names or URLs of the Logs of each. If unset, the temporal shards of each operator in log_list are grouped into families by their descriptions")
+
+	successionPeriod  = flag.Duration("succession_period", 24*time.Hour, "How often to check for temporal shards in each family that are ending with no successor")
+	successionWarning = flag.Duration("succession_warning", rootsanalyzer.DefaultSuccessionWarning, "How long before the end of its temporal interval a shard with no successor is reported")
+
+	shardFor = flag.String("shard_for", "", "Path to a PEM certificate. If set, the Log in each family to which the certificate should be submitted, based on its NotAfter time, is printed, and nothing is analyzed")
+
+	pemPrograms   = flag.String("pem_programs", "", "Comma-separated NAME=PATH root programs, each given as a PEM bundle of its roots, e.g. Apple=apple.pem")
+	ccadbPrograms = flag.String("ccadb_programs", "", "Comma-separated NAME=PATH or NAME=PATH=STATUS_COLUMN root programs, each given as a CCADB-style CSV report of its roots. If STATUS_COLUMN is given, only roots whose status in that column is Included are in the program, e.g. Mozilla=mozilla.csv=Mozilla Status")
+)
+
+// loadLogList returns the log list at path.
+func loadLogList(path string) (*loglist2.LogList, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return loglist2.NewFromJSON(b)
+}
+
+// logsFromLogList returns the Logs in ll.
+func logsFromLogList(ll *loglist2.LogList) ([]*ctlog.Log, error) {
+	var logs []*ctlog.Log
+	for _, op := range ll.Operators {
+		for _, l := range op.Logs {
+			cl, err := ctlog.FromLogList(l)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", l.URL, err)
+			}
+			logs = append(logs, cl)
+		}
+	}
+	return logs, nil
+}
+
+// loadPrograms returns the root programs given by the pem_programs and
+// ccadb_programs flags.
+func loadPrograms() (rootsanalyzer.Programs, error) {
+	var ps rootsanalyzer.Programs
+	for _, spec := range split(*pemPrograms) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid PEM program %q: want NAME=PATH", spec)
+		}
+		p, err := rootsanalyzer.LoadPEMProgram(parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	for _, spec := range split(*ccadbPrograms) {
+		parts := strings.SplitN(spec, "=", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid CCADB program %q: want NAME=PATH or NAME=PATH=STATUS_COLUMN", spec)
+		}
+		var statusColumn string
+		if len(parts) == 3 {
+			statusColumn = parts[2]
+		}
+		p, err := rootsanalyzer.LoadCCADBProgram(parts[0], parts[1], statusColumn)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// split splits a comma-separated flag value, returning nil if it is empty.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// printShards prints the Log in each of families that accepts the PEM
+// certificate at path, if any.
+func printShards(path string, families []*rootsanalyzer.Family) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cert, err := x509util.CertificateFromPEM(b)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %s", err)
+	}
+	for _, f := range families {
+		name := strings.TrimSpace(f.Operator + " " + f.Name)
+		if l := f.ShardFor(cert); l != nil {
+			fmt.Printf("%s: %s (%s)\n", name, l.Name, l.URL)
+		} else {
+			fmt.Printf("%s: no shard accepts certificates expiring at %s\n", name, cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// setupReporter returns the incident reporter configured by flags, and a
+// function to call to close it.
+func setupReporter(ctx context.Context) (incident.Reporter, func(), error) {
+	const source = "rootsanalyzer"
+	switch {
+	case *incidentsMySQLURI != "":
+		db, err := mysql.Open(*incidentsMySQLURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open incidents database: %s", err)
+		}
+		rep, err := incidentmysql.NewGroupingMySQLReporter(ctx, db, source, *groupWindow)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return rep, func() { db.Close() }, nil
+	case *incidentsFile != "":
+		rep, err := local.New(local.Options{Path: *incidentsFile, Source: source, GroupWindow: *groupWindow})
+		if err != nil {
+			return nil, nil, err
+		}
+		return rep, func() {}, nil
+	default:
+		return &incident.LoggingReporter{}, func() {}, nil
+	}
+}
+
+// setupStorage returns the storage.RootsReader configured by flags, and a
+// function to call to close it.
+func setupStorage(ctx context.Context, logs []*ctlog.Log) (storage.RootsReader, func(), error) {
+	switch {
+	case *mysqlURI != "":
+		db, err := mysql.Open(*mysqlURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open MySQL database: %s", err)
+		}
+		return mysql.NewRootStore(ctx, db), func() { db.Close() }, nil
+	case *storageDir != "":
+		fr := &fileRoots{
+			Storage: memory.New(),
+			dir:     *storageDir,
+			byURL:   make(map[string]*ctlog.Log),
+			latest:  make(map[string]time.Time),
+		}
+		for _, l := range logs {
+			fr.byURL[normalizeURL(l.URL)] = l
+		}
+		// The root sets already recorded are read before any analysis starts,
+		// so that the latest of them is the first observed.
+		if err := fr.read(ctx); err != nil {
+			glog.Errorf("failed to read root sets from %s: %s", fr.dir, err)
+		}
+		go fr.poll(ctx, *pollPeriod)
+		return fr, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("neither mysql_uri nor storage_dir provided")
+	}
+}
+
+// fileRoots is a storage.RootsReader for the root sets recorded in the files
+// written by datacollectors to a directory, which it rereads periodically.
+type fileRoots struct {
+	*memory.Storage
+	dir   string
+	byURL map[string]*ctlog.Log
+
+	mu sync.Mutex // guards latest
+	// latest is the time of the latest root set read for each Log, by name,
+	// so that the root sets that have already been read are not read again.
+	latest map[string]time.Time
+}
+
+// poll rereads the directory every period, until ctx is done.
+func (f *fileRoots) poll(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := f.read(ctx); err != nil {
+			glog.Errorf("failed to read root sets from %s: %s", f.dir, err)
+		}
+	}
+}
+
+// read reads every file in the directory, and stores the root sets of the
+// Logs that are newer than those already read.  As the latest file of each
+// datacollector may be being written, an error reading it leaves the root sets
+// after the error to be read next time.
+func (f *fileRoots) read(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	files, err := file.AllFiles(f.dir)
+	if err != nil {
+		return err
+	}
+	r := file.NewReader(files)
+	defer r.Close()
+	return file.Replay(ctx, r, &rootsWriter{f: f})
+}
+
+// rootsWriter is the storage.RootsWriter through which fileRoots replays
+// records.  It is a separate type so that Replay writes only root sets.
+type rootsWriter struct {
+	f *fileRoots
+}
+
+// WriteRoots stores roots as observed at receivedAt by the Log with the same
+// URL as l, unless a root set at least as recent has already been stored for
+// it.
+func (w *rootsWriter) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	log := w.f.byURL[normalizeURL(l.URL)]
+	if log == nil || !receivedAt.After(w.f.latest[log.Name]) {
+		return nil
+	}
+	w.f.latest[log.Name] = receivedAt
+	return w.f.Storage.WriteRoots(ctx, log, roots, receivedAt)
+}
+
+// normalizeURL returns the URL of a Log without any trailing slash, as the URL
+// given to a datacollector need not be written as it is in the log list.
+func normalizeURL(u string) string {
+	return strings.TrimSuffix(u, "/")
+}
+
+func main() {
+	flag.Parse()
+	if *logList == "" {
+		glog.Exit("No log_list provided.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		glog.Infof("Received %s, shutting down", sig)
+		cancel()
+	}()
+
+	ll, err := loadLogList(*logList)
+	if err != nil {
+		glog.Exitf("Unable to load log list: %s", err)
+	}
+	logs, err := logsFromLogList(ll)
+	if err != nil {
+		glog.Exitf("Invalid log list: %s", err)
+	}
+	var families []*rootsanalyzer.Family
+	if *familiesFile != "" {
+		families, err = rootsanalyzer.LoadFamilies(*familiesFile, logs)
+	} else {
+		families, err = rootsanalyzer.FamiliesFromLogList(ll, time.Now())
+	}
+	if err != nil {
+		glog.Exitf("Unable to load families: %s", err)
+	}
+	if *shardFor != "" {
+		if err := printShards(*shardFor, families); err != nil {
+			glog.Exitf("Unable to find shards: %s", err)
+		}
+		return
+	}
+	programs, err := loadPrograms()
+	if err != nil {
+		glog.Exitf("Unable to load root programs: %s", err)
+	}
+	rep, closeRep, err := setupReporter(ctx)
+	if err != nil {
+		glog.Exitf("Unable to create incident reporter: %s", err)
+	}
+	defer closeRep()
+	st, closeStorage, err := setupStorage(ctx, logs)
+	if err != nil {
+		glog.Exitf("Unable to open storage: %s", err)
+	}
+	defer closeStorage()
+
+	opts := rootsanalyzer.Options{
+		HoldObservations: *holdObservations,
+		HoldDuration:     *holdDuration,
+		FlapThreshold:    *flapThreshold,
+		Programs:         programs,
+	}
+	if *healthChecks {
+		opts.Health = &rootsanalyzer.HealthOptions{
+			ExpiryWarning: *expiryWarning,
+			MinRSABits:    *minRSABits,
+			MinECDSABits:  *minECDSABits,
+		}
+	}
+	var wg sync.WaitGroup
+	for _, l := range logs {
+		wg.Add(1)
+		go func(l *ctlog.Log) {
+			defer wg.Done()
+			rootsanalyzer.Run(ctx, st, rep, l, opts)
+		}(l)
+	}
+	for _, f := range families {
+		wg.Add(1)
+		go func(f *rootsanalyzer.Family) {
+			defer wg.Done()
+			rootsanalyzer.RunFamily(ctx, st, rep, f)
+		}(f)
+		wg.Add(1)
+		go func(f *rootsanalyzer.Family) {
+			defer wg.Done()
+			rootsanalyzer.RunSuccession(ctx, rep, f, *successionPeriod, *successionWarning)
+		}(f)
+	}
+	wg.Wait()
+}
@@ -17,12 +17,14 @@ package rootsanalyzer
 import (
 	"context"
 	"encoding/base64"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
 	"github.com/google/monologue/storage"
 
 	itestonly "github.com/google/monologue/incident/testonly"
@@ -76,9 +78,11 @@ func TestRootsChanged(t *testing.T) {
 				rootSetID2: {root1, root2},
 			},
 			wantReport: &itestonly.Report{
-				Summary: "Root certificates changed",
-				BaseURL: "https://ct.googleapis.com/testtube/",
-				FullURL: "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Summary:  "Root certificates changed",
+				Category: incident.RootChange,
+				BaseURL:  "https://ct.googleapis.com/testtube/",
+				FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Evidence: []incident.EvidenceRef{{Kind: "roots", ID: "31"}, {Kind: "roots", ID: "32"}},
 				Details: `The root certificates accepted by testtube (https://ct.googleapis.com/testtube/) have changed.
 
 Certificates added (1):
@@ -94,9 +98,11 @@ CN=A-Trust-Qual-02,OU=A-Trust-Qual-02,O=A-Trust Ges. f. Sicherheitssysteme im el
 				rootSetID2: {root1, root2},
 			},
 			wantReport: &itestonly.Report{
-				Summary: "Root certificates changed",
-				BaseURL: "https://ct.googleapis.com/testtube/",
-				FullURL: "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Summary:  "Root certificates changed",
+				Category: incident.RootChange,
+				BaseURL:  "https://ct.googleapis.com/testtube/",
+				FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Evidence: []incident.EvidenceRef{{Kind: "roots", ID: "31"}, {Kind: "roots", ID: "32"}},
 				Details: `The root certificates accepted by testtube (https://ct.googleapis.com/testtube/) have changed.
 
 Certificates added (2):
@@ -113,9 +119,11 @@ CN=GLOBALTRUST,OU=GLOBALTRUST Certification Service,O=ARGE DATEN - Austrian Soci
 				rootSetID2: {root1},
 			},
 			wantReport: &itestonly.Report{
-				Summary: "Root certificates changed",
-				BaseURL: "https://ct.googleapis.com/testtube/",
-				FullURL: "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Summary:  "Root certificates changed",
+				Category: incident.RootChange,
+				BaseURL:  "https://ct.googleapis.com/testtube/",
+				FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Evidence: []incident.EvidenceRef{{Kind: "roots", ID: "31"}, {Kind: "roots", ID: "32"}},
 				Details: `The root certificates accepted by testtube (https://ct.googleapis.com/testtube/) have changed.
 
 Certificates removed (1):
@@ -131,9 +139,11 @@ CN=A-Trust-Qual-02,OU=A-Trust-Qual-02,O=A-Trust Ges. f. Sicherheitssysteme im el
 				rootSetID2: {},
 			},
 			wantReport: &itestonly.Report{
-				Summary: "Root certificates changed",
-				BaseURL: "https://ct.googleapis.com/testtube/",
-				FullURL: "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Summary:  "Root certificates changed",
+				Category: incident.RootChange,
+				BaseURL:  "https://ct.googleapis.com/testtube/",
+				FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Evidence: []incident.EvidenceRef{{Kind: "roots", ID: "31"}, {Kind: "roots", ID: "32"}},
 				Details: `The root certificates accepted by testtube (https://ct.googleapis.com/testtube/) have changed.
 
 Certificates removed (2):
@@ -150,9 +160,11 @@ CN=GLOBALTRUST,OU=GLOBALTRUST Certification Service,O=ARGE DATEN - Austrian Soci
 				rootSetID2: {root2},
 			},
 			wantReport: &itestonly.Report{
-				Summary: "Root certificates changed",
-				BaseURL: "https://ct.googleapis.com/testtube/",
-				FullURL: "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Summary:  "Root certificates changed",
+				Category: incident.RootChange,
+				BaseURL:  "https://ct.googleapis.com/testtube/",
+				FullURL:  "https://ct.googleapis.com/testtube/ct/v1/get-roots",
+				Evidence: []incident.EvidenceRef{{Kind: "roots", ID: "31"}, {Kind: "roots", ID: "32"}},
 				Details: `The root certificates accepted by testtube (https://ct.googleapis.com/testtube/) have changed.
 
 Certificates added (1):
@@ -180,7 +192,7 @@ CN=GLOBALTRUST,OU=GLOBALTRUST Certification Service,O=ARGE DATEN - Austrian Soci
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 
-			go Run(ctx, fakeStorage, fakeReporter, l)
+			go Run(ctx, fakeStorage, fakeReporter, l, Options{})
 
 			for _, id := range test.rootSetIDs {
 				fakeStorage.RootSetChan <- id
@@ -199,3 +211,105 @@ CN=GLOBALTRUST,OU=GLOBALTRUST Certification Service,O=ARGE DATEN - Austrian Soci
 		})
 	}
 }
+
+func TestRootsHoldDown(t *testing.T) {
+	const (
+		set1 = storage.RootSetID("1")
+		set2 = storage.RootSetID("2")
+	)
+	l := &ctlog.Log{
+		Name: "testtube",
+		URL:  "https://ct.googleapis.com/testtube/",
+	}
+	rootSetCerts := map[storage.RootSetID][]*x509.Certificate{
+		set1: {root1},
+		set2: {root1, root2},
+	}
+
+	tests := []struct {
+		desc        string
+		opts        Options
+		rootSetIDs  []storage.RootSetID
+		wantReports []itestonly.Report
+	}{
+		{
+			desc:       "held by observations",
+			opts:       Options{HoldObservations: 3},
+			rootSetIDs: []storage.RootSetID{set1, set2, set2},
+		},
+		{
+			desc:        "persists for observations",
+			opts:        Options{HoldObservations: 3},
+			rootSetIDs:  []storage.RootSetID{set1, set2, set2, set2},
+			wantReports: []itestonly.Report{{Summary: changeSummary, Category: incident.RootChange}},
+		},
+		{
+			desc:       "flaps back once",
+			opts:       Options{HoldObservations: 3},
+			rootSetIDs: []storage.RootSetID{set1, set2, set2, set1},
+		},
+		{
+			desc:        "persists for duration",
+			opts:        Options{HoldDuration: 50 * time.Millisecond},
+			rootSetIDs:  []storage.RootSetID{set1, set2},
+			wantReports: []itestonly.Report{{Summary: changeSummary, Category: incident.RootChange}},
+		},
+		{
+			desc:       "repeated flapping",
+			opts:       Options{HoldObservations: 3},
+			rootSetIDs: []storage.RootSetID{set1, set2, set1, set2, set1, set2, set1, set2, set1},
+			wantReports: []itestonly.Report{
+				{Summary: flapSummary, Category: incident.RootChange, Severity: incident.Warning},
+			},
+		},
+		{
+			desc:       "flapping then persists",
+			opts:       Options{HoldObservations: 5, HoldDuration: 50 * time.Millisecond, FlapThreshold: 3},
+			rootSetIDs: []storage.RootSetID{set1, set2, set1, set2, set1, set2, set1, set2},
+			wantReports: []itestonly.Report{
+				{Summary: flapSummary, Category: incident.RootChange, Severity: incident.Warning},
+				{Summary: changeSummary, Category: incident.RootChange},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			test := test
+			t.Parallel()
+
+			fakeStorage := &stestonly.FakeRootsReader{
+				RootSetChan:  make(chan storage.RootSetID, len(test.rootSetIDs)),
+				RootSetCerts: rootSetCerts,
+			}
+			fakeReporter := &itestonly.FakeReporter{
+				Updates: make(chan itestonly.Report, len(test.wantReports)+1),
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go Run(ctx, fakeStorage, fakeReporter, l, test.opts)
+
+			for _, id := range test.rootSetIDs {
+				fakeStorage.RootSetChan <- id
+			}
+
+			var got []itestonly.Report
+			for {
+				select {
+				case r := <-fakeReporter.Updates:
+					if r.Summary == flapSummary && (!strings.Contains(r.Details, "Root set 31 (1 certificates)") || !strings.Contains(r.Details, "Root set 32 (2 certificates)")) {
+						t.Errorf("Inconsistent frontends incident does not list both root sets:\n%s", r.Details)
+					}
+					got = append(got, itestonly.Report{Summary: r.Summary, Category: r.Category, Severity: r.Severity})
+					continue
+				case <-time.After(500 * time.Millisecond):
+				}
+				break
+			}
+			if diff := cmp.Diff(test.wantReports, got); diff != "" {
+				t.Errorf("Incident reports diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/schedule"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+)
+
+// ShardEndingSummary is the summary of the incident reported when the temporal
+// interval of a shard is about to end, or has ended, and no other Log in its
+// Family accepts the certificates that it will no longer accept.  The incident
+// is resolved once a successor is configured.
+const ShardEndingSummary = "Temporal shard ending with no successor"
+
+// DefaultSuccessionWarning is how long before the end of its temporal interval
+// a shard with no successor is reported, if no other warning is given.
+const DefaultSuccessionWarning = 90 * 24 * time.Hour
+
+// ShardFor returns the Log in f that accepts cert, based on its NotAfter time,
+// or nil if none of them do.
+func (f *Family) ShardFor(cert *x509.Certificate) *ctlog.Log {
+	return ctlog.ShardFor(f.Logs, cert.NotAfter)
+}
+
+// EndingShards returns the temporal shards in f whose intervals end within
+// warning of now, or have already ended, and which have no successor: no Log in
+// f accepts certificates whose NotAfter is the end of their interval.  Shards
+// whose intervals ended more than EndedShardAge before now are taken to have
+// been retired, and are not returned.
+func EndingShards(f *Family, now time.Time, warning time.Duration) []*ctlog.Log {
+	var ending []*ctlog.Log
+	for _, l := range f.Logs {
+		ti := l.TemporalInterval
+		if ti == nil || ti.End.Sub(now) > warning || endedLongAgo(l, now) {
+			continue
+		}
+		if ctlog.ShardFor(f.Logs, ti.End) == nil {
+			ending = append(ending, l)
+		}
+	}
+	return ending
+}
+
+// RunSuccession periodically checks the temporal shards in f, and reports an
+// incident for each one whose interval ends within warning with no successor,
+// as described for EndingShards.  If warning is 0, DefaultSuccessionWarning is
+// used.
+func RunSuccession(ctx context.Context, rep incident.Reporter, f *Family, period, warning time.Duration) {
+	if warning == 0 {
+		warning = DefaultSuccessionWarning
+	}
+	glog.Infof("%s %s: %s: shard succession checks started with period %v", f.Operator, f.Name, logStr, period)
+	reported := make(map[*ctlog.Log]incident.Severity)
+	schedule.Every(ctx, period, func(ctx context.Context) {
+		reportSuccession(ctx, rep, f, time.Now(), warning, reported)
+	})
+	glog.Infof("%s %s: %s: shard succession checks stopped", f.Operator, f.Name, logStr)
+}
+
+// reportSuccession reports the shards in f that are ending with no successor,
+// unless they are in reported with the same severity, and declares resolved
+// the incidents of those in reported that now have a successor, or have been
+// retired.  reported is
+// updated to match, so that a shard reported as ending is reported again once
+// it has ended.
+func reportSuccession(ctx context.Context, rep incident.Reporter, f *Family, now time.Time, warning time.Duration, reported map[*ctlog.Log]incident.Severity) {
+	ending := make(map[*ctlog.Log]incident.Severity)
+	for _, l := range EndingShards(f, now, warning) {
+		end := l.TemporalInterval.End
+		severity := incident.Warning
+		when := fmt.Sprintf("ends on %s, in %d days", end.UTC().Format("2006-01-02"), int(end.Sub(now).Hours()/24))
+		if !end.After(now) {
+			severity = incident.Critical
+			when = fmt.Sprintf("ended on %s", end.UTC().Format("2006-01-02"))
+		}
+		ending[l] = severity
+		if reported[l] == severity {
+			continue
+		}
+		incident.Report(ctx, rep, &incident.Incident{
+			BaseURL:  l.URL,
+			Summary:  ShardEndingSummary,
+			FullURL:  l.URL,
+			Details:  fmt.Sprintf("The temporal interval of %s (%s) %s, and no other %s shard accepts certificates that expire at or after %s.", l.Name, l.URL, when, familyName(f), end.UTC().Format(time.RFC3339)),
+			Category: incident.ShardSuccession,
+			Severity: severity,
+			LogID:    l.LogID,
+		})
+	}
+	for l := range reported {
+		if _, ok := ending[l]; !ok {
+			incident.LogResolved(ctx, rep, l.URL, ShardEndingSummary)
+		}
+	}
+	for l := range reported {
+		delete(reported, l)
+	}
+	for l, severity := range ending {
+		reported[l] = severity
+	}
+}
+
+// familyName returns the name of f, prefixed by its operator if known.
+func familyName(f *Family) string {
+	if f.Operator == "" {
+		return f.Name
+	}
+	return f.Operator + " " + f.Name
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rootsanalyzer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/interval"
+)
+
+func yearShard(name string, year int) *ctlog.Log {
+	return &ctlog.Log{
+		Name: name,
+		URL:  "https://ct.googleapis.com/logs/" + name + "/",
+		TemporalInterval: &interval.Interval{
+			Start: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestFamilyShardFor(t *testing.T) {
+	f := &Family{Operator: "Google", Name: "Argon", Logs: []*ctlog.Log{yearShard("argon2020", 2020), yearShard("argon2021", 2021)}}
+	for _, test := range []struct {
+		notAfter time.Time
+		want     string
+	}{
+		{notAfter: time.Date(2020, time.December, 31, 23, 59, 59, 0, time.UTC), want: "argon2020"},
+		{notAfter: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), want: "argon2021"},
+		{notAfter: time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		var got string
+		if l := f.ShardFor(&x509.Certificate{NotAfter: test.notAfter}); l != nil {
+			got = l.Name
+		}
+		if got != test.want {
+			t.Errorf("ShardFor(NotAfter: %s) = %q, want %q", test.notAfter, got, test.want)
+		}
+	}
+}
+
+func TestEndingShards(t *testing.T) {
+	argon2020, argon2021 := yearShard("argon2020", 2020), yearShard("argon2021", 2021)
+	f := &Family{Name: "Argon", Logs: []*ctlog.Log{argon2020, argon2021}}
+	warning := 30 * 24 * time.Hour
+
+	tests := []struct {
+		desc string
+		now  time.Time
+		want []string
+	}{
+		{desc: "long before the end", now: time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{desc: "first shard ending, with successor", now: time.Date(2020, time.December, 15, 0, 0, 0, 0, time.UTC)},
+		{desc: "last shard ending", now: time.Date(2021, time.December, 15, 0, 0, 0, 0, time.UTC), want: []string{"argon2021"}},
+		{desc: "last shard ended", now: time.Date(2022, time.February, 1, 0, 0, 0, 0, time.UTC), want: []string{"argon2021"}},
+		{desc: "last shard retired", now: time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			var got []string
+			for _, l := range EndingShards(f, test.now, warning) {
+				got = append(got, l.Name)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("EndingShards() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReportSuccession(t *testing.T) {
+	ctx := context.Background()
+	argon2020 := yearShard("argon2020", 2020)
+	f := &Family{Operator: "Google", Name: "Argon", Logs: []*ctlog.Log{argon2020}}
+	rep := &incidentRecorder{incidents: make(chan *incident.Incident, 1), resolved: make(chan string, 1)}
+	reported := make(map[*ctlog.Log]incident.Severity)
+	now := time.Date(2020, time.December, 2, 0, 0, 0, 0, time.UTC)
+
+	reportSuccession(ctx, rep, f, now, DefaultSuccessionWarning, reported)
+	got := <-rep.incidents
+	if got.Summary != ShardEndingSummary || got.Category != incident.ShardSuccession || got.Severity != incident.Warning {
+		t.Errorf("reportSuccession() reported %q, %q, %q; want %q, %q, %q", got.Summary, got.Category, got.Severity, ShardEndingSummary, incident.ShardSuccession, incident.Warning)
+	}
+	if want := "argon2020 (https://ct.googleapis.com/logs/argon2020/) ends on 2021-01-01, in 30 days, and no other Google Argon shard"; !strings.Contains(got.Details, want) {
+		t.Errorf("reportSuccession() details %q; want to contain %q", got.Details, want)
+	}
+
+	// The same shard is not reported again.
+	reportSuccession(ctx, rep, f, now.Add(24*time.Hour), DefaultSuccessionWarning, reported)
+	if len(rep.incidents) != 0 {
+		t.Errorf("reportSuccession() reported %+v again", <-rep.incidents)
+	}
+
+	// Once its interval has ended, the shard is reported again as Critical,
+	// but only once.
+	ended := time.Date(2021, time.January, 2, 0, 0, 0, 0, time.UTC)
+	reportSuccession(ctx, rep, f, ended, DefaultSuccessionWarning, reported)
+	select {
+	case got := <-rep.incidents:
+		if got.Severity != incident.Critical {
+			t.Errorf("reportSuccession() reported severity %q once the shard ended, want %q", got.Severity, incident.Critical)
+		}
+		if want := "ended on 2021-01-01"; !strings.Contains(got.Details, want) {
+			t.Errorf("reportSuccession() details %q; want to contain %q", got.Details, want)
+		}
+	default:
+		t.Errorf("reportSuccession() did not report the shard again once it ended")
+	}
+	reportSuccession(ctx, rep, f, ended.Add(24*time.Hour), DefaultSuccessionWarning, reported)
+	if len(rep.incidents) != 0 {
+		t.Errorf("reportSuccession() reported %+v again", <-rep.incidents)
+	}
+
+	// Once a successor is configured, the incident is resolved.
+	f.Logs = append(f.Logs, yearShard("argon2021", 2021))
+	reportSuccession(ctx, rep, f, ended.Add(48*time.Hour), DefaultSuccessionWarning, reported)
+	if got := <-rep.resolved; got != ShardEndingSummary {
+		t.Errorf("reportSuccession() resolved %q, want %q", got, ShardEndingSummary)
+	}
+}
@@ -27,6 +27,8 @@ import (
 	"github.com/google/monologue/apicall"
 	"github.com/google/monologue/client"
 	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/rootsanalyzer"
 	"github.com/google/monologue/storage"
 )
 
@@ -39,7 +41,8 @@ type Storage interface {
 }
 
 // Run starts a Roots Getter, which periodically queries a Log for its set of acceptable root certificates and stores them.
-func Run(ctx context.Context, lc *client.LogClient, st Storage, l *ctlog.Log, period time.Duration) {
+// Duplicate root certificates in the Log's responses are reported to rep.
+func Run(ctx context.Context, lc *client.LogClient, st Storage, rep incident.Reporter, l *ctlog.Log, period time.Duration) {
 	glog.Infof("%s: %s: started with period %v", l.URL, logStr, period)
 
 	schedule.Every(ctx, period, func(ctx context.Context) {
@@ -48,6 +51,9 @@ func Run(ctx context.Context, lc *client.LogClient, st Storage, l *ctlog.Log, pe
 			glog.Errorf("%s: %s: %s", l.URL, logStr, err)
 			return
 		}
+		if err := rootsanalyzer.ReportDuplicates(ctx, rep, l, roots); err != nil {
+			glog.Errorf("%s: %s: %s", l.URL, logStr, err)
+		}
 		if err := st.WriteRoots(ctx, l, roots, receivedAt); err != nil {
 			glog.Errorf("%s: %s: %s", l.URL, logStr, err)
 		}
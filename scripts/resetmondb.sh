@@ -4,7 +4,9 @@ set -e
 
 usage() {
   cat <<EOF
-$(basename $0) [--force] [--verbose] ...
+$(basename $0) [--force] [--verbose] [--migrate] ...
+--migrate upgrades the schema of the existing database, keeping its data,
+instead of resetting it.
 All unrecognised arguments will be passed through to the 'mysql' command.
 Accepts environment variables:
 - MYSQL_ROOT_USER: A user with sufficient rights to create/reset the
@@ -42,10 +44,12 @@ collect_vars() {
   # handle flags
   FORCE=false
   VERBOSE=false
+  MIGRATE=false
   while [[ $# -gt 0 ]]; do
     case "$1" in
       --force) FORCE=true ;;
       --verbose) VERBOSE=true ;;
+      --migrate) MIGRATE=true ;;
       --help) usage; exit ;;
       *) FLAGS+=("$1")
     esac
@@ -61,6 +65,18 @@ collect_vars() {
 
   # append password if supplied
   [ -z ${MYSQL_ROOT_PASSWORD+x} ] || FLAGS+=(-p"${MYSQL_ROOT_PASSWORD}")
+
+  MYSQL_URI="${MYSQL_ROOT_USER}"
+  [ -z ${MYSQL_ROOT_PASSWORD+x} ] || MYSQL_URI+=":${MYSQL_ROOT_PASSWORD}"
+  MYSQL_URI+="@tcp(${MYSQL_HOST}:${MYSQL_PORT})/${MYSQL_DATABASE}"
+}
+
+# migrate adds the columns and indexes that the incident table of an existing
+# database, created by an earlier version of incident.sql, lacks.
+migrate() {
+  go run github.com/google/monologue/incident/incidenttool \
+    --mysql_uri="${MYSQL_URI}" --logtostderr migrate || \
+    die "Error: Failed to migrate incident table in '${MYSQL_DATABASE}' database."
 }
 
 main() {
@@ -68,6 +84,14 @@ main() {
 
   readonly INCIDENT_PATH=$(go list -f '{{.Dir}}' github.com/google/monologue/incident/mysql)
 
+  if [[ ${MIGRATE} = true ]]
+  then
+      echo "Migrating DB '${MYSQL_DATABASE}'..."
+      migrate
+      echo "Migration Complete"
+      return
+  fi
+
   echo "Warning: about to destroy and reset database '${MYSQL_DATABASE}'"
 
   [[ ${FORCE} = true ]] || read -p "Are you sure? [Y/N]: " -n 1 -r
@@ -86,6 +110,7 @@ main() {
         die "Error: Failed to grant '${MYSQL_USER}' user all privileges on '${MYSQL_DATABASE}'."
       mysql "${FLAGS[@]}" -D ${MYSQL_DATABASE} < ${INCIDENT_PATH}/incident.sql || \
         die "Error: Failed to create incident table in '${MYSQL_DATABASE}' database."
+      migrate
       echo "Reset Complete"
   fi
 }
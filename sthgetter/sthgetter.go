@@ -19,6 +19,8 @@ package sthgetter
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
@@ -29,11 +31,18 @@ import (
 	"github.com/google/monologue/client"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/errors"
+	"github.com/google/monologue/incident"
 	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/blob"
 )
 
 const logStr = "STH Getter"
 
+// StaleSTHSummary is the summary of the incident reported when a Log serves an
+// STH that is older than its MMD.  The incident is resolved once the Log serves
+// an STH that is not.
+const StaleSTHSummary = "STH older than MMD"
+
 // APICallSTHWriter represents a type that can store API Calls and store STHs.
 type APICallSTHWriter interface {
 	storage.APICallWriter
@@ -42,18 +51,18 @@ type APICallSTHWriter interface {
 
 // Run runs an STH Getter, which periodically gets an STH from a Log, checks
 // that each one meets per-STH requirements defined in RFC 6962, and stores
-// them.
-func Run(ctx context.Context, lc *client.LogClient, sv *ct.SignatureVerifier, st APICallSTHWriter, l *ctlog.Log, period time.Duration) {
+// them.  Incidents found are reported to rep.
+func Run(ctx context.Context, lc *client.LogClient, sv *ct.SignatureVerifier, st APICallSTHWriter, rep incident.Reporter, l *ctlog.Log, period time.Duration) {
 	glog.Infof("%s: %s: started with period %v", l.URL, logStr, period)
 
 	schedule.Every(ctx, period, func(ctx context.Context) {
-		getCheckStoreSTH(ctx, lc, sv, st, l)
+		getCheckStoreSTH(ctx, lc, sv, st, rep, l)
 	})
 
 	glog.Infof("%s: %s: stopped", l.URL, logStr)
 }
 
-func getCheckStoreSTH(ctx context.Context, lc *client.LogClient, sv *ct.SignatureVerifier, st APICallSTHWriter, l *ctlog.Log) {
+func getCheckStoreSTH(ctx context.Context, lc *client.LogClient, sv *ct.SignatureVerifier, st APICallSTHWriter, rep incident.Reporter, l *ctlog.Log) {
 	// Get STH from Log.
 	glog.Infof("%s: %s: getting STH...", l.URL, logStr)
 	sth, httpData, getErr := lc.GetSTH()
@@ -86,6 +95,7 @@ func getCheckStoreSTH(ctx context.Context, lc *client.LogClient, sv *ct.Signatur
 		}
 		glog.Infof("%s: %s: %s", l.URL, logStr, b.String())
 	}
+	reportStaleness(ctx, rep, l, sth, apiCall, errs)
 
 	// Store STH & associated errors.
 	glog.Infof("%s: %s: writing STH...", l.URL, logStr)
@@ -121,6 +131,41 @@ func checkSTH(sth *ct.SignedTreeHead, receivedAt time.Time, sv *ct.SignatureVeri
 	return errs
 }
 
+// reportStaleness reports an incident if errs shows that sth was older than the
+// MMD of the Log when it was received, giving the response body of apiCall, the
+// get-sth call that returned it, as evidence.  Otherwise it declares any such
+// incident resolved.
+func reportStaleness(ctx context.Context, rep incident.Reporter, l *ctlog.Log, sth *ct.SignedTreeHead, apiCall *apicall.APICall, errs []error) {
+	for _, err := range errs {
+		if _, ok := err.(*OldTimestampError); ok {
+			incident.Report(ctx, rep, &incident.Incident{
+				BaseURL:     l.URL,
+				Summary:     StaleSTHSummary,
+				FullURL:     getSTHURL(l),
+				Details:     fmt.Sprintf("%s served STH %v: %s", l.Name, sth, err),
+				IsViolation: true,
+				Category:    incident.MMDBreach,
+				LogID:       l.LogID,
+				Evidence:    []incident.EvidenceRef{blob.Evidence(apiCall.Body)},
+				RFCSections: []string{"RFC 6962 s3.5"},
+			})
+			return
+		}
+	}
+	incident.LogResolved(ctx, rep, l.URL, StaleSTHSummary)
+}
+
+// getSTHURL returns the URL of the get-sth endpoint of the Log.
+func getSTHURL(l *ctlog.Log) string {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		glog.Errorf("%s: %s: failed to parse CT Log URL: %v", l.URL, logStr, err)
+		return l.URL
+	}
+	u.Path = path.Join(u.Path, ct.GetSTHPath)
+	return u.String()
+}
+
 // checkSTHTimestamp checks that the STH was "no older than the Maximum Merge
 // Delay" (RFC 6962 section 3.5) when it was received.
 func checkSTHTimestamp(sth *ct.SignedTreeHead, receivedAt time.Time, mmd time.Duration) error {
@@ -15,13 +15,19 @@
 package sthgetter
 
 import (
+	"context"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
 
 	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/monologue/apicall"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/errors"
+	"github.com/google/monologue/incident"
+	itestonly "github.com/google/monologue/incident/testonly"
+	"github.com/google/monologue/storage/blob"
 	"github.com/google/monologue/testonly"
 )
 
@@ -173,3 +179,62 @@ func TestCheckSTHTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestReportStaleness(t *testing.T) {
+	ctx := context.Background()
+	l, err := ctlog.New("https://ct.googleapis.com/pilot", "google_pilot", b64PubKey, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Unable to obtain Log metadata: %s", err)
+	}
+	sth, err := validSTH.ToSignedTreeHead()
+	if err != nil {
+		t.Fatalf("Unable to create STH: %s", err)
+	}
+
+	tests := []struct {
+		desc          string
+		errs          []error
+		wantViolation bool
+	}{
+		{desc: "fresh", errs: nil},
+		{desc: "bad signature", errs: []error{&errors.SignatureVerificationError{Err: fmt.Errorf("bad")}}},
+		{desc: "stale", errs: []error{&OldTimestampError{Err: fmt.Errorf("old")}}, wantViolation: true},
+	}
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			rep := &itestonly.FakeReporter{
+				Updates:     make(chan itestonly.Report, 1),
+				Violations:  make(chan itestonly.Report, 1),
+				Resolutions: make(chan itestonly.Resolution, 1),
+			}
+			apiCall := &apicall.APICall{Endpoint: ct.GetSTHStr, Body: []byte(`{"tree_size":30}`)}
+			reportStaleness(ctx, rep, l, sth, apiCall, test.errs)
+
+			if test.wantViolation {
+				if len(rep.Violations) != 1 || len(rep.Resolutions) != 0 {
+					t.Fatalf("reported %d violations and %d resolutions, want 1 violation", len(rep.Violations), len(rep.Resolutions))
+				}
+				got := <-rep.Violations
+				if got.BaseURL != l.URL || got.Summary != StaleSTHSummary {
+					t.Errorf("reported %q for %s, want %q for %s", got.Summary, got.BaseURL, StaleSTHSummary, l.URL)
+				}
+				if want := "https://ct.googleapis.com/pilot/ct/v1/get-sth"; got.FullURL != want {
+					t.Errorf("reported full URL %q, want %q", got.FullURL, want)
+				}
+				if got.Category != incident.MMDBreach {
+					t.Errorf("reported category %q, want %q", got.Category, incident.MMDBreach)
+				}
+				if want := []incident.EvidenceRef{blob.Evidence(apiCall.Body)}; !reflect.DeepEqual(got.Evidence, want) {
+					t.Errorf("reported evidence %v, want %v", got.Evidence, want)
+				}
+				return
+			}
+			if len(rep.Violations) != 0 || len(rep.Resolutions) != 1 {
+				t.Fatalf("reported %d violations and %d resolutions, want 1 resolution", len(rep.Violations), len(rep.Resolutions))
+			}
+			if got, want := <-rep.Resolutions, (itestonly.Resolution{BaseURL: l.URL, Summary: StaleSTHSummary}); got != want {
+				t.Errorf("resolved %+v, want %+v", got, want)
+			}
+		})
+	}
+}
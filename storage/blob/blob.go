@@ -0,0 +1,171 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package blob provides content-addressed storage for large, frequently
+// repeated pieces of data, such as the bodies of responses from CT Logs.
+//
+// Each blob is stored once, keyed by the SHA-256 hash of its content, so that
+// records referring to it need only keep the hash.
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/incident"
+	"github.com/google/monologue/storage"
+)
+
+// ErrNotFound is returned when a requested blob is not in a Store.
+var ErrNotFound = errors.New("blob not found")
+
+// Hash is the SHA-256 hash of the content of a blob.
+type Hash [sha256.Size]byte
+
+// HashOf returns the Hash of data.
+func HashOf(data []byte) Hash {
+	return sha256.Sum256(data)
+}
+
+// HashFromBytes converts a slice, such as apicall.APICall.BodyHash, to a Hash.
+func HashFromBytes(b []byte) (Hash, error) {
+	var h Hash
+	if len(b) != len(h) {
+		return h, fmt.Errorf("hash is %d bytes long, want %d", len(b), len(h))
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// ParseHash parses a hex-encoded Hash, as returned by Hash.String.
+func ParseHash(s string) (Hash, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return Hash{}, err
+	}
+	return HashFromBytes(b)
+}
+
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// EvidenceKind is the Kind of incident.EvidenceRef that refers to a blob.  The
+// ID of such a ref is the hex-encoded Hash of the blob.
+const EvidenceKind = "blob"
+
+// Evidence returns an incident.EvidenceRef referring to the blob that holds
+// data, such as the response body of an API call that an APICallWriter has
+// stored.
+func Evidence(data []byte) incident.EvidenceRef {
+	return incident.EvidenceRef{Kind: EvidenceKind, ID: HashOf(data).String()}
+}
+
+// Store is an interface for content-addressed storage of blobs.
+type Store interface {
+	// Put stores data, returning its Hash.  Storing data that is already
+	// stored has no effect.
+	Put(ctx context.Context, data []byte) (Hash, error)
+	// Get returns the blob with the given Hash, or ErrNotFound if there isn't
+	// one.
+	Get(ctx context.Context, h Hash) ([]byte, error)
+	// Delete removes the blob with the given Hash.  Deleting a blob that isn't
+	// stored is not an error.
+	Delete(ctx context.Context, h Hash) error
+	// List calls fn with the Hash of every stored blob, and the time at which
+	// it was first stored.  If fn returns an error, List stops and returns it.
+	List(ctx context.Context, fn func(h Hash, storedAt time.Time) error) error
+}
+
+// Referencer is an interface for anything that stores records referring to
+// blobs, such as APICalls with a BodyHash.
+type Referencer interface {
+	// BlobRefs calls fn with the Hash of every blob referred to by a stored
+	// record.  The same Hash may be passed to fn more than once.  If fn returns
+	// an error, BlobRefs stops and returns it.
+	BlobRefs(ctx context.Context, fn func(h Hash) error) error
+}
+
+// APICallWriter implements storage.APICallWriter by moving the response body of
+// each API Call into a Store, and passing the API Call on to another
+// storage.APICallWriter with only the hash of the body.
+type APICallWriter struct {
+	blobs Store
+	next  storage.APICallWriter
+}
+
+// NewAPICallWriter returns an APICallWriter that stores response bodies in
+// blobs, and the rest of each API Call in next.
+func NewAPICallWriter(blobs Store, next storage.APICallWriter) *APICallWriter {
+	return &APICallWriter{blobs: blobs, next: next}
+}
+
+// WriteAPICall stores the response body of apiCall, if it has one, in the blob
+// Store and then writes apiCall, with BodyHash set in place of the body, to the
+// next storage.APICallWriter.  apiCall itself is not modified.
+func (w *APICallWriter) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	if len(apiCall.Body) == 0 {
+		return w.next.WriteAPICall(ctx, l, apiCall)
+	}
+	h, err := w.blobs.Put(ctx, apiCall.Body)
+	if err != nil {
+		return fmt.Errorf("failed to store response body: %s", err)
+	}
+	ac := *apiCall
+	ac.Body = nil
+	ac.BodyHash = h[:]
+	return w.next.WriteAPICall(ctx, l, &ac)
+}
+
+// Collect deletes every blob in s that is not referred to by any of refs and
+// that was stored before minAge ago.  minAge allows for records that refer to
+// recently stored blobs not having been written yet.  It returns the number of
+// blobs deleted.
+func Collect(ctx context.Context, s Store, refs []Referencer, minAge time.Duration) (int, error) {
+	live := make(map[Hash]bool)
+	for _, r := range refs {
+		if err := r.BlobRefs(ctx, func(h Hash) error {
+			live[h] = true
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("failed to find referenced blobs: %s", err)
+		}
+	}
+
+	cutoff := time.Now().Add(-minAge)
+	var garbage []Hash
+	if err := s.List(ctx, func(h Hash, storedAt time.Time) error {
+		if !live[h] && storedAt.Before(cutoff) {
+			garbage = append(garbage, h)
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("failed to list blobs: %s", err)
+	}
+
+	for i, h := range garbage {
+		if err := s.Delete(ctx, h); err != nil {
+			return i, fmt.Errorf("failed to delete blob %s: %s", h, err)
+		}
+	}
+	glog.Infof("blob: collected %d unreferenced blobs", len(garbage))
+	return len(garbage), nil
+}
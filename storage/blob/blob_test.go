@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/monologue/storage/storagetest"
+)
+
+var l = &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+
+func newFileStore(t *testing.T) (*blob.FileStore, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monologue-blob-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %s", err)
+	}
+	s, err := blob.NewFileStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewFileStore(%s) = _, %s", dir, err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestFileStoreConformance(t *testing.T) {
+	var cleanups []func()
+	defer func() {
+		for _, c := range cleanups {
+			c()
+		}
+	}()
+	storagetest.RunBlobStore(t, func(ctx context.Context, t *testing.T) blob.Store {
+		s, cleanup := newFileStore(t)
+		cleanups = append(cleanups, cleanup)
+		return s
+	})
+}
+
+func TestParseHash(t *testing.T) {
+	h := blob.HashOf([]byte("data"))
+	got, err := blob.ParseHash(h.String())
+	if err != nil {
+		t.Fatalf("ParseHash(%s) = _, %s", h, err)
+	}
+	if got != h {
+		t.Errorf("ParseHash(%s) = %s", h, got)
+	}
+	for _, s := range []string{"", "zz", h.String()[2:]} {
+		if _, err := blob.ParseHash(s); err == nil {
+			t.Errorf("ParseHash(%q) = _, nil, want error", s)
+		}
+	}
+}
+
+func TestAPICallWriter(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newFileStore(t)
+	defer cleanup()
+	mem := memory.New()
+	w := blob.NewAPICallWriter(s, mem)
+
+	body := []byte(`{"certificates":[]}`)
+	calls := []*apicall.APICall{
+		apicall.New(ct.GetRootsStr, &client.HTTPData{Body: body}, nil),
+		apicall.New(ct.GetRootsStr, &client.HTTPData{Body: body}, nil),
+		apicall.New(ct.GetRootsStr, nil, context.DeadlineExceeded),
+	}
+	for _, c := range calls {
+		if err := w.WriteAPICall(ctx, l, c); err != nil {
+			t.Fatalf("WriteAPICall() = %s", err)
+		}
+	}
+	if !bytes.Equal(calls[0].Body, body) {
+		t.Errorf("WriteAPICall() modified the API Call passed to it")
+	}
+
+	got := mem.APICalls(l.Name)
+	if len(got) != len(calls) {
+		t.Fatalf("stored %d API Calls, want %d", len(got), len(calls))
+	}
+	want := blob.HashOf(body)
+	for _, c := range got[:2] {
+		if len(c.Body) != 0 {
+			t.Errorf("stored API Call has %d byte body, want none", len(c.Body))
+		}
+		if !bytes.Equal(c.BodyHash, want[:]) {
+			t.Errorf("stored API Call has BodyHash %x, want %s", c.BodyHash, want)
+		}
+	}
+	if got[2].BodyHash != nil {
+		t.Errorf("stored API Call without body has BodyHash %x, want nil", got[2].BodyHash)
+	}
+
+	stored, err := s.Get(ctx, want)
+	if err != nil {
+		t.Fatalf("Get(%s) = _, %s", want, err)
+	}
+	if !bytes.Equal(stored, body) {
+		t.Errorf("Get(%s) = %q, want %q", want, stored, body)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := newFileStore(t)
+	defer cleanup()
+	mem := memory.New()
+	w := blob.NewAPICallWriter(s, mem)
+
+	referenced := []byte("referenced")
+	if err := w.WriteAPICall(ctx, l, apicall.New(ct.GetRootsStr, &client.HTTPData{Body: referenced}, nil)); err != nil {
+		t.Fatalf("WriteAPICall() = %s", err)
+	}
+	unreferenced, err := s.Put(ctx, []byte("unreferenced"))
+	if err != nil {
+		t.Fatalf("Put() = _, %s", err)
+	}
+
+	// Nothing is old enough to be collected.
+	if n, err := blob.Collect(ctx, s, []blob.Referencer{mem}, time.Hour); err != nil || n != 0 {
+		t.Errorf("Collect(minAge=1h) = %d, %v, want 0, nil", n, err)
+	}
+	if n, err := blob.Collect(ctx, s, []blob.Referencer{mem}, -time.Hour); err != nil || n != 1 {
+		t.Errorf("Collect(minAge=-1h) = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := s.Get(ctx, unreferenced); err != blob.ErrNotFound {
+		t.Errorf("Get(unreferenced) after Collect = _, %v, want %v", err, blob.ErrNotFound)
+	}
+	if _, err := s.Get(ctx, blob.HashOf(referenced)); err != nil {
+		t.Errorf("Get(referenced) after Collect = _, %v, want nil", err)
+	}
+}
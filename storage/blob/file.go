@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore is a Store that keeps each blob in its own file, named after the
+// hex-encoded Hash of the blob and placed in a subdirectory named after the
+// first byte of the Hash.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore that keeps blobs in dir, which must already
+// exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(h Hash) string {
+	name := h.String()
+	return filepath.Join(s.dir, name[:2], name)
+}
+
+// Put stores data in a file, unless a file for it already exists, in which
+// case the file's modification time is updated so that the blob is not
+// collected before the record referring to it again has been written.  The
+// file is written to a temporary location and then renamed into place, so a
+// partially written blob is never visible.
+func (s *FileStore) Put(ctx context.Context, data []byte) (Hash, error) {
+	h := HashOf(data)
+	p := s.path(h)
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err == nil {
+		return h, nil
+	} else if !os.IsNotExist(err) {
+		return h, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return h, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return h, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return h, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return h, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return h, err
+	}
+	return h, nil
+}
+
+// Get returns the content of the file for the blob with the given Hash.
+func (s *FileStore) Get(ctx context.Context, h Hash) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path(h))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete removes the file for the blob with the given Hash.
+func (s *FileStore) Delete(ctx context.Context, h Hash) error {
+	if err := os.Remove(s.path(h)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List calls fn for every blob file, using the file's modification time as the
+// time the blob was stored.
+func (s *FileStore) List(ctx context.Context, fn func(h Hash, storedAt time.Time) error) error {
+	subdirs, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, sd := range subdirs {
+		if !sd.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.dir, sd.Name()))
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			h, err := ParseHash(f.Name())
+			if err != nil {
+				// Not a blob, e.g. a temporary file.
+				continue
+			}
+			if err := fn(h, f.ModTime()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
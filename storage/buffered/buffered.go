@@ -0,0 +1,295 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package buffered provides a wrapper around the storage needed by the
+// collector that queues writes and performs them in the background, so that
+// the time taken to store data does not affect the timing of requests to the
+// Log being monitored.
+package buffered
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/collector"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/trillian/monitoring"
+)
+
+const (
+	// DefaultQueueSize is the number of writes that can be queued if no
+	// QueueSize is configured.
+	DefaultQueueSize = 1000
+	// DefaultBatchSize is the maximum number of queued writes performed
+	// together if no BatchSize is configured.
+	DefaultBatchSize = 100
+)
+
+var (
+	// ErrQueueFull is returned by writes that were dropped because the queue
+	// stayed full for longer than the configured MaxBlock.
+	ErrQueueFull = errors.New("storage write queue full, write dropped")
+	// ErrClosed is returned by writes made after Close has been called.
+	ErrClosed = errors.New("storage closed")
+)
+
+var (
+	once          sync.Once
+	queueDepth    monitoring.Gauge
+	droppedWrites monitoring.Counter
+	failedWrites  monitoring.Counter
+	queueWait     monitoring.Histogram
+	batchSize     monitoring.Histogram
+)
+
+func setupMetrics(mf monitoring.MetricFactory) {
+	queueDepth = mf.NewGauge("storage_queue_depth", "Number of writes waiting in the storage write queue")
+	droppedWrites = mf.NewCounter("storage_dropped_writes", "Number of writes dropped because the storage write queue was full", "kind")
+	failedWrites = mf.NewCounter("storage_failed_writes", "Number of queued writes that returned an error", "kind")
+	queueWait = mf.NewHistogram("storage_queue_wait_seconds", "Time each write spent in the storage write queue before being performed", "kind")
+	batchSize = mf.NewHistogram("storage_batch_size", "Number of queued writes performed together in each batch")
+}
+
+// Batcher is implemented by storage that can perform several writes more
+// efficiently together than one at a time, e.g. in a single transaction or a
+// single write to a file.
+type Batcher interface {
+	// Batch calls fn, which performs a batch of writes, and completes them
+	// together.  Errors that can only be detected once the writes are
+	// completed are returned by Batch.
+	Batch(ctx context.Context, fn func() error) error
+}
+
+// Options configures a Storage.
+type Options struct {
+	// QueueSize is the maximum number of writes that can be waiting to be
+	// performed.  If 0, DefaultQueueSize is used.
+	QueueSize int
+	// MaxBlock is how long a write waits for space in a full queue before
+	// being dropped.  If 0, writes to a full queue are dropped immediately.
+	MaxBlock time.Duration
+	// BatchSize is the maximum number of queued writes that are performed
+	// together.  Whatever is waiting in the queue, up to BatchSize writes, is
+	// performed as one batch, inside a call to Batch if the underlying storage
+	// is a Batcher.  If 0, DefaultBatchSize is used.
+	BatchSize int
+	// MetricFactory is used to create the metrics reported by every Storage.
+	// Only the MetricFactory passed to the first call to New is used.  If
+	// nil, monitoring.InertMetricFactory is used.
+	MetricFactory monitoring.MetricFactory
+}
+
+// write is a single queued write.
+type write struct {
+	kind     string
+	l        *ctlog.Log
+	fn       func(ctx context.Context) error
+	queuedAt time.Time
+}
+
+// Storage implements collector.Storage by queueing each write and performing
+// it later, in a background goroutine, on another collector.Storage.
+//
+// Write methods return as soon as the write is queued, so a nil error does not
+// mean that the write succeeded; errors from the underlying storage are logged
+// and counted instead.  Close must be called to ensure queued writes are
+// performed.
+type Storage struct {
+	next      collector.Storage
+	maxBlock  time.Duration
+	batchSize int
+
+	// mu guards closed and the adding of writers to pending.  It is not held
+	// while a write waits for space in the queue, so Close is never held up
+	// by a blocked write; instead, closing is closed to make blocked writes
+	// give up, and Close waits for pending before closing the queue.
+	mu      sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+	closing chan struct{}
+	queue   chan *write
+	done    chan struct{}
+}
+
+// New returns a Storage that performs writes on next, and starts the goroutine
+// that does so.
+func New(next collector.Storage, opts Options) *Storage {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	mf := opts.MetricFactory
+	if mf == nil {
+		mf = monitoring.InertMetricFactory{}
+	}
+	once.Do(func() { setupMetrics(mf) })
+
+	s := &Storage{
+		next:      next,
+		maxBlock:  opts.MaxBlock,
+		batchSize: opts.BatchSize,
+		closing:   make(chan struct{}),
+		queue:     make(chan *write, opts.QueueSize),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// WriteAPICall queues a write of apiCall.
+func (s *Storage) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	return s.enqueue(ctx, &write{kind: "api_call", l: l, fn: func(ctx context.Context) error {
+		return s.next.WriteAPICall(ctx, l, apiCall)
+	}})
+}
+
+// WriteSTH queues a write of sth and errs.
+func (s *Storage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error {
+	return s.enqueue(ctx, &write{kind: "sth", l: l, fn: func(ctx context.Context) error {
+		return s.next.WriteSTH(ctx, l, sth, receivedAt, errs)
+	}})
+}
+
+// WriteRoots queues a write of roots.
+func (s *Storage) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	return s.enqueue(ctx, &write{kind: "roots", l: l, fn: func(ctx context.Context) error {
+		return s.next.WriteRoots(ctx, l, roots, receivedAt)
+	}})
+}
+
+// WriteSCT queues a write of sct, chain and errs.
+func (s *Storage) WriteSCT(ctx context.Context, l *ctlog.Log, sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, receivedAt time.Time, errs []error) error {
+	return s.enqueue(ctx, &write{kind: "sct", l: l, fn: func(ctx context.Context) error {
+		return s.next.WriteSCT(ctx, l, sct, chain, receivedAt, errs)
+	}})
+}
+
+// Close stops any more writes from being queued, and waits for those already
+// queued to be performed.  Writes waiting for space in a full queue are
+// dropped and return ErrClosed.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.done
+		return nil
+	}
+	s.closed = true
+	close(s.closing)
+	s.mu.Unlock()
+
+	// No more writers can be added to pending, and those already waiting
+	// give up now that closing is closed.
+	s.pending.Wait()
+	close(s.queue)
+	<-s.done
+	return nil
+}
+
+func (s *Storage) enqueue(ctx context.Context, w *write) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.pending.Add(1)
+	s.mu.Unlock()
+	defer s.pending.Done()
+
+	w.queuedAt = time.Now()
+	select {
+	case s.queue <- w:
+		queueDepth.Set(float64(len(s.queue)))
+		return nil
+	default:
+	}
+	if s.maxBlock > 0 {
+		t := time.NewTimer(s.maxBlock)
+		defer t.Stop()
+		select {
+		case s.queue <- w:
+			queueDepth.Set(float64(len(s.queue)))
+			return nil
+		case <-s.closing:
+			droppedWrites.Inc(w.kind)
+			return ErrClosed
+		case <-t.C:
+		case <-ctx.Done():
+		}
+	}
+	droppedWrites.Inc(w.kind)
+	return ErrQueueFull
+}
+
+// run performs queued writes, in the order they were queued, until the queue
+// is closed and empty.  Each write is performed in a batch along with those
+// queued behind it, up to batchSize writes.
+func (s *Storage) run() {
+	defer close(s.done)
+	// Writes outlive the contexts of the calls that queued them, so are
+	// performed with a context of their own.
+	ctx := context.Background()
+	for w := range s.queue {
+		batch := []*write{w}
+	drain:
+		for len(batch) < s.batchSize {
+			select {
+			case w, ok := <-s.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+		queueDepth.Set(float64(len(s.queue)))
+		s.performBatch(ctx, batch)
+	}
+}
+
+// performBatch performs the writes in batch, together if the underlying
+// storage is a Batcher.
+func (s *Storage) performBatch(ctx context.Context, batch []*write) {
+	batchSize.Observe(float64(len(batch)))
+	perform := func() error {
+		for _, w := range batch {
+			queueWait.Observe(time.Since(w.queuedAt).Seconds(), w.kind)
+			if err := w.fn(ctx); err != nil {
+				failedWrites.Inc(w.kind)
+				glog.Errorf("%s: error performing queued %s write: %s", w.l.URL, w.kind, err)
+			}
+		}
+		return nil
+	}
+	b, ok := s.next.(Batcher)
+	if !ok {
+		perform()
+		return
+	}
+	if err := b.Batch(ctx, perform); err != nil {
+		for _, w := range batch {
+			failedWrites.Inc(w.kind)
+		}
+		glog.Errorf("error completing batch of %d queued writes: %s", len(batch), err)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package buffered
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/collector"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/trillian/monitoring"
+)
+
+var l = &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+
+// blockingStorage is a collector.Storage whose WriteSTH blocks until unblock
+// is closed.
+type blockingStorage struct {
+	*memory.Storage
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func newBlockingStorage() *blockingStorage {
+	return &blockingStorage{Storage: memory.New(), started: make(chan struct{}, 100), unblock: make(chan struct{})}
+}
+
+func (b *blockingStorage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error {
+	b.started <- struct{}{}
+	<-b.unblock
+	return b.Storage.WriteSTH(ctx, l, sth, receivedAt, errs)
+}
+
+// failingStorage is a collector.Storage whose WriteAPICall always fails.
+type failingStorage struct {
+	*memory.Storage
+}
+
+func (failingStorage) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	return errors.New("database unavailable")
+}
+
+func metricValue(t *testing.T, m interface{}, labels ...string) float64 {
+	t.Helper()
+	f, ok := m.(*monitoring.InertFloat)
+	if !ok {
+		t.Fatalf("metric is %T, want *monitoring.InertFloat", m)
+	}
+	return f.Value(labels...)
+}
+
+func writeSTHs(ctx context.Context, t *testing.T, st collector.Storage, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{TreeSize: uint64(i)}, time.Now(), nil); err != nil {
+			t.Fatalf("WriteSTH(%d) = %s", i, err)
+		}
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	ctx := context.Background()
+	mem := memory.New()
+	st := New(mem, Options{QueueSize: 20})
+	writeSTHs(ctx, t, st, 10)
+	if err := st.WriteAPICall(ctx, l, apicall.New(ct.GetSTHStr, nil, nil)); err != nil {
+		t.Fatalf("WriteAPICall() = %s", err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	sths := mem.STHs(l.Name)
+	if len(sths) != 10 {
+		t.Fatalf("stored %d STHs, want 10", len(sths))
+	}
+	for i, sth := range sths {
+		if sth.STH.TreeSize != uint64(i) {
+			t.Errorf("STH %d has tree size %d, want %d", i, sth.STH.TreeSize, i)
+		}
+	}
+	if got := len(mem.APICalls(l.Name)); got != 1 {
+		t.Errorf("stored %d API Calls, want 1", got)
+	}
+	if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{}, time.Now(), nil); err != ErrClosed {
+		t.Errorf("WriteSTH() after Close() = %v, want %v", err, ErrClosed)
+	}
+	if err := st.Close(); err != nil {
+		t.Errorf("second Close() = %s", err)
+	}
+}
+
+func TestWritesDoNotWaitForStorage(t *testing.T) {
+	ctx := context.Background()
+	bs := newBlockingStorage()
+	st := New(bs, Options{QueueSize: 5})
+
+	// The first write is taken off the queue and blocks in the underlying
+	// storage, leaving room for the queue to fill.
+	writeSTHs(ctx, t, st, 1)
+	<-bs.started
+	start := time.Now()
+	writeSTHs(ctx, t, st, 5)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("queueing writes took %s", d)
+	}
+	if got := metricValue(t, queueDepth); got != 5 {
+		t.Errorf("queue depth = %v, want 5", got)
+	}
+
+	close(bs.unblock)
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	if got := len(bs.STHs(l.Name)); got != 6 {
+		t.Errorf("stored %d STHs, want 6", got)
+	}
+	if got := metricValue(t, queueDepth); got != 0 {
+		t.Errorf("queue depth after Close() = %v, want 0", got)
+	}
+}
+
+func TestFullQueueDrops(t *testing.T) {
+	ctx := context.Background()
+	bs := newBlockingStorage()
+	st := New(bs, Options{QueueSize: 2, MaxBlock: 10 * time.Millisecond})
+	defer func() {
+		close(bs.unblock)
+		st.Close()
+	}()
+
+	writeSTHs(ctx, t, st, 1)
+	<-bs.started
+	writeSTHs(ctx, t, st, 2)
+
+	dropped := metricValue(t, droppedWrites, "sth")
+	start := time.Now()
+	if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{}, time.Now(), nil); err != ErrQueueFull {
+		t.Errorf("WriteSTH() to full queue = %v, want %v", err, ErrQueueFull)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("WriteSTH() to full queue returned after %s, want at least MaxBlock", d)
+	}
+	if got, want := metricValue(t, droppedWrites, "sth"), dropped+1; got != want {
+		t.Errorf("dropped writes = %v, want %v", got, want)
+	}
+
+	// A write that is waiting for space gives up when its context is done.
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	st.maxBlock = time.Hour
+	if err := st.WriteSTH(cctx, l, &ct.SignedTreeHead{}, time.Now(), nil); err != ErrQueueFull {
+		t.Errorf("WriteSTH() with cancelled context = %v, want %v", err, ErrQueueFull)
+	}
+}
+
+func TestCloseDoesNotWaitForBlockedWrites(t *testing.T) {
+	ctx := context.Background()
+	bs := newBlockingStorage()
+	st := New(bs, Options{QueueSize: 1, MaxBlock: time.Hour})
+
+	writeSTHs(ctx, t, st, 1)
+	<-bs.started
+	writeSTHs(ctx, t, st, 1)
+
+	// The queue is full, so this write waits for up to MaxBlock.
+	blocked := make(chan error)
+	go func() {
+		blocked <- st.WriteSTH(ctx, l, &ct.SignedTreeHead{}, time.Now(), nil)
+	}()
+	// Give the write time to start waiting.
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error)
+	go func() { closed <- st.Close() }()
+	select {
+	case err := <-blocked:
+		if err != ErrClosed {
+			t.Errorf("blocked WriteSTH() = %v, want %v", err, ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked WriteSTH() did not return after Close()")
+	}
+
+	close(bs.unblock)
+	if err := <-closed; err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	if got := len(bs.STHs(l.Name)); got != 2 {
+		t.Errorf("stored %d STHs, want 2", got)
+	}
+}
+
+func TestFailedWritesCounted(t *testing.T) {
+	ctx := context.Background()
+	st := New(failingStorage{memory.New()}, Options{})
+	failed := metricValue(t, failedWrites, "api_call")
+	if err := st.WriteAPICall(ctx, l, apicall.New(ct.GetSTHStr, nil, nil)); err != nil {
+		t.Fatalf("WriteAPICall() = %s, want nil as the write is queued", err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	if got, want := metricValue(t, failedWrites, "api_call"), failed+1; got != want {
+		t.Errorf("failed writes = %v, want %v", got, want)
+	}
+}
+
+// batchingStorage is a blockingStorage that is a Batcher, and records the
+// number of writes performed in each batch.
+type batchingStorage struct {
+	*blockingStorage
+	batches []int
+	writes  int
+}
+
+func (b *batchingStorage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error {
+	b.writes++
+	return b.blockingStorage.WriteSTH(ctx, l, sth, receivedAt, errs)
+}
+
+func (b *batchingStorage) Batch(ctx context.Context, fn func() error) error {
+	b.writes = 0
+	err := fn()
+	b.batches = append(b.batches, b.writes)
+	return err
+}
+
+func TestBurstWrittenInBatches(t *testing.T) {
+	ctx := context.Background()
+	bs := &batchingStorage{blockingStorage: newBlockingStorage()}
+	st := New(bs, Options{QueueSize: 100, BatchSize: 4})
+
+	// Hold up the first write, so that a burst of writes queues up behind
+	// it.
+	writeSTHs(ctx, t, st, 1)
+	<-bs.started
+	writeSTHs(ctx, t, st, 9)
+	close(bs.unblock)
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	want := []int{1, 4, 4, 1}
+	if len(bs.batches) != len(want) {
+		t.Fatalf("batch sizes = %v, want %v", bs.batches, want)
+	}
+	for i := range want {
+		if bs.batches[i] != want[i] {
+			t.Fatalf("batch sizes = %v, want %v", bs.batches, want)
+		}
+	}
+	if got := len(bs.STHs(l.Name)); got != 10 {
+		t.Errorf("stored %d STHs, want 10", got)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The compactor applies a data retention policy to everything stored about a
+// Log by the datacollector, deleting or downsampling old records.
+package main
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/schedule"
+	"github.com/google/monologue/ctlog"
+	incidentmysql "github.com/google/monologue/incident/mysql"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/file"
+	"github.com/google/monologue/storage/mysql"
+	"github.com/google/monologue/storage/retention"
+)
+
+var (
+	logURL  = flag.String("log_url", "", "The URL of the Log whose records should be compacted, e.g. https://ct.googleapis.com/pilot/")
+	logName = flag.String("log_name", "", "The name of the Log whose records should be compacted, as given to the datacollector")
+
+	storageDir      = flag.String("storage_dir", "", "Directory of JSON Lines files to compact, as written by the datacollector")
+	storagePrefix   = flag.String("storage_prefix", "", "Prefix of the files in storage_dir to compact. If unset, log_name is used")
+	storageCompress = flag.Bool("storage_compress", true, "Whether the datacollector gzips files in storage_dir once they have been rotated, as set by its own storage_compress flag. If so, only gzipped files are compacted, so that no file is compacted while it is being compressed")
+	mysqlURI        = flag.String("mysql_uri", "", "URI of a MySQL database holding root sets and incidents (parseTime=true is always set). If set, root set observations are compacted, and records near any incident for the Log are kept")
+	holdMargin      = flag.Duration("hold_margin", 24*time.Hour, "How long before an unresolved incident for the Log was first seen that its records are kept, regardless of the retention policy")
+	period          = flag.Duration("period", 0, "How regularly to compact. If 0, compact once and exit")
+
+	blobDir    = flag.String("blob_dir", "", "Directory of response bodies stored by the datacollector. If set, after compacting, bodies no longer referred to by any file in storage_dir, or by the evidence of any incident in mysql_uri, are deleted")
+	blobMinAge = flag.Duration("blob_min_age", 24*time.Hour, "How long a response body must have been stored before it can be deleted, so that bodies whose records have not been written yet are kept")
+
+	apiCallsRetention = flag.String("api_calls_retention", "720h/1h/8760h", "Retention rule for API calls, as RAW[/BUCKET/AGGREGATE] durations, or forever")
+	sthsRetention     = flag.String("sths_retention", "forever", "Retention rule for STHs, as RAW[/BUCKET/AGGREGATE] durations, or forever")
+	sctsRetention     = flag.String("scts_retention", "forever", "Retention rule for SCTs, as RAW[/BUCKET/AGGREGATE] durations, or forever")
+	rootsRetention    = flag.String("roots_retention", "forever", "Retention rule for root set observations, as RAW[/BUCKET/AGGREGATE] durations, or forever")
+)
+
+func mustParseRule(name, s string) retention.Rule {
+	r, err := retention.ParseRule(s)
+	if err != nil {
+		glog.Exitf("Invalid %s: %s", name, err)
+	}
+	return r
+}
+
+func main() {
+	flag.Parse()
+	if *logURL == "" {
+		glog.Exit("No Log URL provided.")
+	}
+	if *logName == "" {
+		glog.Exit("No Log name provided.")
+	}
+	if *storageDir == "" && *mysqlURI == "" {
+		glog.Exit("Neither storage_dir nor mysql_uri provided.")
+	}
+	if *blobDir != "" && *storageDir == "" {
+		glog.Exit("blob_dir requires storage_dir, which holds the records that refer to the blobs.")
+	}
+
+	ctx := context.Background()
+	l := &ctlog.Log{Name: *logName, URL: *logURL}
+	p := retention.Policy{
+		APICalls: mustParseRule("api_calls_retention", *apiCallsRetention),
+		STHs:     mustParseRule("sths_retention", *sthsRetention),
+		SCTs:     mustParseRule("scts_retention", *sctsRetention),
+		Roots:    mustParseRule("roots_retention", *rootsRetention),
+	}
+
+	var holds retention.Holds = retention.NoHolds{}
+	var compactors []retention.Compactor
+	var refs []blob.Referencer
+	if *storageDir != "" {
+		prefix := *storagePrefix
+		if prefix == "" {
+			prefix = l.Name
+		}
+		fc := file.NewCompactor(*storageDir, prefix)
+		fc.Compressed = *storageCompress
+		compactors = append(compactors, fc)
+		// Blobs may be shared by the files of every Log in storage_dir, not
+		// only those being compacted.
+		refs = append(refs, file.NewReferencer(*storageDir))
+	}
+	if *mysqlURI != "" {
+		db, err := mysql.Open(*mysqlURI)
+		if err != nil {
+			glog.Exitf("Unable to open MySQL database: %s", err)
+		}
+		defer db.Close()
+		holds = incidentmysql.NewEvidenceHolds(ctx, db, *holdMargin)
+		compactors = append(compactors, mysql.NewCompactor(ctx, db))
+		refs = append(refs, incidentmysql.NewEvidenceBlobRefs(ctx, db))
+	}
+	var blobs blob.Store
+	if *blobDir != "" {
+		var err error
+		if blobs, err = blob.NewFileStore(*blobDir); err != nil {
+			glog.Exitf("Unable to open blob storage: %s", err)
+		}
+	}
+
+	compact := func(ctx context.Context) {
+		now := time.Now().UTC()
+		for _, c := range compactors {
+			if _, err := c.Compact(ctx, l, p, holds, now); err != nil {
+				glog.Errorf("%s: compaction failed: %s", l.Name, err)
+			}
+		}
+		if blobs != nil {
+			if _, err := blob.Collect(ctx, blobs, refs, *blobMinAge); err != nil {
+				glog.Errorf("%s: blob collection failed: %s", l.Name, err)
+			}
+		}
+	}
+	if *period <= 0 {
+		compact(ctx)
+		return
+	}
+	schedule.Every(ctx, *period, compact)
+}
@@ -0,0 +1,404 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/retention"
+)
+
+// Compactor applies a retention.Policy to the files written by a Storage,
+// rewriting each file without the records that the Policy says should be
+// deleted, and with aggregates in place of those that should be aggregated.
+//
+// The most recently created file is never compacted, as a Storage may still be
+// writing to it, and nor is a file that is being compressed.
+type Compactor struct {
+	dir, prefix string
+
+	// Compressed is whether the Storage compresses its files once they have
+	// been rotated.  If set, only compressed files are compacted, as an
+	// uncompressed file is either being written or about to be compressed.
+	Compressed bool
+}
+
+// NewCompactor returns a Compactor for the files in dir written by a Storage
+// using the given prefix.  If prefix is empty, DefaultPrefix is used.
+func NewCompactor(dir, prefix string) *Compactor {
+	return &Compactor{dir: dir, prefix: prefix}
+}
+
+// Compact implements retention.Compactor.  Records about Logs other than l are
+// left untouched.
+func (c *Compactor) Compact(ctx context.Context, l *ctlog.Log, p retention.Policy, holds retention.Holds, now time.Time) (retention.Stats, error) {
+	var stats retention.Stats
+	files, err := Files(c.dir, c.prefix)
+	if err != nil {
+		return stats, err
+	}
+	files, compactable := c.settledFiles(files)
+	if len(compactable) == 0 {
+		return stats, nil
+	}
+	windows, err := holds.Holds(ctx, l)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get evidence holds for %s: %s", l.Name, err)
+	}
+	latestRoots, err := latestRootsTime(files, l)
+	if err != nil {
+		return stats, err
+	}
+
+	cp := &compaction{l: l, p: p, holds: windows, now: now, latestRoots: latestRoots}
+	for _, f := range compactable {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		s, err := cp.compactFile(f)
+		if err != nil {
+			return stats, fmt.Errorf("failed to compact %s: %s", f, err)
+		}
+		stats.Add(s)
+	}
+	glog.Infof("%s: compacted %s: %+v", l.Name, c.dir, stats)
+	return stats, nil
+}
+
+// settledFiles returns files without the compressed copies of files that are
+// still being compressed, which are incomplete, and the files among them that
+// can be compacted: every one but the most recently created, and those being
+// compressed.  If the Compactor is Compressed, uncompressed files are not
+// compacted either.
+func (c *Compactor) settledFiles(files []string) (settled, compactable []string) {
+	listed := make(map[string]bool)
+	for _, f := range files {
+		listed[f] = true
+	}
+	for _, f := range files {
+		if !(strings.HasSuffix(f, ".gz") && listed[strings.TrimSuffix(f, ".gz")]) {
+			settled = append(settled, f)
+		}
+	}
+	if len(settled) == 0 {
+		return settled, nil
+	}
+	for _, f := range settled[:len(settled)-1] {
+		compressed := strings.HasSuffix(f, ".gz")
+		if compressed || (!c.Compressed && !listed[f+".gz"]) {
+			compactable = append(compactable, f)
+		}
+	}
+	return settled, compactable
+}
+
+// latestRootsTime returns the time of the most recent roots record about l,
+// which is never deleted.
+func latestRootsTime(files []string, l *ctlog.Log) (time.Time, error) {
+	var latest time.Time
+	r := NewReader(files)
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return latest, nil
+		}
+		if err != nil {
+			return latest, err
+		}
+		if rec.Type == RootsRecord && rec.LogName == l.Name && rec.Time.After(latest) {
+			latest = rec.Time
+		}
+	}
+}
+
+type compaction struct {
+	l           *ctlog.Log
+	p           retention.Policy
+	holds       []retention.Window
+	now         time.Time
+	latestRoots time.Time
+}
+
+// aggKey identifies the Aggregate that a record is folded into.
+type aggKey struct {
+	recordType RecordType
+	endpoint   string
+	start      time.Time
+}
+
+// rootsKey identifies the single roots record kept for each distinct set of
+// roots in a bucket.
+type rootsKey struct {
+	setID storage.RootSetID
+	start time.Time
+}
+
+func (cp *compaction) rule(t RecordType) (retention.Rule, bool) {
+	switch t {
+	case APICallRecord:
+		return cp.p.APICalls, true
+	case STHRecord:
+		return cp.p.STHs, true
+	case SCTRecord:
+		return cp.p.SCTs, true
+	case RootsRecord:
+		return cp.p.Roots, true
+	}
+	return retention.Rule{}, false
+}
+
+// compactFile rewrites the file called name, if applying the Policy to it
+// changes anything.
+func (cp *compaction) compactFile(name string) (retention.Stats, error) {
+	var stats retention.Stats
+	r := NewReader([]string{name})
+	defer r.Close()
+
+	var out []*Record
+	aggs := make(map[aggKey]*Record)
+	roots := make(map[rootsKey]bool)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		if rec.LogName != cp.l.Name {
+			out = append(out, rec)
+			continue
+		}
+
+		if rec.Type == AggregateRecord {
+			if rec.Aggregate == nil {
+				return stats, fmt.Errorf("aggregate record at %s has no aggregate", rec.Time)
+			}
+			rule, _ := cp.rule(rec.Aggregate.RecordType)
+			if !rule.KeepAggregate(rec.Time, cp.now) {
+				stats.Deleted++
+				continue
+			}
+			k := aggKey{recordType: rec.Aggregate.RecordType, endpoint: string(rec.Aggregate.Endpoint), start: rec.Time}
+			if agg, ok := aggs[k]; ok {
+				// Merging an aggregate left by an earlier compaction.
+				mergeAggregate(agg.Aggregate, rec.Aggregate)
+				stats.Deleted++
+				continue
+			}
+			aggs[k] = rec
+			out = append(out, rec)
+			continue
+		}
+
+		rule, ok := cp.rule(rec.Type)
+		if !ok {
+			return stats, fmt.Errorf("unknown record type %q", rec.Type)
+		}
+		action := rule.Apply(rec.Time, cp.now)
+		if action != retention.Keep && retention.Held(cp.holds, rec.Time) {
+			stats.Held++
+			action = retention.Keep
+		}
+		if rec.Type == RootsRecord && !rec.Time.Before(cp.latestRoots) {
+			action = retention.Keep
+		}
+
+		switch action {
+		case retention.Keep:
+			out = append(out, rec)
+		case retention.Delete:
+			stats.Deleted++
+		case retention.Aggregate:
+			start := rule.BucketStart(rec.Time)
+			if rec.Type == RootsRecord {
+				// Downsample by keeping the first observation of each set
+				// of roots in each bucket.
+				certs, err := Certificates(rec.Roots)
+				if err != nil {
+					return stats, err
+				}
+				setID, err := rootsanalyzer.GenerateSetID(certs)
+				if err != nil {
+					return stats, err
+				}
+				k := rootsKey{setID: setID, start: start}
+				if roots[k] {
+					stats.Deleted++
+					continue
+				}
+				roots[k] = true
+				out = append(out, rec)
+				continue
+			}
+
+			k := aggKey{recordType: rec.Type, start: start}
+			if rec.APICall != nil {
+				k.endpoint = string(rec.APICall.Endpoint)
+			}
+			agg, ok := aggs[k]
+			if !ok {
+				agg = &Record{
+					Type:      AggregateRecord,
+					LogName:   rec.LogName,
+					LogURL:    rec.LogURL,
+					Time:      start,
+					Aggregate: &Aggregate{RecordType: rec.Type, Bucket: rule.Bucket, Endpoint: ct.APIEndpoint(k.endpoint)},
+				}
+				aggs[k] = agg
+				out = append(out, agg)
+			}
+			addToAggregate(agg.Aggregate, rec)
+			stats.Aggregated++
+		}
+	}
+	if err := r.Close(); err != nil {
+		return stats, err
+	}
+
+	if stats.Aggregated == 0 && stats.Deleted == 0 {
+		return stats, nil
+	}
+	if len(out) == 0 {
+		return stats, os.Remove(name)
+	}
+	return stats, rewriteFile(name, out)
+}
+
+func addToAggregate(agg *Aggregate, rec *Record) {
+	agg.Count++
+	switch {
+	case rec.APICall != nil:
+		a := rec.APICall
+		if a.Err != "" || (a.StatusCode != 0 && a.StatusCode != http.StatusOK) {
+			agg.Errors++
+		}
+		if a.StatusCode != 0 {
+			if agg.StatusCodes == nil {
+				agg.StatusCodes = make(map[int]int)
+			}
+			agg.StatusCodes[a.StatusCode]++
+		}
+		latency := a.End.Sub(a.Start)
+		agg.TotalLatency += latency
+		if latency > agg.MaxLatency {
+			agg.MaxLatency = latency
+		}
+	case rec.STH != nil:
+		if len(rec.Errors) > 0 {
+			agg.Errors++
+		}
+		if agg.Count == 1 || rec.STH.TreeSize < agg.MinTreeSize {
+			agg.MinTreeSize = rec.STH.TreeSize
+		}
+		if rec.STH.TreeSize > agg.MaxTreeSize {
+			agg.MaxTreeSize = rec.STH.TreeSize
+		}
+	default:
+		if len(rec.Errors) > 0 {
+			agg.Errors++
+		}
+	}
+}
+
+// mergeAggregate adds the counts in o, which covers the same bucket, to agg.
+func mergeAggregate(agg, o *Aggregate) {
+	if o.Count > 0 && (agg.Count == 0 || o.MinTreeSize < agg.MinTreeSize) {
+		agg.MinTreeSize = o.MinTreeSize
+	}
+	if o.MaxTreeSize > agg.MaxTreeSize {
+		agg.MaxTreeSize = o.MaxTreeSize
+	}
+	if o.MaxLatency > agg.MaxLatency {
+		agg.MaxLatency = o.MaxLatency
+	}
+	agg.Count += o.Count
+	agg.Errors += o.Errors
+	agg.TotalLatency += o.TotalLatency
+	for code, n := range o.StatusCodes {
+		if agg.StatusCodes == nil {
+			agg.StatusCodes = make(map[int]int)
+		}
+		agg.StatusCodes[code] += n
+	}
+}
+
+// rewriteFile replaces the file called name with one containing recs,
+// compressed if the original was.  The new file is written to a temporary
+// location and then renamed into place, so that the file is never partially
+// written.
+func rewriteFile(name string, recs []*Record) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".compact-")
+	if err != nil {
+		return err
+	}
+	if err := writeRecords(tmp, recs, strings.HasSuffix(name, ".gz")); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func writeRecords(w io.Writer, recs []*Record, compress bool) error {
+	bw := bufio.NewWriter(w)
+	var out io.Writer = bw
+	var zw *gzip.Writer
+	if compress {
+		zw = gzip.NewWriter(bw)
+		out = zw
+	}
+	enc := json.NewEncoder(out)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return fmt.Errorf("failed to write %s record: %s", rec.Type, err)
+		}
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,229 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/retention"
+)
+
+type fakeHolds []retention.Window
+
+func (h fakeHolds) Holds(ctx context.Context, l *ctlog.Log) ([]retention.Window, error) {
+	return h, nil
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() time.Time) { timeNowUTC = f }(timeNowUTC)
+	now := start
+	timeNowUTC = func() time.Time { return now }
+	day := 24 * time.Hour
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	st, err := New(Options{Dir: dir, MaxAge: day, Compress: true})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+	other := &ctlog.Log{Name: "aviator", URL: "https://ct.googleapis.com/aviator/"}
+
+	writeAPICall := func(l *ctlog.Log, ep ct.APIEndpoint, latency time.Duration, status int) {
+		t.Helper()
+		ac := apicall.New(ep, &client.HTTPData{
+			Timing:   client.Timing{Start: now, End: now.Add(latency)},
+			Response: &http.Response{StatusCode: status},
+		}, nil)
+		if err := st.WriteAPICall(ctx, l, ac); err != nil {
+			t.Fatalf("WriteAPICall() = %s", err)
+		}
+	}
+	writeSTH := func(size uint64) {
+		t.Helper()
+		if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{TreeSize: size}, now, nil); err != nil {
+			t.Fatalf("WriteSTH() = %s", err)
+		}
+	}
+	writeRoots := func() {
+		t.Helper()
+		if err := st.WriteRoots(ctx, l, chain[1:], now); err != nil {
+			t.Fatalf("WriteRoots() = %s", err)
+		}
+	}
+
+	// Day 0: everything is old enough to be aggregated or deleted.
+	writeAPICall(l, ct.GetSTHStr, time.Second, http.StatusOK)
+	writeAPICall(other, ct.GetSTHStr, time.Second, http.StatusOK)
+	writeSTH(10)
+	writeRoots()
+	now = now.Add(10 * time.Minute)
+	writeAPICall(l, ct.GetSTHStr, 3*time.Second, http.StatusServiceUnavailable)
+	writeAPICall(l, ct.GetRootsStr, time.Second, http.StatusOK)
+	writeSTH(20)
+	writeRoots()
+	// Day 1: an STH within a hold.
+	now = start.Add(day)
+	writeSTH(30)
+	// Day 35: recent enough to be kept.
+	now = start.Add(35 * day)
+	writeAPICall(l, ct.GetSTHStr, time.Second, http.StatusOK)
+	// Day 39: the newest file, which is never compacted.
+	now = start.Add(39 * day)
+	writeSTH(40)
+	writeRoots()
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+	before := readAll(t, dir)
+
+	p := retention.Policy{
+		APICalls: retention.Rule{Raw: 30 * day, Bucket: time.Hour},
+		STHs:     retention.Rule{Raw: 30 * day},
+		Roots:    retention.Rule{Raw: 30 * day, Bucket: time.Hour},
+	}
+	holds := fakeHolds{{Start: start.Add(day - time.Hour), End: start.Add(day + time.Hour)}}
+	c := NewCompactor(dir, "")
+	now = start.Add(40 * day)
+
+	stats, err := c.Compact(ctx, l, p, holds, now)
+	if err != nil {
+		t.Fatalf("Compact() = _, %s", err)
+	}
+	if want := (retention.Stats{Aggregated: 3, Deleted: 3, Held: 1}); stats != want {
+		t.Errorf("Compact() = %+v, want %+v", stats, want)
+	}
+
+	want := []*Record{
+		{
+			Type: AggregateRecord, LogName: l.Name, LogURL: l.URL, Time: start,
+			Aggregate: &Aggregate{
+				RecordType: APICallRecord, Bucket: time.Hour, Endpoint: ct.GetSTHStr, Count: 2, Errors: 1,
+				StatusCodes:  map[int]int{http.StatusOK: 1, http.StatusServiceUnavailable: 1},
+				TotalLatency: 4 * time.Second, MaxLatency: 3 * time.Second,
+			},
+		},
+		before[1], // The other Log's API Call.
+		before[3], // The first observation of the roots.
+		{
+			Type: AggregateRecord, LogName: l.Name, LogURL: l.URL, Time: start,
+			Aggregate: &Aggregate{
+				RecordType: APICallRecord, Bucket: time.Hour, Endpoint: ct.GetRootsStr, Count: 1,
+				StatusCodes:  map[int]int{http.StatusOK: 1},
+				TotalLatency: time.Second, MaxLatency: time.Second,
+			},
+		},
+		before[8],  // The held STH.
+		before[9],  // The recent API Call.
+		before[10], // The newest file.
+		before[11],
+	}
+	if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("records after Compact(): diff (-got +want)\n%s", diff)
+	}
+
+	// Compacting again changes nothing.
+	stats, err = c.Compact(ctx, l, p, holds, now)
+	if err != nil {
+		t.Fatalf("Compact() = _, %s", err)
+	}
+	if want := (retention.Stats{Held: 1}); stats != want {
+		t.Errorf("second Compact() = %+v, want %+v", stats, want)
+	}
+	if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("records after second Compact(): diff (-got +want)\n%s", diff)
+	}
+
+	// Once aggregates expire, they are deleted, along with the files left
+	// empty, but the latest roots are always kept.
+	p.APICalls.Aggregate = 100 * day
+	p.Roots = retention.Rule{Raw: 30 * day}
+	now = start.Add(200 * day)
+	stats, err = c.Compact(ctx, l, retention.Policy{APICalls: p.APICalls, STHs: p.STHs, Roots: p.Roots}, retention.NoHolds{}, now)
+	if err != nil {
+		t.Fatalf("Compact() = _, %s", err)
+	}
+	if want := (retention.Stats{Deleted: 5}); stats != want {
+		t.Errorf("third Compact() = %+v, want %+v", stats, want)
+	}
+	want = []*Record{before[1], before[10], before[11]}
+	if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("records after third Compact(): diff (-got +want)\n%s", diff)
+	}
+	files, err := Files(dir, "")
+	if err != nil {
+		t.Fatalf("Files() = _, %s", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d files remain after third Compact(), want 2", len(files))
+	}
+}
+
+func TestSettledFiles(t *testing.T) {
+	for _, test := range []struct {
+		desc            string
+		compressed      bool
+		files           []string
+		wantSettled     []string
+		wantCompactable []string
+	}{
+		{
+			desc:            "uncompressed",
+			files:           []string{"m-1.jsonl", "m-2.jsonl", "m-3.jsonl"},
+			wantSettled:     []string{"m-1.jsonl", "m-2.jsonl", "m-3.jsonl"},
+			wantCompactable: []string{"m-1.jsonl", "m-2.jsonl"},
+		},
+		{
+			desc:            "being compressed",
+			files:           []string{"m-1.jsonl.gz", "m-2.jsonl", "m-2.jsonl.gz", "m-3.jsonl"},
+			wantSettled:     []string{"m-1.jsonl.gz", "m-2.jsonl", "m-3.jsonl"},
+			wantCompactable: []string{"m-1.jsonl.gz"},
+		},
+		{
+			desc:            "rotated and being compressed",
+			files:           []string{"m-1.jsonl.gz", "m-2.jsonl", "m-2.jsonl.gz"},
+			wantSettled:     []string{"m-1.jsonl.gz", "m-2.jsonl"},
+			wantCompactable: []string{"m-1.jsonl.gz"},
+		},
+		{
+			desc:            "about to be compressed",
+			compressed:      true,
+			files:           []string{"m-1.jsonl.gz", "m-2.jsonl", "m-3.jsonl"},
+			wantSettled:     []string{"m-1.jsonl.gz", "m-2.jsonl", "m-3.jsonl"},
+			wantCompactable: []string{"m-1.jsonl.gz"},
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			c := &Compactor{Compressed: test.compressed}
+			settled, compactable := c.settledFiles(test.files)
+			if diff := cmp.Diff(test.wantSettled, settled); diff != "" {
+				t.Errorf("settledFiles() settled diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantCompactable, compactable); diff != "" {
+				t.Errorf("settledFiles() compactable diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
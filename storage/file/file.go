@@ -0,0 +1,435 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package file provides an implementation of the storage interfaces needed by
+// the CT monitor that appends everything passed to it to files, as one JSON
+// object per line.
+//
+// Files are rotated when they reach a configured size or age, and may be
+// compressed once rotated.  The Reader in this package reads the files back,
+// and Replay can be used to copy their contents into any other storage.
+package file
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage/blob"
+)
+
+const (
+	// DefaultPrefix is the file name prefix used if none is configured.
+	DefaultPrefix = "monologue"
+
+	// Extension is the file name extension of files being written to.
+	Extension = ".jsonl"
+	// CompressedExtension is the file name extension of compressed files.
+	CompressedExtension = Extension + ".gz"
+
+	// timeFormat is used to put the time a file was created in its name, such
+	// that sorting file names sorts the files by creation time.
+	timeFormat = "20060102T150405.000000000Z"
+)
+
+var timeNowUTC = func() time.Time {
+	return time.Now().UTC()
+}
+
+// Options configures a Storage.
+type Options struct {
+	// Dir is the directory to write files to.  It must already exist.
+	Dir string
+	// Prefix is the prefix of the name of every file written.  If unset,
+	// DefaultPrefix is used.
+	Prefix string
+	// MaxBytes is the size beyond which a file will be rotated.  If 0, files
+	// are not rotated based on their size.
+	MaxBytes int64
+	// MaxAge is the age beyond which a file will be rotated.  If 0, files are
+	// not rotated based on their age.
+	MaxAge time.Duration
+	// Compress indicates whether files should be gzip compressed once they
+	// have been rotated.
+	Compress bool
+}
+
+// Storage implements the storage interfaces needed by the CT monitor, writing
+// everything to files in JSON Lines format.  It is safe for concurrent use.
+type Storage struct {
+	opts Options
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+	// seq is the number of files created so far, which is included in file
+	// names so that two files created at the same instant don't collide.
+	seq int
+	// batches is the number of calls to Batch in progress.  While it is
+	// non-zero, records are held back in held, rather than being written to
+	// f straight away.
+	batches int
+	held    []byte
+}
+
+// New returns a Storage that writes files as configured by opts.
+func New(opts Options) (*Storage, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("no directory provided")
+	}
+	if fi, err := os.Stat(opts.Dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", opts.Dir)
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	return &Storage{opts: opts}, nil
+}
+
+// WriteAPICall writes a record of the API Call passed to it.
+func (s *Storage) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	return s.write(&Record{
+		Type:    APICallRecord,
+		LogName: l.Name,
+		LogURL:  l.URL,
+		Time:    apiCall.End,
+		APICall: newAPICall(apiCall),
+	})
+}
+
+// WriteSTH writes a record of the STH and errors passed to it.
+func (s *Storage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error {
+	return s.write(&Record{
+		Type:    STHRecord,
+		LogName: l.Name,
+		LogURL:  l.URL,
+		Time:    receivedAt,
+		STH:     sth,
+		Errors:  errorStrings(errs),
+	})
+}
+
+// WriteSCT writes a record of the SCT, chain and errors passed to it.
+func (s *Storage) WriteSCT(ctx context.Context, l *ctlog.Log, sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, receivedAt time.Time, errs []error) error {
+	return s.write(&Record{
+		Type:    SCTRecord,
+		LogName: l.Name,
+		LogURL:  l.URL,
+		Time:    receivedAt,
+		SCT:     sct,
+		Chain:   derList(chain),
+		Errors:  errorStrings(errs),
+	})
+}
+
+// WriteRoots writes a record of the roots passed to it, with any duplicate
+// certificates removed.
+func (s *Storage) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	var ders [][]byte
+	seen := make(map[[32]byte]bool)
+	for _, r := range roots {
+		id, err := rootsanalyzer.GenerateCertID(r)
+		if err != nil {
+			return fmt.Errorf("WriteRoots: %s", err)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ders = append(ders, r.Raw)
+	}
+	return s.write(&Record{
+		Type:    RootsRecord,
+		LogName: l.Name,
+		LogURL:  l.URL,
+		Time:    receivedAt,
+		Roots:   ders,
+	})
+}
+
+// BlobRefs implements blob.Referencer, calling fn with the hash of the response
+// body of every API Call written to the files of this Storage whose body was
+// stored separately.  Writes are blocked while it runs.
+func (s *Storage) BlobRefs(ctx context.Context, fn func(h blob.Hash) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := Files(s.opts.Dir, s.opts.Prefix)
+	if err != nil {
+		return err
+	}
+	return blobRefs(files, fn)
+}
+
+// Referencer is a blob.Referencer for every file written to a directory by any
+// Storage, whatever its prefix, so that blobs shared by the Storages of several
+// Logs are not collected while any of them still refers to them.
+type Referencer struct {
+	dir string
+}
+
+// NewReferencer returns a Referencer for the files in dir.
+func NewReferencer(dir string) *Referencer {
+	return &Referencer{dir: dir}
+}
+
+// BlobRefs implements blob.Referencer, calling fn with the hash of the response
+// body of every API Call in the files in the directory whose body was stored
+// separately.
+//
+// As the files may be being written by other processes, a file that is still
+// being compressed is read from its uncompressed original, a file compressed
+// since the directory was listed is read from its compressed copy, and a
+// record that cannot be decoded from the end of the latest file for a prefix is
+// taken to be still being written and ignored.
+func (r *Referencer) BlobRefs(ctx context.Context, fn func(h blob.Hash) error) error {
+	files, err := AllFiles(r.dir)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	latest := make(map[string]string)
+	for _, f := range files {
+		listed[f] = true
+		latest[filePrefix(f)] = f
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".gz") && listed[strings.TrimSuffix(f, ".gz")] {
+			continue
+		}
+		err := blobRefs([]string{f}, fn)
+		if os.IsNotExist(err) && !strings.HasSuffix(f, ".gz") {
+			err = blobRefs([]string{f + ".gz"}, fn)
+		}
+		var de *decodeError
+		if errors.As(err, &de) && latest[filePrefix(f)] == f && !strings.HasSuffix(f, ".gz") {
+			glog.Warningf("file: ignoring the rest of %s, which is still being written: %s", f, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filePrefix returns the prefix with which the file called name was written by
+// a Storage.
+func filePrefix(name string) string {
+	base := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(name), ".gz"), Extension)
+	for i := 0; i < 2; i++ {
+		if j := strings.LastIndex(base, "-"); j >= 0 {
+			base = base[:j]
+		}
+	}
+	return base
+}
+
+// blobRefs calls fn with the hash of the response body of every API Call in
+// files whose body was stored separately.
+func blobRefs(files []string, fn func(h blob.Hash) error) error {
+	r := NewReader(files)
+	defer r.Close()
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.APICall == nil || len(rec.APICall.BodyHash) == 0 {
+			continue
+		}
+		h, err := blob.HashFromBytes(rec.APICall.BodyHash)
+		if err != nil {
+			return fmt.Errorf("invalid body hash in record for %s at %s: %s", rec.LogName, rec.Time, err)
+		}
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+}
+
+// Close closes the file currently being written to, compressing it if
+// configured to do so.
+func (s *Storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeFile()
+}
+
+// Rotate closes the file currently being written to, so that the next record
+// is written to a new file.
+func (s *Storage) Rotate() error {
+	return s.Close()
+}
+
+func (s *Storage) write(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s record: %s", r.Type, err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f != nil && s.needsRotation(int64(len(line))) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.openFile(); err != nil {
+			return err
+		}
+	}
+
+	if s.batches > 0 {
+		s.held = append(s.held, line...)
+		s.size += int64(len(line))
+		return nil
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write %s record to %s: %s", r.Type, s.f.Name(), err)
+	}
+	return nil
+}
+
+// Batch calls fn, holding back the records written while it runs, and then
+// writes them to the file together.  An error writing the held back records
+// is returned by Batch, rather than by the writes that made them.
+func (s *Storage) Batch(ctx context.Context, fn func() error) error {
+	s.mu.Lock()
+	s.batches++
+	s.mu.Unlock()
+
+	err := fn()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches--
+	if s.batches > 0 {
+		return err
+	}
+	if ferr := s.writeHeld(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// writeHeld writes the records held back by Batch to the current file.
+func (s *Storage) writeHeld() error {
+	if len(s.held) == 0 {
+		return nil
+	}
+	held := s.held
+	s.held = nil
+	if _, err := s.f.Write(held); err != nil {
+		return fmt.Errorf("failed to write records to %s: %s", s.f.Name(), err)
+	}
+	return nil
+}
+
+// needsRotation returns whether the current file should be rotated before
+// writing another n bytes to it.
+func (s *Storage) needsRotation(n int64) bool {
+	if s.opts.MaxBytes > 0 && s.size > 0 && s.size+n > s.opts.MaxBytes {
+		return true
+	}
+	if s.opts.MaxAge > 0 && timeNowUTC().Sub(s.created) >= s.opts.MaxAge {
+		return true
+	}
+	return false
+}
+
+func (s *Storage) openFile() error {
+	now := timeNowUTC()
+	name := filepath.Join(s.opts.Dir, fmt.Sprintf("%s-%s-%06d%s", s.opts.Prefix, now.Format(timeFormat), s.seq, Extension))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s", err)
+	}
+	s.f = f
+	s.size = 0
+	s.created = now
+	s.seq++
+	return nil
+}
+
+func (s *Storage) closeFile() error {
+	if s.f == nil {
+		return nil
+	}
+	name := s.f.Name()
+	err := s.writeHeld()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	if err != nil {
+		return fmt.Errorf("failed to close %s: %s", name, err)
+	}
+	if s.opts.Compress {
+		return compressFile(name)
+	}
+	return nil
+}
+
+// compressFile gzips the file called name, replacing it with a file of the
+// same name plus a .gz extension.
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed file: %s", err)
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to compress %s: %s", name, err)
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to compress %s: %s", name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("failed to close compressed file: %s", err)
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,449 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/monologue/storage/storagetest"
+	"github.com/google/monologue/testdata"
+	"github.com/google/monologue/testonly"
+)
+
+var (
+	l     = &ctlog.Log{Name: "pilot", URL: "https://ct.googleapis.com/pilot/"}
+	start = time.Date(2019, time.April, 10, 15, 0, 0, 0, time.UTC)
+	chain = testonly.MustCreateChain([]string{testdata.LeafCertPEM, testdata.IntermediateCertPEM, testdata.RootCertPEM})
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "monologue-file-test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir() = %s", err)
+	}
+	return dir
+}
+
+func readAll(t *testing.T, dir string) []*Record {
+	t.Helper()
+	files, err := Files(dir, "")
+	if err != nil {
+		t.Fatalf("Files(%s) = _, %s", dir, err)
+	}
+	r := NewReader(files)
+	defer r.Close()
+	var recs []*Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatalf("Next() = _, %s", err)
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	storagetest.Run(t, func(ctx context.Context, t *testing.T) interface{} {
+		dir, err := ioutil.TempDir(root, "conformance")
+		if err != nil {
+			t.Fatalf("ioutil.TempDir() = %s", err)
+		}
+		st, err := New(Options{Dir: dir})
+		if err != nil {
+			t.Fatalf("New() = _, %s", err)
+		}
+		return &replayingStorage{Storage: st, t: t, dir: dir}
+	})
+}
+
+// replayingStorage is a Storage whose records can be read back by the
+// conformance tests, by replaying them into a memory.Storage.
+type replayingStorage struct {
+	*Storage
+	t   *testing.T
+	dir string
+}
+
+func (s *replayingStorage) replay() *memory.Storage {
+	s.t.Helper()
+	files, err := AllFiles(s.dir)
+	if err != nil {
+		s.t.Fatalf("AllFiles(%s) = _, %s", s.dir, err)
+	}
+	r := NewReader(files)
+	defer r.Close()
+	st := memory.New()
+	if err := Replay(context.Background(), r, st); err != nil {
+		s.t.Fatalf("Replay() = %s", err)
+	}
+	return st
+}
+
+func (s *replayingStorage) APICalls(logName string) []*apicall.APICall {
+	return s.replay().APICalls(logName)
+}
+
+func (s *replayingStorage) STHs(logName string) []memory.STHEntry {
+	return s.replay().STHs(logName)
+}
+
+func TestNewErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	f, err := ioutil.TempFile(dir, "not-a-dir")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile() = _, %s", err)
+	}
+	f.Close()
+
+	for _, opts := range []Options{
+		{},
+		{Dir: dir + "/does-not-exist"},
+		{Dir: f.Name()},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) = _, nil, want error", opts)
+		}
+	}
+}
+
+func TestWriteAndRead(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	st, err := New(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+
+	apiCall := apicall.New(ct.GetSTHStr, &client.HTTPData{
+		Timing:   client.Timing{Start: start, End: start.Add(time.Second)},
+		Response: &http.Response{StatusCode: http.StatusOK},
+		Body:     []byte(`{"tree_size":30}`),
+	}, nil)
+	sth := &ct.SignedTreeHead{TreeSize: 30, Timestamp: 1512556025588}
+	sct := &ct.SignedCertificateTimestamp{Timestamp: 1512556025588}
+
+	if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
+		t.Fatalf("WriteAPICall() = %s", err)
+	}
+	if err := st.WriteSTH(ctx, l, sth, start, []error{io.ErrUnexpectedEOF}); err != nil {
+		t.Fatalf("WriteSTH() = %s", err)
+	}
+	if err := st.WriteRoots(ctx, l, []*x509.Certificate{chain[2], chain[1], chain[2]}, start); err != nil {
+		t.Fatalf("WriteRoots() = %s", err)
+	}
+	if err := st.WriteSCT(ctx, l, sct, chain, start, nil); err != nil {
+		t.Fatalf("WriteSCT() = %s", err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	want := []*Record{
+		{
+			Type: APICallRecord, LogName: l.Name, LogURL: l.URL, Time: start.Add(time.Second),
+			APICall: &APICall{Start: start, End: start.Add(time.Second), Endpoint: ct.GetSTHStr, StatusCode: http.StatusOK, Body: []byte(`{"tree_size":30}`)},
+		},
+		{Type: STHRecord, LogName: l.Name, LogURL: l.URL, Time: start, STH: sth, Errors: []string{io.ErrUnexpectedEOF.Error()}},
+		{Type: RootsRecord, LogName: l.Name, LogURL: l.URL, Time: start, Roots: [][]byte{chain[2].Raw, chain[1].Raw}},
+		{Type: SCTRecord, LogName: l.Name, LogURL: l.URL, Time: start, SCT: sct, Chain: derList(chain)},
+	}
+	if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("records: diff (-got +want)\n%s", diff)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	ctx := context.Background()
+	defer func(f func() time.Time) { timeNowUTC = f }(timeNowUTC)
+	now := start
+	timeNowUTC = func() time.Time { return now }
+
+	tests := []struct {
+		desc      string
+		opts      Options
+		step      time.Duration
+		wantFiles int
+	}{
+		{desc: "no rotation", wantFiles: 1, step: time.Hour},
+		{desc: "by size", opts: Options{MaxBytes: 1}, wantFiles: 5, step: time.Second},
+		{desc: "by age", opts: Options{MaxAge: 2 * time.Hour}, wantFiles: 3, step: time.Hour},
+		{desc: "compressed", opts: Options{MaxAge: 2 * time.Hour, Compress: true}, wantFiles: 3, step: time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			opts := test.opts
+			opts.Dir = dir
+			st, err := New(opts)
+			if err != nil {
+				t.Fatalf("New() = _, %s", err)
+			}
+
+			var want []*Record
+			for i := 0; i < 5; i++ {
+				now = start.Add(time.Duration(i) * test.step)
+				if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{TreeSize: uint64(i)}, now, nil); err != nil {
+					t.Fatalf("WriteSTH() = %s", err)
+				}
+				want = append(want, &Record{Type: STHRecord, LogName: l.Name, LogURL: l.URL, Time: now, STH: &ct.SignedTreeHead{TreeSize: uint64(i)}})
+			}
+			if err := st.Close(); err != nil {
+				t.Fatalf("Close() = %s", err)
+			}
+
+			files, err := Files(dir, "")
+			if err != nil {
+				t.Fatalf("Files() = _, %s", err)
+			}
+			if len(files) != test.wantFiles {
+				t.Errorf("wrote %d files, want %d", len(files), test.wantFiles)
+			}
+			for _, f := range files {
+				if got := strings.HasSuffix(f, CompressedExtension); got != opts.Compress {
+					t.Errorf("file %s compressed = %t, want %t", f, got, opts.Compress)
+				}
+			}
+			if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("records: diff (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []Options{{}, {MaxBytes: 1}} {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		opts.Dir = dir
+		st, err := New(opts)
+		if err != nil {
+			t.Fatalf("New() = _, %s", err)
+		}
+
+		var want []*Record
+		err = st.Batch(ctx, func() error {
+			for i := 0; i < 5; i++ {
+				if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{TreeSize: uint64(i)}, start, nil); err != nil {
+					t.Fatalf("WriteSTH() = %s", err)
+				}
+				want = append(want, &Record{Type: STHRecord, LogName: l.Name, LogURL: l.URL, Time: start, STH: &ct.SignedTreeHead{TreeSize: uint64(i)}})
+			}
+			if opts.MaxBytes == 0 {
+				if got := readAll(t, dir); len(got) != 0 {
+					t.Errorf("read %d records before the batch completed, want 0", len(got))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Batch() = %s", err)
+		}
+		if opts.MaxBytes == 0 {
+			if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("records after Batch: diff (-got +want)\n%s", diff)
+			}
+		}
+		if err := st.Close(); err != nil {
+			t.Fatalf("Close() = %s", err)
+		}
+		if diff := cmp.Diff(readAll(t, dir), want, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("MaxBytes %d: records: diff (-got +want)\n%s", opts.MaxBytes, diff)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	st, err := New(Options{Dir: dir, MaxBytes: 1, Compress: true})
+	if err != nil {
+		t.Fatalf("New() = _, %s", err)
+	}
+
+	apiCall := apicall.New(ct.GetRootsStr, &client.HTTPData{
+		Timing:   client.Timing{Start: start, End: start.Add(time.Second)},
+		Response: &http.Response{StatusCode: http.StatusServiceUnavailable},
+	}, io.ErrUnexpectedEOF)
+	if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
+		t.Fatalf("WriteAPICall() = %s", err)
+	}
+	if err := st.WriteRoots(ctx, l, chain[1:], start); err != nil {
+		t.Fatalf("WriteRoots() = %s", err)
+	}
+	if err := st.WriteSTH(ctx, l, &ct.SignedTreeHead{TreeSize: 30}, start, nil); err != nil {
+		t.Fatalf("WriteSTH() = %s", err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close() = %s", err)
+	}
+
+	files, err := Files(dir, "")
+	if err != nil {
+		t.Fatalf("Files() = _, %s", err)
+	}
+	mem := memory.New()
+	if err := Replay(ctx, NewReader(files), mem); err != nil {
+		t.Fatalf("Replay() = %s", err)
+	}
+
+	calls := mem.APICalls(l.Name)
+	if len(calls) != 1 {
+		t.Fatalf("replayed %d API calls, want 1", len(calls))
+	}
+	if got, want := calls[0].Response.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("replayed API call status = %d, want %d", got, want)
+	}
+	if got, want := calls[0].Err.Error(), io.ErrUnexpectedEOF.Error(); got != want {
+		t.Errorf("replayed API call error = %q, want %q", got, want)
+	}
+	if got := len(mem.STHs(l.Name)); got != 1 {
+		t.Errorf("replayed %d STHs, want 1", got)
+	}
+
+	c, err := mem.WatchRoots(ctx, l)
+	if err != nil {
+		t.Fatalf("WatchRoots() = _, %s", err)
+	}
+	roots, err := mem.ReadRoots(ctx, <-c)
+	if err != nil {
+		t.Fatalf("ReadRoots() = _, %s", err)
+	}
+	if len(roots) != 2 || !roots[0].Equal(chain[1]) || !roots[1].Equal(chain[2]) {
+		t.Errorf("replayed roots = %v, want %v", roots, chain[1:])
+	}
+}
+
+func TestReferencer(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	var want []blob.Hash
+	for _, prefix := range []string{"pilot", "aviator"} {
+		st, err := New(Options{Dir: dir, Prefix: prefix})
+		if err != nil {
+			t.Fatalf("New() = _, %s", err)
+		}
+		h := blob.HashOf([]byte(prefix))
+		want = append(want, h)
+		apiCall := apicall.New(ct.GetSTHStr, &client.HTTPData{Timing: client.Timing{Start: start, End: start}}, nil)
+		apiCall.BodyHash = h[:]
+		if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
+			t.Fatalf("WriteAPICall() = %s", err)
+		}
+		if err := st.Close(); err != nil {
+			t.Fatalf("Close() = %s", err)
+		}
+	}
+
+	got := make(map[blob.Hash]bool)
+	if err := NewReferencer(dir).BlobRefs(ctx, func(h blob.Hash) error {
+		got[h] = true
+		return nil
+	}); err != nil {
+		t.Fatalf("BlobRefs() = %s", err)
+	}
+	for _, h := range want {
+		if !got[h] {
+			t.Errorf("BlobRefs() did not find %s", h)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("BlobRefs() found %d hashes, want %d", len(got), len(want))
+	}
+}
+
+func TestReferencerToleratesFilesInProgress(t *testing.T) {
+	ctx := context.Background()
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	var want []blob.Hash
+	for _, prefix := range []string{"pilot", "aviator"} {
+		st, err := New(Options{Dir: dir, Prefix: prefix})
+		if err != nil {
+			t.Fatalf("New() = _, %s", err)
+		}
+		defer st.Close()
+		h := blob.HashOf([]byte(prefix))
+		want = append(want, h)
+		apiCall := apicall.New(ct.GetSTHStr, &client.HTTPData{Timing: client.Timing{Start: start, End: start}}, nil)
+		apiCall.BodyHash = h[:]
+		if err := st.WriteAPICall(ctx, l, apiCall); err != nil {
+			t.Fatalf("WriteAPICall() = %s", err)
+		}
+	}
+	pilotFiles, err := Files(dir, "pilot")
+	if err != nil || len(pilotFiles) != 1 {
+		t.Fatalf("Files(pilot) = %v, %v; want 1 file", pilotFiles, err)
+	}
+	// The pilot file is being compressed, so its compressed copy is
+	// incomplete...
+	if err := ioutil.WriteFile(pilotFiles[0]+".gz", []byte{0x1f, 0x8b}, 0644); err != nil {
+		t.Fatalf("WriteFile() = %s", err)
+	}
+	// ... and a record is being written to the aviator file.
+	aviatorFiles, err := Files(dir, "aviator")
+	if err != nil || len(aviatorFiles) != 1 {
+		t.Fatalf("Files(aviator) = %v, %v; want 1 file", aviatorFiles, err)
+	}
+	f, err := os.OpenFile(aviatorFiles[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile() = _, %s", err)
+	}
+	f.WriteString(`{"type":"api_call","log_name":`)
+	f.Close()
+
+	got := make(map[blob.Hash]bool)
+	if err := NewReferencer(dir).BlobRefs(ctx, func(h blob.Hash) error {
+		got[h] = true
+		return nil
+	}); err != nil {
+		t.Fatalf("BlobRefs() = %s", err)
+	}
+	for _, h := range want {
+		if !got[h] {
+			t.Errorf("BlobRefs() did not find %s", h)
+		}
+	}
+}
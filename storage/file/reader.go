@@ -0,0 +1,221 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/monologue/storage"
+)
+
+// Reader reads the records in a set of files written by Storage, in the order
+// they were written.
+type Reader struct {
+	files []string
+
+	f   *os.File
+	zr  *gzip.Reader
+	dec *json.Decoder
+}
+
+// Files returns the names of the files in dir that were written by a Storage
+// using the given prefix, sorted into the order in which they were created.
+// If prefix is empty, DefaultPrefix is used.
+func Files(dir, prefix string) ([]string, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix+"-") {
+			continue
+		}
+		if strings.HasSuffix(name, Extension) || strings.HasSuffix(name, CompressedExtension) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimSuffix(files[i], ".gz") < strings.TrimSuffix(files[j], ".gz")
+	})
+	return files, nil
+}
+
+// AllFiles returns the names of the files in dir that were written by a
+// Storage, whatever its prefix, sorted so that the files with each prefix are
+// in the order in which they were created.
+func AllFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && (strings.HasSuffix(name, Extension) || strings.HasSuffix(name, CompressedExtension)) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimSuffix(files[i], ".gz") < strings.TrimSuffix(files[j], ".gz")
+	})
+	return files, nil
+}
+
+// NewReader returns a Reader for the given files, which will be read in the
+// order given.  Compressed files are decompressed as they are read.
+func NewReader(files []string) *Reader {
+	return &Reader{files: files}
+}
+
+// Next returns the next record.  It returns io.EOF once every record in every
+// file has been read.
+func (r *Reader) Next() (*Record, error) {
+	for {
+		if r.dec == nil {
+			if len(r.files) == 0 {
+				return nil, io.EOF
+			}
+			if err := r.open(r.files[0]); err != nil {
+				return nil, err
+			}
+			r.files = r.files[1:]
+		}
+
+		var rec Record
+		err := r.dec.Decode(&rec)
+		if err == io.EOF {
+			if err := r.Close(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, &decodeError{name: r.f.Name(), err: err}
+		}
+		return &rec, nil
+	}
+}
+
+// decodeError is returned by Reader.Next when a record cannot be decoded.
+type decodeError struct {
+	name string
+	err  error
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("failed to decode record from %s: %s", e.name, e.err)
+}
+
+// Close closes the file currently being read.
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	if r.zr != nil {
+		r.zr.Close()
+	}
+	err := r.f.Close()
+	r.f, r.zr, r.dec = nil, nil, nil
+	return err
+}
+
+func (r *Reader) open(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	var in io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to decompress %s: %s", name, err)
+		}
+		r.zr = zr
+		in = zr
+	}
+	r.f = f
+	r.dec = json.NewDecoder(in)
+	return nil
+}
+
+// Replay reads every record from r and writes it to st.  Each record is
+// written using whichever of the storage.APICallWriter, storage.STHWriter,
+// storage.RootsWriter and storage.SCTWriter interfaces is appropriate; records
+// of a type that st cannot store, including aggregates left by compaction, are
+// skipped.
+func Replay(ctx context.Context, r *Reader, st interface{}) error {
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := replayRecord(ctx, rec, st); err != nil {
+			return fmt.Errorf("failed to replay %s record for %s at %s: %s", rec.Type, rec.LogName, rec.Time, err)
+		}
+	}
+}
+
+func replayRecord(ctx context.Context, rec *Record, st interface{}) error {
+	switch rec.Type {
+	case APICallRecord:
+		if w, ok := st.(storage.APICallWriter); ok && rec.APICall != nil {
+			return w.WriteAPICall(ctx, rec.Log(), rec.APICall.ToAPICall())
+		}
+	case STHRecord:
+		if w, ok := st.(storage.STHWriter); ok {
+			return w.WriteSTH(ctx, rec.Log(), rec.STH, rec.Time, errorValues(rec.Errors))
+		}
+	case RootsRecord:
+		if w, ok := st.(storage.RootsWriter); ok {
+			roots, err := Certificates(rec.Roots)
+			if err != nil {
+				return err
+			}
+			return w.WriteRoots(ctx, rec.Log(), roots, rec.Time)
+		}
+	case SCTRecord:
+		if w, ok := st.(storage.SCTWriter); ok {
+			chain, err := Certificates(rec.Chain)
+			if err != nil {
+				return err
+			}
+			return w.WriteSCT(ctx, rec.Log(), rec.SCT, chain, rec.Time, errorValues(rec.Errors))
+		}
+	case AggregateRecord:
+		// No storage interface accepts aggregates.
+	default:
+		return fmt.Errorf("unknown record type %q", rec.Type)
+	}
+	return nil
+}
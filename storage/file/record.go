@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/ctlog"
+)
+
+// RecordType identifies what kind of data a Record holds.
+type RecordType string
+
+// Types of Record.
+const (
+	APICallRecord RecordType = "api_call"
+	STHRecord     RecordType = "sth"
+	RootsRecord   RecordType = "roots"
+	SCTRecord     RecordType = "sct"
+	// AggregateRecord summarises records of another type that have been
+	// removed by compaction.
+	AggregateRecord RecordType = "aggregate"
+)
+
+// Record is a single line in a file written by Storage.  Which of the optional
+// fields are set depends on the Type of the Record.
+type Record struct {
+	Type    RecordType `json:"type"`
+	LogName string     `json:"log_name"`
+	LogURL  string     `json:"log_url"`
+	// Time is when the data in the Record was received from the Log.
+	Time time.Time `json:"time"`
+
+	// Set for APICallRecord.
+	APICall *APICall `json:"api_call,omitempty"`
+	// Set for STHRecord.
+	STH *ct.SignedTreeHead `json:"sth,omitempty"`
+	// Set for RootsRecord; the DER of each distinct root certificate.
+	Roots [][]byte `json:"roots,omitempty"`
+	// Set for SCTRecord, along with Chain; the DER of each certificate in the
+	// chain that was submitted to get the SCT.
+	SCT   *ct.SignedCertificateTimestamp `json:"sct,omitempty"`
+	Chain [][]byte                       `json:"chain,omitempty"`
+	// Errors found when checking an STH or SCT.
+	Errors []string `json:"errors,omitempty"`
+	// Set for AggregateRecord, in which case Time is the start of the bucket
+	// that the Aggregate covers.
+	Aggregate *Aggregate `json:"aggregate,omitempty"`
+}
+
+// APICall is the serializable form of an apicall.APICall.
+type APICall struct {
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Endpoint   ct.APIEndpoint `json:"endpoint"`
+	StatusCode int            `json:"status_code,omitempty"`
+	Body       []byte         `json:"body,omitempty"`
+	BodyHash   []byte         `json:"body_sha256,omitempty"`
+	Err        string         `json:"error,omitempty"`
+}
+
+// Aggregate summarises all of the records of one type, about one Log, that were
+// received during a bucket of time.
+type Aggregate struct {
+	// RecordType is the type of the records summarised.
+	RecordType RecordType `json:"record_type"`
+	// Bucket is the length of the bucket of time covered.
+	Bucket time.Duration `json:"bucket"`
+	// Count is the number of records summarised.
+	Count int `json:"count"`
+	// Errors is the number of records summarised that had errors.
+	Errors int `json:"errors,omitempty"`
+
+	// Set for API Calls, which are aggregated separately for each endpoint.
+	Endpoint     ct.APIEndpoint `json:"endpoint,omitempty"`
+	StatusCodes  map[int]int    `json:"status_codes,omitempty"`
+	TotalLatency time.Duration  `json:"total_latency,omitempty"`
+	MaxLatency   time.Duration  `json:"max_latency,omitempty"`
+
+	// Set for STHs.
+	MinTreeSize uint64 `json:"min_tree_size,omitempty"`
+	MaxTreeSize uint64 `json:"max_tree_size,omitempty"`
+}
+
+// Log returns a ctlog.Log containing the Log details stored in the Record.
+// Only the Name and URL fields are populated.
+func (r *Record) Log() *ctlog.Log {
+	return &ctlog.Log{Name: r.LogName, URL: r.LogURL}
+}
+
+// ToAPICall converts a serialized APICall back into an apicall.APICall.  The
+// HTTP response, if there was one, only has its status code populated.
+func (a *APICall) ToAPICall() *apicall.APICall {
+	ac := &apicall.APICall{
+		Start:    a.Start,
+		End:      a.End,
+		Endpoint: a.Endpoint,
+		Body:     a.Body,
+		BodyHash: a.BodyHash,
+	}
+	if a.StatusCode != 0 {
+		ac.Response = &http.Response{StatusCode: a.StatusCode, Status: fmt.Sprintf("%d %s", a.StatusCode, http.StatusText(a.StatusCode))}
+	}
+	if a.Err != "" {
+		ac.Err = errors.New(a.Err)
+	}
+	return ac
+}
+
+// Certificates parses a list of DER-encoded certificates, such as the Roots or
+// Chain of a Record.
+func Certificates(ders [][]byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0, len(ders))
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if x509.IsFatal(err) {
+			return nil, fmt.Errorf("unable to parse certificate: %s", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func newAPICall(ac *apicall.APICall) *APICall {
+	a := &APICall{
+		Start:    ac.Start,
+		End:      ac.End,
+		Endpoint: ac.Endpoint,
+		Body:     ac.Body,
+		BodyHash: ac.BodyHash,
+	}
+	if ac.Response != nil {
+		a.StatusCode = ac.Response.StatusCode
+	}
+	if ac.Err != nil {
+		a.Err = ac.Err.Error()
+	}
+	return a
+}
+
+func errorStrings(errs []error) []string {
+	var s []string
+	for _, err := range errs {
+		s = append(s, err.Error())
+	}
+	return s
+}
+
+// errorValues converts error strings read from a Record back into errors.
+func errorValues(errs []string) []error {
+	var e []error
+	for _, err := range errs {
+		e = append(e, errors.New(err))
+	}
+	return e
+}
+
+func derList(certs []*x509.Certificate) [][]byte {
+	ders := make([][]byte, 0, len(certs))
+	for _, c := range certs {
+		ders = append(ders, c.Raw)
+	}
+	return ders
+}
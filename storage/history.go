@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/certificate-transparency-go/x509"
+)
+
+// RootSetObservation records that a Log returned a particular set of roots at
+// a particular time.
+type RootSetObservation struct {
+	RootSetID  RootSetID
+	ReceivedAt time.Time
+}
+
+// RootSetTransition records that the root set returned by a Log changed.
+type RootSetTransition struct {
+	// From is the root set returned before the change.  It is empty if the
+	// Log's roots had not been observed before.
+	From RootSetID
+	To   RootSetID
+	// At is when To was first observed.
+	At time.Time
+}
+
+// RootSpan is a span of time in which a Log accepted a root certificate.
+type RootSpan struct {
+	LogName string
+	// Start is the first observation of the Log's roots that included the
+	// certificate, and LastSeen is the last of the consecutive observations
+	// that did.
+	Start    time.Time
+	LastSeen time.Time
+	// End is the first subsequent observation of the Log's roots that did not
+	// include the certificate.  It is zero if the Log still accepts it.
+	End time.Time
+}
+
+// Transitions returns the transitions between the root sets in obs, which must
+// be ordered by time.  prev is the root set observed before obs, if any.
+func Transitions(prev RootSetID, obs []RootSetObservation) []RootSetTransition {
+	var ts []RootSetTransition
+	for _, o := range obs {
+		if o.RootSetID != prev {
+			ts = append(ts, RootSetTransition{From: prev, To: o.RootSetID, At: o.ReceivedAt})
+			prev = o.RootSetID
+		}
+	}
+	return ts
+}
+
+// Spans returns the spans of time in which the observations in obs, of the
+// roots of the Log with the given name, included a root certificate.  obs must
+// be ordered by time, and contains reports whether a root set includes the
+// certificate.
+func Spans(logName string, obs []RootSetObservation, contains func(RootSetID) bool) []RootSpan {
+	var spans []RootSpan
+	var cur *RootSpan
+	for _, o := range obs {
+		switch in := contains(o.RootSetID); {
+		case in && cur == nil:
+			spans = append(spans, RootSpan{LogName: logName, Start: o.ReceivedAt, LastSeen: o.ReceivedAt})
+			cur = &spans[len(spans)-1]
+		case in:
+			cur.LastSeen = o.ReceivedAt
+		case cur != nil:
+			cur.End = o.ReceivedAt
+			cur = nil
+		}
+	}
+	return spans
+}
+
+// RootSetDiff is the difference between two root sets.
+type RootSetDiff struct {
+	// Added are the certificates only in the second root set, and Removed
+	// those only in the first.
+	Added   []*x509.Certificate
+	Removed []*x509.Certificate
+}
+
+// DiffRootSets reads the root sets from and to from r, and returns the
+// certificates added and removed between them, as found by DiffRoots.
+func DiffRootSets(ctx context.Context, r RootsReader, from, to RootSetID) (*RootSetDiff, error) {
+	fromRoots, err := r.ReadRoots(ctx, from)
+	if err != nil {
+		return nil, fmt.Errorf("DiffRootSets: %s", err)
+	}
+	toRoots, err := r.ReadRoots(ctx, to)
+	if err != nil {
+		return nil, fmt.Errorf("DiffRootSets: %s", err)
+	}
+	added, removed := DiffRoots(fromRoots, toRoots)
+	return &RootSetDiff{Added: added, Removed: removed}, nil
+}
+
+// DiffRoots returns the certificates that have been added or removed in new,
+// relative to old, in the order they appear in new and old respectively.  old
+// and new are treated as multisets: if a certificate appears more times in new
+// than in old, it is added once for each extra occurrence, and likewise for
+// removals, so that a Log starting or stopping returning duplicate roots is
+// seen as a change.
+func DiffRoots(old, new []*x509.Certificate) (added, removed []*x509.Certificate) {
+	// oldCount holds, for each certificate in old, how many more times it
+	// appears in old than in the part of new seen so far.
+	oldCount := make(map[string]int, len(old))
+	for _, cert := range old {
+		oldCount[string(cert.Raw)]++
+	}
+	for _, cert := range new {
+		certDER := string(cert.Raw)
+		if oldCount[certDER] > 0 {
+			// This occurrence of cert is in both old and new.
+			oldCount[certDER]--
+		} else {
+			// This occurrence of cert is only in new.
+			added = append(added, cert)
+		}
+	}
+	// Any occurrences of certs in old that remain uncounted are only in old.
+	for _, cert := range old {
+		certDER := string(cert.Raw)
+		if oldCount[certDER] > 0 {
+			oldCount[certDER]--
+			removed = append(removed, cert)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/testdata"
+	"github.com/google/monologue/testonly"
+)
+
+func TestDiffRoots(t *testing.T) {
+	chain := testonly.MustCreateChain([]string{testdata.IntermediateCertPEM, testdata.RootCertPEM})
+	root1, root2 := chain[0], chain[1]
+
+	tests := []struct {
+		desc        string
+		old, new    []*x509.Certificate
+		wantAdded   []*x509.Certificate
+		wantRemoved []*x509.Certificate
+	}{
+		{
+			desc: "unchanged",
+			old:  []*x509.Certificate{root1, root2},
+			new:  []*x509.Certificate{root2, root1},
+		},
+		{
+			desc:        "replaced",
+			old:         []*x509.Certificate{root1},
+			new:         []*x509.Certificate{root2},
+			wantAdded:   []*x509.Certificate{root2},
+			wantRemoved: []*x509.Certificate{root1},
+		},
+		{
+			desc: "duplicate in both",
+			old:  []*x509.Certificate{root1, root1, root2},
+			new:  []*x509.Certificate{root2, root1, root1},
+		},
+		{
+			desc:      "duplicate added",
+			old:       []*x509.Certificate{root1},
+			new:       []*x509.Certificate{root1, root1},
+			wantAdded: []*x509.Certificate{root1},
+		},
+		{
+			desc:        "duplicate removed",
+			old:         []*x509.Certificate{root2, root1, root2, root2},
+			new:         []*x509.Certificate{root1, root2},
+			wantRemoved: []*x509.Certificate{root2, root2},
+		},
+		{
+			desc:        "duplicates replaced",
+			old:         []*x509.Certificate{root1, root1},
+			new:         []*x509.Certificate{root2, root2, root2},
+			wantAdded:   []*x509.Certificate{root2, root2, root2},
+			wantRemoved: []*x509.Certificate{root1, root1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			added, removed := storage.DiffRoots(test.old, test.new)
+			if diff := cmp.Diff(test.wantAdded, added); diff != "" {
+				t.Errorf("DiffRoots() added diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantRemoved, removed); diff != "" {
+				t.Errorf("DiffRoots() removed diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory provides an in-memory implementation of the storage
+// interfaces needed by the CT monitor.
+//
+// Nothing stored is persisted, so this package is mostly useful in tests and
+// as a reference implementation of the semantics that every storage backend
+// must provide.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/blob"
+)
+
+// watchBufferSize is the number of RootSetIDs that can be queued for a
+// watcher before WriteRoots blocks waiting for it to read them.
+const watchBufferSize = 16
+
+// STHEntry is an STH stored by Storage.WriteSTH.
+type STHEntry struct {
+	STH        *ct.SignedTreeHead
+	ReceivedAt time.Time
+	Errs       []error
+}
+
+// SCTEntry is an SCT stored by Storage.WriteSCT.
+type SCTEntry struct {
+	SCT        *ct.SignedCertificateTimestamp
+	Chain      []*x509.Certificate
+	ReceivedAt time.Time
+	Errs       []error
+}
+
+// RootSetObservation records that a Log returned a particular set of roots at
+// a particular time.
+type RootSetObservation = storage.RootSetObservation
+
+type watcher struct {
+	ctx context.Context
+	c   chan storage.RootSetID
+}
+
+// Storage implements the storage interfaces needed by the CT monitor, keeping
+// everything in memory.  It is safe for concurrent use.
+type Storage struct {
+	mu           sync.Mutex
+	apiCalls     map[string][]*apicall.APICall
+	sths         map[string][]STHEntry
+	scts         map[string][]SCTEntry
+	roots        map[[32]byte]*x509.Certificate
+	rootSets     map[storage.RootSetID][][32]byte
+	observations map[string][]RootSetObservation
+	watchers     map[string][]*watcher
+}
+
+// New returns an empty Storage.
+func New() *Storage {
+	return &Storage{
+		apiCalls:     make(map[string][]*apicall.APICall),
+		sths:         make(map[string][]STHEntry),
+		scts:         make(map[string][]SCTEntry),
+		roots:        make(map[[32]byte]*x509.Certificate),
+		rootSets:     make(map[storage.RootSetID][][32]byte),
+		observations: make(map[string][]RootSetObservation),
+		watchers:     make(map[string][]*watcher),
+	}
+}
+
+// WriteAPICall stores the API Call passed to it.
+func (s *Storage) WriteAPICall(ctx context.Context, l *ctlog.Log, apiCall *apicall.APICall) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiCalls[l.Name] = append(s.apiCalls[l.Name], apiCall)
+	return nil
+}
+
+// APICalls returns the API Calls that have been stored for the Log with the
+// given name, in the order they were written.
+func (s *Storage) APICalls(logName string) []*apicall.APICall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*apicall.APICall(nil), s.apiCalls[logName]...)
+}
+
+// BlobRefs implements blob.Referencer, calling fn with the BodyHash of every
+// stored API Call that has one.
+func (s *Storage) BlobRefs(ctx context.Context, fn func(h blob.Hash) error) error {
+	s.mu.Lock()
+	var hashes [][]byte
+	for _, calls := range s.apiCalls {
+		for _, c := range calls {
+			if len(c.BodyHash) > 0 {
+				hashes = append(hashes, c.BodyHash)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	for _, hb := range hashes {
+		h, err := blob.HashFromBytes(hb)
+		if err != nil {
+			return err
+		}
+		if err := fn(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSTH stores the STH and errors passed to it.
+func (s *Storage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sths[l.Name] = append(s.sths[l.Name], STHEntry{STH: sth, ReceivedAt: receivedAt, Errs: errs})
+	return nil
+}
+
+// STHs returns the STHs that have been stored for the Log with the given
+// name, in the order they were written.
+func (s *Storage) STHs(logName string) []STHEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]STHEntry(nil), s.sths[logName]...)
+}
+
+// WriteSCT stores the SCT, chain and errors passed to it.
+func (s *Storage) WriteSCT(ctx context.Context, l *ctlog.Log, sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, receivedAt time.Time, errs []error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scts[l.Name] = append(s.scts[l.Name], SCTEntry{SCT: sct, Chain: chain, ReceivedAt: receivedAt, Errs: errs})
+	return nil
+}
+
+// SCTs returns the SCTs that have been stored for the Log with the given
+// name, in the order they were written.
+func (s *Storage) SCTs(logName string) []SCTEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SCTEntry(nil), s.scts[logName]...)
+}
+
+// WriteRoots stores the fact that the given roots were received from a
+// particular CT Log at the specified time, and notifies anything watching the
+// roots of that Log.
+func (s *Storage) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
+	rootSetID, err := rootsanalyzer.GenerateSetID(roots)
+	if err != nil {
+		return fmt.Errorf("unable to generate RootSetID: %s", err)
+	}
+
+	s.mu.Lock()
+	if _, ok := s.rootSets[rootSetID]; !ok {
+		ids := [][32]byte{}
+		for _, r := range roots {
+			id, err := rootsanalyzer.GenerateCertID(r)
+			if err != nil {
+				s.mu.Unlock()
+				return fmt.Errorf("WriteRoots: %s", err)
+			}
+			if containsID(ids, id) {
+				continue
+			}
+			s.roots[id] = r
+			ids = append(ids, id)
+		}
+		s.rootSets[rootSetID] = ids
+	}
+	s.observations[l.Name] = append(s.observations[l.Name], RootSetObservation{RootSetID: rootSetID, ReceivedAt: receivedAt})
+	watchers := append([]*watcher(nil), s.watchers[l.Name]...)
+	s.mu.Unlock()
+
+	for _, w := range watchers {
+		select {
+		case w.c <- rootSetID:
+		case <-w.ctx.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func containsID(ids [][32]byte, id [32]byte) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RootSetObservations returns every observation of a root set that has been
+// stored for the Log with the given name, in the order they were written.
+func (s *Storage) RootSetObservations(logName string) []RootSetObservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]RootSetObservation(nil), s.observations[logName]...)
+}
+
+// WatchRoots sends the RootSetID of every set of roots subsequently stored for
+// l over the returned channel, until ctx is cancelled.  It immediately sends
+// the RootSetID of the most recently received set of roots, if there is one.
+func (s *Storage) WatchRoots(ctx context.Context, l *ctlog.Log) (<-chan storage.RootSetID, error) {
+	w := &watcher{ctx: ctx, c: make(chan storage.RootSetID, watchBufferSize)}
+
+	s.mu.Lock()
+	if latest, ok := latestObservation(s.observations[l.Name]); ok {
+		w.c <- latest.RootSetID
+	}
+	s.watchers[l.Name] = append(s.watchers[l.Name], w)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		ws := s.watchers[l.Name]
+		for i := range ws {
+			if ws[i] == w {
+				s.watchers[l.Name] = append(ws[:i], ws[i+1:]...)
+				break
+			}
+		}
+	}()
+
+	return w.c, nil
+}
+
+// latestObservation returns the observation with the latest ReceivedAt time.
+func latestObservation(obs []RootSetObservation) (RootSetObservation, bool) {
+	if len(obs) == 0 {
+		return RootSetObservation{}, false
+	}
+	latest := obs[0]
+	for _, o := range obs[1:] {
+		if !o.ReceivedAt.Before(latest.ReceivedAt) {
+			latest = o
+		}
+	}
+	return latest, true
+}
+
+// ReadRoots returns the root certificates that make up a particular RootSet.
+// If the RootSet is unknown, no certificates are returned.
+func (s *Storage) ReadRoots(ctx context.Context, rootSet storage.RootSetID) ([]*x509.Certificate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := s.rootSets[rootSet]
+	roots := make([]*x509.Certificate, 0, len(ids))
+	for _, id := range ids {
+		roots = append(roots, s.roots[id])
+	}
+	return roots, nil
+}
+
+// sortedObservations returns the observations of the roots of the Log with the
+// given name, ordered by the time they were received.  s.mu must be held.
+func (s *Storage) sortedObservations(logName string) []RootSetObservation {
+	obs := append([]RootSetObservation(nil), s.observations[logName]...)
+	sort.SliceStable(obs, func(i, j int) bool {
+		return obs[i].ReceivedAt.Before(obs[j].ReceivedAt)
+	})
+	return obs
+}
+
+// RootSetTransitions returns the changes in the root set returned by l that
+// were first observed at or after since and before until.
+func (s *Storage) RootSetTransitions(ctx context.Context, l *ctlog.Log, since, until time.Time) ([]storage.RootSetTransition, error) {
+	s.mu.Lock()
+	obs := s.sortedObservations(l.Name)
+	s.mu.Unlock()
+
+	var ts []storage.RootSetTransition
+	for _, t := range storage.Transitions("", obs) {
+		if t.At.Before(since) || (!until.IsZero() && !t.At.Before(until)) {
+			continue
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+// RootSpans returns every span of time in which any Log accepted the root
+// certificate with the given ID.
+func (s *Storage) RootSpans(ctx context.Context, rootID [32]byte) ([]storage.RootSpan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contains := func(id storage.RootSetID) bool {
+		return containsID(s.rootSets[id], rootID)
+	}
+
+	var logNames []string
+	for name := range s.observations {
+		logNames = append(logNames, name)
+	}
+	sort.Strings(logNames)
+	var spans []storage.RootSpan
+	for _, name := range logNames {
+		spans = append(spans, storage.Spans(name, s.sortedObservations(name), contains)...)
+	}
+	return spans, nil
+}
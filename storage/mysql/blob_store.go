@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/monologue/storage/blob"
+)
+
+// blobStore implements the blob.Store interface.
+type blobStore struct {
+	db *sql.DB
+}
+
+// NewBlobStore builds a blob.Store that keeps blobs in a MySQL database.
+func NewBlobStore(ctx context.Context, db *sql.DB) blob.Store {
+	return &blobStore{db: db}
+}
+
+func (bs *blobStore) Put(ctx context.Context, data []byte) (blob.Hash, error) {
+	h := blob.HashOf(data)
+	if _, err := bs.db.ExecContext(ctx, "INSERT INTO Blobs(Hash, Data, StoredAt) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE StoredAt=VALUES(StoredAt);", h[:], data, time.Now().UTC()); err != nil {
+		return h, fmt.Errorf("Put: %s", err)
+	}
+	return h, nil
+}
+
+func (bs *blobStore) Get(ctx context.Context, h blob.Hash) ([]byte, error) {
+	var data []byte
+	err := bs.db.QueryRowContext(ctx, "SELECT Data FROM Blobs WHERE Hash = ?;", h[:]).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, blob.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Get: %s", err)
+	}
+	return data, nil
+}
+
+func (bs *blobStore) Delete(ctx context.Context, h blob.Hash) error {
+	if _, err := bs.db.ExecContext(ctx, "DELETE FROM Blobs WHERE Hash = ?;", h[:]); err != nil {
+		return fmt.Errorf("Delete: %s", err)
+	}
+	return nil
+}
+
+func (bs *blobStore) List(ctx context.Context, fn func(h blob.Hash, storedAt time.Time) error) error {
+	rows, err := bs.db.QueryContext(ctx, "SELECT Hash, StoredAt FROM Blobs;")
+	if err != nil {
+		return fmt.Errorf("List: %s", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hb []byte
+		var storedAt time.Time
+		if err := rows.Scan(&hb, &storedAt); err != nil {
+			return fmt.Errorf("List: %s", err)
+		}
+		h, err := blob.HashFromBytes(hb)
+		if err != nil {
+			return fmt.Errorf("List: %s", err)
+		}
+		if err := fn(h, storedAt); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS Blobs(
+  Hash BINARY(32),
+  Data LONGBLOB,
+  StoredAt DATETIME,
+  PRIMARY KEY(Hash)
+);
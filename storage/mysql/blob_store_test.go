@@ -0,0 +1,31 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/monologue/storage/blob"
+	"github.com/google/monologue/storage/mysql/testdb"
+	"github.com/google/monologue/storage/storagetest"
+)
+
+func TestBlobStoreConformance(t *testing.T) {
+	storagetest.RunBlobStore(t, func(ctx context.Context, t *testing.T) blob.Store {
+		testdb.Clean(ctx, blobDB, "Blobs")
+		return NewBlobStore(ctx, blobDB)
+	})
+}
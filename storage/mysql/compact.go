@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/retention"
+)
+
+// compactor implements the retention.Compactor interface.
+type compactor struct {
+	db *sql.DB
+}
+
+// NewCompactor builds a retention.Compactor that applies the Roots rule of a
+// retention.Policy to the root set observations stored by the RootStore.
+// Downsampling keeps only the first observation of each root set in each
+// bucket.  The most recent observation for each Log is always kept, as is the
+// root set data itself.
+func NewCompactor(ctx context.Context, db *sql.DB) retention.Compactor {
+	return &compactor{db: db}
+}
+
+type observation struct {
+	setID      []byte
+	receivedAt time.Time
+}
+
+func (c *compactor) Compact(ctx context.Context, l *ctlog.Log, p retention.Policy, holds retention.Holds, now time.Time) (retention.Stats, error) {
+	var stats retention.Stats
+	rule := p.Roots
+	if rule.Forever() {
+		return stats, nil
+	}
+	windows, err := holds.Holds(ctx, l)
+	if err != nil {
+		return stats, fmt.Errorf("failed to get evidence holds for %s: %s", l.Name, err)
+	}
+	obs, err := c.observations(ctx, l)
+	if err != nil {
+		return stats, err
+	}
+	if len(obs) == 0 {
+		return stats, nil
+	}
+
+	type bucketKey struct {
+		setID string
+		start time.Time
+	}
+	kept := make(map[bucketKey]bool)
+	var doomed []observation
+	// The last observation is the most recent, which is always kept.
+	for _, o := range obs[:len(obs)-1] {
+		action := rule.Apply(o.receivedAt, now)
+		if action != retention.Keep && retention.Held(windows, o.receivedAt) {
+			stats.Held++
+			continue
+		}
+		switch action {
+		case retention.Aggregate:
+			k := bucketKey{setID: string(o.setID), start: rule.BucketStart(o.receivedAt)}
+			if !kept[k] {
+				kept[k] = true
+				continue
+			}
+			doomed = append(doomed, o)
+		case retention.Delete:
+			doomed = append(doomed, o)
+		}
+	}
+
+	for _, o := range doomed {
+		if _, err := c.db.ExecContext(ctx, "DELETE FROM RootSetObservations WHERE LogName = ? AND RootSetID = ? AND ReceivedAt = ?;", l.Name, o.setID, o.receivedAt); err != nil {
+			return stats, fmt.Errorf("Compact: %s", err)
+		}
+		stats.Deleted++
+	}
+	glog.Infof("%s: compacted root set observations: %+v", l.Name, stats)
+	return stats, nil
+}
+
+// observations returns every root set observation for l, oldest first.
+func (c *compactor) observations(ctx context.Context, l *ctlog.Log) ([]observation, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? ORDER BY ReceivedAt;", l.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Compact: %s", err)
+	}
+	defer rows.Close()
+
+	var obs []observation
+	for rows.Next() {
+		var o observation
+		if err := rows.Scan(&o.setID, &o.receivedAt); err != nil {
+			return nil, fmt.Errorf("Compact: %s", err)
+		}
+		obs = append(obs, o)
+	}
+	return obs, rows.Err()
+}
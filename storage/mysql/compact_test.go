@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage/mysql/testdb"
+	"github.com/google/monologue/storage/retention"
+)
+
+type fakeHolds []retention.Window
+
+func (h fakeHolds) Holds(ctx context.Context, l *ctlog.Log) ([]retention.Window, error) {
+	return h, nil
+}
+
+func TestCompact(t *testing.T) {
+	ctx := context.Background()
+	testdb.Clean(ctx, testDB, "RootSetObservations")
+
+	day := 24 * time.Hour
+	start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	setA := []byte("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+	setB := []byte("BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB")
+
+	obs := []obEntry{
+		// Downsampled to the first observation of each set in the bucket.
+		{LogName: pilot.Name, RootSetID: setA, ReceivedAt: start},
+		{LogName: pilot.Name, RootSetID: setB, ReceivedAt: start.Add(10 * time.Minute)},
+		{LogName: pilot.Name, RootSetID: setA, ReceivedAt: start.Add(20 * time.Minute)},
+		// Held.
+		{LogName: pilot.Name, RootSetID: setA, ReceivedAt: start.Add(30 * time.Minute)},
+		// Too old to keep even downsampled.
+		{LogName: pilot.Name, RootSetID: setA, ReceivedAt: start.Add(-400 * day)},
+		// Recent.
+		{LogName: pilot.Name, RootSetID: setA, ReceivedAt: start.Add(35 * day)},
+		// Another Log.
+		{LogName: "aviator", RootSetID: setA, ReceivedAt: start.Add(-400 * day)},
+	}
+	for _, o := range obs {
+		if _, err := testDB.ExecContext(ctx, "INSERT INTO RootSetObservations(LogName, RootSetID, ReceivedAt) VALUES (?, ?, ?);", o.LogName, o.RootSetID, o.ReceivedAt); err != nil {
+			t.Fatalf("failed to insert observation: %s", err)
+		}
+	}
+
+	p := retention.Policy{Roots: retention.Rule{Raw: 30 * day, Bucket: time.Hour, Aggregate: 365 * day}}
+	holds := fakeHolds{{Start: start.Add(25 * time.Minute), End: start.Add(35 * time.Minute)}}
+	stats, err := NewCompactor(ctx, testDB).Compact(ctx, pilot, p, holds, start.Add(40*day))
+	if err != nil {
+		t.Fatalf("Compact() = _, %s", err)
+	}
+	if want := (retention.Stats{Deleted: 2, Held: 1}); stats != want {
+		t.Errorf("Compact() = %+v, want %+v", stats, want)
+	}
+
+	rows, err := testDB.QueryContext(ctx, "SELECT LogName, RootSetID, ReceivedAt FROM RootSetObservations ORDER BY LogName, ReceivedAt;")
+	if err != nil {
+		t.Fatalf("failed to query observations: %s", err)
+	}
+	defer rows.Close()
+	var got []obEntry
+	for rows.Next() {
+		var o obEntry
+		if err := rows.Scan(&o.LogName, &o.RootSetID, &o.ReceivedAt); err != nil {
+			t.Fatalf("failed to scan observation: %s", err)
+		}
+		got = append(got, o)
+	}
+	want := []obEntry{obs[6], obs[0], obs[1], obs[3], obs[5]}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("observations after Compact(): diff (-got +want)\n%s", diff)
+	}
+}
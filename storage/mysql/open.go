@@ -0,0 +1,44 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// DataSourceName returns uri, a MySQL data source name, with parseTime=true
+// set, so that DATETIME columns can be scanned into time.Time, as the stores
+// in this package, and in incident/mysql, require.
+func DataSourceName(uri string) (string, error) {
+	cfg, err := mysql.ParseDSN(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid MySQL URI: %s", err)
+	}
+	cfg.ParseTime = true
+	return cfg.FormatDSN(), nil
+}
+
+// Open opens the MySQL database at uri, with parseTime=true set whether or not
+// uri sets it.
+func Open(uri string) (*sql.DB, error) {
+	dsn, err := DataSourceName(uri)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("mysql", dsn)
+}
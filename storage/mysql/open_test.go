@@ -0,0 +1,41 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import "testing"
+
+func TestDataSourceName(t *testing.T) {
+	tests := []struct {
+		uri     string
+		want    string
+		wantErr bool
+	}{
+		{uri: "user:pw@tcp(db:3306)/monologue", want: "user:pw@tcp(db:3306)/monologue?parseTime=true"},
+		{uri: "user:pw@tcp(db:3306)/monologue?parseTime=false", want: "user:pw@tcp(db:3306)/monologue?parseTime=true"},
+		{uri: "user:pw@tcp(db:3306)/monologue?parseTime=true&loc=UTC", want: "user:pw@tcp(db:3306)/monologue?parseTime=true"},
+		{uri: "user@/monologue?timeout=5s", want: "user@tcp(127.0.0.1:3306)/monologue?parseTime=true&timeout=5s"},
+		{uri: "no database name", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := DataSourceName(test.uri)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("DataSourceName(%q) = _, %v, want err %t", test.uri, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("DataSourceName(%q) = %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
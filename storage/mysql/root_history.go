@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage"
+)
+
+// NewRootsHistoryReader builds a storage.RootsHistoryReader that queries the
+// root sets recorded in a MySQL database by a RootStore.
+func NewRootsHistoryReader(ctx context.Context, db *sql.DB) storage.RootsHistoryReader {
+	return &rootStore{rootDB: db, watchPollPeriod: defaultWatchPollPeriod}
+}
+
+func (rs *rootStore) RootSetTransitions(ctx context.Context, l *ctlog.Log, since, until time.Time) ([]storage.RootSetTransition, error) {
+	// The root set observed before since is needed to tell whether the first
+	// observation in the range is a change.
+	var prev []byte
+	err := rs.rootDB.QueryRowContext(ctx, "SELECT RootSetID FROM RootSetObservations WHERE LogName = ? AND ReceivedAt < ? ORDER BY ReceivedAt DESC LIMIT 1;", l.Name, since).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("RootSetTransitions: %s", err)
+	}
+
+	query := "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? AND ReceivedAt >= ? ORDER BY ReceivedAt;"
+	args := []interface{}{l.Name, since}
+	if !until.IsZero() {
+		query = "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? AND ReceivedAt >= ? AND ReceivedAt < ? ORDER BY ReceivedAt;"
+		args = append(args, until)
+	}
+	obs, err := rs.queryObservations(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("RootSetTransitions: %s", err)
+	}
+	return storage.Transitions(storage.RootSetID(prev), obs), nil
+}
+
+func (rs *rootStore) RootSpans(ctx context.Context, rootID [32]byte) ([]storage.RootSpan, error) {
+	rootSets := make(map[storage.RootSetID]bool)
+	rows, err := rs.rootDB.QueryContext(ctx, "SELECT RootSetID FROM RootSets WHERE RootID = ?;", rootID[:])
+	if err != nil {
+		return nil, fmt.Errorf("RootSpans: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id []byte
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("RootSpans: %s", err)
+		}
+		rootSets[storage.RootSetID(id)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("RootSpans: %s", err)
+	}
+	if len(rootSets) == 0 {
+		return nil, nil
+	}
+
+	logNames, err := rs.logsWithRoot(ctx, rootID)
+	if err != nil {
+		return nil, fmt.Errorf("RootSpans: %s", err)
+	}
+	var spans []storage.RootSpan
+	for _, name := range logNames {
+		obs, err := rs.queryObservations(ctx, "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? ORDER BY ReceivedAt;", name)
+		if err != nil {
+			return nil, fmt.Errorf("RootSpans: %s", err)
+		}
+		spans = append(spans, storage.Spans(name, obs, func(id storage.RootSetID) bool { return rootSets[id] })...)
+	}
+	return spans, nil
+}
+
+// logsWithRoot returns the names of the Logs that have ever returned the root
+// certificate with the given ID, in order.
+func (rs *rootStore) logsWithRoot(ctx context.Context, rootID [32]byte) ([]string, error) {
+	rows, err := rs.rootDB.QueryContext(ctx, "SELECT DISTINCT RootSetObservations.LogName FROM RootSetObservations JOIN RootSets ON RootSetObservations.RootSetID = RootSets.RootSetID WHERE RootSets.RootID = ? ORDER BY RootSetObservations.LogName;", rootID[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// queryObservations runs a query selecting the RootSetID and ReceivedAt of
+// root set observations, and returns them.
+func (rs *rootStore) queryObservations(ctx context.Context, query string, args ...interface{}) ([]storage.RootSetObservation, error) {
+	rows, err := rs.rootDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var obs []storage.RootSetObservation
+	for rows.Next() {
+		var id []byte
+		var o storage.RootSetObservation
+		if err := rows.Scan(&id, &o.ReceivedAt); err != nil {
+			return nil, err
+		}
+		o.RootSetID = storage.RootSetID(id)
+		obs = append(obs, o)
+	}
+	return obs, rows.Err()
+}
@@ -13,6 +13,9 @@
 // limitations under the License.
 
 // Package mysql provides a MySQL based implementation of Monologue storage.
+//
+// Times are scanned from DATETIME columns, so databases must be opened with
+// parseTime=true set in their data source name; Open ensures that it is.
 package mysql
 
 import (
@@ -21,20 +24,27 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/google/certificate-transparency-go/x509"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/rootsanalyzer"
 	"github.com/google/monologue/storage"
 )
 
-// RootStore implements storage.RootsWriter interface.
+// defaultWatchPollPeriod is how often WatchRoots checks the database for new
+// root set observations.
+const defaultWatchPollPeriod = 30 * time.Second
+
+// rootStore implements the storage.RootsWriter and storage.RootsReader interfaces.
 type rootStore struct {
 	rootDB *sql.DB
+	// watchPollPeriod is how often WatchRoots polls for new observations.
+	watchPollPeriod time.Duration
 }
 
 // NewRootStore builds an RootStore instance that records root certificates in a MySQL database.
-func NewRootStore(ctx context.Context, db *sql.DB) storage.RootsWriter {
-	return &rootStore{rootDB: db}
+func NewRootStore(ctx context.Context, db *sql.DB) storage.RootsReadWriter {
+	return &rootStore{rootDB: db, watchPollPeriod: defaultWatchPollPeriod}
 }
 
 func (rs *rootStore) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) error {
@@ -65,3 +75,120 @@ func (rs *rootStore) WriteRoots(ctx context.Context, l *ctlog.Log, roots []*x509
 
 	return nil
 }
+
+func (rs *rootStore) ReadRoots(ctx context.Context, rootSet storage.RootSetID) ([]*x509.Certificate, error) {
+	rows, err := rs.rootDB.QueryContext(ctx, "SELECT Roots.DER FROM RootSets JOIN Roots ON RootSets.RootID = Roots.ID WHERE RootSets.RootSetID = ?;", []byte(rootSet))
+	if err != nil {
+		return nil, fmt.Errorf("ReadRoots: %s", err)
+	}
+	defer rows.Close()
+
+	var roots []*x509.Certificate
+	for rows.Next() {
+		var der []byte
+		if err := rows.Scan(&der); err != nil {
+			return nil, fmt.Errorf("ReadRoots: %s", err)
+		}
+		root, err := x509.ParseCertificate(der)
+		if x509.IsFatal(err) {
+			return nil, fmt.Errorf("ReadRoots: unable to parse stored certificate: %s", err)
+		}
+		roots = append(roots, root)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ReadRoots: %s", err)
+	}
+	return roots, nil
+}
+
+// WatchRoots polls the database for new observations of the roots of l, and
+// sends the RootSetID of each one over the returned channel.  The RootSetID of
+// the latest observation, if there is one, is sent immediately.
+//
+// ReceivedAt is only stored to the second, so each poll reads the observations
+// received in the same second as the latest one already sent, and skips those
+// whose RootSetIDs have already been sent for that second.
+func (rs *rootStore) WatchRoots(ctx context.Context, l *ctlog.Log) (<-chan storage.RootSetID, error) {
+	var latestID []byte
+	var latestAt time.Time
+	err := rs.rootDB.QueryRowContext(ctx, "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? ORDER BY ReceivedAt DESC LIMIT 1;", l.Name).Scan(&latestID, &latestAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("WatchRoots: %s", err)
+	}
+
+	// sent holds the RootSetIDs observed at latestAt that are not to be
+	// sent again.  Only the latest observation is sent to begin with, so
+	// any others in the same second are treated as already sent.
+	sent := make(map[storage.RootSetID]bool)
+	if latestID != nil {
+		ids, _, err := rs.observationsSince(ctx, l, latestAt)
+		if err != nil {
+			return nil, fmt.Errorf("WatchRoots: %s", err)
+		}
+		for _, id := range ids {
+			sent[id] = true
+		}
+	}
+
+	c := make(chan storage.RootSetID, 1)
+	if latestID != nil {
+		c <- storage.RootSetID(latestID)
+	}
+
+	go func() {
+		ticker := time.NewTicker(rs.watchPollPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			ids, ats, err := rs.observationsSince(ctx, l, latestAt)
+			if err != nil {
+				glog.Errorf("%s: WatchRoots: %s", l.Name, err)
+				continue
+			}
+			for i, id := range ids {
+				if ats[i].Equal(latestAt) && sent[id] {
+					continue
+				}
+				select {
+				case c <- id:
+				case <-ctx.Done():
+					return
+				}
+				if ats[i].After(latestAt) {
+					latestAt = ats[i]
+					sent = make(map[storage.RootSetID]bool)
+				}
+				sent[id] = true
+			}
+		}
+	}()
+	return c, nil
+}
+
+// observationsSince returns the RootSetIDs and times of all observations of
+// the roots of l received at or after since, in the order they were received.
+func (rs *rootStore) observationsSince(ctx context.Context, l *ctlog.Log, since time.Time) ([]storage.RootSetID, []time.Time, error) {
+	rows, err := rs.rootDB.QueryContext(ctx, "SELECT RootSetID, ReceivedAt FROM RootSetObservations WHERE LogName = ? AND ReceivedAt >= ? ORDER BY ReceivedAt, RootSetID;", l.Name, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []storage.RootSetID
+	var ats []time.Time
+	for rows.Next() {
+		var id []byte
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, nil, err
+		}
+		ids = append(ids, storage.RootSetID(id))
+		ats = append(ats, at)
+	}
+	return ids, ats, rows.Err()
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/monologue/ctlog"
 	"github.com/google/monologue/storage/mysql/testdb"
+	"github.com/google/monologue/storage/storagetest"
 
 	_ "github.com/go-sql-driver/mysql" // Load MySQL driver
 )
@@ -300,6 +301,17 @@ func TestWriteRoots(t *testing.T) {
 	}
 }
 
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(ctx context.Context, t *testing.T) interface{} {
+		testdb.Clean(ctx, testDB, "Roots")
+		testdb.Clean(ctx, testDB, "RootSets")
+		testdb.Clean(ctx, testDB, "RootSetObservations")
+		st := NewRootStore(ctx, testDB)
+		st.(*rootStore).watchPollPeriod = 100 * time.Millisecond
+		return st
+	})
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	if err := testdb.MySQLAvailable(); err != nil {
@@ -314,11 +326,18 @@ func TestMain(m *testing.M) {
 	}
 	defer testDB.Close()
 	testdb.Clean(ctx, testDB, "Roots")
+	blobDB, err = testdb.New(ctx, blobStoreSQL)
+	if err != nil {
+		glog.Exitf("failed to create test blob database: %v", err)
+	}
+	defer blobDB.Close()
 	ec := m.Run()
 	os.Exit(ec)
 }
 
 var (
 	testDB       *sql.DB
+	blobDB       *sql.DB
 	rootStoreSQL = "root_store.sql"
+	blobStoreSQL = "blob_store.sql"
 )
@@ -46,6 +46,12 @@ func (s *Storage) WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTree
 	return nil
 }
 
+// WriteSCT simply prints the SCT and errors passed to it.
+func (s *Storage) WriteSCT(ctx context.Context, l *ctlog.Log, sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, receivedAt time.Time, errs []error) error {
+	glog.Infof("%s at %s:\n\tSCT: %s\n\tVerification errors: %s", l.Name, receivedAt, sct, errs)
+	return nil
+}
+
 // WriteRoots simply prints the number of certificates passed to it.
 func (s *Storage) WriteRoots(ctx context.Context, l *ctlog.Log, certs []*x509.Certificate, receivedAt time.Time) error {
 	glog.Infof("%s at %s: %d root certificates", l.Name, receivedAt, len(certs))
@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package retention describes how long the data stored by the CT monitor is
+// kept for, and provides the means to apply that to storage.
+//
+// Each type of stored record is kept in full for a period, and may then be
+// downsampled into per-bucket aggregates that are kept for a longer period,
+// before finally being deleted.  Records that are evidence for an open
+// incident are never deleted or downsampled, whatever their age.
+//
+// Incidents themselves are not subject to retention, and are kept forever.
+package retention
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/monologue/ctlog"
+)
+
+// Rule describes how long one type of record is kept for.  The zero Rule keeps
+// records forever.
+type Rule struct {
+	// Raw is how long individual records are kept for.  If 0, records are
+	// kept forever and the other fields are ignored.
+	Raw time.Duration
+	// Bucket is the period of time that records older than Raw are
+	// aggregated over.  If 0, records older than Raw are deleted without
+	// being aggregated.
+	Bucket time.Duration
+	// Aggregate is how long aggregates are kept for, measured from the start
+	// of their bucket.  If 0, aggregates are kept forever.
+	Aggregate time.Duration
+}
+
+// Forever returns whether the Rule keeps records forever.
+func (r Rule) Forever() bool {
+	return r.Raw <= 0
+}
+
+// Action is what a Rule says should happen to a record.
+type Action int
+
+// Actions that a Rule can require.
+const (
+	// Keep means the record should be kept as it is.
+	Keep Action = iota
+	// Aggregate means the record should be folded into an aggregate for the
+	// bucket returned by BucketStart.
+	Aggregate
+	// Delete means the record should be deleted.
+	Delete
+)
+
+// Apply returns what should happen, at time now, to a record received at t.
+func (r Rule) Apply(t, now time.Time) Action {
+	age := now.Sub(t)
+	switch {
+	case r.Forever() || age < r.Raw:
+		return Keep
+	case r.Bucket > 0 && r.KeepAggregate(r.BucketStart(t), now):
+		return Aggregate
+	default:
+		return Delete
+	}
+}
+
+// KeepAggregate returns whether, at time now, an aggregate for the bucket
+// starting at start should be kept.
+func (r Rule) KeepAggregate(start, now time.Time) bool {
+	return r.Aggregate <= 0 || now.Sub(start) < r.Aggregate
+}
+
+// BucketStart returns the start of the bucket that a record received at t is
+// aggregated into.
+func (r Rule) BucketStart(t time.Time) time.Time {
+	return t.Truncate(r.Bucket)
+}
+
+func (r Rule) String() string {
+	if r.Forever() {
+		return "forever"
+	}
+	if r.Bucket <= 0 {
+		return r.Raw.String()
+	}
+	agg := "forever"
+	if r.Aggregate > 0 {
+		agg = r.Aggregate.String()
+	}
+	return fmt.Sprintf("%s/%s/%s", r.Raw, r.Bucket, agg)
+}
+
+// ParseRule parses a Rule from a string of the form RAW[/BUCKET/AGGREGATE],
+// where each part is a duration as accepted by time.ParseDuration, or the word
+// "forever".  For example, "720h/1h/8760h" keeps raw records for 30 days and
+// hourly aggregates for a year, and "720h" deletes records after 30 days.  The
+// empty string and "forever" both keep records forever.
+func ParseRule(s string) (Rule, error) {
+	if s == "" || s == "forever" {
+		return Rule{}, nil
+	}
+	parts := strings.Split(s, "/")
+	if len(parts) != 1 && len(parts) != 3 {
+		return Rule{}, fmt.Errorf("retention rule %q is not of the form RAW[/BUCKET/AGGREGATE]", s)
+	}
+	var ds []time.Duration
+	for _, p := range parts {
+		if p == "forever" {
+			ds = append(ds, 0)
+			continue
+		}
+		d, err := time.ParseDuration(p)
+		if err != nil {
+			return Rule{}, fmt.Errorf("retention rule %q: %s", s, err)
+		}
+		if d <= 0 {
+			return Rule{}, fmt.Errorf("retention rule %q: durations must be positive", s)
+		}
+		ds = append(ds, d)
+	}
+	r := Rule{Raw: ds[0]}
+	if len(ds) == 3 {
+		if r.Raw == 0 {
+			return Rule{}, fmt.Errorf("retention rule %q: records kept forever cannot be aggregated", s)
+		}
+		if ds[1] == 0 {
+			return Rule{}, fmt.Errorf("retention rule %q: bucket cannot be forever", s)
+		}
+		r.Bucket, r.Aggregate = ds[1], ds[2]
+	}
+	return r, nil
+}
+
+// Policy is the set of Rules for each type of record stored by the monitor.
+// The zero Policy keeps everything forever.
+type Policy struct {
+	APICalls Rule
+	STHs     Rule
+	SCTs     Rule
+	// Roots applies to observations of the set of roots accepted by a Log.
+	// The most recent observation for each Log is always kept.
+	Roots Rule
+}
+
+// Window is a period of time, including its Start and End.
+type Window struct {
+	Start, End time.Time
+}
+
+// Contains returns whether t is within the Window.
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && !t.After(w.End)
+}
+
+// Holds is an interface for finding evidence that must be kept regardless of
+// Policy, such as that relating to an open incident.
+type Holds interface {
+	// Holds returns the periods of time for which every record about l must
+	// be kept.
+	Holds(ctx context.Context, l *ctlog.Log) ([]Window, error)
+}
+
+// Held returns whether t falls within any of the given Windows.
+func Held(holds []Window, t time.Time) bool {
+	for _, w := range holds {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NoHolds is a Holds that never holds anything.
+type NoHolds struct{}
+
+// Holds returns no Windows.
+func (NoHolds) Holds(ctx context.Context, l *ctlog.Log) ([]Window, error) {
+	return nil, nil
+}
+
+// Stats counts what a Compactor did.
+type Stats struct {
+	// Aggregated is the number of records folded into aggregates.
+	Aggregated int
+	// Deleted is the number of records, including aggregates, deleted.
+	Deleted int
+	// Held is the number of records kept only because they were held.
+	Held int
+}
+
+// Add adds the counts in o to s.
+func (s *Stats) Add(o Stats) {
+	s.Aggregated += o.Aggregated
+	s.Deleted += o.Deleted
+	s.Held += o.Held
+}
+
+// Compactor is an interface for applying a Policy to stored records.
+type Compactor interface {
+	// Compact applies p, as of time now, to the records about l, keeping any
+	// record within the Windows returned by holds.
+	Compact(ctx context.Context, l *ctlog.Log, p Policy, holds Holds, now time.Time) (Stats, error)
+}
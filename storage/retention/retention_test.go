@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{in: "", want: Rule{}},
+		{in: "forever", want: Rule{}},
+		{in: "720h", want: Rule{Raw: 720 * time.Hour}},
+		{in: "720h/1h/8760h", want: Rule{Raw: 720 * time.Hour, Bucket: time.Hour, Aggregate: 8760 * time.Hour}},
+		{in: "720h/1h/forever", want: Rule{Raw: 720 * time.Hour, Bucket: time.Hour}},
+		{in: "720h/1h", wantErr: true},
+		{in: "30d", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "forever/1h/8760h", wantErr: true},
+		{in: "720h/forever/8760h", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := ParseRule(test.in)
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("ParseRule(%q) = _, %v, want err? %t", test.in, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+		if err == nil && test.in != "" {
+			if again, err := ParseRule(got.String()); err != nil || again != got {
+				t.Errorf("ParseRule(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+			}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	now := time.Date(2020, time.March, 1, 12, 30, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	rule := Rule{Raw: 30 * day, Bucket: time.Hour, Aggregate: 365 * day}
+
+	tests := []struct {
+		desc string
+		rule Rule
+		age  time.Duration
+		want Action
+	}{
+		{desc: "forever", rule: Rule{}, age: 1000 * day, want: Keep},
+		{desc: "recent", rule: rule, age: time.Hour, want: Keep},
+		{desc: "old", rule: rule, age: 31 * day, want: Aggregate},
+		{desc: "ancient", rule: rule, age: 400 * day, want: Delete},
+		{desc: "no buckets", rule: Rule{Raw: 30 * day}, age: 31 * day, want: Delete},
+		{desc: "aggregates forever", rule: Rule{Raw: 30 * day, Bucket: time.Hour}, age: 1000 * day, want: Aggregate},
+	}
+	for _, test := range tests {
+		if got := test.rule.Apply(now.Add(-test.age), now); got != test.want {
+			t.Errorf("%s: Apply() = %v, want %v", test.desc, got, test.want)
+		}
+	}
+}
+
+func TestHeld(t *testing.T) {
+	start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	holds := []Window{{Start: start, End: start.Add(time.Hour)}}
+	for _, test := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{t: start.Add(-time.Second), want: false},
+		{t: start, want: true},
+		{t: start.Add(time.Hour), want: true},
+		{t: start.Add(time.Hour + time.Second), want: false},
+	} {
+		if got := Held(holds, test.t); got != test.want {
+			t.Errorf("Held(%s) = %t, want %t", test.t, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// The roothistory tool queries the history of the root certificates accepted
+// by Logs, as recorded by the datacollector.
+//
+// Usage:
+//
+//	roothistory [flags] transitions LOG_NAME
+//	roothistory [flags] diff FROM_ROOT_SET_ID TO_ROOT_SET_ID
+//	roothistory [flags] root SHA256
+//
+// transitions lists the changes in the root set of a Log, diff lists the
+// certificates added and removed between two root sets, and root lists every
+// Log and span of time in which the root certificate with the given SHA-256
+// fingerprint was accepted.  Root set IDs and fingerprints are given in hex.
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/file"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/monologue/storage/mysql"
+)
+
+var (
+	mysqlURI      = flag.String("mysql_uri", "", "URI of a MySQL database holding root sets (parseTime=true is always set)")
+	storageDir    = flag.String("storage_dir", "", "Directory of JSON Lines files written by the datacollector. Used if mysql_uri is not set")
+	storagePrefix = flag.String("storage_prefix", "", "Prefix of the files in storage_dir to read, as given to the datacollector. If unset, the default prefix is used")
+
+	since = flag.String("since", "", "Only list transitions at or after this time, in RFC 3339 format, or this long ago, e.g. 8760h")
+	until = flag.String("until", "", "Only list transitions before this time, in RFC 3339 format, or this long ago")
+
+	format = flag.String("format", "text", "Output format: text or json")
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] transitions LOG_NAME | diff FROM_ROOT_SET_ID TO_ROOT_SET_ID | root SHA256\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func openStorage(ctx context.Context) (storage.RootsHistoryReader, func(), error) {
+	switch {
+	case *mysqlURI != "":
+		db, err := mysql.Open(*mysqlURI)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to open MySQL database: %s", err)
+		}
+		return mysql.NewRootsHistoryReader(ctx, db), func() { db.Close() }, nil
+	case *storageDir != "":
+		files, err := file.Files(*storageDir, *storagePrefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		r := file.NewReader(files)
+		defer r.Close()
+		st := memory.New()
+		if err := file.Replay(ctx, r, st); err != nil {
+			return nil, nil, err
+		}
+		return st, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("neither mysql_uri nor storage_dir provided")
+	}
+}
+
+// parseTime parses s as either an RFC 3339 time, or a duration before now.
+func parseTime(name, s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	return t, nil
+}
+
+// parseHex parses a hex-encoded SHA-256 hash, which may be separated by colons
+// as in many certificate fingerprints.
+func parseHex(s string) ([32]byte, error) {
+	var h [32]byte
+	b, err := hex.DecodeString(strings.Replace(s, ":", "", -1))
+	if err != nil || len(b) != len(h) {
+		return h, fmt.Errorf("invalid SHA-256 hash %q", s)
+	}
+	copy(h[:], b)
+	return h, nil
+}
+
+// run performs the command given by args, writing its output to w.
+func run(ctx context.Context, st storage.RootsHistoryReader, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command provided")
+	}
+	cmd, args := args[0], args[1:]
+	want := map[string]int{"transitions": 1, "diff": 2, "root": 1}
+	n, ok := want[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d argument(s), got %d", cmd, n, len(args))
+	}
+
+	switch cmd {
+	case "transitions":
+		now := time.Now()
+		start, err := parseTime("since", *since, now)
+		if err != nil {
+			return err
+		}
+		end, err := parseTime("until", *until, now)
+		if err != nil {
+			return err
+		}
+		ts, err := st.RootSetTransitions(ctx, &ctlog.Log{Name: args[0]}, start, end)
+		if err != nil {
+			return err
+		}
+		return writeTransitions(w, ts)
+	case "diff":
+		var ids [2]storage.RootSetID
+		for i, arg := range args {
+			h, err := parseHex(arg)
+			if err != nil {
+				return err
+			}
+			ids[i] = storage.RootSetID(h[:])
+		}
+		diff, err := storage.DiffRootSets(ctx, st, ids[0], ids[1])
+		if err != nil {
+			return err
+		}
+		return writeDiff(w, diff)
+	case "root":
+		id, err := parseHex(args[0])
+		if err != nil {
+			return err
+		}
+		spans, err := st.RootSpans(ctx, id)
+		if err != nil {
+			return err
+		}
+		return writeSpans(w, spans)
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatID(id storage.RootSetID) string {
+	if id == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%X", string(id))
+}
+
+// jsonTransition is the JSON representation of a storage.RootSetTransition.
+type jsonTransition struct {
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+func writeTransitions(w io.Writer, ts []storage.RootSetTransition) error {
+	if *format == "json" {
+		js := []jsonTransition{}
+		for _, t := range ts {
+			j := jsonTransition{To: formatID(t.To), At: t.At}
+			if t.From != "" {
+				j.From = formatID(t.From)
+			}
+			js = append(js, j)
+		}
+		return writeJSON(w, js)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tFROM\tTO")
+	for _, t := range ts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", formatTime(t.At), formatID(t.From), formatID(t.To))
+	}
+	return tw.Flush()
+}
+
+// jsonCert is the JSON representation of a root certificate.
+type jsonCert struct {
+	Subject string `json:"subject"`
+	SHA256  string `json:"sha256"`
+}
+
+func toJSONCerts(certs []*x509.Certificate) []jsonCert {
+	js := []jsonCert{}
+	for _, cert := range certs {
+		js = append(js, jsonCert{Subject: cert.Subject.String(), SHA256: fmt.Sprintf("%X", sha256.Sum256(cert.Raw))})
+	}
+	return js
+}
+
+func writeDiff(w io.Writer, diff *storage.RootSetDiff) error {
+	if *format == "json" {
+		return writeJSON(w, struct {
+			Added   []jsonCert `json:"added"`
+			Removed []jsonCert `json:"removed"`
+		}{toJSONCerts(diff.Added), toJSONCerts(diff.Removed)})
+	}
+	for _, c := range toJSONCerts(diff.Added) {
+		fmt.Fprintf(w, "+ %s (SHA256: %s)\n", c.Subject, c.SHA256)
+	}
+	for _, c := range toJSONCerts(diff.Removed) {
+		fmt.Fprintf(w, "- %s (SHA256: %s)\n", c.Subject, c.SHA256)
+	}
+	return nil
+}
+
+// jsonSpan is the JSON representation of a storage.RootSpan.
+type jsonSpan struct {
+	LogName  string     `json:"log_name"`
+	Start    time.Time  `json:"start"`
+	LastSeen time.Time  `json:"last_seen"`
+	End      *time.Time `json:"end,omitempty"`
+}
+
+func writeSpans(w io.Writer, spans []storage.RootSpan) error {
+	if *format == "json" {
+		js := []jsonSpan{}
+		for _, s := range spans {
+			j := jsonSpan{LogName: s.LogName, Start: s.Start, LastSeen: s.LastSeen}
+			if !s.End.IsZero() {
+				end := s.End
+				j.End = &end
+			}
+			js = append(js, j)
+		}
+		return writeJSON(w, js)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOG\tSTART\tLAST SEEN\tEND")
+	for _, s := range spans {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.LogName, formatTime(s.Start), formatTime(s.LastSeen), formatTime(s.End))
+	}
+	return tw.Flush()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if *format != "text" && *format != "json" {
+		glog.Exitf("Invalid format %q: must be text or json", *format)
+	}
+
+	ctx := context.Background()
+	st, closeStorage, err := openStorage(ctx)
+	if err != nil {
+		glog.Exit(err)
+	}
+	defer closeStorage()
+	if err := run(ctx, st, flag.Args(), os.Stdout); err != nil {
+		closeStorage()
+		glog.Exit(err)
+	}
+}
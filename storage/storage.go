@@ -37,6 +37,14 @@ type STHWriter interface {
 	WriteSTH(ctx context.Context, l *ctlog.Log, sth *ct.SignedTreeHead, receivedAt time.Time, errs []error) error
 }
 
+// SCTWriter is an interface for storing SCTs received from a CT Log in response
+// to an add-chain or add-pre-chain call.
+type SCTWriter interface {
+	// WriteSCT stores an SCT, the chain that was submitted to get it, the time
+	// it was received and any errors found when checking it.
+	WriteSCT(ctx context.Context, l *ctlog.Log, sct *ct.SignedCertificateTimestamp, chain []*x509.Certificate, receivedAt time.Time, errs []error) error
+}
+
 // RootsWriter is an interface for storing root certificates retrieved from a CT get-roots call.
 type RootsWriter interface {
 	// WriteRoots stores the fact that the given roots were received from a particular CT Log at the specified time.
@@ -59,3 +67,26 @@ type RootsReader interface {
 	// i.e. the set of certificates returned by a CT get-roots call.
 	ReadRoots(ctx context.Context, rootSet RootSetID) ([]*x509.Certificate, error)
 }
+
+// RootsReadWriter is an interface for both storing root certificates and reading them back.
+type RootsReadWriter interface {
+	RootsWriter
+	RootsReader
+}
+
+// RootsHistoryReader is an interface for querying the history of the root
+// certificates accepted by CT Logs, as recorded by a RootsWriter.
+type RootsHistoryReader interface {
+	RootsReader
+
+	// RootSetTransitions returns the changes in the root set returned by l
+	// that were first observed at or after since and before until, in the
+	// order they were observed.  A zero since or until leaves the range
+	// unbounded at that end.
+	RootSetTransitions(ctx context.Context, l *ctlog.Log, since, until time.Time) ([]RootSetTransition, error)
+
+	// RootSpans returns every span of time in which any Log accepted the
+	// root certificate whose DER encoding has the given SHA-256 hash, ordered
+	// by Log name and then by time.
+	RootSpans(ctx context.Context, rootID [32]byte) ([]RootSpan, error)
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/monologue/storage/blob"
+)
+
+// BlobStoreFactory returns a new, empty blob.Store for use by a single test.
+type BlobStoreFactory func(ctx context.Context, t *testing.T) blob.Store
+
+// RunBlobStore runs the conformance test suite for blob.Store implementations
+// against the Stores produced by f.
+func RunBlobStore(t *testing.T, f BlobStoreFactory) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, t *testing.T, s blob.Store)
+	}{
+		{name: "PutGet", fn: testBlobPutGet},
+		{name: "PutIsIdempotent", fn: testBlobPutIsIdempotent},
+		{name: "PutRefreshesStoredAt", fn: testBlobPutRefreshesStoredAt},
+		{name: "GetMissing", fn: testBlobGetMissing},
+		{name: "Delete", fn: testBlobDelete},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			test.fn(ctx, t, f(ctx, t))
+		})
+	}
+}
+
+func mustPut(ctx context.Context, t *testing.T, s blob.Store, data []byte) blob.Hash {
+	t.Helper()
+	h, err := s.Put(ctx, data)
+	if err != nil {
+		t.Fatalf("Put(%q) = _, %s", data, err)
+	}
+	if want := blob.HashOf(data); h != want {
+		t.Fatalf("Put(%q) = %s, want %s", data, h, want)
+	}
+	return h
+}
+
+func listBlobs(ctx context.Context, t *testing.T, s blob.Store) map[blob.Hash]time.Time {
+	t.Helper()
+	got := make(map[blob.Hash]time.Time)
+	if err := s.List(ctx, func(h blob.Hash, storedAt time.Time) error {
+		got[h] = storedAt
+		return nil
+	}); err != nil {
+		t.Fatalf("List() = %s", err)
+	}
+	return got
+}
+
+func testBlobPutGet(ctx context.Context, t *testing.T, s blob.Store) {
+	for _, data := range [][]byte{[]byte("get-roots response"), {}, bytes.Repeat([]byte{0xff}, 1<<20)} {
+		h := mustPut(ctx, t, s, data)
+		got, err := s.Get(ctx, h)
+		if err != nil {
+			t.Fatalf("Get(%s) = _, %s", h, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Get(%s) returned %d bytes, want the %d bytes stored", h, len(got), len(data))
+		}
+	}
+}
+
+func testBlobPutIsIdempotent(ctx context.Context, t *testing.T, s blob.Store) {
+	data := []byte("get-roots response")
+	h := mustPut(ctx, t, s, data)
+	mustPut(ctx, t, s, data)
+
+	blobs := listBlobs(ctx, t, s)
+	if len(blobs) != 1 {
+		t.Errorf("List() returned %d blobs, want 1", len(blobs))
+	}
+	if storedAt, ok := blobs[h]; !ok {
+		t.Errorf("List() did not return %s", h)
+	} else if storedAt.IsZero() {
+		t.Errorf("List() returned zero storage time for %s", h)
+	}
+}
+
+// testBlobPutRefreshesStoredAt checks that storing a blob again updates the
+// time it was stored, so that it is not collected as if it had not been
+// referred to for as long as it has been stored.
+func testBlobPutRefreshesStoredAt(ctx context.Context, t *testing.T, s blob.Store) {
+	data := []byte("get-roots response")
+	h := mustPut(ctx, t, s, data)
+	first := listBlobs(ctx, t, s)[h]
+
+	// Some stores only keep the time to the second.
+	time.Sleep(1100 * time.Millisecond)
+	before := time.Now().Truncate(time.Second)
+	mustPut(ctx, t, s, data)
+	if got := listBlobs(ctx, t, s)[h]; got.Before(before) || !got.After(first) {
+		t.Errorf("List() after storing %s again returned storage time %s, want at or after %s", h, got, before)
+	}
+}
+
+func testBlobGetMissing(ctx context.Context, t *testing.T, s blob.Store) {
+	h := blob.HashOf([]byte("never stored"))
+	if _, err := s.Get(ctx, h); err != blob.ErrNotFound {
+		t.Errorf("Get(%s) = _, %v, want %v", h, err, blob.ErrNotFound)
+	}
+}
+
+func testBlobDelete(ctx context.Context, t *testing.T, s blob.Store) {
+	h1 := mustPut(ctx, t, s, []byte("one"))
+	h2 := mustPut(ctx, t, s, []byte("two"))
+	if err := s.Delete(ctx, h1); err != nil {
+		t.Fatalf("Delete(%s) = %s", h1, err)
+	}
+	if err := s.Delete(ctx, h1); err != nil {
+		t.Errorf("Delete(%s) of deleted blob = %s, want nil", h1, err)
+	}
+	if _, err := s.Get(ctx, h1); err != blob.ErrNotFound {
+		t.Errorf("Get(%s) after Delete = _, %v, want %v", h1, err, blob.ErrNotFound)
+	}
+	blobs := listBlobs(ctx, t, s)
+	if _, ok := blobs[h2]; !ok || len(blobs) != 1 {
+		t.Errorf("List() after Delete = %v, want only %s", blobs, h2)
+	}
+}
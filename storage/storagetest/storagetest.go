@@ -0,0 +1,543 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package storagetest provides a conformance test suite that can be run
+// against any implementation of the interfaces in the storage package, to check
+// that it behaves in the same way as every other implementation.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	"github.com/google/certificate-transparency-go/x509"
+	"github.com/google/monologue/apicall"
+	"github.com/google/monologue/client"
+	"github.com/google/monologue/ctlog"
+	"github.com/google/monologue/rootsanalyzer"
+	"github.com/google/monologue/storage"
+	"github.com/google/monologue/storage/memory"
+	"github.com/google/monologue/testdata"
+	"github.com/google/monologue/testonly"
+)
+
+// watchTimeout is how long to wait for a RootSetID to be sent by WatchRoots
+// before failing a test.
+const watchTimeout = 10 * time.Second
+
+// Factory returns a new, empty storage implementation for use by a single
+// test.  The value returned should implement one or more of the interfaces in
+// the storage package; tests of interfaces that it does not implement will be
+// skipped.
+type Factory func(ctx context.Context, t *testing.T) interface{}
+
+// Run runs the conformance test suite against the storage implementations
+// produced by f.
+func Run(t *testing.T, f Factory) {
+	tests := []struct {
+		name string
+		fn   func(ctx context.Context, t *testing.T, st interface{})
+	}{
+		{name: "WriteAPICall", fn: testWriteAPICall},
+		{name: "WriteSTH", fn: testWriteSTH},
+		{name: "WriteSCT", fn: testWriteSCT},
+		{name: "WriteRootsRemovesDuplicates", fn: testWriteRootsRemovesDuplicates},
+		{name: "RootSetIDIsStable", fn: testRootSetIDIsStable},
+		{name: "WatchRootsSendsLatest", fn: testWatchRootsSendsLatest},
+		{name: "WatchRootsSendsNew", fn: testWatchRootsSendsNew},
+		{name: "WatchRootsSendsSameSecond", fn: testWatchRootsSendsSameSecond},
+		{name: "WatchRootsIsPerLog", fn: testWatchRootsIsPerLog},
+		{name: "RootSetTransitions", fn: testRootSetTransitions},
+		{name: "RootSpans", fn: testRootSpans},
+		{name: "DiffRootSets", fn: testDiffRootSets},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			test.fn(ctx, t, f(ctx, t))
+		})
+	}
+}
+
+var (
+	root         = testonly.MustCreateChain([]string{testdata.RootCertPEM})[0]
+	intermediate = testonly.MustCreateChain([]string{testdata.IntermediateCertPEM})[0]
+	leaf         = testonly.MustCreateChain([]string{testdata.LeafCertPEM})[0]
+
+	start = time.Date(2019, time.April, 10, 15, 0, 0, 0, time.UTC)
+)
+
+func mustCreateLog(t *testing.T, url, name string) *ctlog.Log {
+	t.Helper()
+	l, err := ctlog.New(url, name, "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEfahLEimAoz2t01p3uMziiLOl/fHTDM0YDOhBRuiBARsV4UvxG2LdNgoIGLrtCzWE0J5APC2em4JlvR8EEEFMoA==", 24*time.Hour, nil)
+	if err != nil {
+		t.Fatalf("ctlog.New(%q, %q) = _, %s", url, name, err)
+	}
+	return l
+}
+
+func pilot(t *testing.T) *ctlog.Log {
+	return mustCreateLog(t, "https://ct.googleapis.com/pilot/", "pilot")
+}
+
+func aviator(t *testing.T) *ctlog.Log {
+	return mustCreateLog(t, "https://ct.googleapis.com/aviator/", "aviator")
+}
+
+func mustSetID(t *testing.T, roots []*x509.Certificate) storage.RootSetID {
+	t.Helper()
+	id, err := rootsanalyzer.GenerateSetID(roots)
+	if err != nil {
+		t.Fatalf("rootsanalyzer.GenerateSetID() = _, %s", err)
+	}
+	return id
+}
+
+func rootsStorage(t *testing.T, st interface{}) (storage.RootsWriter, storage.RootsReader) {
+	t.Helper()
+	w, ok := st.(storage.RootsWriter)
+	if !ok {
+		t.Skip("storage.RootsWriter not implemented")
+	}
+	r, ok := st.(storage.RootsReader)
+	if !ok {
+		t.Skip("storage.RootsReader not implemented")
+	}
+	return w, r
+}
+
+func rootsHistoryStorage(t *testing.T, st interface{}) (storage.RootsWriter, storage.RootsHistoryReader) {
+	t.Helper()
+	w, _ := rootsStorage(t, st)
+	h, ok := st.(storage.RootsHistoryReader)
+	if !ok {
+		t.Skip("storage.RootsHistoryReader not implemented")
+	}
+	return w, h
+}
+
+func mustWriteRoots(ctx context.Context, t *testing.T, w storage.RootsWriter, l *ctlog.Log, roots []*x509.Certificate, receivedAt time.Time) {
+	t.Helper()
+	if err := w.WriteRoots(ctx, l, roots, receivedAt); err != nil {
+		t.Fatalf("WriteRoots(%s, %d roots, %s) = %s", l.Name, len(roots), receivedAt, err)
+	}
+}
+
+func mustWatchRoots(ctx context.Context, t *testing.T, r storage.RootsReader, l *ctlog.Log) <-chan storage.RootSetID {
+	t.Helper()
+	c, err := r.WatchRoots(ctx, l)
+	if err != nil {
+		t.Fatalf("WatchRoots(%s) = _, %s", l.Name, err)
+	}
+	return c
+}
+
+// nextRootSetID returns the next RootSetID sent over c, failing the test if
+// none arrives in time.
+func nextRootSetID(t *testing.T, c <-chan storage.RootSetID) storage.RootSetID {
+	t.Helper()
+	select {
+	case id := <-c:
+		return id
+	case <-time.After(watchTimeout):
+		t.Fatalf("no RootSetID received within %s", watchTimeout)
+	}
+	return ""
+}
+
+// waitForRootSetID reads from c until want arrives, failing the test if it
+// doesn't arrive in time.
+func waitForRootSetID(t *testing.T, c <-chan storage.RootSetID, want storage.RootSetID) {
+	t.Helper()
+	timeout := time.After(watchTimeout)
+	for {
+		select {
+		case id := <-c:
+			if id == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("RootSetID %x not received within %s", want, watchTimeout)
+		}
+	}
+}
+
+// checkRoots checks that ReadRoots returns exactly the certificates in want,
+// in any order.
+func checkRoots(ctx context.Context, t *testing.T, r storage.RootsReader, id storage.RootSetID, want []*x509.Certificate) {
+	t.Helper()
+	got, err := r.ReadRoots(ctx, id)
+	if err != nil {
+		t.Fatalf("ReadRoots(%x) = _, %s", id, err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ReadRoots(%x) returned %d certificates, want %d", id, len(got), len(want))
+	}
+	sortByDER(got)
+	sortByDER(want)
+	for i := range got {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("ReadRoots(%x)[%d] = %s, want %s", id, i, got[i].Subject, want[i].Subject)
+		}
+	}
+}
+
+func sortByDER(certs []*x509.Certificate) {
+	sort.Slice(certs, func(i, j int) bool {
+		return bytes.Compare(certs[i].Raw, certs[j].Raw) < 0
+	})
+}
+
+// APICallLister is implemented by storage implementations whose API Calls can
+// be read back, so that testWriteAPICall can check what was written.
+type APICallLister interface {
+	APICalls(logName string) []*apicall.APICall
+}
+
+// STHLister is implemented by storage implementations whose STHs can be read
+// back, so that testWriteSTH can check what was written.
+type STHLister interface {
+	STHs(logName string) []memory.STHEntry
+}
+
+func testWriteAPICall(ctx context.Context, t *testing.T, st interface{}) {
+	w, ok := st.(storage.APICallWriter)
+	if !ok {
+		t.Skip("storage.APICallWriter not implemented")
+	}
+	calls := []*apicall.APICall{
+		apicall.New(ct.GetSTHStr, &client.HTTPData{
+			Timing: client.Timing{Start: start, End: start.Add(time.Second)},
+			Body:   []byte(`{"tree_size":30}`),
+		}, nil),
+		apicall.New(ct.GetRootsStr, nil, &client.GetError{URL: "https://ct.googleapis.com/pilot/ct/v1/get-roots", Err: context.DeadlineExceeded}),
+	}
+	for _, c := range calls {
+		if err := w.WriteAPICall(ctx, pilot(t), c); err != nil {
+			t.Errorf("WriteAPICall(%s) = %s", c, err)
+		}
+	}
+
+	lister, ok := st.(APICallLister)
+	if !ok {
+		t.Skip("API Calls cannot be read back")
+	}
+	got := lister.APICalls(pilot(t).Name)
+	if len(got) != len(calls) {
+		t.Fatalf("read back %d API Calls, want %d", len(got), len(calls))
+	}
+	for i, want := range calls {
+		g := got[i]
+		if g.Endpoint != want.Endpoint || !g.Start.Equal(want.Start) || !g.End.Equal(want.End) || !bytes.Equal(g.Body, want.Body) || errString(g.Err) != errString(want.Err) {
+			t.Errorf("read back API Call %d = %s, want %s", i, g, want)
+		}
+	}
+}
+
+func testWriteSTH(ctx context.Context, t *testing.T, st interface{}) {
+	w, ok := st.(storage.STHWriter)
+	if !ok {
+		t.Skip("storage.STHWriter not implemented")
+	}
+	sth := &ct.SignedTreeHead{TreeSize: testdata.TreeSize, Timestamp: uint64(start.UnixNano() / int64(time.Millisecond))}
+	if err := w.WriteSTH(ctx, pilot(t), sth, start, nil); err != nil {
+		t.Errorf("WriteSTH(%s, nil) = %s", sth, err)
+	}
+	errs := []error{context.DeadlineExceeded}
+	if err := w.WriteSTH(ctx, pilot(t), sth, start.Add(time.Minute), errs); err != nil {
+		t.Errorf("WriteSTH(%s, %v) = %s", sth, errs, err)
+	}
+
+	lister, ok := st.(STHLister)
+	if !ok {
+		t.Skip("STHs cannot be read back")
+	}
+	got := lister.STHs(pilot(t).Name)
+	want := []memory.STHEntry{{STH: sth, ReceivedAt: start}, {STH: sth, ReceivedAt: start.Add(time.Minute), Errs: errs}}
+	if len(got) != len(want) {
+		t.Fatalf("read back %d STHs, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.STH.TreeSize != w.STH.TreeSize || g.STH.Timestamp != w.STH.Timestamp || !g.ReceivedAt.Equal(w.ReceivedAt) || len(g.Errs) != len(w.Errs) {
+			t.Errorf("read back STH %d = %+v, want %+v", i, g, w)
+			continue
+		}
+		for j := range w.Errs {
+			if g.Errs[j].Error() != w.Errs[j].Error() {
+				t.Errorf("read back STH %d error %d = %q, want %q", i, j, g.Errs[j], w.Errs[j])
+			}
+		}
+	}
+}
+
+// errString returns the message of err, or "" if it is nil.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func testWriteSCT(ctx context.Context, t *testing.T, st interface{}) {
+	w, ok := st.(storage.SCTWriter)
+	if !ok {
+		t.Skip("storage.SCTWriter not implemented")
+	}
+	var rsp ct.AddChainResponse
+	if err := json.Unmarshal([]byte(testdata.SCT), &rsp); err != nil {
+		t.Fatalf("json.Unmarshal(%q) = %s", testdata.SCT, err)
+	}
+	sct, err := rsp.ToSignedCertificateTimestamp()
+	if err != nil {
+		t.Fatalf("ToSignedCertificateTimestamp() = _, %s", err)
+	}
+	chain := []*x509.Certificate{leaf, intermediate, root}
+	if err := w.WriteSCT(ctx, pilot(t), sct, chain, start, nil); err != nil {
+		t.Errorf("WriteSCT(%v, nil) = %s", sct, err)
+	}
+	errs := []error{context.DeadlineExceeded}
+	if err := w.WriteSCT(ctx, pilot(t), sct, chain, start.Add(time.Minute), errs); err != nil {
+		t.Errorf("WriteSCT(%v, %v) = %s", sct, errs, err)
+	}
+}
+
+func testWriteRootsRemovesDuplicates(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l := pilot(t)
+	mustWriteRoots(ctx, t, w, l, []*x509.Certificate{root, intermediate, root, root}, start)
+
+	id := nextRootSetID(t, mustWatchRoots(ctx, t, r, l))
+	checkRoots(ctx, t, r, id, []*x509.Certificate{root, intermediate})
+}
+
+func testRootSetIDIsStable(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l := pilot(t)
+	want := mustSetID(t, []*x509.Certificate{root, intermediate})
+
+	// The same set of certificates, in any order and with any number of
+	// duplicates, must always be given the same ID, and that ID must be the
+	// same regardless of which storage implementation is in use.
+	for i, roots := range [][]*x509.Certificate{
+		{root, intermediate},
+		{intermediate, root},
+		{intermediate, root, intermediate},
+	} {
+		mustWriteRoots(ctx, t, w, l, roots, start.Add(time.Duration(i)*time.Hour))
+		ctx, cancel := context.WithCancel(ctx)
+		if got := nextRootSetID(t, mustWatchRoots(ctx, t, r, l)); got != want {
+			t.Errorf("WatchRoots() sent RootSetID %x after writing %d roots, want %x", got, len(roots), want)
+		}
+		cancel()
+	}
+	checkRoots(ctx, t, r, want, []*x509.Certificate{root, intermediate})
+}
+
+func testWatchRootsSendsLatest(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l := pilot(t)
+	mustWriteRoots(ctx, t, w, l, []*x509.Certificate{root}, start)
+	mustWriteRoots(ctx, t, w, l, []*x509.Certificate{root, intermediate}, start.Add(time.Hour))
+
+	want := mustSetID(t, []*x509.Certificate{root, intermediate})
+	if got := nextRootSetID(t, mustWatchRoots(ctx, t, r, l)); got != want {
+		t.Errorf("WatchRoots() first sent RootSetID %x, want latest %x", got, want)
+	}
+}
+
+func testWatchRootsSendsNew(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l := pilot(t)
+	mustWriteRoots(ctx, t, w, l, []*x509.Certificate{root}, start)
+
+	c := mustWatchRoots(ctx, t, r, l)
+	if got, want := nextRootSetID(t, c), mustSetID(t, []*x509.Certificate{root}); got != want {
+		t.Fatalf("WatchRoots() first sent RootSetID %x, want %x", got, want)
+	}
+
+	roots := []*x509.Certificate{root, intermediate, leaf}
+	mustWriteRoots(ctx, t, w, l, roots, start.Add(time.Hour))
+	waitForRootSetID(t, c, mustSetID(t, roots))
+}
+
+// testWatchRootsSendsSameSecond checks that observations received within the
+// same second are all sent, as some implementations only store times to the
+// second.
+func testWatchRootsSendsSameSecond(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l := pilot(t)
+	mustWriteRoots(ctx, t, w, l, []*x509.Certificate{root}, start)
+
+	c := mustWatchRoots(ctx, t, r, l)
+	if got, want := nextRootSetID(t, c), mustSetID(t, []*x509.Certificate{root}); got != want {
+		t.Fatalf("WatchRoots() first sent RootSetID %x, want %x", got, want)
+	}
+
+	sets := [][]*x509.Certificate{{root, intermediate}, {root, intermediate, leaf}}
+	want := make(map[storage.RootSetID]bool)
+	for i, roots := range sets {
+		mustWriteRoots(ctx, t, w, l, roots, start.Add(time.Duration(i+1)*100*time.Millisecond))
+		want[mustSetID(t, roots)] = true
+	}
+	// The order of observations within a second may not be kept.
+	for range sets {
+		got := nextRootSetID(t, c)
+		if !want[got] {
+			t.Fatalf("WatchRoots() sent RootSetID %x, which was not written or was already sent", got)
+		}
+		delete(want, got)
+	}
+}
+
+func testWatchRootsIsPerLog(ctx context.Context, t *testing.T, st interface{}) {
+	w, r := rootsStorage(t, st)
+	l1, l2 := pilot(t), aviator(t)
+
+	c := mustWatchRoots(ctx, t, r, l1)
+	mustWriteRoots(ctx, t, w, l2, []*x509.Certificate{intermediate}, start)
+	mustWriteRoots(ctx, t, w, l1, []*x509.Certificate{root}, start.Add(time.Hour))
+
+	want := mustSetID(t, []*x509.Certificate{root})
+	if got := nextRootSetID(t, c); got != want {
+		t.Errorf("WatchRoots(%s) sent RootSetID %x, want %x", l1.Name, got, want)
+	}
+}
+
+func testRootSetTransitions(ctx context.Context, t *testing.T, st interface{}) {
+	w, h := rootsHistoryStorage(t, st)
+	l1, l2 := pilot(t), aviator(t)
+	set1 := []*x509.Certificate{root}
+	set2 := []*x509.Certificate{root, intermediate}
+	for i, roots := range [][]*x509.Certificate{set1, set1, set2, set2, set1} {
+		mustWriteRoots(ctx, t, w, l1, roots, start.Add(time.Duration(i)*time.Hour))
+	}
+	mustWriteRoots(ctx, t, w, l2, set2, start)
+	id1, id2 := mustSetID(t, set1), mustSetID(t, set2)
+
+	for _, test := range []struct {
+		desc         string
+		since, until time.Time
+		want         []storage.RootSetTransition
+	}{
+		{
+			desc: "all",
+			want: []storage.RootSetTransition{
+				{To: id1, At: start},
+				{From: id1, To: id2, At: start.Add(2 * time.Hour)},
+				{From: id2, To: id1, At: start.Add(4 * time.Hour)},
+			},
+		},
+		{
+			desc:  "range",
+			since: start.Add(time.Hour),
+			until: start.Add(4 * time.Hour),
+			want: []storage.RootSetTransition{
+				{From: id1, To: id2, At: start.Add(2 * time.Hour)},
+			},
+		},
+		{
+			desc:  "no changes",
+			since: start.Add(3 * time.Hour),
+			until: start.Add(4 * time.Hour),
+		},
+	} {
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := h.RootSetTransitions(ctx, l1, test.since, test.until)
+			if err != nil {
+				t.Fatalf("RootSetTransitions(%s, %s, %s) = _, %s", l1.Name, test.since, test.until, err)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("RootSetTransitions(%s, %s, %s) returned %d transitions, want %d", l1.Name, test.since, test.until, len(got), len(test.want))
+			}
+			for i := range got {
+				if got[i].From != test.want[i].From || got[i].To != test.want[i].To || !got[i].At.Equal(test.want[i].At) {
+					t.Errorf("RootSetTransitions(%s, %s, %s)[%d] = {%x, %x, %s}, want {%x, %x, %s}", l1.Name, test.since, test.until, i, got[i].From, got[i].To, got[i].At, test.want[i].From, test.want[i].To, test.want[i].At)
+				}
+			}
+		})
+	}
+}
+
+func testRootSpans(ctx context.Context, t *testing.T, st interface{}) {
+	w, h := rootsHistoryStorage(t, st)
+	l1, l2 := pilot(t), aviator(t)
+	for i, roots := range [][]*x509.Certificate{
+		{root},
+		{root, intermediate},
+		{root, intermediate},
+		{root},
+		{intermediate},
+	} {
+		mustWriteRoots(ctx, t, w, l1, roots, start.Add(time.Duration(i)*time.Hour))
+	}
+	mustWriteRoots(ctx, t, w, l2, []*x509.Certificate{intermediate}, start)
+
+	id, err := rootsanalyzer.GenerateCertID(intermediate)
+	if err != nil {
+		t.Fatalf("rootsanalyzer.GenerateCertID() = _, %s", err)
+	}
+	got, err := h.RootSpans(ctx, id)
+	if err != nil {
+		t.Fatalf("RootSpans(%x) = _, %s", id, err)
+	}
+	want := []storage.RootSpan{
+		{LogName: l2.Name, Start: start, LastSeen: start},
+		{LogName: l1.Name, Start: start.Add(time.Hour), LastSeen: start.Add(2 * time.Hour), End: start.Add(3 * time.Hour)},
+		{LogName: l1.Name, Start: start.Add(4 * time.Hour), LastSeen: start.Add(4 * time.Hour)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("RootSpans(%x) returned %d spans, want %d: %v", id, len(got), len(want), got)
+	}
+	for i := range got {
+		if got[i].LogName != want[i].LogName || !got[i].Start.Equal(want[i].Start) || !got[i].LastSeen.Equal(want[i].LastSeen) || !got[i].End.Equal(want[i].End) {
+			t.Errorf("RootSpans(%x)[%d] = %v, want %v", id, i, got[i], want[i])
+		}
+	}
+
+	unknown, err := rootsanalyzer.GenerateCertID(leaf)
+	if err != nil {
+		t.Fatalf("rootsanalyzer.GenerateCertID() = _, %s", err)
+	}
+	if got, err := h.RootSpans(ctx, unknown); err != nil || len(got) != 0 {
+		t.Errorf("RootSpans(%x) = %v, %v; want no spans", unknown, got, err)
+	}
+}
+
+func testDiffRootSets(ctx context.Context, t *testing.T, st interface{}) {
+	w, h := rootsHistoryStorage(t, st)
+	l := pilot(t)
+	from := []*x509.Certificate{root, intermediate}
+	to := []*x509.Certificate{root, leaf}
+	mustWriteRoots(ctx, t, w, l, from, start)
+	mustWriteRoots(ctx, t, w, l, to, start.Add(time.Hour))
+
+	diff, err := storage.DiffRootSets(ctx, h, mustSetID(t, from), mustSetID(t, to))
+	if err != nil {
+		t.Fatalf("DiffRootSets() = _, %s", err)
+	}
+	if len(diff.Added) != 1 || !diff.Added[0].Equal(leaf) {
+		t.Errorf("DiffRootSets() added %d certificates, want only %s", len(diff.Added), leaf.Subject)
+	}
+	if len(diff.Removed) != 1 || !diff.Removed[0].Equal(intermediate) {
+		t.Errorf("DiffRootSets() removed %d certificates, want only %s", len(diff.Removed), intermediate.Subject)
+	}
+}